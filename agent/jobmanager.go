@@ -7,8 +7,15 @@
 package agent
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"hash"
 	"io"
+	"net/http"
 	"os"
 	"strconv"
 	"sync"
@@ -25,6 +32,8 @@ type JobManager struct {
 	running       bool
 	jobProcessors []JobProcessor
 	lock          sync.RWMutex
+	pushRetryTime time.Time
+	outbox        *Outbox
 }
 
 func NewJobManager(agent *Agent) *JobManager {
@@ -32,6 +41,7 @@ func NewJobManager(agent *Agent) *JobManager {
 		agent: agent,
 		node:  model.NewNode(agent.Config.NodeId),
 	}
+	mgr.outbox = NewOutbox(agent.Config.OutboxPath, agent.Config.OutboxRetryMs, agent.Config.OutboxMaxRetryMs)
 
 	// Any field/value added to this list must be manually copied to the
 	// existing node object in filedatastoreimpl.go::UpdateNode()
@@ -47,46 +57,195 @@ func NewJobManager(agent *Agent) *JobManager {
 func (mgr *JobManager) Start() {
 	mgr.running = true
 	mgr.updateOnlineTime("/nsm/pcapout")
+	mgr.outbox.Prune()
 	for mgr.running {
 		mgr.updateDataEpoch()
-		job, err := mgr.PollPendingJobs()
+		mgr.deliverOutbox()
+		job, waited, err := mgr.nextJob()
 		if err != nil {
 			log.WithError(err).Warn("Failed to poll for pending jobs")
 			time.Sleep(time.Duration(mgr.agent.Config.PollIntervalMs) * time.Millisecond)
 		} else if job == nil {
 			log.Debug("No pending jobs available")
-			time.Sleep(time.Duration(mgr.agent.Config.PollIntervalMs) * time.Millisecond)
+			if !waited {
+				time.Sleep(time.Duration(mgr.agent.Config.PollIntervalMs) * time.Millisecond)
+			}
 		} else {
 			log.WithField("jobId", job.Id).Info("Discovered pending job")
+			tracker := NewJobProgressTracker()
+			ctx, cancel := context.WithCancel(WithJobProgressTracker(context.Background(), tracker))
+			watcher := mgr.watchJob(ctx, cancel, job.Id, tracker)
 			var reader io.ReadCloser
-			reader, err = mgr.ProcessJob(job)
-			if err == nil {
-				if reader != nil {
-					defer reader.Close()
-					err = mgr.StreamJobResults(job, reader)
-				} else {
+			var entry *OutboxEntry
+			reader, err = mgr.ProcessJob(ctx, job)
+			cancelled := ctx.Err() != nil
+			if err == nil && !cancelled {
+				if reader == nil {
 					log.WithField("jobId", job.Id).Debug("Job completed without stream result")
 				}
+				// Spool the results before uploading them so that they can be uploaded again
+				// later if the server can't be reached now.
+				entry, err = mgr.outbox.Add(job, reader)
+				if err == nil {
+					if uploadErr := mgr.uploadResult(entry); uploadErr != nil {
+						log.WithError(uploadErr).WithField("jobId", job.Id).Warn("Failed to upload job results; will retry from outbox")
+					}
+				}
+			} else if reader != nil {
+				reader.Close()
 			}
-			if err == nil {
+			cancel()
+			serverStatus := <-watcher
+			job.Progress = tracker.Latest()
+			if cancelled {
+				log.WithField("jobId", job.Id).Info("Job was cancelled while processing")
+				job.Cancel()
+			} else if err == nil {
 				job.Complete()
 			} else {
 				job.Fail(err)
 			}
 			mgr.CleanupJob(job)
-			err = mgr.UpdateJob(job)
-			if err != nil {
-				log.WithError(err).WithField("jobId", job.Id).Error("Failed to update job")
-				time.Sleep(time.Duration(mgr.agent.Config.PollIntervalMs) * time.Millisecond)
+			if serverStatus == model.JobStatusDeleted {
+				log.WithField("jobId", job.Id).Info("Job was deleted while processing; skipping update")
+				mgr.outbox.Remove(entry)
+				continue
+			}
+			if serverStatus == model.JobStatusFailed {
+				log.WithField("jobId", job.Id).Info("Job lease was lost while processing; skipping update")
+				mgr.outbox.Remove(entry)
+				continue
+			}
+			if entry == nil {
+				entry, _ = mgr.outbox.Add(job, nil)
+			}
+			if err = mgr.outbox.Save(entry); err != nil {
+				log.WithError(err).WithField("jobId", job.Id).Error("Failed to save job update to outbox")
+			}
+			if err = mgr.deliver(entry); err != nil {
+				log.WithError(err).WithField("jobId", job.Id).Error("Failed to update job; will retry from outbox")
+			}
+		}
+	}
+}
+
+// watchJob periodically sends the job's progress to the server while it is being
+// processed, or just renews the job's lease if there is no new progress, and cancels
+// the context once the job has been cancelled, deleted or failed by the server. The
+// returned channel receives the last status observed on the server after the context
+// is done.
+func (mgr *JobManager) watchJob(ctx context.Context, cancel context.CancelFunc, jobId int, tracker *JobProgressTracker) <-chan int {
+	statusChan := make(chan int, 1)
+	go func() {
+		status := model.JobStatusPending
+		ticker := time.NewTicker(time.Duration(mgr.agent.Config.CancelCheckIntervalMs) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				statusChan <- status
+				return
+			case <-ticker.C:
+				var current int
+				var err error
+				if progress := tracker.Take(); progress != nil {
+					current, err = mgr.SendJobProgress(jobId, progress)
+				} else {
+					current, err = mgr.RenewJobLease(jobId)
+				}
+				if err != nil {
+					log.WithError(err).WithField("jobId", jobId).Warn("Failed to check job status")
+				} else {
+					status = current
+					if status == model.JobStatusCancelRequested || status == model.JobStatusDeleted || status == model.JobStatusFailed {
+						log.WithFields(log.Fields{
+							"jobId":  jobId,
+							"status": status,
+						}).Info("Cancelling job processing")
+						cancel()
+					}
+				}
 			}
 		}
+	}()
+	return statusChan
+}
+
+// GetJobStatus returns the job's current status on the server, or JobStatusDeleted
+// if the server no longer knows about the job.
+func (mgr *JobManager) GetJobStatus(jobId int) (int, error) {
+	resp, err := mgr.agent.Client.SendAuthorizedRequest("GET", "/api/job/"+strconv.Itoa(jobId), "application/json", nil)
+	return mgr.readJobStatus(resp, err)
+}
+
+// RenewJobLease tells the server the job is still being processed, returning the
+// job's current status the same way as GetJobStatus.
+func (mgr *JobManager) RenewJobLease(jobId int) (int, error) {
+	resp, err := mgr.agent.Client.SendAuthorizedRequest("PUT", "/api/job/"+strconv.Itoa(jobId)+"/lease", "application/json", nil)
+	return mgr.readJobStatus(resp, err)
+}
+
+// SendJobProgress reports the job's progress to the server, returning the job's
+// current status the same way as GetJobStatus.
+func (mgr *JobManager) SendJobProgress(jobId int, progress *model.JobProgress) (int, error) {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return 0, err
 	}
+	resp, err := mgr.agent.Client.SendAuthorizedRequest("PUT", "/api/job/"+strconv.Itoa(jobId)+"/progress", "application/json", bytes.NewReader(data))
+	return mgr.readJobStatus(resp, err)
+}
+
+func (mgr *JobManager) readJobStatus(resp *http.Response, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return model.JobStatusDeleted, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New("Unable to check job status (" + strconv.Itoa(resp.StatusCode) + "): " + resp.Status)
+	}
+	job := model.NewJob()
+	err = json.NewDecoder(resp.Body).Decode(job)
+	return job.Status, err
 }
 
 func (mgr *JobManager) Stop() {
 	mgr.running = false
 }
 
+// nextJob asks the server for the next job. With push dispatch enabled the server holds
+// the request open until a job is added for this node, and polling is only used while
+// that channel is unavailable. The returned flag reports whether the server already held
+// the request for at least a poll interval, in which case it can be repeated right away.
+func (mgr *JobManager) nextJob() (*model.Job, bool, error) {
+	if mgr.agent.Config.JobPushEnabled && !time.Now().Before(mgr.pushRetryTime) {
+		start := time.Now()
+		job, err := mgr.WaitForPendingJobs()
+		if err == nil {
+			waited := time.Since(start) >= time.Duration(mgr.agent.Config.PollIntervalMs)*time.Millisecond
+			return job, waited, nil
+		}
+		log.WithError(err).WithField("retryMs", mgr.agent.Config.JobPushRetryMs).Warn("Job push channel is unavailable; falling back to polling")
+		mgr.pushRetryTime = time.Now().Add(time.Duration(mgr.agent.Config.JobPushRetryMs) * time.Millisecond)
+	}
+	job, err := mgr.PollPendingJobs()
+	return job, false, err
+}
+
+// WaitForPendingJobs is the long-poll variant of PollPendingJobs, waiting up to JobWaitMs
+// on the server for a job to become available.
+func (mgr *JobManager) WaitForPendingJobs() (*model.Job, error) {
+	job := model.NewJob()
+	available, err := mgr.agent.Client.SendAuthorizedObject("POST", "/api/node/wait?waitMs="+strconv.Itoa(mgr.agent.Config.JobWaitMs), mgr.node, job)
+	if !available {
+		job = nil
+	}
+	return job, err
+}
+
 func (mgr *JobManager) PollPendingJobs() (*model.Job, error) {
 	job := model.NewJob()
 	available, err := mgr.agent.Client.SendAuthorizedObject("POST", "/api/node", mgr.node, job)
@@ -96,7 +255,7 @@ func (mgr *JobManager) PollPendingJobs() (*model.Job, error) {
 	return job, err
 }
 
-func (mgr *JobManager) ProcessJob(job *model.Job) (io.ReadCloser, error) {
+func (mgr *JobManager) ProcessJob(ctx context.Context, job *model.Job) (io.ReadCloser, error) {
 	mgr.lock.RLock()
 	defer mgr.lock.RUnlock()
 	var reader io.ReadCloser
@@ -104,7 +263,7 @@ func (mgr *JobManager) ProcessJob(job *model.Job) (io.ReadCloser, error) {
 
 	job.Size = 0
 	for _, processor := range mgr.jobProcessors {
-		reader, err = processor.ProcessJob(job, reader)
+		reader, err = processor.ProcessJob(ctx, job, reader)
 	}
 	if err != nil && reader != nil {
 		// Don't fail all processors if at least one provided some data.
@@ -140,14 +299,243 @@ func (mgr *JobManager) updateDataEpoch() {
 	}
 }
 
+// StreamJobResults uploads the job's packet stream to the server in checksummed chunks.
+// A chunk that fails to upload is retried from the last offset acknowledged by the
+// server, so that a dropped connection does not require the job to be processed again.
+// When the stream can be rewound, an upload left unfinished by an earlier attempt
+// continues from where the server left off, and starts over should that fail.
 func (mgr *JobManager) StreamJobResults(job *model.Job, reader io.ReadCloser) error {
-	resp, err := mgr.agent.Client.SendAuthorizedRequest("POST", "/api/stream?jobId="+strconv.Itoa(job.Id), "application/octet-stream", reader)
-	if resp.StatusCode != 200 {
+	if seeker, ok := reader.(io.Seeker); ok {
+		if offset := mgr.resumableOffset(job.Id); offset > 0 {
+			err := mgr.resumeUpload(job, reader, offset)
+			if err == nil {
+				return nil
+			}
+			log.WithError(err).WithFields(log.Fields{
+				"jobId":  job.Id,
+				"offset": offset,
+			}).Warn("Unable to resume job results upload; starting over")
+			if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+	}
+	return mgr.uploadStream(job, reader, sha256.New(), 0)
+}
+
+// resumableOffset returns how much of an earlier upload of the job's results the server
+// holds, or zero if there is nothing to resume.
+func (mgr *JobManager) resumableOffset(jobId int) int64 {
+	offset, err := mgr.GetStreamUploadOffset(jobId)
+	if err != nil {
+		log.WithError(err).WithField("jobId", jobId).Debug("Unable to check for an upload to resume")
+		return 0
+	}
+	return offset
+}
+
+// resumeUpload skips past the part of the stream the server already holds and uploads
+// the rest. The skipped bytes are still hashed, since the checksum covers the whole stream.
+func (mgr *JobManager) resumeUpload(job *model.Job, reader io.Reader, offset int64) error {
+	hasher := sha256.New()
+	if _, err := io.CopyN(hasher, reader, offset); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"jobId":  job.Id,
+		"offset": offset,
+	}).Info("Resuming job results upload")
+	return mgr.uploadStream(job, reader, hasher, offset)
+}
+
+// uploadStream uploads the remainder of the stream, starting at the given offset, and
+// then completes the upload.
+func (mgr *JobManager) uploadStream(job *model.Job, reader io.Reader, hasher hash.Hash, offset int64) error {
+	buffer := make([]byte, mgr.agent.Config.StreamChunkSizeBytes)
+	for {
+		count, readErr := io.ReadFull(reader, buffer)
+		if readErr == io.EOF && offset > 0 {
+			break
+		}
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return readErr
+		}
+
+		// Always send at least one chunk, even for an empty stream, to start the upload.
+		chunk := buffer[:count]
+		if err := mgr.retryUpload(job.Id, offset, int64(count), func() error {
+			return mgr.SendStreamChunk(job.Id, offset, chunk)
+		}); err != nil {
+			return err
+		}
+		hasher.Write(chunk)
+		offset += int64(count)
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	err := mgr.retryUpload(job.Id, -1, 0, func() error {
+		return mgr.CompleteStreamUpload(job.Id, offset, checksum)
+	})
+	if err == nil {
+		log.WithFields(log.Fields{
+			"jobId": job.Id,
+			"bytes": offset,
+		}).Info("Uploaded job results")
+	}
+	return err
+}
+
+// retryUpload calls send until it succeeds or the retries are exhausted. Before each
+// retry the server is asked how much of the upload it has received, in case the
+// previous attempt was stored but its response was lost. A negative offset skips this
+// check.
+func (mgr *JobManager) retryUpload(jobId int, offset int64, length int64, send func() error) error {
+	err := send()
+	for attempt := 1; err != nil && attempt <= mgr.agent.Config.StreamChunkRetries; attempt++ {
+		log.WithError(err).WithFields(log.Fields{
+			"jobId":   jobId,
+			"offset":  offset,
+			"attempt": attempt,
+		}).Warn("Failed to upload job results; retrying")
+		time.Sleep(time.Duration(mgr.agent.Config.PollIntervalMs*attempt) * time.Millisecond)
+
+		// A chunk at offset zero restarts the upload, so it can always be resent.
+		if offset > 0 {
+			acknowledged, statusErr := mgr.GetStreamUploadOffset(jobId)
+			if statusErr == nil && acknowledged == offset+length {
+				return nil
+			}
+			if statusErr == nil && acknowledged != offset {
+				return errors.New("Unable to resume job results upload at offset " + strconv.FormatInt(offset, 10) + "; server has " + strconv.FormatInt(acknowledged, 10) + " bytes")
+			}
+		}
+		err = send()
+	}
+	return err
+}
+
+// GetStreamUploadOffset returns the number of bytes of the job's packet stream upload
+// that the server has acknowledged.
+func (mgr *JobManager) GetStreamUploadOffset(jobId int) (int64, error) {
+	resp, err := mgr.agent.Client.SendAuthorizedRequest("GET", "/api/stream/"+strconv.Itoa(jobId)+"/upload", "application/json", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New("Unable to check job results upload (" + strconv.Itoa(resp.StatusCode) + "): " + resp.Status)
+	}
+	upload := model.NewStreamUpload(jobId, 0)
+	err = json.NewDecoder(resp.Body).Decode(upload)
+	return upload.Offset, err
+}
+
+func (mgr *JobManager) SendStreamChunk(jobId int, offset int64, chunk []byte) error {
+	digest := sha256.Sum256(chunk)
+	path := "/api/stream/" + strconv.Itoa(jobId) + "/upload?offset=" + strconv.FormatInt(offset, 10) + "&checksum=" + hex.EncodeToString(digest[:])
+	resp, err := mgr.agent.Client.SendAuthorizedRequest("PUT", path, "application/octet-stream", bytes.NewReader(chunk))
+	return mgr.checkUploadResponse(resp, err)
+}
+
+func (mgr *JobManager) CompleteStreamUpload(jobId int, size int64, checksum string) error {
+	path := "/api/stream/" + strconv.Itoa(jobId) + "/upload/complete?size=" + strconv.FormatInt(size, 10) + "&checksum=" + checksum
+	resp, err := mgr.agent.Client.SendAuthorizedRequest("POST", path, "application/json", nil)
+	return mgr.checkUploadResponse(resp, err)
+}
+
+func (mgr *JobManager) checkUploadResponse(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
 		err = errors.New("Unable to submit job results (" + strconv.Itoa(resp.StatusCode) + "): " + resp.Status)
 	}
 	return err
 }
 
+// deliverOutbox replays the outbox entries that are due, oldest first. An entry that fails
+// is retried after its own delay without holding back the entries after it.
+func (mgr *JobManager) deliverOutbox() {
+	entries, err := mgr.outbox.Entries()
+	if err != nil {
+		log.WithError(err).Error("Unable to read outbox")
+		return
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.NextAttemptTime.After(now) {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"jobId":    entry.Job.Id,
+			"attempts": entry.Attempts,
+		}).Info("Replaying job update from outbox")
+		if err = mgr.deliver(entry); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"jobId":           entry.Job.Id,
+				"nextAttemptTime": entry.NextAttemptTime,
+			}).Warn("Failed to replay job update from outbox")
+		}
+	}
+}
+
+// deliver reports the entry's results and final state to the server. The entry is removed
+// once the server acknowledges it, or once the job has moved on and the server no longer
+// accepts updates for it. Otherwise the next attempt is scheduled with a growing delay.
+func (mgr *JobManager) deliver(entry *OutboxEntry) error {
+	uploaded := entry.Uploaded
+	err := mgr.uploadResult(entry)
+	if err == nil && entry.Uploaded && !uploaded {
+		if saveErr := mgr.outbox.Save(entry); saveErr != nil {
+			log.WithError(saveErr).WithField("jobId", entry.Job.Id).Warn("Unable to save outbox entry")
+		}
+	}
+	if err == nil {
+		err = mgr.UpdateJob(entry.Job)
+	}
+	if err == nil {
+		mgr.outbox.Remove(entry)
+		return nil
+	}
+
+	status, statusErr := mgr.GetJobStatus(entry.Job.Id)
+	current := &model.Job{Id: entry.Job.Id, Status: status}
+	if statusErr == nil && !current.CanProcess() {
+		log.WithError(err).WithFields(log.Fields{
+			"jobId":  entry.Job.Id,
+			"status": status,
+		}).Warn("Job no longer accepts updates; discarding outbox entry")
+		mgr.outbox.Remove(entry)
+		return nil
+	}
+
+	if deferErr := mgr.outbox.Defer(entry, time.Now()); deferErr != nil {
+		log.WithError(deferErr).WithField("jobId", entry.Job.Id).Warn("Unable to save outbox entry")
+	}
+	return err
+}
+
+func (mgr *JobManager) uploadResult(entry *OutboxEntry) error {
+	if !entry.HasResult || entry.Uploaded {
+		return nil
+	}
+	result, err := mgr.outbox.OpenResult(entry)
+	if err == nil {
+		err = mgr.StreamJobResults(entry.Job, result)
+		result.Close()
+	}
+	if err == nil {
+		entry.Uploaded = true
+	}
+	return err
+}
+
 func (mgr *JobManager) UpdateJob(job *model.Job) error {
 	_, err := mgr.agent.Client.SendAuthorizedObject("PUT", "/api/job/", job, nil)
 	return err
@@ -7,15 +7,23 @@ package agent
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/security-onion-solutions/securityonion-soc/config"
 	"github.com/security-onion-solutions/securityonion-soc/model"
 	"github.com/security-onion-solutions/securityonion-soc/web"
 	"github.com/stretchr/testify/assert"
@@ -25,7 +33,7 @@ import (
 // to the stream without panicking.
 type idJobProcessor struct{}
 
-func (jp *idJobProcessor) ProcessJob(job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
+func (jp *idJobProcessor) ProcessJob(ctx context.Context, job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
 	buf := bytes.NewBuffer([]byte{})
 
 	if reader != nil {
@@ -56,7 +64,7 @@ type panicProcessor struct {
 	errorString  string
 }
 
-func (jp *panicProcessor) ProcessJob(job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
+func (jp *panicProcessor) ProcessJob(ctx context.Context, job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
 	jp.processCount++
 	return reader, errors.New(jp.errorString)
 }
@@ -81,7 +89,7 @@ func TestProcessJob(t *testing.T) {
 	}
 
 	// test
-	stream, err := jm.ProcessJob(job)
+	stream, err := jm.ProcessJob(context.Background(), job)
 
 	// verify
 	data, rerr := io.ReadAll(stream)
@@ -105,7 +113,7 @@ func TestProcessJobContinuesIfNoDataAvailable(t *testing.T) {
 	}
 
 	// test
-	_, err := jm.ProcessJob(job)
+	_, err := jm.ProcessJob(context.Background(), job)
 
 	assert.Equal(t, 2, proc.processCount)
 	assert.ErrorContains(t, err, "No data available")
@@ -178,3 +186,462 @@ func TestNoJobReady(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, job)
 }
+
+func newMockedJobManager(body string, statusCode int) *JobManager {
+	client := &web.Client{
+		Auth: &ClientAuthMock{},
+	}
+	client.MockStringResponse(body, statusCode, nil)
+
+	return &JobManager{
+		agent: &Agent{
+			Client: client,
+			Config: &config.AgentConfig{CancelCheckIntervalMs: 1},
+		},
+		node: &model.Node{},
+	}
+}
+
+func TestGetJobStatus(t *testing.T) {
+	jm := newMockedJobManager(`{"id":101,"status":4}`, http.StatusOK)
+	status, err := jm.GetJobStatus(101)
+	assert.NoError(t, err)
+	assert.Equal(t, model.JobStatusCancelRequested, status)
+
+	jm = newMockedJobManager("", http.StatusNotFound)
+	status, err = jm.GetJobStatus(101)
+	assert.NoError(t, err)
+	assert.Equal(t, model.JobStatusDeleted, status)
+
+	jm = newMockedJobManager("", http.StatusInternalServerError)
+	_, err = jm.GetJobStatus(101)
+	assert.Error(t, err)
+}
+
+func TestSendJobProgress(t *testing.T) {
+	jm := newMockedJobManager(`{"id":101,"status":0}`, http.StatusOK)
+	status, err := jm.SendJobProgress(101, model.NewJobProgress())
+	assert.NoError(t, err)
+	assert.Equal(t, model.JobStatusPending, status)
+
+	jm = newMockedJobManager("", http.StatusNotFound)
+	status, err = jm.SendJobProgress(101, model.NewJobProgress())
+	assert.NoError(t, err)
+	assert.Equal(t, model.JobStatusDeleted, status)
+}
+
+func TestWatchJobCancels(t *testing.T) {
+	jm := newMockedJobManager("", http.StatusNotFound)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := jm.watchJob(ctx, cancel, 101, NewJobProgressTracker())
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second * 5):
+		assert.Fail(t, "Job watcher did not cancel deleted job")
+	}
+	assert.Equal(t, model.JobStatusDeleted, <-watcher)
+}
+
+func TestRenewJobLease(t *testing.T) {
+	jm := newMockedJobManager(`{"id":101,"status":0}`, http.StatusOK)
+	status, err := jm.RenewJobLease(101)
+	assert.NoError(t, err)
+	assert.Equal(t, model.JobStatusPending, status)
+
+	jm = newMockedJobManager(`{"id":101,"status":6}`, http.StatusOK)
+	status, err = jm.RenewJobLease(101)
+	assert.NoError(t, err)
+	assert.Equal(t, model.JobStatusFailed, status)
+}
+
+func TestWatchJobCancelsFailedJob(t *testing.T) {
+	jm := newMockedJobManager(`{"id":101,"status":6}`, http.StatusOK)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := jm.watchJob(ctx, cancel, 101, NewJobProgressTracker())
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second * 5):
+		assert.Fail(t, "Job watcher did not cancel failed job")
+	}
+	assert.Equal(t, model.JobStatusFailed, <-watcher)
+}
+
+// uploadServer stores chunked uploads in memory. The response to the chunk at
+// dropOffset is lost the first time, after the chunk has already been stored.
+type uploadServer struct {
+	data       bytes.Buffer
+	dropOffset int64
+	dropped    bool
+	completed  string
+}
+
+func (us *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		w.Write([]byte(`{"jobId":101,"offset":` + strconv.Itoa(us.data.Len()) + `}`))
+	case r.Method == "PUT":
+		offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		chunk, _ := io.ReadAll(r.Body)
+		digest := sha256.Sum256(chunk)
+		if offset != int64(us.data.Len()) && offset != 0 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if hex.EncodeToString(digest[:]) != r.URL.Query().Get("checksum") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if offset == 0 {
+			us.data.Reset()
+		}
+		us.data.Write(chunk)
+		if offset == us.dropOffset && !us.dropped {
+			us.dropped = true
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	case strings.HasSuffix(r.URL.Path, "/complete"):
+		digest := sha256.Sum256(us.data.Bytes())
+		if r.URL.Query().Get("size") != strconv.Itoa(us.data.Len()) || r.URL.Query().Get("checksum") != hex.EncodeToString(digest[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		us.completed = us.data.String()
+	}
+}
+
+func newUploadJobManager(url string) *JobManager {
+	client := web.NewClient(url, false)
+	client.Auth = &ClientAuthMock{}
+	return &JobManager{
+		agent: &Agent{
+			Client: client,
+			Config: &config.AgentConfig{PollIntervalMs: 1, StreamChunkSizeBytes: 4, StreamChunkRetries: 2},
+		},
+		node: &model.Node{},
+	}
+}
+
+func TestStreamJobResultsResumes(t *testing.T) {
+	us := &uploadServer{dropOffset: 4}
+	ts := httptest.NewServer(us)
+	defer ts.Close()
+
+	job := model.NewJob()
+	job.Id = 101
+	jm := newUploadJobManager(ts.URL)
+	err := jm.StreamJobResults(job, io.NopCloser(strings.NewReader("0123456789")))
+	assert.NoError(t, err)
+	assert.True(t, us.dropped)
+	assert.Equal(t, "0123456789", us.completed)
+}
+
+func TestStreamJobResultsEmpty(t *testing.T) {
+	us := &uploadServer{dropOffset: -1}
+	ts := httptest.NewServer(us)
+	defer ts.Close()
+
+	job := model.NewJob()
+	job.Id = 101
+	jm := newUploadJobManager(ts.URL)
+	assert.NoError(t, jm.StreamJobResults(job, io.NopCloser(strings.NewReader(""))))
+}
+
+func openSpooledResult(t *testing.T, data string) *os.File {
+	filename := filepath.Join(t.TempDir(), "101.result")
+	os.WriteFile(filename, []byte(data), 0600)
+	file, err := os.Open(filename)
+	assert.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestStreamJobResultsContinuesEarlierUpload(t *testing.T) {
+	us := &uploadServer{dropOffset: -1}
+	us.data.WriteString("01234567")
+	var chunkOffsets []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			chunkOffsets = append(chunkOffsets, r.URL.Query().Get("offset"))
+		}
+		us.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	job := model.NewJob()
+	job.Id = 101
+	jm := newUploadJobManager(ts.URL)
+	assert.NoError(t, jm.StreamJobResults(job, openSpooledResult(t, "0123456789")))
+	assert.Equal(t, []string{"8"}, chunkOffsets)
+	assert.Equal(t, "0123456789", us.completed)
+}
+
+func TestStreamJobResultsStartsOverMismatchedUpload(t *testing.T) {
+	us := &uploadServer{dropOffset: -1}
+	us.data.WriteString("stale")
+	ts := httptest.NewServer(us)
+	defer ts.Close()
+
+	job := model.NewJob()
+	job.Id = 101
+	jm := newUploadJobManager(ts.URL)
+	assert.NoError(t, jm.StreamJobResults(job, openSpooledResult(t, "0123456789")))
+	assert.Equal(t, "0123456789", us.completed)
+
+	// The server holds more than was spooled
+	us.data.Reset()
+	us.data.WriteString("0123456789abcdef")
+	assert.NoError(t, jm.StreamJobResults(job, openSpooledResult(t, "0123")))
+	assert.Equal(t, "0123", us.completed)
+}
+
+func TestStreamJobResultsGivesUp(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	job := model.NewJob()
+	job.Id = 101
+	jm := newUploadJobManager(ts.URL)
+	err := jm.StreamJobResults(job, io.NopCloser(strings.NewReader("0123456789")))
+	assert.ErrorContains(t, err, "503")
+}
+
+type dispatchServer struct {
+	paths       []string
+	waitMissing bool
+	lock        sync.Mutex
+}
+
+func (ds *dispatchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ds.lock.Lock()
+	ds.paths = append(ds.paths, r.URL.RequestURI())
+	ds.lock.Unlock()
+
+	if r.URL.Path == "/api/node/wait" {
+		if ds.waitMissing {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"id":202}`))
+	}
+}
+
+func newDispatchJobManager(url string, pushEnabled bool) *JobManager {
+	jm := newUploadJobManager(url)
+	jm.agent.Config.JobPushEnabled = pushEnabled
+	jm.agent.Config.JobWaitMs = 25000
+	jm.agent.Config.JobPushRetryMs = 60000
+	jm.agent.Config.PollIntervalMs = 60000
+	return jm
+}
+
+func TestNextJobPush(t *testing.T) {
+	ds := &dispatchServer{}
+	ts := httptest.NewServer(ds)
+	defer ts.Close()
+
+	jm := newDispatchJobManager(ts.URL, true)
+	job, waited, err := jm.nextJob()
+	assert.NoError(t, err)
+	assert.False(t, waited)
+	if assert.NotNil(t, job) {
+		assert.Equal(t, 202, job.Id)
+	}
+	assert.Equal(t, []string{"/api/node/wait?waitMs=25000"}, ds.paths)
+}
+
+func TestNextJobPushFallback(t *testing.T) {
+	ds := &dispatchServer{waitMissing: true}
+	ts := httptest.NewServer(ds)
+	defer ts.Close()
+
+	jm := newDispatchJobManager(ts.URL, true)
+	job, waited, err := jm.nextJob()
+	assert.NoError(t, err)
+	assert.False(t, waited)
+	assert.Nil(t, job)
+	assert.True(t, jm.pushRetryTime.After(time.Now()))
+
+	// The push channel isn't retried until the retry interval has passed
+	jm.nextJob()
+	assert.Equal(t, []string{"/api/node/wait?waitMs=25000", "/api/node", "/api/node"}, ds.paths)
+
+	jm.pushRetryTime = time.Now()
+	jm.nextJob()
+	assert.Equal(t, "/api/node/wait?waitMs=25000", ds.paths[3])
+}
+
+func TestNextJobPollOnly(t *testing.T) {
+	ds := &dispatchServer{}
+	ts := httptest.NewServer(ds)
+	defer ts.Close()
+
+	jm := newDispatchJobManager(ts.URL, false)
+	job, waited, err := jm.nextJob()
+	assert.NoError(t, err)
+	assert.False(t, waited)
+	assert.Nil(t, job)
+	assert.Equal(t, []string{"/api/node"}, ds.paths)
+}
+
+type outboxServer struct {
+	uploads     uploadServer
+	updates     []*model.Job
+	requests    int
+	unavailable bool
+	rejected    bool
+	rejectedId  int
+	status      int
+}
+
+func (obs *outboxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	obs.requests++
+	switch {
+	case obs.unavailable:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case strings.HasPrefix(r.URL.Path, "/api/stream/"):
+		obs.uploads.ServeHTTP(w, r)
+	case r.Method == "PUT":
+		job := model.NewJob()
+		json.NewDecoder(r.Body).Decode(job)
+		if obs.rejected || job.Id == obs.rejectedId {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		obs.updates = append(obs.updates, job)
+	case r.Method == "GET":
+		w.Write([]byte(`{"id":101,"status":` + strconv.Itoa(obs.status) + `}`))
+	}
+}
+
+func newOutboxJobManager(tester *testing.T, url string) *JobManager {
+	jm := newUploadJobManager(url)
+	jm.agent.Config.StreamChunkRetries = 0
+	jm.outbox = NewOutbox(tester.TempDir(), 60000, 60000)
+	return jm
+}
+
+func TestDeliverOutbox(t *testing.T) {
+	server := &outboxServer{unavailable: true, uploads: uploadServer{dropOffset: -1}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	jm := newOutboxJobManager(t, ts.URL)
+	entry, err := jm.outbox.Add(newOutboxJob(101), io.NopCloser(strings.NewReader("0123456789")))
+	assert.NoError(t, err)
+	assert.NoError(t, jm.outbox.Save(entry))
+
+	jm.deliverOutbox()
+	entries, _ := jm.outbox.Entries()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, 1, entries[0].Attempts)
+		assert.False(t, entries[0].Uploaded)
+		assert.True(t, entries[0].NextAttemptTime.After(time.Now()))
+	}
+
+	// Entries aren't replayed until their next attempt is due
+	requests := server.requests
+	jm.deliverOutbox()
+	assert.Equal(t, requests, server.requests)
+
+	server.unavailable = false
+	entries[0].NextAttemptTime = time.Now()
+	assert.NoError(t, jm.outbox.Save(entries[0]))
+	jm.deliverOutbox()
+
+	assert.Equal(t, "0123456789", server.uploads.completed)
+	if assert.Len(t, server.updates, 1) {
+		assert.Equal(t, 101, server.updates[0].Id)
+		assert.Equal(t, model.JobStatusCompleted, server.updates[0].Status)
+		assert.Equal(t, entry.Key, server.updates[0].UpdateKey)
+	}
+	entries, _ = jm.outbox.Entries()
+	assert.Empty(t, entries)
+}
+
+func TestDeliverKeepsUploadProgress(t *testing.T) {
+	server := &outboxServer{rejected: true, status: model.JobStatusPending, uploads: uploadServer{dropOffset: -1}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	jm := newOutboxJobManager(t, ts.URL)
+	entry, _ := jm.outbox.Add(newOutboxJob(101), io.NopCloser(strings.NewReader("0123456789")))
+	assert.NoError(t, jm.outbox.Save(entry))
+
+	assert.Error(t, jm.deliver(entry))
+	entries, _ := jm.outbox.Entries()
+	if assert.Len(t, entries, 1) {
+		assert.True(t, entries[0].Uploaded)
+		assert.Equal(t, 1, entries[0].Attempts)
+	}
+
+	// The results aren't uploaded again when the update is replayed
+	server.uploads.completed = ""
+	server.rejected = false
+	assert.NoError(t, jm.deliver(entries[0]))
+	assert.Empty(t, server.uploads.completed)
+	assert.Len(t, server.updates, 1)
+}
+
+func TestDeliverOutboxContinuesAfterFailure(t *testing.T) {
+	server := &outboxServer{rejectedId: 101, status: model.JobStatusPending}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	jm := newOutboxJobManager(t, ts.URL)
+	rejected, _ := jm.outbox.Add(newOutboxJob(101), nil)
+	assert.NoError(t, jm.outbox.Save(rejected))
+	accepted, _ := jm.outbox.Add(newOutboxJob(102), nil)
+	accepted.CreateTime = rejected.CreateTime.Add(time.Second)
+	assert.NoError(t, jm.outbox.Save(accepted))
+
+	jm.deliverOutbox()
+	if assert.Len(t, server.updates, 1) {
+		assert.Equal(t, 102, server.updates[0].Id)
+	}
+	entries, _ := jm.outbox.Entries()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, 101, entries[0].Job.Id)
+		assert.Equal(t, 1, entries[0].Attempts)
+	}
+}
+
+func TestDeliverKeepsEntryOfQueuedJob(t *testing.T) {
+	for _, status := range []int{model.JobStatusPending, model.JobStatusIncomplete, model.JobStatusCancelRequested} {
+		server := &outboxServer{rejected: true, status: status}
+		ts := httptest.NewServer(server)
+
+		jm := newOutboxJobManager(t, ts.URL)
+		entry, _ := jm.outbox.Add(newOutboxJob(101), nil)
+		assert.NoError(t, jm.outbox.Save(entry))
+
+		assert.Error(t, jm.deliver(entry))
+		entries, _ := jm.outbox.Entries()
+		assert.Len(t, entries, 1)
+		ts.Close()
+	}
+}
+
+func TestDeliverDiscardsStaleEntry(t *testing.T) {
+	server := &outboxServer{rejected: true, status: model.JobStatusCompleted}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	jm := newOutboxJobManager(t, ts.URL)
+	job := newOutboxJob(101)
+	job.Fail(errors.New("late"))
+	entry, _ := jm.outbox.Add(job, nil)
+	assert.NoError(t, jm.outbox.Save(entry))
+
+	assert.NoError(t, jm.deliver(entry))
+	assert.Empty(t, server.updates)
+	entries, _ := jm.outbox.Entries()
+	assert.Empty(t, entries)
+}
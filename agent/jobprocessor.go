@@ -7,6 +7,7 @@
 package agent
 
 import (
+	"context"
 	"io"
 	"time"
 
@@ -14,7 +15,9 @@ import (
 )
 
 type JobProcessor interface {
-	ProcessJob(*model.Job, io.ReadCloser) (io.ReadCloser, error)
+	// ProcessJob should return promptly once the given context is cancelled, which
+	// happens when the job is cancelled or deleted on the server.
+	ProcessJob(context.Context, *model.Job, io.ReadCloser) (io.ReadCloser, error)
 	CleanupJob(*model.Job)
 	GetDataEpoch() time.Time
 }
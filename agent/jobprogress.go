@@ -0,0 +1,78 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+type contextKey string
+
+const ContextKeyJobProgress contextKey = "jobProgress" // *JobProgressTracker
+
+// JobProgressTracker holds the most recent progress reported by the processors of the
+// job currently being processed, until the job manager sends it to the server.
+type JobProgressTracker struct {
+	progress *model.JobProgress
+	changed  bool
+	lock     sync.Mutex
+}
+
+func NewJobProgressTracker() *JobProgressTracker {
+	return &JobProgressTracker{}
+}
+
+func (tracker *JobProgressTracker) Report(progress *model.JobProgress) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	snapshot := *progress
+	snapshot.Estimate(time.Now())
+	tracker.progress = &snapshot
+	tracker.changed = true
+}
+
+// Take returns a copy of the latest progress if it changed since the previous call,
+// otherwise nil.
+func (tracker *JobProgressTracker) Take() *model.JobProgress {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	if !tracker.changed {
+		return nil
+	}
+	tracker.changed = false
+	snapshot := *tracker.progress
+	return &snapshot
+}
+
+// Latest returns a copy of the latest progress, or nil if none was reported.
+func (tracker *JobProgressTracker) Latest() *model.JobProgress {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	if tracker.progress == nil {
+		return nil
+	}
+	snapshot := *tracker.progress
+	return &snapshot
+}
+
+func WithJobProgressTracker(ctx context.Context, tracker *JobProgressTracker) context.Context {
+	return context.WithValue(ctx, ContextKeyJobProgress, tracker)
+}
+
+// ReportJobProgress is used by job processors to publish their progress. It does
+// nothing when the context carries no tracker, such as in unit tests.
+func ReportJobProgress(ctx context.Context, progress *model.JobProgress) {
+	if tracker, ok := ctx.Value(ContextKeyJobProgress).(*JobProgressTracker); ok {
+		tracker.Report(progress)
+	}
+}
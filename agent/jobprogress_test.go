@@ -0,0 +1,40 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobProgressTracker(tester *testing.T) {
+	tracker := NewJobProgressTracker()
+	assert.Nil(tester, tracker.Take())
+	assert.Nil(tester, tracker.Latest())
+
+	ctx := WithJobProgressTracker(context.Background(), tracker)
+	progress := model.NewJobProgress()
+	progress.FilesTotal = 2
+	progress.FilesScanned = 1
+	ReportJobProgress(ctx, progress)
+
+	// Later changes by the processor must not leak into the reported snapshot
+	progress.FilesScanned = 2
+
+	taken := tracker.Take()
+	if assert.NotNil(tester, taken) {
+		assert.Equal(tester, 1, taken.FilesScanned)
+		assert.False(tester, taken.UpdateTime.IsZero())
+	}
+	assert.Nil(tester, tracker.Take())
+	assert.Equal(tester, 1, tracker.Latest().FilesScanned)
+
+	// No tracker in context is a noop
+	ReportJobProgress(context.Background(), progress)
+}
@@ -114,7 +114,7 @@ func (analyze *Analyze) refreshAnalyzers() error {
 	return err
 }
 
-func (analyze *Analyze) ProcessJob(job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
+func (analyze *Analyze) ProcessJob(ctx context.Context, job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
 	var err error
 	if job.GetKind() != "analyze" {
 		log.WithFields(log.Fields{
@@ -152,6 +152,9 @@ func (analyze *Analyze) ProcessJob(job *model.Job, reader io.ReadCloser) (io.Rea
 			var waitGroup sync.WaitGroup
 
 			analyzers := analyze.filterAnalyzers(job)
+			progress := model.NewJobProgress()
+			progress.AnalyzersTotal = len(analyzers)
+			agent.ReportJobProgress(ctx, progress)
 			log.WithFields(log.Fields{
 				"jobId":         job.Id,
 				"parallelLimit": analyze.parallelLimit,
@@ -160,18 +163,24 @@ func (analyze *Analyze) ProcessJob(job *model.Job, reader io.ReadCloser) (io.Rea
 			}).Info("About to run analyzers for job")
 
 			for idx, analyzer := range analyzers {
+				if ctx.Err() != nil {
+					log.WithField("jobId", job.Id).Info("Not starting remaining analyzers due to cancellation")
+					break
+				}
 
 				waitGroup.Add(1)
 				go func(analyzer *model.Analyzer) {
 					defer waitGroup.Done()
 
-					output, err := analyze.startAnalyzer(job, analyzer, input)
+					output, err := analyze.startAnalyzer(ctx, job, analyzer, input)
 					jobResult := analyze.createJobResult(analyzer, input, output, err)
+					resultsLock.Lock()
+					defer resultsLock.Unlock()
 					if jobResult != nil {
-						resultsLock.Lock()
-						defer resultsLock.Unlock()
 						job.Results = append(job.Results, jobResult)
 					}
+					progress.AnalyzersCompleted++
+					agent.ReportJobProgress(ctx, progress)
 
 				}(analyzer)
 
@@ -182,6 +191,7 @@ func (analyze *Analyze) ProcessJob(job *model.Job, reader io.ReadCloser) (io.Rea
 			}
 
 			waitGroup.Wait()
+			err = ctx.Err()
 
 			// sort the results
 			sort.SliceStable(job.Results, func(i, j int) bool {
@@ -284,7 +294,7 @@ func (analyze *Analyze) initAnalyzer(analyzer *model.Analyzer) error {
 	return err
 }
 
-func (analyze *Analyze) startAnalyzer(job *model.Job, analyzer *model.Analyzer, input string) ([]byte, error) {
+func (analyze *Analyze) startAnalyzer(ctx context.Context, job *model.Job, analyzer *model.Analyzer, input string) ([]byte, error) {
 	log.WithFields(log.Fields{
 		"jobId":               job.Id,
 		"analyzersPath":       analyze.analyzersPath,
@@ -292,9 +302,9 @@ func (analyze *Analyze) startAnalyzer(job *model.Job, analyzer *model.Analyzer,
 		"analyzer":            analyzer.Id,
 	}).Info("Executing python analyzer for job")
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(analyze.timeoutMs)*time.Millisecond)
+	execCtx, cancel := context.WithTimeout(ctx, time.Duration(analyze.timeoutMs)*time.Millisecond)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, analyze.analyzerExecutable, "-m", analyzer.GetModule(), input)
+	cmd := exec.CommandContext(execCtx, analyze.analyzerExecutable, "-m", analyzer.GetModule(), input)
 	cmd.Env = append(os.Environ(),
 		"PYTHONPATH="+analyze.analyzersPath+":"+analyzer.GetSitePackagesPath(),
 	)
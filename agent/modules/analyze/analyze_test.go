@@ -7,6 +7,7 @@
 package analyze
 
 import (
+	"context"
 	"os"
 	"os/exec"
 	"testing"
@@ -73,7 +74,7 @@ func TestJobKindMissing(tester *testing.T) {
 
 	// Job kind is not set to analyze, so nothing should execute
 	job := model.NewJob()
-	reader, err := sq.ProcessJob(job, nil)
+	reader, err := sq.ProcessJob(context.Background(), job, nil)
 	assert.Nil(tester, reader)
 	assert.Nil(tester, err)
 	assert.Empty(tester, job.Results)
@@ -87,7 +88,7 @@ func TestJobFilterMissing(tester *testing.T) {
 	// Proper job kind, but no filter set yet
 	job := model.NewJob()
 	job.Kind = "analyze"
-	reader, err := sq.ProcessJob(job, nil)
+	reader, err := sq.ProcessJob(context.Background(), job, nil)
 	assert.Nil(tester, reader)
 	assert.Nil(tester, err)
 	assert.Empty(tester, job.Results)
@@ -102,7 +103,7 @@ func TestAnalyzersMissing(tester *testing.T) {
 	job := model.NewJob()
 	job.Kind = "analyze"
 	job.Filter.Parameters["foo"] = "bar"
-	reader, err := sq.ProcessJob(job, nil)
+	reader, err := sq.ProcessJob(context.Background(), job, nil)
 	assert.Nil(tester, reader)
 	assert.NoError(tester, err)
 	assert.Empty(tester, job.Results)
@@ -123,7 +124,7 @@ func TestAnalyzersExecuted(tester *testing.T) {
 	job := model.NewJob()
 	job.Kind = "analyze"
 	job.Filter.Parameters["artifact"] = " bar\n"
-	reader, err := sq.ProcessJob(job, nil)
+	reader, err := sq.ProcessJob(context.Background(), job, nil)
 	assert.Nil(tester, reader)
 	assert.Nil(tester, err)
 	assert.Len(tester, job.Results, 1)
@@ -133,6 +134,30 @@ func TestAnalyzersExecuted(tester *testing.T) {
 	assert.Equal(tester, "bar", data["input"])
 }
 
+func TestAnalyzersCancelled(tester *testing.T) {
+	init_tmp(tester)
+	defer cleanup_tmp()
+
+	cfg := make(map[string]interface{})
+	cfg["analyzersPath"] = "test-resources"
+	cfg["analyzerExecutable"] = "python3"
+	cfg["sourcePackagesPath"] = "test-source-packages"
+	cfg["sitePackagesPath"] = TMP_DIR
+	sq := NewAnalyze(nil)
+	sq.Init(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	job := model.NewJob()
+	job.Kind = "analyze"
+	job.Filter.Parameters["artifact"] = "bar"
+	reader, err := sq.ProcessJob(ctx, job, nil)
+	assert.Nil(tester, reader)
+	assert.ErrorIs(tester, err, context.Canceled)
+	assert.Empty(tester, job.Results)
+}
+
 func TestCreateResult(tester *testing.T) {
 	cfg := make(map[string]interface{})
 	analyzer := model.NewAnalyzer("test", "path")
@@ -20,6 +20,7 @@ import (
 	"github.com/security-onion-solutions/securityonion-soc/agent"
 	"github.com/security-onion-solutions/securityonion-soc/model"
 	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/security-onion-solutions/securityonion-soc/packet"
 )
 
 const DEFAULT_EXECUTABLE_PATH = "tcpdump"
@@ -73,7 +74,7 @@ func (importer *Importer) IsRunning() bool {
 	return false
 }
 
-func (importer *Importer) ProcessJob(job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
+func (importer *Importer) ProcessJob(ctx context.Context, job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
 	var err error
 	if job.GetKind() != "pcap" {
 		log.WithFields(log.Fields{
@@ -98,9 +99,9 @@ func (importer *Importer) ProcessJob(job *model.Job, reader io.ReadCloser) (io.R
 
 		log.WithField("jobId", job.Id).Info("Processing pcap export for imported PCAP job")
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(importer.timeoutMs)*time.Millisecond)
+		execCtx, cancel := context.WithTimeout(ctx, time.Duration(importer.timeoutMs)*time.Millisecond)
 		defer cancel()
-		cmd := exec.CommandContext(ctx, importer.executablePath, "-r", pcapInputFilepath, "-w", pcapOutputFilepath, query)
+		cmd := exec.CommandContext(execCtx, importer.executablePath, "-r", pcapInputFilepath, "-w", pcapOutputFilepath, query)
 		var output []byte
 		output, err = cmd.CombinedOutput()
 		log.WithFields(log.Fields{
@@ -155,36 +156,8 @@ func (importer *Importer) GetDataEpoch() time.Time {
 	return time.Now()
 }
 
+// buildQuery returns the tcpdump filter for the job, shared with the other packet
+// processors so that every source selects the same packets.
 func (importer *Importer) buildQuery(job *model.Job) string {
-	query := ""
-
-	if len(job.Filter.SrcIp) > 0 {
-		if len(query) > 0 {
-			query = query + " and"
-		}
-		query = fmt.Sprintf("%s host %s", query, job.Filter.SrcIp)
-	}
-
-	if len(job.Filter.DstIp) > 0 {
-		if len(query) > 0 {
-			query = query + " and"
-		}
-		query = fmt.Sprintf("%s host %s", query, job.Filter.DstIp)
-	}
-
-	if job.Filter.SrcPort > 0 {
-		if len(query) > 0 {
-			query = query + " and"
-		}
-		query = fmt.Sprintf("%s port %d", query, job.Filter.SrcPort)
-	}
-
-	if job.Filter.DstPort > 0 {
-		if len(query) > 0 {
-			query = query + " and"
-		}
-		query = fmt.Sprintf("%s port %d", query, job.Filter.DstPort)
-	}
-
-	return query
+	return packet.BuildBpf(job.Filter)
 }
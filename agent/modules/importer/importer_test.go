@@ -46,17 +46,17 @@ func TestBuildQuery(tester *testing.T) {
 
 	job.Filter.SrcIp = "1.2.3.4"
 	query = importer.buildQuery(job)
-	validateQuery(tester, query, " host 1.2.3.4")
-
-	job.Filter.DstIp = "4.3.2.1"
-	query = importer.buildQuery(job)
-	validateQuery(tester, query, " host 1.2.3.4 and host 4.3.2.1")
+	validateQuery(tester, query, "(((src host 1.2.3.4) or (dst host 1.2.3.4))) or (vlan and ((src host 1.2.3.4) or (dst host 1.2.3.4)))")
 
+	job.Filter.DstIp = "4.3.0.0/16"
 	job.Filter.DstPort = 53
+	job.Filter.SrcPort = 33
+	expected := "((src host 1.2.3.4 and src port 33 and dst net 4.3.0.0/16 and dst port 53) or " +
+		"(src net 4.3.0.0/16 and src port 53 and dst host 1.2.3.4 and dst port 33))"
 	query = importer.buildQuery(job)
-	validateQuery(tester, query, " host 1.2.3.4 and host 4.3.2.1 and port 53")
+	validateQuery(tester, query, "("+expected+") or (vlan and "+expected+")")
 
-	job.Filter.SrcPort = 33
+	job.Filter.Vlans = []int{100}
 	query = importer.buildQuery(job)
-	validateQuery(tester, query, " host 1.2.3.4 and host 4.3.2.1 and port 33 and port 53")
+	validateQuery(tester, query, "vlan 100 and "+expected)
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/security-onion-solutions/securityonion-soc/agent"
 	"github.com/security-onion-solutions/securityonion-soc/agent/modules/analyze"
 	"github.com/security-onion-solutions/securityonion-soc/agent/modules/importer"
+	"github.com/security-onion-solutions/securityonion-soc/agent/modules/mtlsauth"
 	"github.com/security-onion-solutions/securityonion-soc/agent/modules/statickeyauth"
 	"github.com/security-onion-solutions/securityonion-soc/agent/modules/stenoquery"
 	"github.com/security-onion-solutions/securityonion-soc/agent/modules/suriquery"
@@ -20,6 +21,7 @@ func BuildModuleMap(agt *agent.Agent) map[string]module.Module {
 	moduleMap := make(map[string]module.Module)
 	moduleMap["analyze"] = analyze.NewAnalyze(agt)
 	moduleMap["importer"] = importer.NewImporter(agt)
+	moduleMap["mtlsauth"] = mtlsauth.NewMtlsAuth(agt)
 	moduleMap["statickeyauth"] = statickeyauth.NewStaticKeyAuth(agt)
 	moduleMap["stenoquery"] = stenoquery.NewStenoQuery(agt)
 	moduleMap["suriquery"] = suriquery.NewSuriQuery(agt)
@@ -0,0 +1,89 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package mtlsauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/security-onion-solutions/securityonion-soc/agent"
+	"github.com/security-onion-solutions/securityonion-soc/module"
+)
+
+const DEFAULT_CA_CERT_PATH = ""
+
+type MtlsAuth struct {
+	config module.ModuleConfig
+	agent  *agent.Agent
+}
+
+func NewMtlsAuth(agt *agent.Agent) *MtlsAuth {
+	return &MtlsAuth{
+		agent: agt,
+	}
+}
+
+func (mtls *MtlsAuth) PrerequisiteModules() []string {
+	return nil
+}
+
+func (mtls *MtlsAuth) Init(cfg module.ModuleConfig) error {
+	mtls.config = cfg
+	certPath, err := module.GetString(cfg, "certPath")
+	if err != nil {
+		return err
+	}
+	keyPath, err := module.GetString(cfg, "keyPath")
+	if err != nil {
+		return err
+	}
+	caCertPath := module.GetStringDefault(cfg, "caCertPath", DEFAULT_CA_CERT_PATH)
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return err
+	}
+
+	var rootCAs *x509.CertPool
+	if caCertPath != "" {
+		caPem, err := os.ReadFile(caCertPath)
+		if err != nil {
+			return err
+		}
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPem) {
+			return errors.New("No CA certificates found in " + caCertPath)
+		}
+	}
+
+	if mtls.agent == nil {
+		return errors.New("Unable to set client auth due to nil agent")
+	}
+	mtls.agent.Client.SetClientCertificate(cert, rootCAs)
+	mtls.agent.Client.Auth = mtls
+	return nil
+}
+
+func (mtls *MtlsAuth) Start() error {
+	return nil
+}
+
+func (mtls *MtlsAuth) Stop() error {
+	return nil
+}
+
+func (mtls *MtlsAuth) IsRunning() bool {
+	return false
+}
+
+// Authorize adds nothing to the request since the client certificate, presented during the TLS
+// handshake, identifies this node to the server.
+func (mtls *MtlsAuth) Authorize(request *http.Request) error {
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package mtlsauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/agent"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func writePem(tester *testing.T, path string, blockType string, der []byte) {
+	assert.NoError(tester, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+}
+
+// issueCert signs a new certificate with the parent, or self-signs it when parent is nil, and
+// writes the certificate and key into dir using the given name.
+func issueCert(tester *testing.T, dir string, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(tester, err)
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(tester, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(tester, err)
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(tester, err)
+	writePem(tester, filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+	writePem(tester, filepath.Join(dir, name+".key"), "PRIVATE KEY", keyDer)
+	return cert, key
+}
+
+func TestInitMtlsAuth(tester *testing.T) {
+	dir := tester.TempDir()
+	ca, caKey := issueCert(tester, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	issueCert(tester, dir, "sensor1", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "sensor1"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	cfg := make(map[string]interface{})
+	auth := NewMtlsAuth(nil)
+	assert.Error(tester, auth.Init(cfg))
+
+	cfg["certPath"] = filepath.Join(dir, "sensor1.crt")
+	assert.Error(tester, auth.Init(cfg))
+
+	cfg["keyPath"] = filepath.Join(dir, "missing.key")
+	assert.Error(tester, auth.Init(cfg))
+
+	cfg["keyPath"] = filepath.Join(dir, "sensor1.key")
+	cfg["caCertPath"] = filepath.Join(dir, "sensor1.key")
+	assert.Error(tester, auth.Init(cfg))
+
+	cfg["caCertPath"] = filepath.Join(dir, "ca.crt")
+	assert.Error(tester, auth.Init(cfg))
+
+	agt := &agent.Agent{Client: web.NewClient("https://localhost", true)}
+	auth = NewMtlsAuth(agt)
+	assert.NoError(tester, auth.Init(cfg))
+	assert.Equal(tester, auth, agt.Client.Auth)
+}
+
+func TestMtlsAuthPresentsCertificate(tester *testing.T) {
+	dir := tester.TempDir()
+	ca, caKey := issueCert(tester, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	issueCert(tester, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "manager"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	issueCert(tester, dir, "sensor1", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "sensor1"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	assert.NoError(tester, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	var presented string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	agt := &agent.Agent{Client: web.NewClient(server.URL, true)}
+	auth := NewMtlsAuth(agt)
+	cfg := map[string]interface{}{
+		"certPath":   filepath.Join(dir, "sensor1.crt"),
+		"keyPath":    filepath.Join(dir, "sensor1.key"),
+		"caCertPath": filepath.Join(dir, "ca.crt"),
+	}
+	if assert.NoError(tester, auth.Init(cfg)) {
+		resp, err := agt.Client.SendAuthorizedRequest("POST", "/api/node", "application/json", nil)
+		if assert.NoError(tester, err) {
+			resp.Body.Close()
+			assert.Equal(tester, http.StatusOK, resp.StatusCode)
+			assert.Equal(tester, "sensor1", presented)
+		}
+	}
+
+	// A configured CA is used to verify the server even when verification is otherwise off
+	issueCert(tester, dir, "other", &x509.Certificate{
+		SerialNumber:          big.NewInt(4),
+		Subject:               pkix.Name{CommonName: "Other CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	agt = &agent.Agent{Client: web.NewClient(server.URL, false)}
+	auth = NewMtlsAuth(agt)
+	cfg["caCertPath"] = filepath.Join(dir, "other.crt")
+	if assert.NoError(tester, auth.Init(cfg)) {
+		_, err = agt.Client.SendAuthorizedRequest("POST", "/api/node", "application/json", nil)
+		assert.ErrorContains(tester, err, "certificate")
+	}
+}
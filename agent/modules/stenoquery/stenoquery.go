@@ -11,9 +11,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/apex/log"
@@ -21,6 +23,7 @@ import (
 	"github.com/security-onion-solutions/securityonion-soc/agent"
 	"github.com/security-onion-solutions/securityonion-soc/model"
 	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/security-onion-solutions/securityonion-soc/packet"
 )
 
 const DEFAULT_EXECUTABLE_PATH = "stenoread"
@@ -87,7 +90,7 @@ func (steno *StenoQuery) getDataLagDate() time.Time {
 	return time.Now().Add(time.Duration(-steno.dataLagMs) * time.Millisecond)
 }
 
-func (steno *StenoQuery) ProcessJob(job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
+func (steno *StenoQuery) ProcessJob(ctx context.Context, job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
 	var err error
 	if job.GetKind() != "pcap" {
 		log.WithFields(log.Fields{
@@ -115,19 +118,25 @@ func (steno *StenoQuery) ProcessJob(job *model.Job, reader io.ReadCloser) (io.Re
 		job.FileExtension = "pcap"
 
 		query := steno.CreateQuery(job)
+		filter := steno.CreateFilter(job)
 
 		pcapFilepath := fmt.Sprintf("%s/%d.%s", steno.pcapOutputPath, job.Id, job.FileExtension)
 
 		log.WithField("jobId", job.Id).Info("Processing pcap export for job")
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(steno.timeoutMs)*time.Millisecond)
+		execCtx, cancel := context.WithTimeout(ctx, time.Duration(steno.timeoutMs)*time.Millisecond)
 		defer cancel()
-		cmd := exec.CommandContext(ctx, steno.executablePath, query, "-w", pcapFilepath)
+		args := []string{query, "-w", pcapFilepath}
+		if filter != "" {
+			args = append(args, filter)
+		}
+		cmd := exec.CommandContext(execCtx, steno.executablePath, args...)
 		var output []byte
 		output, err = cmd.CombinedOutput()
 		log.WithFields(log.Fields{
 			"executablePath": steno.executablePath,
 			"query":          query,
+			"filter":         filter,
 			"output":         string(output),
 			"pcapFilepath":   pcapFilepath,
 			"err":            err,
@@ -170,26 +179,39 @@ func (steno *StenoQuery) CleanupJob(job *model.Job) {
 	os.Remove(pcapOutputFilepath)
 }
 
+// CreateQuery builds the stenographer query for the job. The stenographer query language
+// only understands a subset of BPF, so this narrows the search by time, address, port and
+// common protocols; the precise filter from CreateFilter is then applied by tcpdump.
 func (steno *StenoQuery) CreateQuery(job *model.Job) string {
 	beginTime := job.Filter.BeginTime.Format(time.RFC3339)
 	endTime := job.Filter.EndTime.Format(time.RFC3339)
 
 	query := fmt.Sprintf("before %s and after %s", endTime, beginTime)
 
-	if len(job.Filter.Protocol) > 0 {
-		query = fmt.Sprintf("%s and %s", query, job.Filter.Protocol)
+	srcIp := strings.TrimSpace(job.Filter.SrcIp)
+	dstIp := strings.TrimSpace(job.Filter.DstIp)
+	protocol := strings.ToLower(job.Filter.Protocol)
+
+	switch protocol {
+	case model.PROTOCOL_TCP, model.PROTOCOL_UDP:
+		query = fmt.Sprintf("%s and %s", query, protocol)
+	case model.PROTOCOL_ICMP:
+		// Stenographer's icmp keyword does not include ICMPv6
+		if !strings.Contains(srcIp, ":") && !strings.Contains(dstIp, ":") {
+			query = fmt.Sprintf("%s and %s", query, protocol)
+		}
 	}
 
-	if len(job.Filter.SrcIp) > 0 {
-		query = fmt.Sprintf("%s and host %s", query, job.Filter.SrcIp)
+	if len(srcIp) > 0 {
+		query = fmt.Sprintf("%s and %s", query, stenoAddress(srcIp))
 	}
 
-	if len(job.Filter.DstIp) > 0 {
-		query = fmt.Sprintf("%s and host %s", query, job.Filter.DstIp)
+	if len(dstIp) > 0 {
+		query = fmt.Sprintf("%s and %s", query, stenoAddress(dstIp))
 	}
 
 	// Some legacy jobs won't have the protocol provided
-	if job.Filter.Protocol != model.PROTOCOL_ICMP {
+	if protocol != model.PROTOCOL_ICMP {
 		if job.Filter.SrcPort > 0 {
 			query = fmt.Sprintf("%s and port %d", query, job.Filter.SrcPort)
 		}
@@ -202,6 +224,22 @@ func (steno *StenoQuery) CreateQuery(job *model.Job) string {
 	return query
 }
 
+// CreateFilter returns the BPF expression handed to tcpdump by stenoread to trim the
+// stenographer results down to exactly the packets selected by the job filter.
+func (steno *StenoQuery) CreateFilter(job *model.Job) string {
+	return packet.BuildBpf(job.Filter)
+}
+
+func stenoAddress(address string) string {
+	if strings.Contains(address, "/") {
+		if _, network, err := net.ParseCIDR(address); err == nil {
+			return "net " + network.String()
+		}
+		return "net " + address
+	}
+	return "host " + address
+}
+
 func (steno *StenoQuery) GetDataEpoch() time.Time {
 	now := time.Now()
 	refreshDuration := time.Duration(steno.epochRefreshMs) * time.Millisecond
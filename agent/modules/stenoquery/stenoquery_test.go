@@ -105,3 +105,35 @@ func TestCreateQueryIcmp(tester *testing.T) {
 	query = sq.CreateQuery(job)
 	assert.Equal(tester, expectedQuery, query) // port ignored for icmp
 }
+
+func TestCreateQueryCidrAndIpv6(tester *testing.T) {
+	sq := NewStenoQuery(nil)
+
+	job := model.NewJob()
+	job.Filter.BeginTime, _ = time.Parse(time.RFC3339, "2006-01-02T15:05:05Z")
+	job.Filter.EndTime, _ = time.Parse(time.RFC3339, "2006-01-02T15:06:05Z")
+	job.Filter.Protocol = "UDP"
+	job.Filter.SrcIp = "10.1.2.3/16"
+	job.Filter.DstIp = "2001:db8::1"
+	expectedQuery := "before 2006-01-02T15:06:05Z and after 2006-01-02T15:05:05Z and udp and net 10.1.0.0/16 and host 2001:db8::1"
+	assert.Equal(tester, expectedQuery, sq.CreateQuery(job))
+
+	job.Filter.Protocol = model.PROTOCOL_ICMP
+	expectedQuery = "before 2006-01-02T15:06:05Z and after 2006-01-02T15:05:05Z and net 10.1.0.0/16 and host 2001:db8::1"
+	assert.Equal(tester, expectedQuery, sq.CreateQuery(job)) // icmp6 is left to the tcpdump filter
+
+	job.Filter.Protocol = "sctp"
+	assert.Equal(tester, expectedQuery, sq.CreateQuery(job)) // unsupported by stenographer
+}
+
+func TestCreateFilter(tester *testing.T) {
+	sq := NewStenoQuery(nil)
+
+	job := model.NewJob()
+	assert.Equal(tester, "", sq.CreateFilter(job))
+
+	job.Filter.SrcIp = "1.2.3.4"
+	job.Filter.Vlans = []int{5}
+	job.Filter.Bpf = "tcp[13] = 2"
+	assert.Equal(tester, "vlan 5 and ((src host 1.2.3.4) or (dst host 1.2.3.4)) and (tcp[13] = 2)", sq.CreateFilter(job))
+}
@@ -0,0 +1,62 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package suriquery
+
+import (
+	"hash/fnv"
+)
+
+// bloomFilter is a fixed size set membership summary. It can report false positives but
+// never false negatives, so a miss proves that the key was never added.
+type bloomFilter struct {
+	Bits   []byte `json:"bits"`
+	Hashes int    `json:"hashes"`
+}
+
+func newBloomFilter(bits int, hashes int) *bloomFilter {
+	return &bloomFilter{
+		Bits:   make([]byte, (bits+7)/8),
+		Hashes: hashes,
+	}
+}
+
+// locations derives the bit positions for the key from a single 64-bit hash, using the
+// two halves of the hash to simulate the remaining hash functions.
+func (filter *bloomFilter) locations(key string) []uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	sum := hash.Sum64()
+	first := sum & 0xffffffff
+	second := sum >> 32
+
+	size := uint64(len(filter.Bits)) * 8
+	locations := make([]uint64, filter.Hashes)
+	for idx := range locations {
+		locations[idx] = (first + uint64(idx)*second) % size
+	}
+	return locations
+}
+
+func (filter *bloomFilter) add(key string) {
+	if len(filter.Bits) == 0 {
+		return
+	}
+	for _, location := range filter.locations(key) {
+		filter.Bits[location/8] |= 1 << (location % 8)
+	}
+}
+
+func (filter *bloomFilter) contains(key string) bool {
+	if len(filter.Bits) == 0 {
+		return true
+	}
+	for _, location := range filter.locations(key) {
+		if filter.Bits[location/8]&(1<<(location%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
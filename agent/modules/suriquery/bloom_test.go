@@ -0,0 +1,48 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package suriquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(tester *testing.T) {
+	filter := newBloomFilter(65536, 4)
+	assert.Len(tester, filter.Bits, 8192)
+	assert.False(tester, filter.contains("10.0.0.1"))
+
+	for idx := 0; idx < 1000; idx++ {
+		filter.add(fmt.Sprintf("10.0.%d.%d", idx/256, idx%256))
+	}
+	for idx := 0; idx < 1000; idx++ {
+		assert.True(tester, filter.contains(fmt.Sprintf("10.0.%d.%d", idx/256, idx%256)))
+	}
+
+	falsePositives := 0
+	for idx := 0; idx < 1000; idx++ {
+		if filter.contains(fmt.Sprintf("192.168.%d.%d", idx/256, idx%256)) {
+			falsePositives++
+		}
+	}
+	assert.Less(tester, falsePositives, 10)
+
+	content, err := json.Marshal(filter)
+	assert.NoError(tester, err)
+	decoded := &bloomFilter{}
+	assert.NoError(tester, json.Unmarshal(content, decoded))
+	assert.Equal(tester, filter, decoded)
+	assert.True(tester, decoded.contains("10.0.3.231"))
+}
+
+func TestBloomFilterEmpty(tester *testing.T) {
+	filter := newBloomFilter(0, 4)
+	filter.add("10.0.0.1")
+	assert.True(tester, filter.contains("10.0.0.2"))
+}
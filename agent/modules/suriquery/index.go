@@ -0,0 +1,385 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package suriquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/util"
+)
+
+const DEFAULT_INDEX_ENABLED = true
+const DEFAULT_INDEX_DIR_NAME = ".soc-index"
+const DEFAULT_INDEX_REFRESH_MS = 60000
+const DEFAULT_INDEX_BLOOM_BITS = 65536
+
+const INDEX_ENTRY_SUFFIX = ".json"
+const PCAP_FILE_HEADER_LENGTH = 24
+
+const indexBloomHashes = 4
+
+// pcapIndexEntry summarizes a single Suricata PCAP file. Offset is the position, in
+// decompressed bytes, just past the last complete packet record that was indexed, so that
+// a file which is still being written can be indexed incrementally as it grows.
+type pcapIndexEntry struct {
+	File       string       `json:"file"`
+	Size       int64        `json:"size"`
+	ModTime    time.Time    `json:"modTime"`
+	CreateTime time.Time    `json:"createTime"`
+	Offset     int64        `json:"offset"`
+	Summarized bool         `json:"summarized"`
+	Packets    int          `json:"packets"`
+	StartTime  time.Time    `json:"startTime"`
+	EndTime    time.Time    `json:"endTime"`
+	Protocols  []int        `json:"protocols"`
+	Hosts      *bloomFilter `json:"hosts"`
+}
+
+// pcapIndex tracks the time span and hosts of each Suricata PCAP file so that jobs only
+// read the files that could contain matching packets. Each entry is persisted as its own
+// file beneath the index path so that only the entries of changed PCAP files are rewritten.
+type pcapIndex struct {
+	inputPath string
+	path      string
+	bloomBits int
+	entries   map[string]*pcapIndexEntry
+	loaded    bool
+	lock      sync.Mutex
+}
+
+func newPcapIndex(inputPath string, path string, bloomBits int) *pcapIndex {
+	return &pcapIndex{
+		inputPath: inputPath,
+		path:      path,
+		bloomBits: bloomBits,
+		entries:   make(map[string]*pcapIndexEntry),
+	}
+}
+
+func (index *pcapIndex) entryFilename(file string) string {
+	return filepath.Join(index.path, strings.ReplaceAll(file, string(filepath.Separator), "_")+INDEX_ENTRY_SUFFIX)
+}
+
+func (index *pcapIndex) load() {
+	files, err := os.ReadDir(index.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("indexPath", index.path).Warn("Unable to read Suricata PCAP index")
+		}
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), INDEX_ENTRY_SUFFIX) {
+			continue
+		}
+		filename := filepath.Join(index.path, file.Name())
+		content, err := os.ReadFile(filename)
+		entry := &pcapIndexEntry{}
+		if err == nil {
+			err = json.Unmarshal(content, entry)
+		}
+		if err != nil || entry.File == "" {
+			log.WithError(err).WithField("indexFilename", filename).Warn("Ignoring unreadable Suricata PCAP index entry")
+			continue
+		}
+		index.entries[entry.File] = entry
+	}
+	log.WithFields(log.Fields{
+		"indexPath":  index.path,
+		"entryCount": len(index.entries),
+	}).Info("Loaded Suricata PCAP index")
+}
+
+func (index *pcapIndex) save(entry *pcapIndexEntry) {
+	content, err := json.Marshal(entry)
+	if err == nil {
+		_, err = util.WriteFileAtomically(index.entryFilename(entry.File), bytes.NewReader(content))
+	}
+	if err != nil {
+		log.WithError(err).WithField("pcapFile", entry.File).Warn("Unable to save Suricata PCAP index entry")
+	}
+}
+
+// refresh brings the index up to date with the PCAP files currently on disk. Unchanged
+// files are only examined with a stat, grown files are read from where the previous
+// refresh stopped, and entries of deleted files are discarded.
+func (index *pcapIndex) refresh() error {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	if !index.loaded {
+		index.load()
+		index.loaded = true
+	}
+
+	startTime := time.Now()
+	seen := make(map[string]bool)
+	updatedCount := 0
+	err := filepath.Walk(index.inputPath, func(path string, fileinfo os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if fileinfo.IsDir() {
+			if path == index.path {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		createTime, err := parsePcapCreateTime(path)
+		if err != nil {
+			return nil
+		}
+
+		if trimCompressionSuffix(path) == path {
+			// Skip files that were temporarily decompressed by a job in progress
+			for _, suffix := range suriCompressionSuffixes {
+				if _, statErr := os.Stat(path + suffix); statErr == nil {
+					return nil
+				}
+			}
+		}
+
+		file, err := filepath.Rel(index.inputPath, path)
+		if err != nil {
+			return nil
+		}
+		seen[file] = true
+
+		entry := index.entries[file]
+		if entry != nil && entry.Size == fileinfo.Size() && entry.ModTime.Equal(fileinfo.ModTime()) {
+			return nil
+		}
+		if entry == nil || !entry.Summarized || fileinfo.Size() < entry.Size || !entry.CreateTime.Equal(createTime) {
+			entry = &pcapIndexEntry{
+				File:       file,
+				CreateTime: createTime,
+				Protocols:  make([]int, 0),
+				Hosts:      newBloomFilter(index.bloomBits, indexBloomHashes),
+			}
+		}
+		entry.Size = fileinfo.Size()
+		entry.ModTime = fileinfo.ModTime()
+
+		scanErr := index.scan(entry, path)
+		if scanErr != nil {
+			log.WithError(scanErr).WithField("pcapPath", path).Debug("Unable to summarize Suricata PCAP file; it will be selected by its file times")
+		}
+		index.entries[file] = entry
+		index.save(entry)
+		updatedCount++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	removedCount := 0
+	for file := range index.entries {
+		if !seen[file] {
+			delete(index.entries, file)
+			os.Remove(index.entryFilename(file))
+			removedCount++
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"entryCount":   len(index.entries),
+		"updatedCount": updatedCount,
+		"removedCount": removedCount,
+		"elapsedMs":    time.Since(startTime).Milliseconds(),
+	}).Debug("Refreshed Suricata PCAP index")
+	return nil
+}
+
+// scan reads the packets appended to the file since the entry's offset. A file that
+// isn't a readable classic PCAP is left unsummarized so that it is never ruled out by
+// its contents.
+func (index *pcapIndex) scan(entry *pcapIndexEntry, path string) error {
+	entry.Summarized = false
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	input, err := openDecompressor(path, file)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	header := make([]byte, PCAP_FILE_HEADER_LENGTH)
+	if _, err = io.ReadFull(input, header); err != nil {
+		return err
+	}
+	if entry.Offset > PCAP_FILE_HEADER_LENGTH {
+		if trimCompressionSuffix(path) == path {
+			_, err = file.Seek(entry.Offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, input, entry.Offset-PCAP_FILE_HEADER_LENGTH)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		entry.Offset = PCAP_FILE_HEADER_LENGTH
+	}
+
+	reader, err := pcapgo.NewReader(io.MultiReader(bytes.NewReader(header), input))
+	if err != nil {
+		return err
+	}
+
+	for {
+		data, ci, readErr := reader.ReadPacketData()
+		if readErr == io.EOF || (readErr != nil && strings.Contains(fmt.Sprint(readErr), "unexpected EOF")) {
+			// The remainder of a file still being written is picked up by a later refresh
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+		entry.add(gopacket.NewPacket(data, reader.LinkType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true}), ci.Timestamp)
+		entry.Offset += int64(PCAP_RECORD_HEADER_LENGTH + len(data))
+	}
+
+	entry.Summarized = true
+	return nil
+}
+
+func (entry *pcapIndexEntry) add(pcapPacket gopacket.Packet, timestamp time.Time) {
+	timestamp = timestamp.UTC()
+	if entry.Packets == 0 || timestamp.Before(entry.StartTime) {
+		entry.StartTime = timestamp
+	}
+	if entry.Packets == 0 || timestamp.After(entry.EndTime) {
+		entry.EndTime = timestamp
+	}
+	entry.Packets++
+
+	switch network := pcapPacket.NetworkLayer().(type) {
+	case *layers.IPv4:
+		entry.addHost(network.SrcIP)
+		entry.addHost(network.DstIP)
+		entry.addProtocol(int(network.Protocol))
+	case *layers.IPv6:
+		entry.addHost(network.SrcIP)
+		entry.addHost(network.DstIP)
+		entry.addProtocol(int(network.NextHeader))
+	}
+
+	// BPF host expressions also match ARP packets
+	if arp, ok := pcapPacket.Layer(layers.LayerTypeARP).(*layers.ARP); ok {
+		entry.addHost(net.IP(arp.SourceProtAddress))
+		entry.addHost(net.IP(arp.DstProtAddress))
+	}
+}
+
+func (entry *pcapIndexEntry) addHost(ip net.IP) {
+	entry.Hosts.add(ip.String())
+}
+
+func (entry *pcapIndexEntry) addProtocol(protocol int) {
+	if !slices.Contains(entry.Protocols, protocol) {
+		entry.Protocols = append(entry.Protocols, protocol)
+		slices.Sort(entry.Protocols)
+	}
+}
+
+func (entry *pcapIndexEntry) isInTimeRange(start time.Time, stop time.Time) bool {
+	if !entry.Summarized || entry.Packets == 0 {
+		return isEligibleByFileTimes(entry.CreateTime, entry.ModTime, start, stop)
+	}
+	return !entry.EndTime.Before(start) && !entry.StartTime.After(stop)
+}
+
+// mayMatch reports whether the file could contain packets matching the filter's hosts
+// and protocol. Address ranges and raw BPF expressions are not summarized, and so never
+// rule out a file.
+func (entry *pcapIndexEntry) mayMatch(filter *model.Filter) bool {
+	if !entry.Summarized || filter == nil {
+		return true
+	}
+
+	for _, address := range []string{filter.SrcIp, filter.DstIp} {
+		ip := net.ParseIP(strings.TrimSpace(address))
+		if ip != nil && !entry.Hosts.contains(ip.String()) {
+			return false
+		}
+	}
+
+	var protocols []int
+	switch strings.ToLower(strings.TrimSpace(filter.Protocol)) {
+	case model.PROTOCOL_TCP:
+		protocols = []int{int(layers.IPProtocolTCP)}
+	case model.PROTOCOL_UDP:
+		protocols = []int{int(layers.IPProtocolUDP)}
+	case model.PROTOCOL_ICMP:
+		protocols = []int{int(layers.IPProtocolICMPv4), int(layers.IPProtocolICMPv6)}
+	default:
+		return true
+	}
+	for _, protocol := range protocols {
+		if slices.Contains(entry.Protocols, protocol) {
+			return true
+		}
+	}
+	return false
+}
+
+// findFiles refreshes the index and returns the PCAP files that overlap the filter's time
+// range and may contain its hosts, ordered by path.
+func (index *pcapIndex) findFiles(filter *model.Filter) ([]string, error) {
+	if err := index.refresh(); err != nil {
+		return nil, err
+	}
+
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	files := make([]string, 0)
+	skippedCount := 0
+	for file, entry := range index.entries {
+		if entry.isInTimeRange(filter.BeginTime, filter.EndTime) && entry.mayMatch(filter) {
+			files = append(files, file)
+		} else {
+			skippedCount++
+		}
+	}
+	slices.Sort(files)
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, filepath.Join(index.inputPath, file))
+	}
+
+	log.WithFields(log.Fields{
+		"selectedCount": len(paths),
+		"skippedCount":  skippedCount,
+		"startTime":     filter.BeginTime,
+		"stopTime":      filter.EndTime,
+	}).Debug("Selected Suricata PCAP files from index")
+	return paths, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package suriquery
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPcapFilename = "test_resources/3/so-pcap.1575817346"
+
+func initIndexTest(tester *testing.T, inputPath string) *SuriQuery {
+	cfg := make(map[string]interface{})
+	cfg["pcapInputPath"] = inputPath
+	if inputPath == "test_resources" {
+		cfg["indexPath"] = tester.TempDir()
+	}
+	sq := NewSuriQuery(nil)
+	sq.Init(cfg)
+	return sq
+}
+
+func newIndexFilter(begin string, end string) *model.Filter {
+	filter := model.NewFilter()
+	filter.BeginTime, _ = time.Parse(time.RFC3339, begin)
+	filter.EndTime, _ = time.Parse(time.RFC3339, end)
+	return filter
+}
+
+func TestInitSuriQueryIndex(tester *testing.T) {
+	sq := initIndexTest(tester, "/some/path")
+	assert.NotNil(tester, sq.index)
+	assert.Equal(tester, "/some/path/"+DEFAULT_INDEX_DIR_NAME, sq.indexPath)
+	assert.Equal(tester, DEFAULT_INDEX_REFRESH_MS, sq.indexRefreshMs)
+	assert.Equal(tester, DEFAULT_INDEX_BLOOM_BITS, sq.index.bloomBits)
+
+	cfg := make(map[string]interface{})
+	cfg["indexEnabled"] = false
+	sq = NewSuriQuery(nil)
+	sq.Init(cfg)
+	assert.Nil(tester, sq.index)
+}
+
+func TestIndexFindFilesByTime(tester *testing.T) {
+	sq := initIndexTest(tester, "test_resources")
+
+	files := sq.findFiles(newIndexFilter("2019-12-08T00:00:00Z", "2019-12-08T23:59:59Z"))
+	assert.Equal(tester, []string{"test_resources/1/so-pcap.1575817346.lz4", "test_resources/3/so-pcap.1575817346"}, files)
+
+	// Unlike the file times, the packet times rule out recently modified files
+	files = sq.findFiles(newIndexFilter("2024-02-05T00:00:00Z", "2099-02-06T00:00:00Z"))
+	assert.Empty(tester, files)
+
+	entry := sq.index.entries["3/so-pcap.1575817346"]
+	if assert.NotNil(tester, entry) {
+		assert.True(tester, entry.Summarized)
+		assert.Equal(tester, 22, entry.Packets)
+		assert.Equal(tester, int64(14918), entry.Offset)
+		assert.Equal(tester, time.Unix(1575817346, 0).UTC(), entry.StartTime.Truncate(time.Second))
+		assert.Equal(tester, []int{6}, entry.Protocols)
+	}
+	assert.Equal(tester, 22, sq.index.entries["1/so-pcap.1575817346.lz4"].Packets)
+
+	// Empty files are still being created and are selected by their file times
+	assert.False(tester, sq.index.entries["3/so-pcap.9900000000"].Summarized)
+	assert.NotContains(tester, sq.index.entries, "1/nonconforming-file.123four")
+
+	indexFiles, _ := filepath.Glob(filepath.Join(sq.indexPath, "*"+INDEX_ENTRY_SUFFIX))
+	assert.Len(tester, indexFiles, 3)
+}
+
+func TestIndexFindFilesByHost(tester *testing.T) {
+	sq := initIndexTest(tester, "test_resources")
+
+	filter := newIndexFilter("2019-12-08T00:00:00Z", "2019-12-08T23:59:59Z")
+	filter.SrcIp = "185.47.63.113"
+	filter.DstIp = "176.126.243.198"
+	filter.Protocol = "tcp"
+	assert.Len(tester, sq.findFiles(filter), 2)
+
+	filter.DstIp = "10.1.2.3"
+	assert.Empty(tester, sq.findFiles(filter))
+
+	filter.DstIp = "10.0.0.0/8"
+	assert.Len(tester, sq.findFiles(filter), 2)
+
+	filter.Protocol = "udp"
+	assert.Empty(tester, sq.findFiles(filter))
+
+	filter.Protocol = ""
+	filter.SrcIp = "2001:db8::1"
+	assert.Empty(tester, sq.findFiles(filter))
+}
+
+func TestIndexRefreshIncremental(tester *testing.T) {
+	content, err := os.ReadFile(testPcapFilename)
+	assert.NoError(tester, err)
+
+	// Cut the capture part way through its sixth packet record
+	offset := PCAP_FILE_HEADER_LENGTH
+	for idx := 0; idx < 5; idx++ {
+		offset += PCAP_RECORD_HEADER_LENGTH + int(binary.LittleEndian.Uint32(content[offset+8:]))
+	}
+
+	inputPath := tester.TempDir()
+	pcapFilename := filepath.Join(inputPath, "so-pcap.1575817346")
+	assert.NoError(tester, os.WriteFile(pcapFilename, content[:offset+10], 0644))
+	// Decompressed copies of files being read by a job are not indexed
+	assert.NoError(tester, os.WriteFile(filepath.Join(inputPath, "so-pcap.1575810000"), content, 0644))
+	assert.NoError(tester, os.WriteFile(filepath.Join(inputPath, "so-pcap.1575810000.lz4"), nil, 0644))
+
+	sq := initIndexTest(tester, inputPath)
+	assert.NoError(tester, sq.index.refresh())
+	entry := sq.index.entries["so-pcap.1575817346"]
+	if assert.NotNil(tester, entry) {
+		assert.Equal(tester, 5, entry.Packets)
+		assert.Equal(tester, int64(offset), entry.Offset)
+	}
+	assert.NotContains(tester, sq.index.entries, "so-pcap.1575810000")
+	assert.Contains(tester, sq.index.entries, "so-pcap.1575810000.lz4")
+
+	assert.NoError(tester, os.WriteFile(pcapFilename, content, 0644))
+	assert.NoError(tester, sq.index.refresh())
+	entry = sq.index.entries["so-pcap.1575817346"]
+	assert.Equal(tester, 22, entry.Packets)
+	assert.Equal(tester, int64(len(content)), entry.Offset)
+
+	// A new index resumes from the saved entries
+	reloaded := initIndexTest(tester, inputPath)
+	assert.NoError(tester, reloaded.index.refresh())
+	assert.Equal(tester, 22, reloaded.index.entries["so-pcap.1575817346"].Packets)
+	assert.True(tester, reloaded.index.entries["so-pcap.1575817346"].Hosts.contains("185.47.63.113"))
+
+	// The index is kept beside the PCAP files without being mistaken for one
+	assert.Equal(tester, time.Unix(1575810000, 0).UTC(), reloaded.GetDataEpoch())
+	assert.Len(tester, reloaded.findFilesInTimeRange(time.Unix(0, 0), time.Now().Add(time.Hour)), 3)
+
+	assert.NoError(tester, os.Remove(pcapFilename))
+	assert.NoError(tester, reloaded.index.refresh())
+	assert.NotContains(tester, reloaded.index.entries, "so-pcap.1575817346")
+	_, err = os.Stat(reloaded.index.entryFilename("so-pcap.1575817346"))
+	assert.True(tester, os.IsNotExist(err))
+}
+
+func TestIndexRefresher(tester *testing.T) {
+	cfg := make(map[string]interface{})
+	cfg["pcapInputPath"] = tester.TempDir()
+	cfg["indexRefreshMs"] = float64(10)
+	sq := NewSuriQuery(nil)
+	sq.Init(cfg)
+
+	assert.NoError(tester, sq.Start())
+	assert.True(tester, sq.IsRunning())
+	assert.NoError(tester, sq.Stop())
+	assert.Eventually(tester, func() bool { return !sq.IsRunning() }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,329 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package suriquery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/google/gopacket"
+	"github.com/security-onion-solutions/securityonion-soc/agent"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/packet"
+)
+
+const DEFAULT_SCAN_CONCURRENCY = 4
+const DEFAULT_SCAN_MAX_MEMORY_BYTES = 536870912
+const DEFAULT_SCAN_TEMP_PATH = ""
+
+const SCAN_TEMP_PATTERN = "suriquery-*.pcap"
+
+type scanFile struct {
+	idx              int
+	path             string
+	decompressedPath string
+	err              error
+}
+
+// scanResult holds the packets of one PCAP file that matched the job filter, as a time
+// ordered PCAP stream. The stream is kept in memory until the scan's memory limit is
+// reached, after which results are spilled to temporary files instead.
+type scanResult struct {
+	data     []byte
+	filename string
+	count    int
+	size     int64
+}
+
+type pcapScan struct {
+	suri        *SuriQuery
+	ctx         context.Context
+	filter      *model.Filter
+	progress    *model.JobProgress
+	results     []*scanResult
+	memoryBytes int64
+	spilled     bool
+	lock        sync.Mutex
+}
+
+// tempFileReader removes the temporary file holding the merged packets once the job
+// manager has finished reading it.
+type tempFileReader struct {
+	*os.File
+}
+
+func (reader *tempFileReader) Close() error {
+	err := reader.File.Close()
+	os.Remove(reader.Name())
+	return err
+}
+
+// streamPacketsInPcaps scans the files on up to scanConcurrency goroutines, with the
+// next files being decompressed while the current ones are parsed. The matching packets
+// of each file are then merged into a single time ordered PCAP stream.
+func (suri *SuriQuery) streamPacketsInPcaps(ctx context.Context, paths []string, filter *model.Filter) (io.ReadCloser, int, error) {
+	scan := &pcapScan{
+		suri:     suri,
+		ctx:      ctx,
+		filter:   filter,
+		progress: model.NewJobProgress(),
+		results:  make([]*scanResult, len(paths)),
+	}
+	scan.progress.FilesTotal = len(paths)
+	agent.ReportJobProgress(ctx, scan.progress)
+	defer scan.cleanup()
+
+	concurrency := max(suri.scanConcurrency, 1)
+	pending := make(chan int)
+	decompressed := make(chan *scanFile, concurrency)
+
+	go func() {
+		for idx := range paths {
+			pending <- idx
+		}
+		close(pending)
+	}()
+
+	var decompressors sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		decompressors.Add(1)
+		go func() {
+			defer decompressors.Done()
+			for idx := range pending {
+				file := &scanFile{idx: idx, path: paths[idx]}
+				if ctx.Err() == nil {
+					file.decompressedPath, file.err = suri.decompress(file.path)
+				}
+				decompressed <- file
+			}
+		}()
+	}
+	go func() {
+		decompressors.Wait()
+		close(decompressed)
+	}()
+
+	var scanners sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		scanners.Add(1)
+		go func() {
+			defer scanners.Done()
+			for file := range decompressed {
+				scan.scanFile(file)
+			}
+		}()
+	}
+	scanners.Wait()
+
+	if ctx.Err() != nil {
+		log.WithField("fileCount", len(paths)).Info("Stopping Suricata PCAP scan due to cancellation")
+		return nil, 0, ctx.Err()
+	}
+
+	return scan.merge()
+}
+
+func (scan *pcapScan) scanFile(file *scanFile) {
+	if file.decompressedPath != "" && file.decompressedPath != file.path {
+		defer func() {
+			rerr := os.Remove(file.decompressedPath)
+			if rerr != nil {
+				log.WithError(rerr).WithField("pcapPath", file.decompressedPath).Error("Failed to remove decompressed PCAP file")
+			}
+		}()
+	}
+
+	if scan.ctx.Err() != nil {
+		return
+	}
+
+	if file.err != nil {
+		log.WithError(file.err).WithField("pcapPath", file.path).Error("Failed to decompress PCAP file")
+		scan.fileScanned(nil)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"pcapPath": file.path,
+	}).Debug("Analyzing Suricata PCAP file")
+
+	packets, perr := packet.ParseRawPcap(scan.ctx, file.decompressedPath, scan.suri.pcapMaxCount, scan.filter)
+	if perr != nil {
+		log.WithError(perr).WithField("pcapPath", file.decompressedPath).Error("Failed to parse PCAP file")
+	}
+
+	var result *scanResult
+	if len(packets) > 0 {
+		log.WithFields(log.Fields{
+			"pcapPath":    file.decompressedPath,
+			"packetCount": len(packets),
+		}).Info("Found matching Suricata packets")
+
+		var serr error
+		result, serr = scan.store(packets)
+		if serr != nil {
+			log.WithError(serr).WithField("pcapPath", file.decompressedPath).Error("Failed to store matching Suricata packets")
+		}
+	} else {
+		log.WithFields(log.Fields{
+			"pcapPath": file.decompressedPath,
+		}).Info("No matching Suricata packets found")
+	}
+
+	scan.lock.Lock()
+	scan.results[file.idx] = result
+	scan.lock.Unlock()
+	scan.fileScanned(result)
+}
+
+func (scan *pcapScan) fileScanned(result *scanResult) {
+	scan.lock.Lock()
+	defer scan.lock.Unlock()
+
+	scan.progress.FilesScanned++
+	if result != nil {
+		scan.progress.PacketsMatched += result.count
+		scan.progress.BytesWritten += result.size - PCAP_FILE_HEADER_LENGTH
+	}
+	agent.ReportJobProgress(scan.ctx, scan.progress)
+}
+
+// reserveMemory accounts for a result about to be kept in memory. Returns false, and
+// reserves nothing, when the result would exceed the scan's memory limit.
+func (scan *pcapScan) reserveMemory(size int64) bool {
+	scan.lock.Lock()
+	defer scan.lock.Unlock()
+
+	if scan.memoryBytes+size > int64(scan.suri.scanMaxMemory) {
+		scan.spilled = true
+		return false
+	}
+	scan.memoryBytes += size
+	return true
+}
+
+func (scan *pcapScan) store(packets []gopacket.Packet) (*scanResult, error) {
+	slices.SortStableFunc(packets, func(a, b gopacket.Packet) int {
+		return a.Metadata().Timestamp.Compare(b.Metadata().Timestamp)
+	})
+
+	result := &scanResult{
+		count: len(packets),
+		size:  PCAP_FILE_HEADER_LENGTH,
+	}
+	for _, pkt := range packets {
+		result.size += int64(PCAP_RECORD_HEADER_LENGTH + pkt.Metadata().CaptureLength)
+	}
+
+	if scan.reserveMemory(result.size) {
+		var buffer bytes.Buffer
+		buffer.Grow(int(result.size))
+		err := packet.WritePackets(&buffer, packets)
+		result.data = buffer.Bytes()
+		return result, err
+	}
+
+	file, err := os.CreateTemp(scan.suri.scanTempPath, SCAN_TEMP_PATTERN)
+	if err != nil {
+		return nil, err
+	}
+	result.filename = file.Name()
+
+	writer := bufio.NewWriter(file)
+	err = packet.WritePackets(writer, packets)
+	if err == nil {
+		err = writer.Flush()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(result.filename)
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"spillFilename": result.filename,
+		"packetCount":   result.count,
+	}).Debug("Spilled matching Suricata packets to disk")
+	return result, nil
+}
+
+// merge combines the results with a k-way merge by timestamp. The merged stream is
+// buffered in memory when it fits within the memory limit alongside the results, and
+// otherwise written to a temporary file that is removed once it has been read.
+func (scan *pcapScan) merge() (io.ReadCloser, int, error) {
+	readers := make([]io.Reader, 0, len(scan.results))
+	for _, result := range scan.results {
+		if result == nil {
+			continue
+		}
+		if result.filename == "" {
+			readers = append(readers, bytes.NewReader(result.data))
+			continue
+		}
+		file, err := os.Open(result.filename)
+		if err != nil {
+			log.WithError(err).WithField("spillFilename", result.filename).Error("Failed to open spilled Suricata packets")
+			continue
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+
+	if !scan.spilled && 2*scan.memoryBytes <= int64(scan.suri.scanMaxMemory) {
+		var buffer bytes.Buffer
+		count, err := packet.MergePcaps(&buffer, readers)
+		if err != nil {
+			return nil, 0, err
+		}
+		log.WithField("matchedCount", count).Info("Finished filtering and merging matching packets")
+		return io.NopCloser(bytes.NewReader(buffer.Bytes())), buffer.Len(), nil
+	}
+
+	file, err := os.CreateTemp(scan.suri.scanTempPath, SCAN_TEMP_PATTERN)
+	if err != nil {
+		return nil, 0, err
+	}
+	writer := bufio.NewWriter(file)
+	count, err := packet.MergePcaps(writer, readers)
+	if err == nil {
+		err = writer.Flush()
+	}
+	var size int64
+	if err == nil {
+		size, err = file.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, 0, err
+	}
+
+	log.WithFields(log.Fields{
+		"matchedCount":   count,
+		"outputFilename": file.Name(),
+	}).Info("Finished filtering and merging matching packets")
+	return &tempFileReader{file}, int(size), nil
+}
+
+func (scan *pcapScan) cleanup() {
+	for _, result := range scan.results {
+		if result != nil && result.filename != "" {
+			os.Remove(result.filename)
+		}
+	}
+}
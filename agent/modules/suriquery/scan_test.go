@@ -0,0 +1,184 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package suriquery
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func initScanTest(tester *testing.T, maxMemory int) *SuriQuery {
+	cfg := make(map[string]interface{})
+	cfg["pcapInputPath"] = "test_resources"
+	cfg["indexEnabled"] = false
+	cfg["scanMaxMemoryBytes"] = float64(maxMemory)
+	cfg["scanTempPath"] = tester.TempDir()
+	sq := NewSuriQuery(nil)
+	sq.Init(cfg)
+	return sq
+}
+
+// readTestPackets returns the packets of the test PCAP, spread one second apart and
+// dealt in reverse time order into the given number of files.
+func readTestPackets(tester *testing.T, fileCount int) [][]gopacket.Packet {
+	file, err := os.Open(testPcapFilename)
+	assert.NoError(tester, err)
+	defer file.Close()
+	reader, err := pcapgo.NewReader(file)
+	assert.NoError(tester, err)
+
+	files := make([][]gopacket.Packet, fileCount)
+	for idx := 0; ; idx++ {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(tester, err)
+		pkt := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default)
+		pkt.Metadata().CaptureInfo = ci
+		pkt.Metadata().Timestamp = time.Unix(int64(1000+idx), 0)
+		files[idx%fileCount] = append([]gopacket.Packet{pkt}, files[idx%fileCount]...)
+	}
+	return files
+}
+
+func assertTimeOrdered(tester *testing.T, reader io.Reader, expectedCount int) {
+	pcapReader, err := pcapgo.NewReader(reader)
+	if !assert.NoError(tester, err) {
+		return
+	}
+	var previous time.Time
+	count := 0
+	for {
+		_, ci, err := pcapReader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(tester, err)
+		assert.False(tester, ci.Timestamp.Before(previous))
+		previous = ci.Timestamp
+		count++
+	}
+	assert.Equal(tester, expectedCount, count)
+}
+
+func newTestScan(sq *SuriQuery, fileCount int) *pcapScan {
+	return &pcapScan{
+		suri:    sq,
+		ctx:     context.Background(),
+		results: make([]*scanResult, fileCount),
+	}
+}
+
+func TestScanMergeInMemory(tester *testing.T) {
+	sq := initScanTest(tester, DEFAULT_SCAN_MAX_MEMORY_BYTES)
+	files := readTestPackets(tester, 3)
+	scan := newTestScan(sq, len(files))
+	for idx, packets := range files {
+		result, err := scan.store(packets)
+		assert.NoError(tester, err)
+		assert.NotNil(tester, result.data)
+		assert.Equal(tester, int64(len(result.data)), result.size)
+		scan.results[idx] = result
+	}
+	assert.False(tester, scan.spilled)
+
+	reader, size, err := scan.merge()
+	if assert.NoError(tester, err) {
+		content, _ := io.ReadAll(reader)
+		assert.Equal(tester, 14918, size)
+		assert.Len(tester, content, size)
+		assertTimeOrdered(tester, bytes.NewReader(content), 22)
+		assert.NoError(tester, reader.Close())
+	}
+}
+
+func TestScanMergeSpilled(tester *testing.T) {
+	sq := initScanTest(tester, 8000)
+	files := readTestPackets(tester, 2)
+	scan := newTestScan(sq, len(files)+1)
+	for idx, packets := range files {
+		result, err := scan.store(packets)
+		assert.NoError(tester, err)
+		scan.results[idx] = result
+	}
+	assert.True(tester, scan.spilled)
+	assert.NotNil(tester, scan.results[0].data)
+	assert.NotEmpty(tester, scan.results[1].filename)
+
+	reader, size, err := scan.merge()
+	scan.cleanup()
+	if assert.NoError(tester, err) {
+		assert.IsType(tester, &tempFileReader{}, reader)
+		assert.Equal(tester, 14918, size)
+		assertTimeOrdered(tester, reader, 22)
+		assert.NoError(tester, reader.Close())
+	}
+
+	remaining, _ := os.ReadDir(sq.scanTempPath)
+	assert.Empty(tester, remaining)
+}
+
+func TestScanMergeEmpty(tester *testing.T) {
+	sq := initScanTest(tester, DEFAULT_SCAN_MAX_MEMORY_BYTES)
+	reader, size, err := newTestScan(sq, 2).merge()
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, PCAP_FILE_HEADER_LENGTH, size)
+		assertTimeOrdered(tester, reader, 0)
+	}
+}
+
+func TestDecompressGzipAndZstd(tester *testing.T) {
+	content, err := os.ReadFile(testPcapFilename)
+	assert.NoError(tester, err)
+
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	gzipWriter.Write(content)
+	gzipWriter.Close()
+
+	var zstdCompressed bytes.Buffer
+	zstdWriter, _ := zstd.NewWriter(&zstdCompressed)
+	zstdWriter.Write(content)
+	zstdWriter.Close()
+
+	sq := initTest()
+	dir := tester.TempDir()
+	for suffix, compressed := range map[string][]byte{SURI_GZIP_SUFFIX: gzipped.Bytes(), SURI_ZSTD_SUFFIX: zstdCompressed.Bytes()} {
+		compressedFilename := filepath.Join(dir, "so-pcap.1575817346"+suffix)
+		assert.NoError(tester, os.WriteFile(compressedFilename, compressed, 0644))
+
+		decompressedFilename, err := sq.decompress(compressedFilename)
+		assert.NoError(tester, err)
+		assert.Equal(tester, filepath.Join(dir, "so-pcap.1575817346"), decompressedFilename)
+		decompressed, _ := os.ReadFile(decompressedFilename)
+		assert.Equal(tester, content, decompressed)
+		os.Remove(decompressedFilename)
+
+		created, err := sq.getPcapCreateTime(compressedFilename)
+		assert.NoError(tester, err)
+		assert.Equal(tester, time.Unix(1575817346, 0).UTC(), created)
+	}
+
+	badFilename := filepath.Join(dir, "so-pcap.1575817347"+SURI_GZIP_SUFFIX)
+	assert.NoError(tester, os.WriteFile(badFilename, content, 0644))
+	_, err = sq.decompress(badFilename)
+	assert.Error(tester, err)
+	_, err = os.Stat(filepath.Join(dir, "so-pcap.1575817347"))
+	assert.True(tester, os.IsNotExist(err))
+}
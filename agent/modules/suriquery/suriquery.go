@@ -7,24 +7,25 @@
 package suriquery
 
 import (
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/apex/log"
-	"github.com/google/gopacket"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4/v4"
 	"github.com/security-onion-solutions/securityonion-soc/agent"
 	"github.com/security-onion-solutions/securityonion-soc/model"
 	"github.com/security-onion-solutions/securityonion-soc/module"
-	"github.com/security-onion-solutions/securityonion-soc/packet"
 )
 
 const DEFAULT_PCAP_INPUT_PATH = "/nsm/suripcap"
@@ -33,7 +34,12 @@ const DEFAULT_DATA_LAG_MS = 120000
 const DEFAULT_PCAP_MAX_COUNT = 999999
 
 const SURI_LZ4_SUFFIX = ".lz4"
+const SURI_GZIP_SUFFIX = ".gz"
+const SURI_ZSTD_SUFFIX = ".zst"
 const SURI_PCAP_PREFIX = "so-pcap."
+const PCAP_RECORD_HEADER_LENGTH = 16
+
+var suriCompressionSuffixes = []string{SURI_LZ4_SUFFIX, SURI_GZIP_SUFFIX, SURI_ZSTD_SUFFIX}
 
 type SuriQuery struct {
 	config           module.ModuleConfig
@@ -45,6 +51,14 @@ type SuriQuery struct {
 	epochRefreshMs   int
 	dataLagMs        int
 	pcapMaxCount     int
+	index            *pcapIndex
+	indexPath        string
+	indexRefreshMs   int
+	stopChannel      chan int
+	running          bool
+	scanConcurrency  int
+	scanMaxMemory    int
+	scanTempPath     string
 }
 
 func NewSuriQuery(agt *agent.Agent) *SuriQuery {
@@ -64,6 +78,15 @@ func (suri *SuriQuery) Init(cfg module.ModuleConfig) error {
 	suri.epochRefreshMs = module.GetIntDefault(cfg, "epochRefreshMs", DEFAULT_EPOCH_REFRESH_MS)
 	suri.dataLagMs = module.GetIntDefault(cfg, "dataLagMs", DEFAULT_DATA_LAG_MS)
 	suri.pcapMaxCount = module.GetIntDefault(cfg, "pcapMaxCount", DEFAULT_PCAP_MAX_COUNT)
+	suri.scanConcurrency = module.GetIntDefault(cfg, "scanConcurrency", DEFAULT_SCAN_CONCURRENCY)
+	suri.scanMaxMemory = module.GetIntDefault(cfg, "scanMaxMemoryBytes", DEFAULT_SCAN_MAX_MEMORY_BYTES)
+	suri.scanTempPath = module.GetStringDefault(cfg, "scanTempPath", DEFAULT_SCAN_TEMP_PATH)
+	suri.indexPath = module.GetStringDefault(cfg, "indexPath", filepath.Join(suri.pcapInputPath, DEFAULT_INDEX_DIR_NAME))
+	suri.indexRefreshMs = module.GetIntDefault(cfg, "indexRefreshMs", DEFAULT_INDEX_REFRESH_MS)
+	if module.GetBoolDefault(cfg, "indexEnabled", DEFAULT_INDEX_ENABLED) {
+		bloomBits := module.GetIntDefault(cfg, "indexBloomBits", DEFAULT_INDEX_BLOOM_BITS)
+		suri.index = newPcapIndex(suri.pcapInputPath, suri.indexPath, bloomBits)
+	}
 	if suri.agent == nil {
 		err = errors.New("unable to invoke JobMgr.AddJobProcessor due to nil agent")
 	} else {
@@ -73,22 +96,55 @@ func (suri *SuriQuery) Init(cfg module.ModuleConfig) error {
 }
 
 func (suri *SuriQuery) Start() error {
+	if suri.index != nil && suri.indexRefreshMs > 0 {
+		suri.stopChannel = make(chan int)
+		suri.running = true
+		go suri.refresher()
+	}
 	return nil
 }
 
+// refresher keeps the PCAP index current in the background, so that jobs only need to
+// index the packets written since the last refresh.
+func (suri *SuriQuery) refresher() {
+	refreshTicker := time.NewTicker(time.Duration(suri.indexRefreshMs) * time.Millisecond)
+	suri.refreshIndex()
+
+	for {
+		select {
+		case <-refreshTicker.C:
+			suri.refreshIndex()
+		case <-suri.stopChannel:
+			refreshTicker.Stop()
+			suri.running = false
+			return
+		}
+	}
+}
+
+func (suri *SuriQuery) refreshIndex() {
+	err := suri.index.refresh()
+	if err != nil {
+		log.WithError(err).WithField("pcapInputPath", suri.pcapInputPath).Error("Unable to refresh Suricata PCAP index")
+	}
+}
+
 func (suri *SuriQuery) Stop() error {
+	if suri.stopChannel != nil {
+		close(suri.stopChannel)
+	}
 	return nil
 }
 
 func (suri *SuriQuery) IsRunning() bool {
-	return false
+	return suri.running
 }
 
 func (suri *SuriQuery) getDataLagDate() time.Time {
 	return time.Now().Add(time.Duration(-suri.dataLagMs) * time.Millisecond)
 }
 
-func (suri *SuriQuery) ProcessJob(job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
+func (suri *SuriQuery) ProcessJob(ctx context.Context, job *model.Job, reader io.ReadCloser) (io.ReadCloser, error) {
 	var err error
 	if job.GetKind() != "pcap" {
 		log.WithFields(log.Fields{
@@ -116,10 +172,10 @@ func (suri *SuriQuery) ProcessJob(job *model.Job, reader io.ReadCloser) (io.Read
 		log.WithFields(log.Fields{
 			"jobId": job.Id,
 		}).Debug("Starting to process new Suricata PCAP job")
-		pcapFiles := suri.findFilesInTimeRange(job.Filter.BeginTime, job.Filter.EndTime)
+		pcapFiles := suri.findFiles(job.Filter)
 		var newReader io.ReadCloser
 		var size int
-		newReader, size, err = suri.streamPacketsInPcaps(pcapFiles, job.Filter)
+		newReader, size, err = suri.streamPacketsInPcaps(ctx, pcapFiles, job.Filter)
 
 		if job.Size > size {
 			log.Warn("Discarding Suricata job output since existing job already has more content from another processor")
@@ -139,8 +195,37 @@ func (suri *SuriQuery) CleanupJob(job *model.Job) {
 	// Noop
 }
 
+// trimCompressionSuffix returns the path without its lz4, gzip or zstd suffix. Paths of
+// uncompressed files are returned unchanged.
+func trimCompressionSuffix(path string) string {
+	for _, suffix := range suriCompressionSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return strings.TrimSuffix(path, suffix)
+		}
+	}
+	return path
+}
+
+// openDecompressor wraps the input with a reader that decompresses it according to the
+// suffix of the path.
+func openDecompressor(path string, input io.Reader) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(path, SURI_LZ4_SUFFIX):
+		return io.NopCloser(lz4.NewReader(input)), nil
+	case strings.HasSuffix(path, SURI_GZIP_SUFFIX):
+		return gzip.NewReader(input)
+	case strings.HasSuffix(path, SURI_ZSTD_SUFFIX):
+		decoder, err := zstd.NewReader(input, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(input), nil
+}
+
 func (suri *SuriQuery) decompress(path string) (string, error) {
-	decompressedPath := strings.TrimSuffix(path, SURI_LZ4_SUFFIX)
+	decompressedPath := trimCompressionSuffix(path)
 	if decompressedPath != path {
 		inputReader, oerr := os.Open(path)
 		if oerr != nil {
@@ -148,20 +233,26 @@ func (suri *SuriQuery) decompress(path string) (string, error) {
 		}
 		defer inputReader.Close()
 
+		decompressor, derr := openDecompressor(path, inputReader)
+		if derr != nil {
+			return "", derr
+		}
+		defer decompressor.Close()
+
 		outputWriter, cerr := os.Create(decompressedPath)
 		if cerr != nil {
 			return "", cerr
 		}
 		defer outputWriter.Close()
 
-		lz4Reader := lz4.NewReader(inputReader)
-		count, copyErr := io.Copy(outputWriter, lz4Reader)
+		count, copyErr := io.Copy(outputWriter, decompressor)
 		if copyErr != nil {
 			if strings.Contains(fmt.Sprint(copyErr), "unexpected EOF") {
 				log.WithFields(log.Fields{
 					"decompressedPath": decompressedPath,
 				}).Debug("ignoring EOF error since the filestream is likely still active")
 			} else {
+				os.Remove(decompressedPath)
 				return "", copyErr
 			}
 		}
@@ -169,65 +260,23 @@ func (suri *SuriQuery) decompress(path string) (string, error) {
 			"pcapPath":          path,
 			"decompressedPath":  decompressedPath,
 			"decompressedBytes": count,
-		}).Debug("Decompressed lz4 PCAP file")
+		}).Debug("Decompressed PCAP file")
 	}
 	return decompressedPath, nil
 }
 
-func (suri *SuriQuery) streamPacketsInPcaps(paths []string, filter *model.Filter) (io.ReadCloser, int, error) {
-	allPackets := make([]gopacket.Packet, 0)
-
-	for _, path := range paths {
-		log.WithFields(log.Fields{
-			"pcapPath": path,
-		}).Debug("Analyzing Suricata PCAP file")
-		decompressedPath, derr := suri.decompress(path)
-		if derr != nil {
-			log.WithError(derr).WithField("pcapPath", path).Error("Failed to decompress PCAP file")
-			continue
-		}
-
-		packets, perr := packet.ParseRawPcap(decompressedPath, suri.pcapMaxCount, filter)
-		if perr != nil {
-			log.WithError(perr).WithField("pcapPath", decompressedPath).Error("Failed to parse PCAP file")
-		}
-		if len(packets) > 0 {
-			log.WithFields(log.Fields{
-				"pcapPath":    decompressedPath,
-				"packetCount": len(packets),
-			}).Info("Found matching Suricata packets")
-			allPackets = append(allPackets, packets...)
-		} else {
-			log.WithFields(log.Fields{
-				"pcapPath": decompressedPath,
-			}).Info("No matching Suricata packets found")
-		}
-
-		if path != decompressedPath {
-			rerr := os.Remove(decompressedPath)
-			if rerr != nil {
-				log.WithError(rerr).WithField("pcapPath", decompressedPath).Error("Failed to remove decompressed PCAP file")
-			}
-		}
-	}
-
-	slices.SortFunc(allPackets, func(a, b gopacket.Packet) int {
-		return a.Metadata().Timestamp.Compare(b.Metadata().Timestamp)
-	})
-
-	log.WithField("matchedCount", len(allPackets)).Info("Finished filtering and sorting matching packets")
-
-	return packet.ToStream(allPackets)
+func (suri *SuriQuery) getPcapCreateTime(filepath string) (time.Time, error) {
+	return parsePcapCreateTime(filepath)
 }
 
-func (suri *SuriQuery) getPcapCreateTime(filepath string) (time.Time, error) {
+func parsePcapCreateTime(filepath string) (time.Time, error) {
 	var createTime time.Time
 	var err error
 	filename := path.Base(filepath)
 	if !strings.HasPrefix(filename, SURI_PCAP_PREFIX) {
 		err = errors.New("unsupported pcap file")
 	} else {
-		secondsStr := strings.TrimSuffix(filename, SURI_LZ4_SUFFIX)
+		secondsStr := trimCompressionSuffix(filename)
 		secondsStr = strings.TrimPrefix(secondsStr, SURI_PCAP_PREFIX)
 		var seconds int64
 		seconds, err = strconv.ParseInt(secondsStr, 10, 64)
@@ -238,6 +287,28 @@ func (suri *SuriQuery) getPcapCreateTime(filepath string) (time.Time, error) {
 	return createTime, err
 }
 
+// findFiles returns the PCAP files that may contain packets matching the filter, using
+// the index when it is enabled and otherwise falling back to the file names and times.
+func (suri *SuriQuery) findFiles(filter *model.Filter) []string {
+	if suri.index != nil {
+		files, err := suri.index.findFiles(filter)
+		if err == nil {
+			return files
+		}
+		log.WithError(err).WithField("pcapInputPath", suri.pcapInputPath).Error("Unable to use Suricata PCAP index; searching by file times instead")
+	}
+	return suri.findFilesInTimeRange(filter.BeginTime, filter.EndTime)
+}
+
+func isEligibleByFileTimes(createTime time.Time, modTime time.Time, start time.Time, stop time.Time) bool {
+	// file was created before the time range but has still open when time range started.
+	return (createTime.Before(start) && modTime.After(start)) ||
+		// file was created and finished in between time range start and stop times
+		(createTime.After(start) && createTime.Before(modTime) && modTime.Before(stop)) ||
+		// file was created before the end of the time range but was still being written to after the time range stop time
+		(createTime.Before(stop) && modTime.After(stop))
+}
+
 func (suri *SuriQuery) findFilesInTimeRange(start time.Time, stop time.Time) []string {
 	eligibleFiles := make([]string, 0)
 	err := filepath.Walk(suri.pcapInputPath, func(filepath string, fileinfo os.FileInfo, err error) error {
@@ -246,6 +317,9 @@ func (suri *SuriQuery) findFilesInTimeRange(start time.Time, stop time.Time) []s
 		}
 
 		if fileinfo.IsDir() {
+			if filepath == suri.indexPath {
+				return fs.SkipDir
+			}
 			return nil
 		}
 
@@ -257,16 +331,9 @@ func (suri *SuriQuery) findFilesInTimeRange(start time.Time, stop time.Time) []s
 
 		modTime := fileinfo.ModTime()
 
-		eligible := false
-		// file was created before the time range but has still open when time range started.
-		if (createTime.Before(start) && modTime.After(start)) ||
-			// file was created and finished in between time range start and stop times
-			(createTime.After(start) && createTime.Before(modTime) && modTime.Before(stop)) ||
-			// file was created before the end of the time range but was still being written to after the time range stop time
-			(createTime.Before(stop) && modTime.After(stop)) {
-
+		eligible := isEligibleByFileTimes(createTime, modTime, start, stop)
+		if eligible {
 			eligibleFiles = append(eligibleFiles, filepath)
-			eligible = true
 		}
 		log.WithFields(log.Fields{
 			"pcapPath":   filepath,
@@ -306,6 +373,9 @@ func (suri *SuriQuery) updateEpochTimeTmp(path string, info os.FileInfo, err err
 		log.WithError(err).WithField("pcapPath", path).Error("Unable to access path while updating epoch")
 		return err
 	}
+	if info.IsDir() && path == suri.indexPath {
+		return filepath.SkipDir
+	}
 	if !info.IsDir() && info.Size() > 0 {
 		createTime, err := suri.getPcapCreateTime(path)
 		if err != nil {
@@ -7,10 +7,12 @@
 package suriquery
 
 import (
+	"context"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/security-onion-solutions/securityonion-soc/agent"
 	"github.com/security-onion-solutions/securityonion-soc/model"
 	"github.com/stretchr/testify/assert"
 )
@@ -30,6 +32,9 @@ func TestInitSuriQuery(tester *testing.T) {
 	assert.Equal(tester, DEFAULT_PCAP_INPUT_PATH, sq.pcapInputPath)
 	assert.Equal(tester, DEFAULT_EPOCH_REFRESH_MS, sq.epochRefreshMs)
 	assert.Equal(tester, DEFAULT_DATA_LAG_MS, sq.dataLagMs)
+	assert.Equal(tester, DEFAULT_SCAN_CONCURRENCY, sq.scanConcurrency)
+	assert.Equal(tester, DEFAULT_SCAN_MAX_MEMORY_BYTES, sq.scanMaxMemory)
+	assert.Equal(tester, DEFAULT_SCAN_TEMP_PATH, sq.scanTempPath)
 }
 
 func TestDataLag(tester *testing.T) {
@@ -107,7 +112,7 @@ func TestStreamPacketsInPcaps(tester *testing.T) {
 	filter.DstIp = "176.126.243.198"
 	filter.DstPort = 34515
 
-	reader, size, err := sq.streamPacketsInPcaps(paths, filter)
+	reader, size, err := sq.streamPacketsInPcaps(context.Background(), paths, filter)
 	assert.Nil(tester, err)
 	pcap_length := 14918 // correlates to so-pcap test file
 	bytes := make([]byte, 32768)
@@ -116,3 +121,31 @@ func TestStreamPacketsInPcaps(tester *testing.T) {
 	assert.Equal(tester, pcap_length, count)
 	assert.Equal(tester, pcap_length, size)
 }
+
+func TestStreamPacketsInPcapsCancelled(tester *testing.T) {
+	sq := initTest()
+
+	paths := []string{"test_resources/3/so-pcap.1575817346"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	reader, size, err := sq.streamPacketsInPcaps(ctx, paths, model.NewFilter())
+	assert.ErrorIs(tester, err, context.Canceled)
+	assert.Nil(tester, reader)
+	assert.Equal(tester, 0, size)
+}
+
+func TestStreamPacketsInPcapsProgress(tester *testing.T) {
+	sq := initTest()
+
+	paths := []string{"test_resources/3/so-pcap.1575817346", "test_resources/missing.lz4"}
+	tracker := agent.NewJobProgressTracker()
+	ctx := agent.WithJobProgressTracker(context.Background(), tracker)
+
+	sq.streamPacketsInPcaps(ctx, paths, model.NewFilter())
+	progress := tracker.Latest()
+	if assert.NotNil(tester, progress) {
+		assert.Equal(tester, 2, progress.FilesTotal)
+		assert.Equal(tester, 2, progress.FilesScanned)
+	}
+}
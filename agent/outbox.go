@@ -0,0 +1,173 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/util"
+)
+
+const OUTBOX_ENTRY_SUFFIX = ".json"
+const OUTBOX_RESULT_SUFFIX = ".result"
+
+// OutboxEntry is a finished job waiting to be reported to the server, along with its
+// spooled results, if any. The key is sent with every attempt so that the server can
+// recognize an update it has already applied.
+type OutboxEntry struct {
+	Key             string     `json:"key"`
+	Job             *model.Job `json:"job"`
+	HasResult       bool       `json:"hasResult"`
+	Uploaded        bool       `json:"uploaded"`
+	Attempts        int        `json:"attempts"`
+	CreateTime      time.Time  `json:"createTime"`
+	NextAttemptTime time.Time  `json:"nextAttemptTime"`
+}
+
+// Outbox persists finished jobs on local disk until the server has acknowledged them, so
+// that results survive an unreachable server or an agent restart.
+type Outbox struct {
+	path       string
+	retryMs    int
+	maxRetryMs int
+}
+
+func NewOutbox(path string, retryMs int, maxRetryMs int) *Outbox {
+	return &Outbox{
+		path:       path,
+		retryMs:    retryMs,
+		maxRetryMs: maxRetryMs,
+	}
+}
+
+// Add spools the job's results, if any, into the outbox and closes the reader. The entry
+// itself is not persisted until it is saved with the job's final state.
+func (outbox *Outbox) Add(job *model.Job, result io.ReadCloser) (*OutboxEntry, error) {
+	entry := &OutboxEntry{
+		Key:        uuid.New().String(),
+		Job:        job,
+		CreateTime: time.Now(),
+	}
+	if result != nil {
+		defer result.Close()
+		entry.HasResult = true
+		if _, err := util.WriteFileAtomically(outbox.resultFilename(entry), result); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+func (outbox *Outbox) Save(entry *OutboxEntry) error {
+	entry.Job.UpdateKey = entry.Key
+	data, err := json.Marshal(entry)
+	if err == nil {
+		_, err = util.WriteFileAtomically(outbox.entryFilename(entry), bytes.NewReader(data))
+	}
+	return err
+}
+
+func (outbox *Outbox) Remove(entry *OutboxEntry) {
+	if entry == nil {
+		return
+	}
+	for _, filename := range []string{outbox.entryFilename(entry), outbox.resultFilename(entry)} {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).WithField("filename", filename).Warn("Unable to remove outbox file")
+		}
+	}
+}
+
+func (outbox *Outbox) OpenResult(entry *OutboxEntry) (io.ReadCloser, error) {
+	return os.Open(outbox.resultFilename(entry))
+}
+
+// Defer schedules the next attempt for the entry, doubling the delay after each failed
+// attempt up to the maximum.
+func (outbox *Outbox) Defer(entry *OutboxEntry, now time.Time) error {
+	delay := time.Duration(outbox.retryMs) * time.Millisecond
+	maxDelay := time.Duration(outbox.maxRetryMs) * time.Millisecond
+	for attempt := 0; attempt < entry.Attempts && delay < maxDelay; attempt++ {
+		delay *= 2
+	}
+	entry.Attempts++
+	entry.NextAttemptTime = now.Add(min(delay, maxDelay))
+	return outbox.Save(entry)
+}
+
+// Entries returns the saved entries, oldest first.
+func (outbox *Outbox) Entries() ([]*OutboxEntry, error) {
+	files, err := os.ReadDir(outbox.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*OutboxEntry, 0)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), OUTBOX_ENTRY_SUFFIX) {
+			continue
+		}
+		filename := filepath.Join(outbox.path, file.Name())
+		data, err := os.ReadFile(filename)
+		entry := &OutboxEntry{}
+		if err == nil {
+			err = json.Unmarshal(data, entry)
+		}
+		if err != nil || entry.Job == nil {
+			log.WithError(err).WithField("filename", filename).Error("Ignoring unreadable outbox entry")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreateTime.Before(entries[j].CreateTime)
+	})
+	return entries, nil
+}
+
+// Prune removes spooled results and partially written files that don't belong to a saved
+// entry, which are left behind when the agent stops before a job's final state is known.
+func (outbox *Outbox) Prune() {
+	files, err := os.ReadDir(outbox.path)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || strings.HasSuffix(name, OUTBOX_ENTRY_SUFFIX) {
+			continue
+		}
+		key := strings.TrimSuffix(name, OUTBOX_RESULT_SUFFIX)
+		if key != name {
+			if _, err := os.Stat(filepath.Join(outbox.path, key+OUTBOX_ENTRY_SUFFIX)); err == nil {
+				continue
+			}
+		}
+		log.WithField("filename", name).Info("Removing orphaned outbox file")
+		os.Remove(filepath.Join(outbox.path, name))
+	}
+}
+
+func (outbox *Outbox) entryFilename(entry *OutboxEntry) string {
+	return filepath.Join(outbox.path, entry.Key+OUTBOX_ENTRY_SUFFIX)
+}
+
+func (outbox *Outbox) resultFilename(entry *OutboxEntry) string {
+	return filepath.Join(outbox.path, entry.Key+OUTBOX_RESULT_SUFFIX)
+}
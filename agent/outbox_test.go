@@ -0,0 +1,109 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package agent
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newOutboxJob(id int) *model.Job {
+	job := model.NewJob()
+	job.Id = id
+	job.Complete()
+	return job
+}
+
+func TestOutboxSaveAndLoad(tester *testing.T) {
+	outbox := NewOutbox(filepath.Join(tester.TempDir(), "outbox"), 1000, 8000)
+
+	entries, err := outbox.Entries()
+	assert.NoError(tester, err)
+	assert.Empty(tester, entries)
+
+	first, err := outbox.Add(newOutboxJob(1), io.NopCloser(strings.NewReader("results")))
+	if assert.NoError(tester, err) {
+		assert.True(tester, first.HasResult)
+		assert.NotEmpty(tester, first.Key)
+	}
+	second, err := outbox.Add(newOutboxJob(2), nil)
+	if assert.NoError(tester, err) {
+		assert.False(tester, second.HasResult)
+	}
+
+	// Entries aren't visible until saved
+	entries, _ = outbox.Entries()
+	assert.Empty(tester, entries)
+
+	second.CreateTime = first.CreateTime.Add(time.Second)
+	assert.NoError(tester, outbox.Save(second))
+	assert.NoError(tester, outbox.Save(first))
+	assert.Equal(tester, first.Key, first.Job.UpdateKey)
+
+	entries, err = outbox.Entries()
+	if assert.NoError(tester, err) && assert.Len(tester, entries, 2) {
+		assert.Equal(tester, 1, entries[0].Job.Id)
+		assert.Equal(tester, first.Key, entries[0].Job.UpdateKey)
+		assert.Equal(tester, model.JobStatusCompleted, entries[0].Job.Status)
+		assert.Equal(tester, 2, entries[1].Job.Id)
+
+		result, err := outbox.OpenResult(entries[0])
+		if assert.NoError(tester, err) {
+			data, _ := io.ReadAll(result)
+			result.Close()
+			assert.Equal(tester, "results", string(data))
+		}
+	}
+
+	outbox.Remove(first)
+	entries, _ = outbox.Entries()
+	assert.Len(tester, entries, 1)
+	_, err = outbox.OpenResult(first)
+	assert.True(tester, os.IsNotExist(err))
+}
+
+func TestOutboxDefer(tester *testing.T) {
+	outbox := NewOutbox(tester.TempDir(), 1000, 5000)
+	entry, _ := outbox.Add(newOutboxJob(1), nil)
+
+	now := time.Now()
+	for _, expected := range []int{1, 2, 4, 5, 5} {
+		assert.NoError(tester, outbox.Defer(entry, now))
+		assert.Equal(tester, now.Add(time.Duration(expected)*time.Second), entry.NextAttemptTime)
+	}
+	assert.Equal(tester, 5, entry.Attempts)
+
+	entries, _ := outbox.Entries()
+	if assert.Len(tester, entries, 1) {
+		assert.Equal(tester, 5, entries[0].Attempts)
+	}
+}
+
+func TestOutboxPrune(tester *testing.T) {
+	dir := tester.TempDir()
+	outbox := NewOutbox(dir, 1000, 5000)
+
+	saved, _ := outbox.Add(newOutboxJob(1), io.NopCloser(strings.NewReader("saved")))
+	assert.NoError(tester, outbox.Save(saved))
+	orphan, _ := outbox.Add(newOutboxJob(2), io.NopCloser(strings.NewReader("orphan")))
+	assert.NoError(tester, os.WriteFile(filepath.Join(dir, "partial.json.123.tmp"), []byte("{"), 0600))
+
+	outbox.Prune()
+
+	files, _ := os.ReadDir(dir)
+	assert.Len(tester, files, 2)
+	_, err := outbox.OpenResult(saved)
+	assert.NoError(tester, err)
+	_, err = outbox.OpenResult(orphan)
+	assert.True(tester, os.IsNotExist(err))
+}
@@ -7,6 +7,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -24,6 +25,7 @@ import (
 	"github.com/security-onion-solutions/securityonion-soc/module"
 	"github.com/security-onion-solutions/securityonion-soc/server"
 	serverModules "github.com/security-onion-solutions/securityonion-soc/server/modules"
+	"github.com/security-onion-solutions/securityonion-soc/server/modules/streamstore"
 )
 
 var (
@@ -43,8 +45,30 @@ func InitLogging(logFilename string, logLevel string) (*os.File, error) {
 	return logFile, err
 }
 
+// ConvertStreams rewrites the packet streams of each configured datastore to match the
+// datastore's stream compression and encryption settings.
+func ConvertStreams(cfg *config.Config) error {
+	if cfg.Server == nil {
+		return errors.New("Server configuration is required to convert packet streams")
+	}
+	for _, name := range []string{"filedatastore", "boltdatastore"} {
+		if moduleCfg, exists := cfg.Server.Modules[name]; exists {
+			count, err := streamstore.ConvertPacketStreams(moduleCfg)
+			if err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"module": name,
+				"count":  count,
+			}).Info("Converted packet streams")
+		}
+	}
+	return nil
+}
+
 func main() {
 	configFilename := flag.String("c", "sensoroni.json", "Configuration file, in JSON format")
+	convertStreams := flag.Bool("convertStreams", false, "Convert stored packet streams to the configured compression and encryption, then exit")
 	flag.Parse()
 
 	buildTime, err := time.Parse("2006-01-02T15:04:05", BuildTime)
@@ -61,6 +85,15 @@ func main() {
 			"buildTime": cfg.BuildTime,
 		}).Info("Version Information")
 
+		if *convertStreams {
+			if err = ConvertStreams(cfg); err != nil {
+				log.WithError(err).Error("Failed to convert packet streams")
+				logFile.Close()
+				os.Exit(1)
+			}
+			return
+		}
+
 		moduleMgr := module.NewModuleManager()
 		var srv *server.Server
 		if cfg.Server != nil {
@@ -14,6 +14,14 @@ import (
 )
 
 const DEFAULT_POLL_INTERVAL_MS = 1000
+const DEFAULT_CANCEL_CHECK_INTERVAL_MS = 5000
+const DEFAULT_STREAM_CHUNK_SIZE_BYTES = 8388608
+const DEFAULT_STREAM_CHUNK_RETRIES = 5
+const DEFAULT_JOB_WAIT_MS = 25000
+const DEFAULT_JOB_PUSH_RETRY_MS = 60000
+const DEFAULT_OUTBOX_PATH = "/opt/sensoroni/outbox"
+const DEFAULT_OUTBOX_RETRY_MS = 5000
+const DEFAULT_OUTBOX_MAX_RETRY_MS = 300000
 
 type AgentConfig struct {
 	NodeId                string                 `json:"nodeId"`
@@ -24,6 +32,15 @@ type AgentConfig struct {
 	ServerUrl             string                 `json:"serverUrl"`
 	VerifyCert            bool                   `json:"verifyCert"`
 	PollIntervalMs        int                    `json:"pollIntervalMs"`
+	CancelCheckIntervalMs int                    `json:"cancelCheckIntervalMs"`
+	StreamChunkSizeBytes  int                    `json:"streamChunkSizeBytes"`
+	StreamChunkRetries    int                    `json:"streamChunkRetries"`
+	JobPushEnabled        bool                   `json:"jobPushEnabled"`
+	JobWaitMs             int                    `json:"jobWaitMs"`
+	JobPushRetryMs        int                    `json:"jobPushRetryMs"`
+	OutboxPath            string                 `json:"outboxPath"`
+	OutboxRetryMs         int                    `json:"outboxRetryMs"`
+	OutboxMaxRetryMs      int                    `json:"outboxMaxRetryMs"`
 	Modules               module.ModuleConfigMap `json:"modules"`
 	ModuleFailuresIgnored bool                   `json:"moduleFailuresIgnored"`
 }
@@ -33,6 +50,30 @@ func (config *AgentConfig) Verify() error {
 	if err == nil && config.PollIntervalMs <= 0 {
 		config.PollIntervalMs = DEFAULT_POLL_INTERVAL_MS
 	}
+	if err == nil && config.CancelCheckIntervalMs <= 0 {
+		config.CancelCheckIntervalMs = DEFAULT_CANCEL_CHECK_INTERVAL_MS
+	}
+	if err == nil && config.StreamChunkSizeBytes <= 0 {
+		config.StreamChunkSizeBytes = DEFAULT_STREAM_CHUNK_SIZE_BYTES
+	}
+	if err == nil && config.StreamChunkRetries <= 0 {
+		config.StreamChunkRetries = DEFAULT_STREAM_CHUNK_RETRIES
+	}
+	if err == nil && config.JobWaitMs <= 0 {
+		config.JobWaitMs = DEFAULT_JOB_WAIT_MS
+	}
+	if err == nil && config.JobPushRetryMs <= 0 {
+		config.JobPushRetryMs = DEFAULT_JOB_PUSH_RETRY_MS
+	}
+	if err == nil && config.OutboxPath == "" {
+		config.OutboxPath = DEFAULT_OUTBOX_PATH
+	}
+	if err == nil && config.OutboxRetryMs <= 0 {
+		config.OutboxRetryMs = DEFAULT_OUTBOX_RETRY_MS
+	}
+	if err == nil && config.OutboxMaxRetryMs <= 0 {
+		config.OutboxMaxRetryMs = DEFAULT_OUTBOX_MAX_RETRY_MS
+	}
 	if err == nil && config.NodeId == "" {
 		config.NodeId, err = os.Hostname()
 	}
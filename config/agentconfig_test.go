@@ -16,6 +16,15 @@ func TestVerifyAgent(tester *testing.T) {
 	cfg := &AgentConfig{}
 	err := cfg.Verify()
 	assert.Equal(tester, DEFAULT_POLL_INTERVAL_MS, cfg.PollIntervalMs)
+	assert.Equal(tester, DEFAULT_CANCEL_CHECK_INTERVAL_MS, cfg.CancelCheckIntervalMs)
+	assert.Equal(tester, DEFAULT_STREAM_CHUNK_SIZE_BYTES, cfg.StreamChunkSizeBytes)
+	assert.Equal(tester, DEFAULT_STREAM_CHUNK_RETRIES, cfg.StreamChunkRetries)
+	assert.Equal(tester, DEFAULT_JOB_WAIT_MS, cfg.JobWaitMs)
+	assert.Equal(tester, DEFAULT_JOB_PUSH_RETRY_MS, cfg.JobPushRetryMs)
+	assert.Equal(tester, DEFAULT_OUTBOX_PATH, cfg.OutboxPath)
+	assert.Equal(tester, DEFAULT_OUTBOX_RETRY_MS, cfg.OutboxRetryMs)
+	assert.Equal(tester, DEFAULT_OUTBOX_MAX_RETRY_MS, cfg.OutboxMaxRetryMs)
+	assert.False(tester, cfg.JobPushEnabled)
 	assert.NotEmpty(tester, cfg.NodeId)
 	assert.Empty(tester, cfg.Model)
 	assert.False(tester, cfg.VerifyCert)
@@ -17,9 +17,14 @@ import (
 )
 
 const DEFAULT_MAX_PACKET_COUNT = 5000
+const DEFAULT_MAX_TCP_STREAM_BYTES = 10485760
+const DEFAULT_MAX_CARVE_BYTES = 52428800
 const DEFAULT_IDLE_CONNECTION_TIMEOUT_MS = 300000
 const DEFAULT_MAX_UPLOAD_SIZE_BYTES = 26214400
 const DEFAULT_SRV_EXP_SECONDS = 600
+const DEFAULT_JOB_SCHEDULE_INTERVAL_MS = 30000
+const DEFAULT_JOB_LEASE_CHECK_INTERVAL_MS = 30000
+const DEFAULT_JOB_WAIT_MAX_MS = 30000
 const REQUIRED_SRV_KEY_LENGTH = 64
 
 type ServerConfig struct {
@@ -31,6 +36,8 @@ type ServerConfig struct {
 	HtmlDir                 string                 `json:"htmlDir"`
 	ImportUploadDir         string                 `json:"importUploadDir"`
 	MaxPacketCount          int                    `json:"maxPacketCount"`
+	MaxTcpStreamBytes       int                    `json:"maxTcpStreamBytes"`
+	MaxCarveBytes           int                    `json:"maxCarveBytes"`
 	Modules                 module.ModuleConfigMap `json:"modules"`
 	ModuleFailuresIgnored   bool                   `json:"moduleFailuresIgnored"`
 	ClientParams            ClientParameters       `json:"client"`
@@ -43,6 +50,9 @@ type ServerConfig struct {
 	SrvKey                  string                 `json:"srvKey"`
 	SrvKeyBytes             []byte
 	SrvExpSeconds           int `json:"srvExpSeconds"`
+	JobScheduleIntervalMs   int `json:"jobScheduleIntervalMs"`
+	JobLeaseCheckIntervalMs int `json:"jobLeaseCheckIntervalMs"`
+	JobWaitMaxMs            int `json:"jobWaitMaxMs"`
 }
 
 func (config *ServerConfig) Verify() error {
@@ -50,6 +60,12 @@ func (config *ServerConfig) Verify() error {
 	if config.MaxPacketCount <= 0 {
 		config.MaxPacketCount = DEFAULT_MAX_PACKET_COUNT
 	}
+	if config.MaxTcpStreamBytes <= 0 {
+		config.MaxTcpStreamBytes = DEFAULT_MAX_TCP_STREAM_BYTES
+	}
+	if config.MaxCarveBytes <= 0 {
+		config.MaxCarveBytes = DEFAULT_MAX_CARVE_BYTES
+	}
 	if config.BindAddress == "" {
 		err = errors.New("Server.BindAddress configuration value is required")
 	}
@@ -74,6 +90,15 @@ func (config *ServerConfig) Verify() error {
 	if config.SrvExpSeconds <= 0 {
 		config.SrvExpSeconds = DEFAULT_SRV_EXP_SECONDS
 	}
+	if config.JobScheduleIntervalMs <= 0 {
+		config.JobScheduleIntervalMs = DEFAULT_JOB_SCHEDULE_INTERVAL_MS
+	}
+	if config.JobLeaseCheckIntervalMs <= 0 {
+		config.JobLeaseCheckIntervalMs = DEFAULT_JOB_LEASE_CHECK_INTERVAL_MS
+	}
+	if config.JobWaitMaxMs <= 0 {
+		config.JobWaitMaxMs = DEFAULT_JOB_WAIT_MAX_MS
+	}
 
 	keyLen := len(config.SrvKey)
 	if keyLen != REQUIRED_SRV_KEY_LENGTH {
@@ -17,9 +17,14 @@ func TestVerifyServer(tester *testing.T) {
 	err := cfg.Verify()
 	if assert.Error(tester, err) {
 		assert.Equal(tester, DEFAULT_MAX_PACKET_COUNT, cfg.MaxPacketCount)
+		assert.Equal(tester, DEFAULT_MAX_TCP_STREAM_BYTES, cfg.MaxTcpStreamBytes)
+		assert.Equal(tester, DEFAULT_MAX_CARVE_BYTES, cfg.MaxCarveBytes)
 		assert.Equal(tester, DEFAULT_IDLE_CONNECTION_TIMEOUT_MS, cfg.IdleConnectionTimeoutMs)
 		assert.Equal(tester, DEFAULT_MAX_UPLOAD_SIZE_BYTES, cfg.MaxUploadSizeBytes)
 		assert.Equal(tester, DEFAULT_SRV_EXP_SECONDS, cfg.SrvExpSeconds)
+		assert.Equal(tester, DEFAULT_JOB_SCHEDULE_INTERVAL_MS, cfg.JobScheduleIntervalMs)
+		assert.Equal(tester, DEFAULT_JOB_LEASE_CHECK_INTERVAL_MS, cfg.JobLeaseCheckIntervalMs)
+		assert.Equal(tester, DEFAULT_JOB_WAIT_MAX_MS, cfg.JobWaitMaxMs)
 		assert.False(tester, cfg.DeveloperEnabled)
 		assert.Equal(tester, REQUIRED_SRV_KEY_LENGTH, len(cfg.SrvKeyBytes))
 	}
@@ -22,10 +22,13 @@ require (
 require (
 	github.com/go-git/go-git/v5 v5.12.0
 	github.com/hashicorp/go-multierror v1.1.1
+	github.com/klauspost/compress v1.18.0
 	github.com/pierrec/lz4/v4 v4.1.21
 	github.com/pkg/errors v0.9.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/samber/lo v1.39.0
 	github.com/tj/assert v0.0.3
+	go.etcd.io/bbolt v1.3.10
 	go.uber.org/mock v0.3.0
 	golang.org/x/mod v0.17.0
 )
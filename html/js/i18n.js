@@ -124,6 +124,8 @@ const i18n = {
       beginTimeHelp: 'Filter start time in RFC 3339 format (Ex: 2020-10-16 13:00:00.230-04:00). Unused for imported PCAPs.',
       betweenOp: 'Between',
       blog: 'Blog',
+      bpf: 'BPF',
+      bpfHelp: 'Optional BPF expression to further restrict this job filter (Ex: tcp[tcpflags] & tcp-syn != 0)',
       both: 'Both',
       bulkAction: 'Bulk Action:',
       bulkActionStarted: 'Updating {total} detections. This may take awhile.',
@@ -133,6 +135,8 @@ const i18n = {
       bulkSuccessDelete: 'Bulk delete successfully deleted {modified} of {total} events. ({time})',
       bytes: 'Bytes',
       cancel: 'Cancel',
+      cancelled: 'Cancelled',
+      cancelRequested: 'Cancelling',
       captureLoss: 'Capture Loss',
       captureLossAbbr: 'Cap Loss',
       case: 'Case',
@@ -176,6 +180,7 @@ const i18n = {
       cidr: 'CIDR Notation',
       clear: 'Clear',
       clickForMoreInformation: 'Click for more information',
+      clients: 'Clients',
       collapse: 'Collapse',
       collapseAll: 'Collapse All',
       collapseHelp: 'Collapse all packet data',
@@ -346,8 +351,9 @@ const i18n = {
       downloadsInfo: 'These <a href="/docs/elastic-agent.html">Elastic Agent</a> installers are customized for this specific <a href="/docs/elastic-fleet.html">Elastic Fleet</a> installation. These files are not signed. If you need signed non-customized Elastic Agent installers, you can get them from <a href="https://www.elastic.co/downloads/elastic-agent">elastic.co</a>.',
       downloadsElasticAgent: 'Elastic Agent Installers',
       downloadPackets: 'Download the packets as a PCAP file',
+      downloadPacketsPcapNg: 'Download the packets as a pcapng file, with the job details embedded as comments',
       dstIp: 'Destination IP',
-      dstIpHelp: 'Optional destination IP address to include in this job filter',
+      dstIpHelp: 'Optional destination IPv4 or IPv6 address, or CIDR range, to include in this job filter',
       dstPort: 'Destination Port',
       dstPortHelp: 'Optional destination TCP port to include in this job filter',
       duplicate: 'Duplicate',
@@ -401,6 +407,7 @@ const i18n = {
       incomplete: 'Incomplete',
       invalidHours: 'Hours are not valid. Ex: 1.5',
       ipVar: 'IP/Var',
+      failed: 'Failed',
       failedEvents: 'Failed Events',
       fault: 'Fault',
       featureRequiresAppliance: 'Feature Unavailable',
@@ -440,6 +447,8 @@ const i18n = {
       filterIncludeHelp: 'Adds this value as a required match in the search',
       filterResults: 'Filter Results',
       fingerprint: 'Fingerprint',
+      fingerprints: 'Handshake Fingerprints',
+      fingerprintsHelp: 'JA3, JA3S, JA4 and HASSH fingerprints of the TLS and SSH handshakes in these packets',
       fps: 'Fed Info Proc Stds',
       firstName: 'First Name',
       flags: 'Flags',
@@ -505,6 +514,7 @@ const i18n = {
       huntForError: 'The Hunt interface may contain additional log messages to further diagnose the issue.',
       huntForErrorQuery: 'event.module:"soc" | groupby event.dataset log.level event.action',
       huntForEvidence: 'Hunt for this observable value',
+      huntForFingerprint: 'Hunt for events with this fingerprint',
       huntHelp: 'Start a new hunt based on the current filters',
       id: 'ID',
       idMissingErr: 'This Sigma rule is missing its public Id. A public Id is required.',
@@ -533,8 +543,10 @@ const i18n = {
       invalidCidrOrVar: 'Invalid CIDR Notation or Suricata Variable',
       ipCidr: 'IP - CIDR Notation or Suricata Variable',
       job: 'Job',
+      jobFailed: 'The job was abandoned too many times and will not be retried. Details are available below.',
       jobIncomplete: 'The job was unable to complete and will retry within a few minutes. Details are available below.',
       jobInProgress: 'This job is awaiting completion.',
+      jobReused: 'This job was satisfied using the packets already retrieved by job',
       jobs: 'PCAP',
       keywords: 'Filter Keywords',
       kind: 'Kind',
@@ -728,7 +740,9 @@ const i18n = {
       sensor: 'Sensor',
       sensorId: 'Sensor ID',
       sensorIdRequired: 'The Sensor ID must be entered before adding a new job.',
-      sensorIdHelp: 'The sensor ID must match an actual sensor ID in order for this job to be processed.',
+      sensorIdHelp: 'The sensor ID must match an actual sensor ID in order for this job to be processed. Separate multiple sensor IDs with commas, or use * for all sensors.',
+      serverNames: 'Server Names',
+      servers: 'Servers',
       settingCancelHelp: 'Cancel changes',
       settingCategory_general: 'General',
       settingCategory_ui: 'User Interface',
@@ -834,7 +848,7 @@ const i18n = {
       source: 'Source',
       sponsorsIntro: 'Brought to you by:',
       srcIp: 'Source IP',
-      srcIpHelp: 'Optional source IP address to include in this job filter',
+      srcIpHelp: 'Optional source IPv4 or IPv6 address, or CIDR range, to include in this job filter',
       srcPort: 'Source Port',
       srcPortHelp: 'Optional source TCP port to include in this job filter',
       standardMetrics: 'Basic Metrics',
@@ -915,7 +929,7 @@ const i18n = {
       unlocked: 'Unlocked',
       unmatchedFilter: 'No items match the given filters.',
       unprovisioned: 'Unprovisioned',
-      unwrapHelp: 'Unwrap packets from encapsulation (Ex: VXLAN)',
+      unwrapHelp: 'Unwrap packets from encapsulation (Ex: VXLAN, GENEVE, GRE, ERSPAN, MPLS, VLAN)',
       update: 'Update',
       upload: 'Upload',
       uploadPCAPEVTX: 'Upload PCAP/EVTX',
@@ -945,6 +959,8 @@ const i18n = {
       viewCase: 'Case Details',
       viewInDiscover: 'Test in Kibana',
       viewResults: 'View Results',
+      vlans: 'VLANs',
+      vlansHelp: 'Optional comma-separated VLAN ids to include in this job filter',
       webauthn: 'Security Keys (WebAuthn / PassKey)',
       webauthnActive: 'Webauthn / Security Keys Active',
       webauthnAddKey: 'Add New Security Key',
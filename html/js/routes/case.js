@@ -858,7 +858,7 @@ routes.push({ path: '/case/:id', name: 'case', component: {
                 }
               }
           }});
-          const jobs = response.data;
+          const jobs = response.data.jobs;
 
           for (var idx = 0; idx < jobs.length; idx++) {
             const job = jobs[idx];
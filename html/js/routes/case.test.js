@@ -857,7 +857,7 @@ test('shouldLoadAndUpdateAnalyzeJobs', async () => {
   const job2 = { id: '1002', status: JobStatusCompleted, filter: { parameters: { artifact: { id: 'artifact1' } } } };
   const job3 = { id: '1003', status: JobStatusCompleted, filter: { parameters: { artifact: { id: 'artifact1' } } } };
 
-  mock = mockPapi("get", { data: [job3, job1, job2]});
+  mock = mockPapi("get", { data: { jobs: [job3, job1, job2], nextCursor: '', totalCount: 3 }});
   const showErrorMock = mockShowError();
   comp.associations['evidence'] = [
     { id: 'artifact1' },
@@ -15,6 +15,15 @@ routes.push({ path: '/job/:jobId', name: 'job', component: {
     expanded: [],
     packetOptions: ['packets', 'hex', 'unwrap'],
     packets: [],
+    fingerprints: [],
+    fingerprintHeaders: [
+      { text: this.$root.i18n.type, value: 'type' },
+      { text: this.$root.i18n.fingerprint, value: 'value' },
+      { text: this.$root.i18n.count, value: 'count' },
+      { text: this.$root.i18n.clients, value: 'clientIps' },
+      { text: this.$root.i18n.servers, value: 'serverIps' },
+      { text: this.$root.i18n.serverNames, value: 'serverNames' },
+    ],
     headers: [
       { text: this.$root.i18n.number, value: 'number' },
       { text: this.$root.i18n.timestamp, value: 'timestamp' },
@@ -174,8 +183,12 @@ routes.push({ path: '/job/:jobId', name: 'job', component: {
         this.expandPackets(true);
       }
     },
-    downloadUrl() {
-      return this.$root.apiUrl + "stream?jobId=" + this.job.id + "&ext=pcap&unwrap=" + this.isOptionEnabled('unwrap');
+    downloadUrl(format = 'pcap') {
+      var url = this.$root.apiUrl + "stream?jobId=" + this.job.id + "&ext=" + format + "&unwrap=" + this.isOptionEnabled('unwrap');
+      if (format != 'pcap') {
+        url += "&format=" + format;
+      }
+      return url;
     },
     packetArrayTranscript() {
       return this.packets
@@ -198,7 +211,10 @@ routes.push({ path: '/job/:jobId', name: 'job', component: {
       this.packets = [];
       var unwrap = !this.isOptionEnabled('unwrap'); // option hasn't been flipped yet
       var route = this;
-      setTimeout(function() { route.loadPackets(unwrap); }, 0); // run async to this event
+      setTimeout(function() {
+        route.loadPackets(unwrap);
+        route.loadFingerprints(unwrap);
+      }, 0); // run async to this event
     },
     async loadPackets(unwrap) {
       this.packetsLoading = true;
@@ -227,6 +243,22 @@ routes.push({ path: '/job/:jobId', name: 'job', component: {
       }
       this.packetsLoading = false;
     },
+    async loadFingerprints(unwrap) {
+      this.fingerprints = [];
+      try {
+        const response = await this.$root.papi.get('packets/' + this.$route.params.jobId + '/fingerprints', { params: {
+          unwrap: unwrap
+        }});
+        if (response.data && response.data.fingerprints) {
+          this.fingerprints = response.data.fingerprints;
+        }
+      } catch (error) {
+        if (error.response != undefined && error.response.status == 404) {
+        } else {
+          this.$root.showError(error);
+        }
+      }
+    },
     async loadData() {
       this.$root.startLoading();
       this.loadLocalSettings();
@@ -244,6 +276,7 @@ routes.push({ path: '/job/:jobId', name: 'job', component: {
         this.$root.populateUserDetails(this.job, "userId", "owner");
         this.$root.setSubtitle(this.i18n.jobs + " - " + this.job.id);
         this.loadPackets(this.isOptionEnabled('unwrap'));
+        this.loadFingerprints(this.isOptionEnabled('unwrap'));
       } catch (error) {
         if (error.response != undefined && error.response.status == 404) {
           this.$root.showError(this.i18n.notFound);
@@ -280,6 +313,7 @@ routes.push({ path: '/job/:jobId', name: 'job', component: {
 
       if (this.job.status != job.status) {
         this.loadPackets(this.isOptionEnabled('unwrap'));
+        this.loadFingerprints(this.isOptionEnabled('unwrap'));
       }
 
       this.job = job;
@@ -316,6 +350,42 @@ routes.push({ path: '/job/:jobId', name: 'job', component: {
       }
       return view;
     },
+    formatApplicationView(application) {
+      const lines = [];
+      const flatten = (prefix, value) => {
+        if (value === null || value === undefined || value === '') return;
+        if (Array.isArray(value)) {
+          value.forEach((item, idx) => flatten(prefix + '[' + idx + ']', item));
+        } else if (typeof value === 'object') {
+          Object.keys(value).forEach(key => flatten(prefix ? prefix + '.' + key : key, value[key]));
+        } else {
+          lines.push(prefix + ': ' + value);
+        }
+      };
+      flatten('', application);
+      return lines.join('\n');
+    },
+    formatTunnelView(tunnels) {
+      const endpoint = (ip, port, mac) => {
+        if (!ip) return mac;
+        return port ? ip + ':' + port : ip;
+      };
+      return tunnels.map(tunnel => {
+        let line = tunnel.type + (tunnel.version ? ' type ' + tunnel.version : '') + ' id ' + tunnel.id;
+        const src = endpoint(tunnel.srcIp, tunnel.srcPort, tunnel.srcMac);
+        if (src) {
+          line += ': ' + src + ' -> ' + endpoint(tunnel.dstIp, tunnel.dstPort, tunnel.dstMac);
+        }
+        return line;
+      }).join('\n');
+    },
+    formatFingerprintView(fingerprints) {
+      return fingerprints.map(fingerprint => this.formatFingerprintType(fingerprint.type) + ': ' + fingerprint.value).join('\n');
+    },
+    formatFingerprintType(type) {
+      const names = { ja3: 'JA3', ja3s: 'JA3S', ja4: 'JA4', hassh: 'HASSH', hasshServer: 'HASSHServer' };
+      return names[type] || type;
+    },
     formatHexView(input) {
       var view = "";
       var ascii = "";
@@ -47,3 +47,77 @@ test('transcriptCyberChef_testing', () => {
     // Cleanup
     window.open = originalOpen;
 });
+
+test('formatApplicationView', () => {
+    const application = {
+      protocol: 'http',
+      http: {
+        method: 'GET',
+        uri: '/',
+        version: 'HTTP/1.1',
+        headers: [{ name: 'Host', value: 'example.com' }],
+      },
+    };
+
+    expect(comp.formatApplicationView(application)).toBe(`\
+protocol: http
+http.method: GET
+http.uri: /
+http.version: HTTP/1.1
+http.headers[0].name: Host
+http.headers[0].value: example.com`);
+});
+
+test('downloadUrl', () => {
+    comp.$root.apiUrl = '/api/';
+    comp.job = { id: 7 };
+    comp.packetOptions = ['unwrap'];
+
+    expect(comp.downloadUrl()).toBe('/api/stream?jobId=7&ext=pcap&unwrap=true');
+    expect(comp.downloadUrl('pcapng')).toBe('/api/stream?jobId=7&ext=pcapng&unwrap=true&format=pcapng');
+});
+
+test('formatTunnelView', () => {
+    const tunnels = [
+      { type: 'VLAN', id: 5, srcMac: '02:00:00:00:00:01', dstMac: '02:00:00:00:00:02' },
+      { type: 'ERSPAN', id: 100, version: 2, srcIp: '192.168.1.1', dstIp: '192.168.1.2' },
+      { type: 'VXLAN', id: 42, srcIp: '10.1.1.1', srcPort: 50000, dstIp: '10.1.1.2', dstPort: 4789 },
+      { type: 'MPLS', id: 300 },
+    ];
+
+    expect(comp.formatTunnelView(tunnels)).toBe(`\
+VLAN id 5: 02:00:00:00:00:01 -> 02:00:00:00:00:02
+ERSPAN type 2 id 100: 192.168.1.1 -> 192.168.1.2
+VXLAN id 42: 10.1.1.1:50000 -> 10.1.1.2:4789
+MPLS id 300`);
+});
+
+test('formatFingerprintView', () => {
+    const fingerprints = [
+      { type: 'ja3', value: '50a0e1f8c13ee9e5521e3f374a63a021' },
+      { type: 'ja4', value: 't13d1516h2_8daaf6152771_e5627efa2ab1' },
+      { type: 'hasshServer', value: '702343d79e93ca97f74a97e80d952b26' },
+    ];
+
+    expect(comp.formatFingerprintView(fingerprints)).toBe(`\
+JA3: 50a0e1f8c13ee9e5521e3f374a63a021
+JA4: t13d1516h2_8daaf6152771_e5627efa2ab1
+HASSHServer: 702343d79e93ca97f74a97e80d952b26`);
+    expect(comp.formatFingerprintType('other')).toBe('other');
+});
+
+test('loadFingerprints', async () => {
+    const fingerprint = { type: 'ja3', value: 'abc', query: 'tls.client.ja3:"abc"' };
+    resetPapi();
+    const mock = mockPapi("get", { data: { jobId: 7, fingerprints: [fingerprint] } });
+    comp.$route.params.jobId = 7;
+
+    await comp.loadFingerprints(true);
+
+    expect(mock).toHaveBeenCalledWith('packets/7/fingerprints', { params: { unwrap: true } });
+    expect(comp.fingerprints).toStrictEqual([fingerprint]);
+
+    resetPapi().mockPapi("get", null, { response: { status: 404 } });
+    await comp.loadFingerprints(false);
+    expect(comp.fingerprints).toStrictEqual([]);
+});
@@ -8,12 +8,27 @@ const JobStatusPending = 0;
 const JobStatusCompleted = 1;
 const JobStatusIncomplete = 2;
 const JobStatusDeleted = 3;
+const JobStatusCancelRequested = 4;
+const JobStatusCancelled = 5;
+const JobStatusFailed = 6;
+const JobsPageSize = 500;
 
 routes.push({ path: '/jobs', name: 'jobs', component: {
   template: '#page-jobs',
   data() { return {
     i18n: this.$root.i18n,
     jobs: [],
+    nextCursor: '',
+    totalCount: 0,
+    statusFilter: [],
+    statusOptions: [
+      { text: this.$root.i18n.pending, value: JobStatusPending },
+      { text: this.$root.i18n.completed, value: JobStatusCompleted },
+      { text: this.$root.i18n.incomplete, value: JobStatusIncomplete },
+      { text: this.$root.i18n.cancelRequested, value: JobStatusCancelRequested },
+      { text: this.$root.i18n.cancelled, value: JobStatusCancelled },
+      { text: this.$root.i18n.failed, value: JobStatusFailed },
+    ],
     headers: [
       { text: this.$root.i18n.id, value: 'id' },
       { text: this.$root.i18n.owner, value: 'owner' },
@@ -36,6 +51,8 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
       srcPort: null,
       dstIp: null,
       dstPort: null,
+      vlans: null,
+      bpf: null,
       beginTime: null,
       endTime: null,
     },
@@ -63,8 +80,10 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
         if (this.$route.query.k) {
           this.kind = this.$route.query.k;
         }
-        const response = await this.$root.papi.get('jobs', { params: { kind: this.kind }});
-        this.jobs = response.data;
+        const response = await this.$root.papi.get('jobs', { params: this.buildQuery('') });
+        this.jobs = response.data.jobs;
+        this.nextCursor = response.data.nextCursor;
+        this.totalCount = response.data.totalCount;
         this.loadUserDetails();
         this.loadLocalSettings();
       } catch (error) {
@@ -73,6 +92,30 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
       this.$root.stopLoading();
       this.$root.subscribe("job", this.updateJob);
     },
+    buildQuery(cursor) {
+      // Newest jobs first, one page at a time
+      const params = { kind: this.kind, sortBy: 'id', sortDesc: true, limit: JobsPageSize };
+      if (this.statusFilter && this.statusFilter.length > 0) {
+        params.status = this.statusFilter.join(',');
+      }
+      if (cursor) {
+        params.cursor = cursor;
+      }
+      return params;
+    },
+    async loadMore() {
+      try {
+        const response = await this.$root.papi.get('jobs', { params: this.buildQuery(this.nextCursor) });
+        response.data.jobs.forEach((job) => {
+          this.$root.populateUserDetails(job, "userId", "owner");
+          this.jobs.push(job);
+        });
+        this.nextCursor = response.data.nextCursor;
+        this.totalCount = response.data.totalCount;
+      } catch (error) {
+        this.$root.showError(error);
+      }
+    },
     loadUserDetails() {
       for (var i = 0; i < this.jobs.length; i++) {
         this.$root.populateUserDetails(this.jobs[i], "userId", "owner");
@@ -96,6 +139,8 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
       this.form.srcPort = localStorage['settings.jobs.addJobForm.srcPort'];
       this.form.dstIp = localStorage['settings.jobs.addJobForm.dstIp'];
       this.form.dstPort = localStorage['settings.jobs.addJobForm.dstPort'];
+      this.form.vlans = localStorage['settings.jobs.addJobForm.vlans'];
+      this.form.bpf = localStorage['settings.jobs.addJobForm.bpf'];
       this.form.beginTime = localStorage['settings.jobs.addJobForm.beginTime'];
       this.form.endTime = localStorage['settings.jobs.addJobForm.endTime'];
     },
@@ -113,7 +158,7 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
       }
     },
     submitAddJob(event) {
-      this.addJob(this.form.sensorId, this.form.importId, this.form.protocol, this.form.srcIp, this.form.srcPort, this.form.dstIp, this.form.dstPort, this.form.beginTime, this.form.endTime);
+      this.addJob(this.form.sensorId, this.form.importId, this.form.protocol, this.form.srcIp, this.form.srcPort, this.form.dstIp, this.form.dstPort, this.form.beginTime, this.form.endTime, this.form.vlans, this.form.bpf);
       this.dialog = false;
       this.saveAddJobForm();
     },
@@ -125,6 +170,8 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
       if (this.form.srcPort) localStorage['settings.jobs.addJobForm.srcPort'] = this.form.srcPort;
       if (this.form.dstIp) localStorage['settings.jobs.addJobForm.dstIp'] = this.form.dstIp;
       if (this.form.dstPort) localStorage['settings.jobs.addJobForm.dstPort'] = this.form.dstPort;
+      if (this.form.vlans) localStorage['settings.jobs.addJobForm.vlans'] = this.form.vlans;
+      if (this.form.bpf) localStorage['settings.jobs.addJobForm.bpf'] = this.form.bpf;
       if (this.form.beginTime) localStorage['settings.jobs.addJobForm.beginTime'] = this.form.beginTime;
       if (this.form.endTime) localStorage['settings.jobs.addJobForm.endTime'] = this.form.endTime;
     },    
@@ -136,6 +183,8 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
       this.form.srcPort = null;
       this.form.dstIp = null;
       this.form.dstPort = null;
+      this.form.vlans = null;
+      this.form.bpf = null;
       this.form.beginTime = null;
       this.form.endTime = null;
       localStorage.removeItem('settings.jobs.addJobForm.sensorId');
@@ -145,10 +194,17 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
       localStorage.removeItem('settings.jobs.addJobForm.srcPort');
       localStorage.removeItem('settings.jobs.addJobForm.dstIp');
       localStorage.removeItem('settings.jobs.addJobForm.dstPort');
+      localStorage.removeItem('settings.jobs.addJobForm.vlans');
+      localStorage.removeItem('settings.jobs.addJobForm.bpf');
       localStorage.removeItem('settings.jobs.addJobForm.beginTime');
       localStorage.removeItem('settings.jobs.addJobForm.endTime');
     },
-    async addJob(sensorId, importId, protocol, srcIp, srcPort, dstIp, dstPort, beginTime, endTime) {
+    parseVlans(vlans) {
+      if (!vlans) return null;
+      const ids = String(vlans).split(',').map((id) => id.trim()).filter((id) => id.length > 0).map((id) => parseInt(id));
+      return ids.length > 0 ? ids : null;
+    },
+    async addJob(sensorId, importId, protocol, srcIp, srcPort, dstIp, dstPort, beginTime, endTime, vlans, bpf) {
       try {
         if (!sensorId) {
           this.$root.showError(this.i18n.sensorIdRequired);
@@ -158,8 +214,12 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
           }
           const beginDate = moment(beginTime);
           const endDate = moment(endTime);
+          // Multiple comma-separated sensors, or '*' for all sensors, create a parent job
+          const nodeIds = sensorId.split(',').map((id) => id.trim()).filter((id) => id.length > 0);
+          const multiple = nodeIds.length > 1 || nodeIds[0] == '*';
           const response = await this.$root.papi.post('job/', {
-            nodeId: sensorId,
+            nodeId: multiple ? '' : sensorId,
+            nodeIds: multiple ? nodeIds : null,
             filter: {
               importId: importId,
               protocol: protocol,
@@ -167,6 +227,8 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
               srcPort: parseInt(srcPort),
               dstIp: dstIp,
               dstPort: parseInt(dstPort),
+              vlans: this.parseVlans(vlans),
+              bpf: bpf ? bpf.trim() : '',
               beginTime: beginDate,
               endTime: endDate
             }
@@ -178,6 +240,18 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
          this.$root.showError(error);
       }
     },
+    async cancelJob(job) {
+      try {
+        if (job) {
+          await this.$root.papi.put('job/' + job.id + '/cancel');
+        }
+      } catch (error) {
+         this.$root.showError(error);
+      }
+    },
+    isCancellable(job) {
+      return job.status == JobStatusPending || job.status == JobStatusIncomplete;
+    },
     async deleteJob(job) {
       try {
         if (job) {
@@ -195,6 +269,18 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
         status = this.i18n.incomplete;
       } else if (job.status == JobStatusDeleted) {
         status = this.i18n.deleted;
+      } else if (job.status == JobStatusCancelRequested) {
+        status = this.i18n.cancelRequested;
+      } else if (job.status == JobStatusCancelled) {
+        status = this.i18n.cancelled;
+      } else if (job.status == JobStatusFailed) {
+        status = this.i18n.failed;
+      } else if (job.progress) {
+        const total = job.progress.filesTotal + job.progress.analyzersTotal;
+        const done = job.progress.filesScanned + job.progress.analyzersCompleted;
+        if (total > 0) {
+          status += ' (' + Math.floor(100 * done / total) + '%)';
+        }
       }
       return status;
     },
@@ -204,8 +290,10 @@ routes.push({ path: '/jobs', name: 'jobs', component: {
         color = "success";
       } else if (job.status == JobStatusIncomplete) {
         color = "info";
-      } else if (job.status == JobStatusDeleted) {
+      } else if (job.status == JobStatusDeleted || job.status == JobStatusCancelRequested || job.status == JobStatusCancelled) {
         color = "warning";
+      } else if (job.status == JobStatusFailed) {
+        color = "error";
       }
       return color;
     },
@@ -20,3 +20,20 @@ test('isKind', () => {
     expect(comp.isKind('pcap')).toBe(false);
     expect(comp.isKind('foo')).toBe(true);
 });
+
+test('buildQuery', () => {
+    comp.kind = 'pcap';
+    comp.statusFilter = [];
+    expect(comp.buildQuery('')).toStrictEqual({ kind: 'pcap', sortBy: 'id', sortDesc: true, limit: 500 });
+
+    comp.statusFilter = [0, 2];
+    expect(comp.buildQuery('abc')).toStrictEqual({ kind: 'pcap', sortBy: 'id', sortDesc: true, limit: 500, status: '0,2', cursor: 'abc' });
+});
+
+test('parseVlans', () => {
+    expect(comp.parseVlans(null)).toBe(null);
+    expect(comp.parseVlans('')).toBe(null);
+    expect(comp.parseVlans(' , ')).toBe(null);
+    expect(comp.parseVlans('10')).toStrictEqual([10]);
+    expect(comp.parseVlans('10, 20,')).toStrictEqual([10, 20]);
+});
@@ -0,0 +1,49 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package model
+
+import (
+	"time"
+)
+
+const CarvedFileProtocolHttp = "http"
+const CarvedFileProtocolFtpData = "ftp-data"
+const CarvedFileProtocolSmb2 = "smb2"
+
+// CarvedFile is an object transferred over one of a job's TCP connections and extracted
+// from its reassembled stream. Incomplete files are missing data, either because it was
+// not captured or because the stream exceeded the reassembly limit. Bytes holds the
+// content only when it was requested, such as when attaching the file to a case.
+type CarvedFile struct {
+	JobId       int       `json:"jobId"`
+	Index       int       `json:"index"`
+	Protocol    string    `json:"protocol"`
+	Name        string    `json:"name"`
+	MimeType    string    `json:"mimeType"`
+	Size        int       `json:"size"`
+	Incomplete  bool      `json:"incomplete"`
+	Timestamp   time.Time `json:"timestamp"`
+	StreamIndex int       `json:"streamIndex"`
+	ClientIp    string    `json:"clientIp"`
+	ClientPort  int       `json:"clientPort"`
+	ServerIp    string    `json:"serverIp"`
+	ServerPort  int       `json:"serverPort"`
+	Md5         string    `json:"md5"`
+	Sha1        string    `json:"sha1"`
+	Sha256      string    `json:"sha256"`
+	Bytes       []byte    `json:"-"`
+}
+
+// CarveRequest selects carved files of a job to attach to a case as artifacts. All of
+// the job's files are attached when no indexes are given.
+type CarveRequest struct {
+	CaseId      string   `json:"caseId"`
+	Indexes     []int    `json:"indexes"`
+	Unwrap      bool     `json:"unwrap"`
+	Tlp         string   `json:"tlp"`
+	Tags        []string `json:"tags"`
+	Description string   `json:"description"`
+}
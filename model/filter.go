@@ -7,13 +7,28 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
 )
 
 const PROTOCOL_ICMP = "icmp"
 const PROTOCOL_TCP = "tcp"
 const PROTOCOL_UDP = "udp"
 
+const MAX_VLAN_ID = 4095
+const MAX_BPF_LENGTH = 4096
+
+var protocolPattern = regexp.MustCompile(`(?i)^[a-z0-9]*$`)
+
 type Filter struct {
 	ImportId   string                 `json:"importId"`
 	BeginTime  time.Time              `json:"beginTime"`
@@ -23,6 +38,8 @@ type Filter struct {
 	DstIp      string                 `json:"dstIp"`
 	DstPort    int                    `json:"dstPort"`
 	Protocol   string                 `json:"protocol"`
+	Vlans      []int                  `json:"vlans,omitempty"`
+	Bpf        string                 `json:"bpf,omitempty"`
 	Parameters map[string]interface{} `json:"parameters"`
 }
 
@@ -31,3 +48,73 @@ func NewFilter() *Filter {
 		Parameters: make(map[string]interface{}),
 	}
 }
+
+// Fingerprint returns a digest identifying the packets selected by the filter. Filters
+// that differ only in formatting, such as time zone or protocol case, share the same
+// fingerprint.
+func (filter *Filter) Fingerprint() string {
+	params, _ := json.Marshal(filter.Parameters) // map keys are marshalled in sorted order
+	canonical := fmt.Sprintf("%s|%d|%d|%s|%d|%s|%d|%s|%s",
+		filter.ImportId,
+		filter.BeginTime.UnixNano(),
+		filter.EndTime.UnixNano(),
+		strings.TrimSpace(filter.SrcIp),
+		filter.SrcPort,
+		strings.TrimSpace(filter.DstIp),
+		filter.DstPort,
+		strings.ToLower(filter.Protocol),
+		params)
+	// Only extend the canonical form when needed so that existing fingerprints are unchanged
+	if len(filter.Vlans) > 0 || strings.TrimSpace(filter.Bpf) != "" {
+		vlans := append([]int{}, filter.Vlans...)
+		sort.Ints(vlans)
+		canonical = fmt.Sprintf("%s|%v|%s", canonical, vlans, strings.TrimSpace(filter.Bpf))
+	}
+	digest := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(digest[:])
+}
+
+// Validate checks the filter fields for values that cannot be safely turned into a packet
+// filter expression. Addresses may be IPv4 or IPv6 hosts, or CIDR ranges. The raw BPF
+// expression is only checked for illegal characters here; its syntax must be verified by
+// a BPF compiler.
+func (filter *Filter) Validate() error {
+	if !isIpOrCidr(filter.SrcIp) {
+		return errors.New("Invalid source IP address or CIDR range: " + filter.SrcIp)
+	}
+	if !isIpOrCidr(filter.DstIp) {
+		return errors.New("Invalid destination IP address or CIDR range: " + filter.DstIp)
+	}
+	if filter.SrcPort < 0 || filter.SrcPort > 65535 {
+		return fmt.Errorf("Invalid source port: %d", filter.SrcPort)
+	}
+	if filter.DstPort < 0 || filter.DstPort > 65535 {
+		return fmt.Errorf("Invalid destination port: %d", filter.DstPort)
+	}
+	if !protocolPattern.MatchString(filter.Protocol) {
+		return errors.New("Invalid protocol: " + filter.Protocol)
+	}
+	for _, vlan := range filter.Vlans {
+		if vlan < 0 || vlan > MAX_VLAN_ID {
+			return fmt.Errorf("Invalid VLAN id: %d", vlan)
+		}
+	}
+	if len(filter.Bpf) > MAX_BPF_LENGTH {
+		return errors.New("BPF expression is too long")
+	}
+	for _, char := range filter.Bpf {
+		if unicode.IsControl(char) && char != '\t' {
+			return errors.New("BPF expression contains illegal characters")
+		}
+	}
+	return nil
+}
+
+func isIpOrCidr(value string) bool {
+	value = strings.TrimSpace(value)
+	if value == "" || net.ParseIP(value) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(value)
+	return err == nil
+}
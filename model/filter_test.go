@@ -0,0 +1,79 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterValidate(tester *testing.T) {
+	filter := NewFilter()
+	assert.NoError(tester, filter.Validate())
+
+	filter.SrcIp = "10.0.0.0/8"
+	filter.DstIp = "2001:db8::1"
+	filter.SrcPort = 65535
+	filter.Protocol = "TCP"
+	filter.Vlans = []int{0, 100, 4095}
+	filter.Bpf = "tcp[tcpflags] & tcp-syn != 0"
+	assert.NoError(tester, filter.Validate())
+
+	filter.DstIp = "2001:db8::/32"
+	assert.NoError(tester, filter.Validate())
+
+	filter.SrcIp = "10.0.0.0/33"
+	assert.EqualError(tester, filter.Validate(), "Invalid source IP address or CIDR range: 10.0.0.0/33")
+	filter.SrcIp = "1.2.3.4 or host 5.6.7.8"
+	assert.Error(tester, filter.Validate())
+	filter.SrcIp = ""
+
+	filter.DstIp = "example.com"
+	assert.EqualError(tester, filter.Validate(), "Invalid destination IP address or CIDR range: example.com")
+	filter.DstIp = ""
+
+	filter.DstPort = 65536
+	assert.EqualError(tester, filter.Validate(), "Invalid destination port: 65536")
+	filter.DstPort = 0
+	filter.SrcPort = -1
+	assert.EqualError(tester, filter.Validate(), "Invalid source port: -1")
+	filter.SrcPort = 0
+
+	filter.Protocol = "tcp or udp"
+	assert.EqualError(tester, filter.Validate(), "Invalid protocol: tcp or udp")
+	filter.Protocol = ""
+
+	filter.Vlans = []int{4096}
+	assert.EqualError(tester, filter.Validate(), "Invalid VLAN id: 4096")
+	filter.Vlans = nil
+
+	filter.Bpf = "tcp\nport 80"
+	assert.EqualError(tester, filter.Validate(), "BPF expression contains illegal characters")
+	filter.Bpf = strings.Repeat("a", MAX_BPF_LENGTH+1)
+	assert.EqualError(tester, filter.Validate(), "BPF expression is too long")
+}
+
+func TestFilterFingerprintVlansAndBpf(tester *testing.T) {
+	filter := NewFilter()
+	filter.SrcIp = "10.0.0.1"
+	legacy := filter.Fingerprint()
+
+	filter.Vlans = []int{20, 10}
+	withVlans := filter.Fingerprint()
+	assert.NotEqual(tester, legacy, withVlans)
+
+	filter.Vlans = []int{10, 20}
+	assert.Equal(tester, withVlans, filter.Fingerprint())
+
+	filter.Bpf = "tcp"
+	assert.NotEqual(tester, withVlans, filter.Fingerprint())
+
+	filter.Vlans = nil
+	filter.Bpf = ""
+	assert.Equal(tester, legacy, filter.Fingerprint())
+}
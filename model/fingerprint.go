@@ -0,0 +1,46 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package model
+
+import (
+	"time"
+)
+
+const FingerprintTypeJa3 = "ja3"
+const FingerprintTypeJa3s = "ja3s"
+const FingerprintTypeJa4 = "ja4"
+const FingerprintTypeHassh = "hassh"
+const FingerprintTypeHasshServer = "hasshServer"
+
+// PacketFingerprint identifies the client or server software behind a TLS or SSH
+// handshake. Raw holds the string the fingerprint value was derived from.
+type PacketFingerprint struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Raw   string `json:"raw"`
+}
+
+// FingerprintSummary lists the distinct fingerprints observed in a job's packets, in the
+// order they first appear.
+type FingerprintSummary struct {
+	JobId        int                        `json:"jobId"`
+	Fingerprints []*FingerprintSummaryEntry `json:"fingerprints"`
+}
+
+// FingerprintSummaryEntry totals the handshakes sharing one fingerprint. Query searches
+// events for the same fingerprint, so analysts can pivot from the packets to the logs.
+type FingerprintSummaryEntry struct {
+	Type        string    `json:"type"`
+	Value       string    `json:"value"`
+	Raw         string    `json:"raw"`
+	Count       int       `json:"count"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+	ClientIps   []string  `json:"clientIps"`
+	ServerIps   []string  `json:"serverIps"`
+	ServerNames []string  `json:"serverNames,omitempty"`
+	Query       string    `json:"query"`
+}
@@ -7,6 +7,7 @@
 package model
 
 import (
+	"fmt"
 	"strings"
 	"time"
 )
@@ -15,9 +16,15 @@ const JobStatusPending = 0
 const JobStatusCompleted = 1
 const JobStatusIncomplete = 2
 const JobStatusDeleted = 3
+const JobStatusCancelRequested = 4
+const JobStatusCancelled = 5
+const JobStatusFailed = 6
 
 const DEFAULT_JOB_KIND = "pcap"
 
+// JobAllNodes may be given as a parent job's node id to target all nodes with PCAP.
+const JobAllNodes = "*"
+
 type JobResult struct {
 	Id      string      `json:"id"`
 	Data    interface{} `json:"data"`
@@ -32,23 +39,64 @@ func NewJobResult(id string, data interface{}, summary string) *JobResult {
 	}
 }
 
+// JobProgress describes how far an agent has gotten while processing a job. Agents only
+// fill in the counters relevant to the job kind.
+type JobProgress struct {
+	FilesTotal            int       `json:"filesTotal"`
+	FilesScanned          int       `json:"filesScanned"`
+	PacketsMatched        int       `json:"packetsMatched"`
+	BytesWritten          int64     `json:"bytesWritten"`
+	AnalyzersTotal        int       `json:"analyzersTotal"`
+	AnalyzersCompleted    int       `json:"analyzersCompleted"`
+	StartTime             time.Time `json:"startTime"`
+	UpdateTime            time.Time `json:"updateTime"`
+	EstimatedCompleteTime time.Time `json:"estimatedCompleteTime"`
+}
+
+func NewJobProgress() *JobProgress {
+	return &JobProgress{
+		StartTime: time.Now(),
+	}
+}
+
+// Estimate extrapolates the completion time from the fraction of files and analyzers
+// already processed, assuming the remaining work proceeds at the same rate.
+func (progress *JobProgress) Estimate(now time.Time) {
+	progress.UpdateTime = now
+	total := progress.FilesTotal + progress.AnalyzersTotal
+	done := progress.FilesScanned + progress.AnalyzersCompleted
+	if total > 0 && done > 0 {
+		elapsed := now.Sub(progress.StartTime)
+		progress.EstimatedCompleteTime = progress.StartTime.Add(elapsed * time.Duration(total) / time.Duration(done))
+	}
+}
+
 type Job struct {
-	Id             int          `json:"id"`
-	CreateTime     time.Time    `json:"createTime"`
-	Status         int          `json:"status"`
-	CompleteTime   time.Time    `json:"completeTime"`
-	FailTime       time.Time    `json:"failTime"`
-	Failure        string       `json:"failure"`
-	FailCount      int          `json:"failCount"`
-	Owner          string       `json:"owner"`
-	NodeId         string       `json:"nodeId"`
-	LegacySensorId string       `json:"sensorId"`
-	FileExtension  string       `json:"fileExtension"`
-	Filter         *Filter      `json:"filter"`
-	UserId         string       `json:"userId"`
-	Kind           string       `json:"kind"`
-	Results        []*JobResult `json:"results"`
-	Size           int          `json:"size"`
+	Id              int          `json:"id"`
+	CreateTime      time.Time    `json:"createTime"`
+	Status          int          `json:"status"`
+	CompleteTime    time.Time    `json:"completeTime"`
+	FailTime        time.Time    `json:"failTime"`
+	Failure         string       `json:"failure"`
+	FailCount       int          `json:"failCount"`
+	Owner           string       `json:"owner"`
+	NodeId          string       `json:"nodeId"`
+	LegacySensorId  string       `json:"sensorId"`
+	FileExtension   string       `json:"fileExtension"`
+	Filter          *Filter      `json:"filter"`
+	UserId          string       `json:"userId"`
+	Kind            string       `json:"kind"`
+	Results         []*JobResult `json:"results"`
+	Size            int          `json:"size"`
+	Priority        int          `json:"priority"`
+	Progress        *JobProgress `json:"progress"`
+	NodeIds         []string     `json:"nodeIds"`
+	ParentId        int          `json:"parentId"`
+	ChildIds        []int        `json:"childIds"`
+	ScheduleId      string       `json:"scheduleId"`
+	ReusedJobId     int          `json:"reusedJobId"`
+	LeaseExpireTime time.Time    `json:"leaseExpireTime"`
+	UpdateKey       string       `json:"updateKey"`
 }
 
 func NewJob() *Job {
@@ -85,8 +133,60 @@ func (job *Job) GetNodeId() string {
 	return job.NodeId
 }
 
+// Fingerprint identifies the results the job will produce, based on the job kind, the
+// node and the filter.
+func (job *Job) Fingerprint() string {
+	filter := job.Filter
+	if filter == nil {
+		filter = NewFilter()
+	}
+	return job.GetKind() + "/" + job.GetNodeId() + "/" + filter.Fingerprint()
+}
+
+// CanReuseResultsOf returns true if the source job's PCAP stream can satisfy this job
+// without any further agent processing. The requested time range must have ended
+// before the source job was created, otherwise the source may be missing packets that
+// have since been captured.
+func (job *Job) CanReuseResultsOf(source *Job) bool {
+	return job.GetKind() == DEFAULT_JOB_KIND &&
+		job.GetNodeId() != "" &&
+		len(job.NodeIds) == 0 &&
+		job.Filter != nil &&
+		!job.Filter.EndTime.IsZero() &&
+		source.Id != job.Id &&
+		source.Status == JobStatusCompleted &&
+		job.Filter.EndTime.Before(source.CreateTime) &&
+		job.Fingerprint() == source.Fingerprint()
+}
+
+// ReuseResultsOf completes the job using the results of the given source job.
+func (job *Job) ReuseResultsOf(source *Job, size int) {
+	job.ReusedJobId = source.Id
+	job.FileExtension = source.FileExtension
+	job.Size = size
+	job.Complete()
+}
+
+func (job *Job) IsParent() bool {
+	return len(job.ChildIds) > 0
+}
+
+func (job *Job) IsQueued() bool {
+	return job.Status == JobStatusPending || job.Status == JobStatusIncomplete
+}
+
+func (job *Job) IsCancelRequested() bool {
+	return job.Status == JobStatusCancelRequested
+}
+
 func (job *Job) CanProcess() bool {
-	return job.Status != JobStatusCompleted && job.Status != JobStatusDeleted
+	return job.IsQueued() || job.IsCancelRequested()
+}
+
+// IsFinished reports whether the job has reached a final status and will not be processed
+// again.
+func (job *Job) IsFinished() bool {
+	return job.Status == JobStatusCompleted || job.Status == JobStatusCancelled || job.Status == JobStatusFailed
 }
 
 func (job *Job) Complete() {
@@ -94,6 +194,52 @@ func (job *Job) Complete() {
 	job.CompleteTime = time.Now()
 }
 
+func (job *Job) Cancel() {
+	job.Status = JobStatusCancelled
+	job.CompleteTime = time.Now()
+}
+
+// Lease reserves the job for the agent it was just handed to. The agent must renew the
+// lease before it expires, otherwise the job is presumed abandoned.
+func (job *Job) Lease(now time.Time, duration time.Duration) {
+	job.LeaseExpireTime = now.Add(duration)
+}
+
+func (job *Job) ReleaseLease() {
+	job.LeaseExpireTime = time.Time{}
+}
+
+func (job *Job) IsLeased(now time.Time) bool {
+	return job.LeaseExpireTime.After(now)
+}
+
+func (job *Job) IsLeaseExpired(now time.Time) bool {
+	return !job.LeaseExpireTime.IsZero() && !job.LeaseExpireTime.After(now)
+}
+
+// ExpireLease handles a job whose agent stopped renewing the lease. The job returns to
+// pending so that it is handed out again, unless the job has already failed maxAttempts
+// times, in which case the job fails permanently. A job awaiting cancellation is
+// simply cancelled since no agent remains to acknowledge it.
+func (job *Job) ExpireLease(now time.Time, maxAttempts int) {
+	job.ReleaseLease()
+	if job.IsCancelRequested() {
+		job.Cancel()
+		return
+	}
+
+	job.FailTime = now
+	job.FailCount++
+	if maxAttempts > 0 && job.FailCount >= maxAttempts {
+		job.Status = JobStatusFailed
+		job.CompleteTime = now
+		job.Failure = fmt.Sprintf("Job lease expired without a response from the agent; giving up after %d attempts", job.FailCount)
+	} else {
+		job.Status = JobStatusPending
+		job.Failure = "Job lease expired without a response from the agent; the job will be retried"
+	}
+}
+
 func (job *Job) Fail(err error) {
 	job.Status = JobStatusIncomplete
 	job.Failure = err.Error()
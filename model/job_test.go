@@ -9,6 +9,7 @@ package model
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -75,6 +76,34 @@ func TestCanProcess(tester *testing.T) {
 	job = NewJob()
 	job.Status = JobStatusDeleted
 	assert.False(tester, job.CanProcess())
+
+	job.Status = JobStatusCancelRequested
+	assert.True(tester, job.CanProcess())
+	assert.True(tester, job.IsCancelRequested())
+	assert.False(tester, job.IsQueued())
+
+	job.Cancel()
+	assert.Equal(tester, JobStatusCancelled, job.Status)
+	assert.False(tester, job.CanProcess())
+	assert.False(tester, job.IsCancelRequested())
+}
+
+func TestIsFinished(tester *testing.T) {
+	job := NewJob()
+	assert.False(tester, job.IsFinished())
+	job.Fail(errors.New("Something"))
+	assert.False(tester, job.IsFinished())
+	job.Status = JobStatusCancelRequested
+	assert.False(tester, job.IsFinished())
+	job.Status = JobStatusDeleted
+	assert.False(tester, job.IsFinished())
+
+	job.Complete()
+	assert.True(tester, job.IsFinished())
+	job.Cancel()
+	assert.True(tester, job.IsFinished())
+	job.Status = JobStatusFailed
+	assert.True(tester, job.IsFinished())
 }
 
 func TestKind(tester *testing.T) {
@@ -84,3 +113,152 @@ func TestKind(tester *testing.T) {
 	job.Kind = "foo"
 	assert.Equal(tester, "foo", job.GetKind())
 }
+
+func TestIsQueued(tester *testing.T) {
+	job := NewJob()
+	assert.True(tester, job.IsQueued())
+	job.Fail(errors.New("Something"))
+	assert.True(tester, job.IsQueued())
+
+	job.Complete()
+	assert.False(tester, job.IsQueued())
+
+	job.Status = JobStatusDeleted
+	assert.False(tester, job.IsQueued())
+}
+
+func TestJobProgressEstimate(tester *testing.T) {
+	progress := NewJobProgress()
+	progress.StartTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := progress.StartTime.Add(time.Minute)
+
+	progress.Estimate(now)
+	assert.Equal(tester, now, progress.UpdateTime)
+	assert.True(tester, progress.EstimatedCompleteTime.IsZero())
+
+	progress.FilesTotal = 4
+	progress.FilesScanned = 1
+	progress.Estimate(now)
+	assert.Equal(tester, progress.StartTime.Add(time.Minute*4), progress.EstimatedCompleteTime)
+
+	progress.AnalyzersTotal = 4
+	progress.AnalyzersCompleted = 3
+	progress.Estimate(now)
+	assert.Equal(tester, progress.StartTime.Add(time.Minute*2), progress.EstimatedCompleteTime)
+}
+
+func TestIsParent(tester *testing.T) {
+	job := NewJob()
+	assert.False(tester, job.IsParent())
+	job.ChildIds = []int{1002}
+	assert.True(tester, job.IsParent())
+}
+
+func newReuseTestJob(id int, endTime time.Time) *Job {
+	job := NewJob()
+	job.Id = id
+	job.SetNodeId("sensor1")
+	job.Filter.BeginTime = endTime.Add(-time.Hour)
+	job.Filter.EndTime = endTime
+	job.Filter.SrcIp = "10.0.0.1"
+	job.Filter.DstPort = 443
+	job.Filter.Protocol = "tcp"
+	return job
+}
+
+func TestJobFingerprint(tester *testing.T) {
+	end := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	job := newReuseTestJob(1001, end)
+	other := newReuseTestJob(1002, end.In(time.FixedZone("EST", -5*3600)))
+	other.Filter.Protocol = "TCP"
+	assert.Equal(tester, job.Fingerprint(), other.Fingerprint())
+
+	other.SetNodeId("sensor2")
+	assert.NotEqual(tester, job.Fingerprint(), other.Fingerprint())
+
+	other = newReuseTestJob(1002, end)
+	other.Filter.DstPort = 80
+	assert.NotEqual(tester, job.Fingerprint(), other.Fingerprint())
+
+	other = newReuseTestJob(1002, end)
+	other.Filter.Parameters["foo"] = "bar"
+	assert.NotEqual(tester, job.Fingerprint(), other.Fingerprint())
+
+	other.Kind = "analyze"
+	other.Filter.Parameters = map[string]interface{}{}
+	assert.NotEqual(tester, job.Fingerprint(), other.Fingerprint())
+}
+
+func TestJobCanReuseResultsOf(tester *testing.T) {
+	end := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	source := newReuseTestJob(1001, end)
+	source.CreateTime = end.Add(time.Minute)
+	job := newReuseTestJob(1002, end)
+
+	assert.False(tester, job.CanReuseResultsOf(source), "source is not complete")
+
+	source.Complete()
+	assert.True(tester, job.CanReuseResultsOf(source))
+	assert.False(tester, source.CanReuseResultsOf(source))
+
+	source.CreateTime = end.Add(-time.Minute)
+	assert.False(tester, job.CanReuseResultsOf(source), "source was created before the time range ended")
+	source.CreateTime = end.Add(time.Minute)
+
+	job.Filter.EndTime = time.Time{}
+	assert.False(tester, job.CanReuseResultsOf(source), "open ended time range")
+
+	job = newReuseTestJob(1002, end)
+	job.NodeIds = []string{"sensor1"}
+	assert.False(tester, job.CanReuseResultsOf(source), "parent jobs are never reused")
+
+	job = newReuseTestJob(1002, end)
+	job.ReuseResultsOf(source, 123)
+	assert.Equal(tester, JobStatusCompleted, job.Status)
+	assert.Equal(tester, 1001, job.ReusedJobId)
+	assert.Equal(tester, 123, job.Size)
+}
+
+func TestJobLease(tester *testing.T) {
+	now := time.Now()
+	job := NewJob()
+	assert.False(tester, job.IsLeased(now))
+	assert.False(tester, job.IsLeaseExpired(now))
+
+	job.Lease(now, time.Minute)
+	assert.True(tester, job.IsLeased(now))
+	assert.False(tester, job.IsLeaseExpired(now))
+	assert.False(tester, job.IsLeased(now.Add(time.Minute)))
+	assert.True(tester, job.IsLeaseExpired(now.Add(time.Minute)))
+
+	job.ReleaseLease()
+	assert.False(tester, job.IsLeased(now))
+	assert.False(tester, job.IsLeaseExpired(now.Add(time.Minute)))
+}
+
+func TestJobExpireLease(tester *testing.T) {
+	now := time.Now()
+	job := NewJob()
+	job.Status = JobStatusIncomplete
+	job.Lease(now, time.Minute)
+
+	job.ExpireLease(now, 2)
+	assert.Equal(tester, JobStatusPending, job.Status)
+	assert.Equal(tester, 1, job.FailCount)
+	assert.Equal(tester, now, job.FailTime)
+	assert.True(tester, job.LeaseExpireTime.IsZero())
+	assert.Contains(tester, job.Failure, "will be retried")
+
+	job.ExpireLease(now, 2)
+	assert.Equal(tester, JobStatusFailed, job.Status)
+	assert.Equal(tester, 2, job.FailCount)
+	assert.Equal(tester, now, job.CompleteTime)
+	assert.Equal(tester, "Job lease expired without a response from the agent; giving up after 2 attempts", job.Failure)
+	assert.False(tester, job.CanProcess())
+
+	job = NewJob()
+	job.Status = JobStatusCancelRequested
+	job.ExpireLease(now, 2)
+	assert.Equal(tester, JobStatusCancelled, job.Status)
+	assert.Equal(tester, 0, job.FailCount)
+}
@@ -0,0 +1,173 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const JobSortId = "id"
+const JobSortCreateTime = "createTime"
+const JobSortCompleteTime = "completeTime"
+const JobSortStatus = "status"
+const JobSortPriority = "priority"
+
+var jobSortKeys = map[string]func(job *Job) int64{
+	JobSortId:           func(job *Job) int64 { return int64(job.Id) },
+	JobSortCreateTime:   func(job *Job) int64 { return job.CreateTime.UnixNano() },
+	JobSortCompleteTime: func(job *Job) int64 { return job.CompleteTime.UnixNano() },
+	JobSortStatus:       func(job *Job) int64 { return int64(job.Status) },
+	JobSortPriority:     func(job *Job) int64 { return int64(job.Priority) },
+}
+
+// JobQuery selects, orders and pages through jobs. Empty criteria match every job. A
+// zero Limit returns all matching jobs in a single page.
+type JobQuery struct {
+	Kind            string
+	Parameters      map[string]interface{}
+	Statuses        []int
+	UserId          string
+	NodeId          string
+	CreateTimeBegin time.Time
+	CreateTimeEnd   time.Time
+	SortBy          string
+	SortDescending  bool
+	Limit           int
+	Cursor          string
+}
+
+// JobPage is a single page of query results. NextCursor is empty once the last page
+// has been returned.
+type JobPage struct {
+	Jobs       []*Job `json:"jobs"`
+	NextCursor string `json:"nextCursor"`
+	TotalCount int    `json:"totalCount"`
+}
+
+func NewJobQuery() *JobQuery {
+	return &JobQuery{
+		Kind:   DEFAULT_JOB_KIND,
+		SortBy: JobSortId,
+	}
+}
+
+func (query *JobQuery) Validate() error {
+	if _, ok := jobSortKeys[query.SortBy]; !ok {
+		return errors.New("Unsupported job sort field: " + query.SortBy)
+	}
+	if query.Limit < 0 {
+		return errors.New("Job query limit must not be negative")
+	}
+	if !query.CreateTimeBegin.IsZero() && !query.CreateTimeEnd.IsZero() && !query.CreateTimeBegin.Before(query.CreateTimeEnd) {
+		return errors.New("Job query create time range is empty")
+	}
+	_, _, err := query.decodeCursor()
+	return err
+}
+
+// Matches returns true if the job satisfies every criteria of the query other than the
+// filter parameters, which are matched by the datastore.
+func (query *JobQuery) Matches(job *Job) bool {
+	if query.Kind != "" && job.GetKind() != query.Kind {
+		return false
+	}
+	if len(query.Statuses) > 0 && !slices.Contains(query.Statuses, job.Status) {
+		return false
+	}
+	if query.UserId != "" && job.UserId != query.UserId {
+		return false
+	}
+	if query.NodeId != "" {
+		nodeId := strings.ToLower(query.NodeId)
+		if job.GetNodeId() != nodeId && !slices.Contains(job.NodeIds, nodeId) {
+			return false
+		}
+	}
+	if !query.CreateTimeBegin.IsZero() && job.CreateTime.Before(query.CreateTimeBegin) {
+		return false
+	}
+	if !query.CreateTimeEnd.IsZero() && !job.CreateTime.Before(query.CreateTimeEnd) {
+		return false
+	}
+	return true
+}
+
+func (query *JobQuery) isBefore(sortKey func(job *Job) int64, job *Job, value int64, id int) bool {
+	jobValue := sortKey(job)
+	if jobValue == value {
+		if query.SortDescending {
+			return job.Id > id
+		}
+		return job.Id < id
+	}
+	if query.SortDescending {
+		return jobValue > value
+	}
+	return jobValue < value
+}
+
+func (query *JobQuery) encodeCursor(value int64, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d,%d", value, id)))
+}
+
+func (query *JobQuery) decodeCursor() (int64, int, error) {
+	var value int64
+	var id int
+	if query.Cursor == "" {
+		return value, id, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err == nil {
+		_, err = fmt.Sscanf(string(decoded), "%d,%d", &value, &id)
+	}
+	if err != nil {
+		err = errors.New("Invalid job query cursor")
+	}
+	return value, id, err
+}
+
+// Paginate sorts the matching jobs and returns the page following the query's cursor.
+// Jobs are ordered by the sort field, with ties broken by job id, so that a cursor
+// remains valid while new jobs are added.
+func (query *JobQuery) Paginate(jobs []*Job) (*JobPage, error) {
+	sortKey, ok := jobSortKeys[query.SortBy]
+	if !ok {
+		return nil, errors.New("Unsupported job sort field: " + query.SortBy)
+	}
+	cursorValue, cursorId, err := query.decodeCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return query.isBefore(sortKey, jobs[i], sortKey(jobs[j]), jobs[j].Id)
+	})
+
+	start := 0
+	if query.Cursor != "" {
+		start = sort.Search(len(jobs), func(i int) bool {
+			return !query.isBefore(sortKey, jobs[i], cursorValue, cursorId) &&
+				!(sortKey(jobs[i]) == cursorValue && jobs[i].Id == cursorId)
+		})
+	}
+
+	page := &JobPage{
+		Jobs:       jobs[start:],
+		TotalCount: len(jobs),
+	}
+	if query.Limit > 0 && len(page.Jobs) > query.Limit {
+		page.Jobs = page.Jobs[:query.Limit]
+		last := page.Jobs[query.Limit-1]
+		page.NextCursor = query.encodeCursor(sortKey(last), last.Id)
+	}
+	return page, nil
+}
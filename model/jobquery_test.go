@@ -0,0 +1,134 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newQueryTestJob(id int, status int, created time.Time) *Job {
+	job := NewJob()
+	job.Id = id
+	job.Status = status
+	job.CreateTime = created
+	job.UserId = "user1"
+	job.SetNodeId("sensor1")
+	return job
+}
+
+func jobIds(page *JobPage) []int {
+	ids := make([]int, 0, len(page.Jobs))
+	for _, job := range page.Jobs {
+		ids = append(ids, job.Id)
+	}
+	return ids
+}
+
+func TestJobQueryMatches(tester *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	job := newQueryTestJob(1001, JobStatusCompleted, now)
+
+	query := NewJobQuery()
+	assert.True(tester, query.Matches(job))
+
+	query.Kind = "analyze"
+	assert.False(tester, query.Matches(job))
+	query.Kind = ""
+	assert.True(tester, query.Matches(job))
+
+	query.Statuses = []int{JobStatusPending, JobStatusIncomplete}
+	assert.False(tester, query.Matches(job))
+	query.Statuses = []int{JobStatusCompleted}
+	assert.True(tester, query.Matches(job))
+
+	query.UserId = "user2"
+	assert.False(tester, query.Matches(job))
+	query.UserId = "user1"
+
+	query.NodeId = "Sensor2"
+	assert.False(tester, query.Matches(job))
+	job.NodeIds = []string{"sensor2"}
+	assert.True(tester, query.Matches(job))
+	query.NodeId = "SENSOR1"
+	assert.True(tester, query.Matches(job))
+
+	query.CreateTimeBegin = now
+	query.CreateTimeEnd = now.Add(time.Second)
+	assert.True(tester, query.Matches(job))
+	query.CreateTimeBegin = now.Add(time.Nanosecond)
+	assert.False(tester, query.Matches(job))
+	query.CreateTimeBegin = time.Time{}
+	query.CreateTimeEnd = now
+	assert.False(tester, query.Matches(job))
+}
+
+func TestJobQueryValidate(tester *testing.T) {
+	query := NewJobQuery()
+	assert.NoError(tester, query.Validate())
+
+	query.SortBy = "failure"
+	assert.EqualError(tester, query.Validate(), "Unsupported job sort field: failure")
+
+	query = NewJobQuery()
+	query.Limit = -1
+	assert.Error(tester, query.Validate())
+
+	query = NewJobQuery()
+	query.CreateTimeBegin = time.Now()
+	query.CreateTimeEnd = query.CreateTimeBegin
+	assert.Error(tester, query.Validate())
+
+	query = NewJobQuery()
+	query.Cursor = "!!!"
+	assert.EqualError(tester, query.Validate(), "Invalid job query cursor")
+}
+
+func TestJobQueryPaginate(tester *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	jobs := []*Job{
+		newQueryTestJob(1003, JobStatusPending, now.Add(time.Minute)),
+		newQueryTestJob(1001, JobStatusCompleted, now),
+		newQueryTestJob(1004, JobStatusPending, now.Add(time.Minute)),
+		newQueryTestJob(1002, JobStatusCompleted, now.Add(2*time.Minute)),
+	}
+
+	query := NewJobQuery()
+	page, err := query.Paginate(jobs)
+	assert.NoError(tester, err)
+	assert.Equal(tester, []int{1001, 1002, 1003, 1004}, jobIds(page))
+	assert.Empty(tester, page.NextCursor)
+	assert.Equal(tester, 4, page.TotalCount)
+
+	// Ties on the sort field are broken by id
+	query.SortBy = JobSortCreateTime
+	query.SortDescending = true
+	query.Limit = 2
+	page, err = query.Paginate(jobs)
+	assert.NoError(tester, err)
+	assert.Equal(tester, []int{1002, 1004}, jobIds(page))
+	assert.NotEmpty(tester, page.NextCursor)
+
+	query.Cursor = page.NextCursor
+	page, err = query.Paginate(jobs)
+	assert.NoError(tester, err)
+	assert.Equal(tester, []int{1003, 1001}, jobIds(page))
+	assert.Empty(tester, page.NextCursor)
+	assert.Equal(tester, 4, page.TotalCount)
+
+	// Jobs added ahead of the cursor do not shift the following page
+	query.SortBy = JobSortId
+	query.SortDescending = false
+	query.Limit = 2
+	query.Cursor = ""
+	page, _ = query.Paginate(jobs)
+	query.Cursor = page.NextCursor
+	jobs = append(jobs, newQueryTestJob(1000, JobStatusPending, now))
+	page, _ = query.Paginate(jobs)
+	assert.Equal(tester, []int{1003, 1004}, jobIds(page))
+}
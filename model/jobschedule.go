@@ -0,0 +1,91 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package model
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+const DEFAULT_JOB_SCHEDULE_HISTORY_LENGTH = 50
+
+// JobScheduleRun records a single attempt to instantiate a job from a schedule. Either
+// JobId or Error will be set.
+type JobScheduleRun struct {
+	Time  time.Time `json:"time"`
+	JobId int       `json:"jobId"`
+	Error string    `json:"error"`
+}
+
+// JobSchedule periodically creates a new job from the template, according to a
+// standard five field cron expression. Jobs are created on behalf of the schedule's
+// owner. When LookbackSeconds is set, each job's filter is given a time range ending at
+// the time the job was created, so that recurring PCAP pulls cover the latest traffic.
+type JobSchedule struct {
+	Id              string            `json:"id"`
+	Name            string            `json:"name"`
+	CronExpression  string            `json:"cronExpression"`
+	Template        *Job              `json:"template"`
+	LookbackSeconds int               `json:"lookbackSeconds"`
+	Enabled         bool              `json:"enabled"`
+	Owner           string            `json:"owner"`
+	UserId          string            `json:"userId"`
+	CreateTime      time.Time         `json:"createTime"`
+	UpdateTime      time.Time         `json:"updateTime"`
+	LastRunTime     time.Time         `json:"lastRunTime"`
+	NextRunTime     time.Time         `json:"nextRunTime"`
+	History         []*JobScheduleRun `json:"history"`
+}
+
+func NewJobSchedule() *JobSchedule {
+	return &JobSchedule{
+		Enabled:    true,
+		CreateTime: time.Now(),
+		Template:   NewJob(),
+		History:    make([]*JobScheduleRun, 0),
+	}
+}
+
+// Copy returns a copy of the schedule that can be read while the original is updated.
+func (schedule *JobSchedule) Copy() *JobSchedule {
+	copied := *schedule
+	if schedule.Template != nil {
+		template := *schedule.Template
+		copied.Template = &template
+	}
+	copied.History = slices.Clone(schedule.History)
+	return &copied
+}
+
+// AddRun appends the run to the history, discarding the oldest runs once the history
+// exceeds maxLength entries.
+func (schedule *JobSchedule) AddRun(run *JobScheduleRun, maxLength int) {
+	schedule.LastRunTime = run.Time
+	schedule.History = append(schedule.History, run)
+	if maxLength > 0 && len(schedule.History) > maxLength {
+		schedule.History = schedule.History[len(schedule.History)-maxLength:]
+	}
+}
+
+// ApplyTemplate copies the template onto a newly created job.
+func (schedule *JobSchedule) ApplyTemplate(job *Job) {
+	job.Kind = schedule.Template.Kind
+	job.Priority = schedule.Template.Priority
+	job.Owner = schedule.Owner
+	job.ScheduleId = schedule.Id
+	job.SetNodeId(schedule.Template.GetNodeId())
+	job.NodeIds = append([]string(nil), schedule.Template.NodeIds...)
+	if schedule.Template.Filter != nil {
+		filter := *schedule.Template.Filter
+		filter.Parameters = maps.Clone(filter.Parameters)
+		job.Filter = &filter
+	}
+	if schedule.LookbackSeconds > 0 {
+		job.Filter.EndTime = job.CreateTime
+		job.Filter.BeginTime = job.CreateTime.Add(-time.Duration(schedule.LookbackSeconds) * time.Second)
+	}
+}
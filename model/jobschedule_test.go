@@ -0,0 +1,75 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobScheduleAddRun(tester *testing.T) {
+	schedule := NewJobSchedule()
+	assert.True(tester, schedule.Enabled)
+	assert.Empty(tester, schedule.History)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		schedule.AddRun(&JobScheduleRun{Time: start.Add(time.Duration(i) * time.Minute), JobId: 1000 + i}, 3)
+	}
+
+	assert.Len(tester, schedule.History, 3)
+	assert.Equal(tester, 1002, schedule.History[0].JobId)
+	assert.Equal(tester, 1004, schedule.History[2].JobId)
+	assert.Equal(tester, start.Add(4*time.Minute), schedule.LastRunTime)
+}
+
+func TestJobScheduleApplyTemplate(tester *testing.T) {
+	schedule := NewJobSchedule()
+	schedule.Id = "abc"
+	schedule.Owner = "analyst@somewhere.invalid"
+	schedule.Template.Kind = "analyze"
+	schedule.Template.Priority = 3
+	schedule.Template.SetNodeId("Sensor1")
+	schedule.Template.Filter.SrcIp = "10.1.2.3"
+	schedule.Template.Filter.Parameters["artifact"] = "watchlist"
+
+	job := NewJob()
+	schedule.ApplyTemplate(job)
+	assert.Equal(tester, "analyze", job.Kind)
+	assert.Equal(tester, 3, job.Priority)
+	assert.Equal(tester, "sensor1", job.NodeId)
+	assert.Equal(tester, "abc", job.ScheduleId)
+	assert.Equal(tester, "analyst@somewhere.invalid", job.Owner)
+	assert.Equal(tester, "10.1.2.3", job.Filter.SrcIp)
+	assert.True(tester, job.Filter.EndTime.IsZero())
+
+	// Changes to the job must not leak back into the template
+	job.Filter.Parameters["artifact"] = "changed"
+	assert.Equal(tester, "watchlist", schedule.Template.Filter.Parameters["artifact"])
+
+	schedule.LookbackSeconds = 3600
+	job = NewJob()
+	schedule.ApplyTemplate(job)
+	assert.Equal(tester, job.CreateTime, job.Filter.EndTime)
+	assert.Equal(tester, job.CreateTime.Add(-time.Hour), job.Filter.BeginTime)
+}
+
+func TestJobScheduleCopy(tester *testing.T) {
+	schedule := NewJobSchedule()
+	schedule.Template.SetNodeId("sensor1")
+	schedule.AddRun(&JobScheduleRun{JobId: 1001}, 0)
+
+	copied := schedule.Copy()
+	assert.Equal(tester, schedule, copied)
+
+	schedule.AddRun(&JobScheduleRun{JobId: 1002}, 1)
+	schedule.Template.SetNodeId("sensor2")
+	assert.Len(tester, copied.History, 1)
+	assert.Equal(tester, 1001, copied.History[0].JobId)
+	assert.Equal(tester, "sensor1", copied.Template.GetNodeId())
+}
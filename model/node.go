@@ -7,12 +7,18 @@
 package model
 
 import (
+	"slices"
 	"time"
 
 	"github.com/security-onion-solutions/securityonion-soc/json"
 )
 
 const NodeRoleDesktop = "so-desktop"
+
+// NodeRolesWithPcap lists the node roles that capture packets and can therefore
+// process PCAP jobs.
+var NodeRolesWithPcap = []string{"so-eval", "so-heavynode", "so-import", "so-sensor", "so-standalone"}
+
 const NodeStatusUnknown = "unknown"
 const NodeStatusOk = "ok"
 const NodeStatusFault = "fault"
@@ -148,6 +154,10 @@ func (node *Node) UpdateOverallStatus(enhancedStatusEnabled bool) bool {
 	return oldStatus != node.Status
 }
 
+func (node *Node) HasPcap() bool {
+	return slices.Contains(NodeRolesWithPcap, node.Role)
+}
+
 func (node *Node) IsProcessRunning(match string) bool {
 	nodeStatus := NodeStatus{}
 	err := json.LoadJson([]byte(node.ProcessJson), &nodeStatus)
@@ -215,3 +215,12 @@ func TestIsProcessRunning(tester *testing.T) {
 	node.ProcessJson = `{"containers":[{"Name":"so-test", "Status":"running"}]}`
 	assert.True(tester, node.IsProcessRunning("so-test"))
 }
+
+func TestHasPcap(tester *testing.T) {
+	node := NewNode("foo")
+	assert.False(tester, node.HasPcap())
+	node.Role = "so-sensor"
+	assert.True(tester, node.HasPcap())
+	node.Role = NodeRoleDesktop
+	assert.False(tester, node.HasPcap())
+}
@@ -11,23 +11,26 @@ import (
 )
 
 type Packet struct {
-	Number        int       `json:"number"`
-	Type          string    `json:"type"`
-	SrcMac        string    `json:"srcMac"`
-	DstMac        string    `json:"dstMac"`
-	SrcIp         string    `json:"srcIp"`
-	SrcPort       int       `json:"srcPort"`
-	DstIp         string    `json:"dstIp"`
-	DstPort       int       `json:"dstPort"`
-	Length        int       `json:"length"`
-	Timestamp     time.Time `json:"timestamp"`
-	Sequence      int       `json:"sequence"`
-	Acknowledge   int       `json:"acknowledge"`
-	Window        int       `json:"window"`
-	Checksum      int       `json:"checksum"`
-	Flags         []string  `json:"flags"`
-	Payload       string    `json:"payload"`
-	PayloadOffset int       `json:"payloadOffset"`
+	Number        int                  `json:"number"`
+	Type          string               `json:"type"`
+	SrcMac        string               `json:"srcMac"`
+	DstMac        string               `json:"dstMac"`
+	SrcIp         string               `json:"srcIp"`
+	SrcPort       int                  `json:"srcPort"`
+	DstIp         string               `json:"dstIp"`
+	DstPort       int                  `json:"dstPort"`
+	Length        int                  `json:"length"`
+	Timestamp     time.Time            `json:"timestamp"`
+	Sequence      int                  `json:"sequence"`
+	Acknowledge   int                  `json:"acknowledge"`
+	Window        int                  `json:"window"`
+	Checksum      int                  `json:"checksum"`
+	Flags         []string             `json:"flags"`
+	Payload       string               `json:"payload"`
+	PayloadOffset int                  `json:"payloadOffset"`
+	Application   *PacketApplication   `json:"application,omitempty"`
+	Tunnels       []*PacketTunnel      `json:"tunnels,omitempty"`
+	Fingerprints  []*PacketFingerprint `json:"fingerprints,omitempty"`
 }
 
 func NewPacket(number int) *Packet {
@@ -36,3 +39,112 @@ func NewPacket(number int) *Packet {
 		Type:   "UNKNOWN",
 	}
 }
+
+const PacketTunnelVxlan = "VXLAN"
+const PacketTunnelGeneve = "GENEVE"
+const PacketTunnelGre = "GRE"
+const PacketTunnelErspan = "ERSPAN"
+const PacketTunnelMpls = "MPLS"
+const PacketTunnelVlan = "VLAN"
+
+// PacketTunnel describes an encapsulation stripped from a packet while unwrapping it,
+// ordered from the outermost. Id holds the VXLAN or GENEVE network identifier, GRE key,
+// ERSPAN session, MPLS label or VLAN id. Version holds the ERSPAN type. The addresses
+// are those of the outer headers carrying the encapsulation, identifying the tap.
+type PacketTunnel struct {
+	Type    string `json:"type"`
+	Id      int    `json:"id"`
+	Version int    `json:"version,omitempty"`
+	SrcMac  string `json:"srcMac,omitempty"`
+	DstMac  string `json:"dstMac,omitempty"`
+	SrcIp   string `json:"srcIp,omitempty"`
+	DstIp   string `json:"dstIp,omitempty"`
+	SrcPort int    `json:"srcPort,omitempty"`
+	DstPort int    `json:"dstPort,omitempty"`
+}
+
+// PacketApplication holds the decoded application layer of a packet. Only the section
+// matching Protocol is populated.
+type PacketApplication struct {
+	Protocol string      `json:"protocol"`
+	Dns      *PacketDns  `json:"dns,omitempty"`
+	Http     *PacketHttp `json:"http,omitempty"`
+	Tls      *PacketTls  `json:"tls,omitempty"`
+	Smb      *PacketSmb  `json:"smb,omitempty"`
+	Dhcp     *PacketDhcp `json:"dhcp,omitempty"`
+}
+
+type PacketDns struct {
+	Id           int                  `json:"id"`
+	Response     bool                 `json:"response"`
+	Opcode       string               `json:"opcode"`
+	ResponseCode string               `json:"responseCode"`
+	Questions    []*PacketDnsQuestion `json:"questions"`
+	Answers      []*PacketDnsRecord   `json:"answers"`
+}
+
+type PacketDnsQuestion struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+}
+
+type PacketDnsRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+	Ttl   int    `json:"ttl"`
+	Data  string `json:"data"`
+}
+
+type PacketHttp struct {
+	Method     string              `json:"method,omitempty"`
+	Uri        string              `json:"uri,omitempty"`
+	Version    string              `json:"version"`
+	StatusCode int                 `json:"statusCode,omitempty"`
+	Reason     string              `json:"reason,omitempty"`
+	Headers    []*PacketHttpHeader `json:"headers"`
+}
+
+type PacketHttpHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PacketTls struct {
+	Handshake         string   `json:"handshake"`
+	Version           string   `json:"version"`
+	ServerName        string   `json:"serverName,omitempty"`
+	Alpn              []string `json:"alpn,omitempty"`
+	SupportedVersions []string `json:"supportedVersions,omitempty"`
+}
+
+type PacketSmb struct {
+	Version   int    `json:"version"`
+	Command   string `json:"command"`
+	Response  bool   `json:"response"`
+	Status    string `json:"status"`
+	MessageId uint64 `json:"messageId,omitempty"`
+	TreeId    uint32 `json:"treeId,omitempty"`
+	SessionId uint64 `json:"sessionId,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Filename  string `json:"filename,omitempty"`
+}
+
+type PacketDhcp struct {
+	MessageType   string   `json:"messageType"`
+	TransactionId string   `json:"transactionId"`
+	ClientMac     string   `json:"clientMac"`
+	ClientIp      string   `json:"clientIp,omitempty"`
+	YourIp        string   `json:"yourIp,omitempty"`
+	ServerIp      string   `json:"serverIp,omitempty"`
+	RelayIp       string   `json:"relayIp,omitempty"`
+	Hostname      string   `json:"hostname,omitempty"`
+	RequestedIp   string   `json:"requestedIp,omitempty"`
+	ServerId      string   `json:"serverId,omitempty"`
+	LeaseSeconds  int      `json:"leaseSeconds,omitempty"`
+	Routers       []string `json:"routers,omitempty"`
+	DnsServers    []string `json:"dnsServers,omitempty"`
+	DomainName    string   `json:"domainName,omitempty"`
+	VendorClass   string   `json:"vendorClass,omitempty"`
+}
@@ -0,0 +1,75 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package model
+
+import (
+	"time"
+)
+
+// PacketStatistics summarizes the packets captured by a job. Talkers and conversations
+// are sorted by bytes, largest first, and may be limited to the top entries; the totals
+// report how many exist in the capture.
+type PacketStatistics struct {
+	JobId              int                     `json:"jobId"`
+	PacketCount        int                     `json:"packetCount"`
+	ByteCount          int                     `json:"byteCount"`
+	StartTime          time.Time               `json:"startTime"`
+	EndTime            time.Time               `json:"endTime"`
+	Duration           float64                 `json:"duration"`
+	TotalTalkers       int                     `json:"totalTalkers"`
+	TopTalkers         []*PacketTalker         `json:"topTalkers"`
+	TotalConversations int                     `json:"totalConversations"`
+	Conversations      []*PacketConversation   `json:"conversations"`
+	Protocols          *PacketProtocolNode     `json:"protocols"`
+	TimelineInterval   int                     `json:"timelineInterval"`
+	Timeline           []*PacketTimelineBucket `json:"timeline"`
+}
+
+// PacketTalker totals the traffic sent and received by one IP address.
+type PacketTalker struct {
+	Ip              string `json:"ip"`
+	Packets         int    `json:"packets"`
+	Bytes           int    `json:"bytes"`
+	SentPackets     int    `json:"sentPackets"`
+	SentBytes       int    `json:"sentBytes"`
+	ReceivedPackets int    `json:"receivedPackets"`
+	ReceivedBytes   int    `json:"receivedBytes"`
+}
+
+// PacketConversation totals the traffic of one 5-tuple in both directions. The source is
+// the endpoint that sent the first packet seen.
+type PacketConversation struct {
+	Protocol       string    `json:"protocol"`
+	SrcIp          string    `json:"srcIp"`
+	SrcPort        int       `json:"srcPort"`
+	DstIp          string    `json:"dstIp"`
+	DstPort        int       `json:"dstPort"`
+	Packets        int       `json:"packets"`
+	Bytes          int       `json:"bytes"`
+	ForwardPackets int       `json:"forwardPackets"`
+	ForwardBytes   int       `json:"forwardBytes"`
+	ReversePackets int       `json:"reversePackets"`
+	ReverseBytes   int       `json:"reverseBytes"`
+	StartTime      time.Time `json:"startTime"`
+	EndTime        time.Time `json:"endTime"`
+	Duration       float64   `json:"duration"`
+}
+
+// PacketProtocolNode counts the packets containing a protocol beneath its parent in the
+// protocol hierarchy.
+type PacketProtocolNode struct {
+	Protocol string                `json:"protocol"`
+	Packets  int                   `json:"packets"`
+	Bytes    int                   `json:"bytes"`
+	Children []*PacketProtocolNode `json:"children,omitempty"`
+}
+
+// PacketTimelineBucket counts the packets captured during one interval of the timeline.
+type PacketTimelineBucket struct {
+	Time    time.Time `json:"time"`
+	Packets int       `json:"packets"`
+	Bytes   int       `json:"bytes"`
+}
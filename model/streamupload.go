@@ -0,0 +1,21 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package model
+
+// StreamUpload describes a job result stream being uploaded in chunks. Offset is the
+// number of bytes the server has received and acknowledged so far, and is where the
+// next chunk must begin.
+type StreamUpload struct {
+	JobId  int   `json:"jobId"`
+	Offset int64 `json:"offset"`
+}
+
+func NewStreamUpload(jobId int, offset int64) *StreamUpload {
+	return &StreamUpload{
+		JobId:  jobId,
+		Offset: offset,
+	}
+}
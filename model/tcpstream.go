@@ -0,0 +1,54 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package model
+
+import (
+	"time"
+)
+
+const TcpStreamDirectionClient = "client"
+const TcpStreamDirectionServer = "server"
+
+const TcpStreamFormatAscii = "ascii"
+const TcpStreamFormatHex = "hex"
+const TcpStreamFormatRaw = "raw"
+
+// TcpStream is the reassembled conversation of one TCP connection captured by a job.
+// Streams are numbered from zero in the order they first appear in the capture.
+type TcpStream struct {
+	JobId        int               `json:"jobId"`
+	Index        int               `json:"index"`
+	Count        int               `json:"count"`
+	ClientIp     string            `json:"clientIp"`
+	ClientPort   int               `json:"clientPort"`
+	ServerIp     string            `json:"serverIp"`
+	ServerPort   int               `json:"serverPort"`
+	ClientBytes  int               `json:"clientBytes"`
+	ServerBytes  int               `json:"serverBytes"`
+	MissingBytes int               `json:"missingBytes"`
+	Truncated    bool              `json:"truncated"`
+	Format       string            `json:"format"`
+	Chunks       []*TcpStreamChunk `json:"chunks"`
+}
+
+// TcpStreamChunk is a contiguous run of data sent in one direction. A chunk with
+// Missing bytes marks a gap in the capture and carries no data.
+type TcpStreamChunk struct {
+	Direction string    `json:"direction"`
+	Timestamp time.Time `json:"timestamp"`
+	Offset    int       `json:"offset"`
+	Length    int       `json:"length"`
+	Missing   int       `json:"missing,omitempty"`
+	Data      string    `json:"data"`
+	Bytes     []byte    `json:"-"`
+}
+
+func NewTcpStream(index int) *TcpStream {
+	return &TcpStream{
+		Index:  index,
+		Chunks: make([]*TcpStreamChunk, 0),
+	}
+}
@@ -94,3 +94,13 @@ func GetStringArrayDefault(options map[string]interface{}, key string, dflt []st
 	}
 	return value
 }
+
+func GetMapDefault(options map[string]interface{}, key string, dflt map[string]interface{}) map[string]interface{} {
+	var value map[string]interface{}
+	if gen, ok := options[key]; ok {
+		value = gen.(map[string]interface{})
+	} else {
+		value = dflt
+	}
+	return value
+}
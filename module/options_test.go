@@ -103,3 +103,14 @@ func TestGetStringArrayDefault(tester *testing.T) {
 	assert.Equal(tester, "MyValue1", actual[0])
 	assert.Equal(tester, "MyValue2", actual[1])
 }
+
+func TestGetMapDefault(tester *testing.T) {
+	options := make(map[string]interface{})
+	actual := GetMapDefault(options, "MyKey", nil)
+	assert.Nil(tester, actual)
+	nested := make(map[string]interface{})
+	nested["MyNestedKey"] = "MyValue"
+	options["MyKey"] = nested
+	actual = GetMapDefault(options, "MyKey", nil)
+	assert.Equal(tester, "MyValue", actual["MyNestedKey"])
+}
@@ -0,0 +1,154 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+// BuildBpf converts a job filter into a BPF expression suitable for libpcap and tcpdump.
+// The source and destination endpoints are matched in both directions so that replies are
+// included, while still keeping each port bound to its own address. Without explicit VLAN
+// ids the expression matches both untagged and single-tagged traffic.
+func BuildBpf(filter *model.Filter) string {
+	if filter == nil {
+		return ""
+	}
+
+	srcIp := strings.TrimSpace(filter.SrcIp)
+	dstIp := strings.TrimSpace(filter.DstIp)
+	protocol := strings.ToLower(strings.TrimSpace(filter.Protocol))
+
+	parts := make([]string, 0)
+	srcPort := filter.SrcPort
+	dstPort := filter.DstPort
+	if protocol == model.PROTOCOL_ICMP {
+		parts = append(parts, icmpBpf(srcIp, dstIp))
+		// Some legacy jobs won't have the protocol provided, but those that do
+		// never have meaningful ports for ICMP
+		srcPort = 0
+		dstPort = 0
+	} else if protocol != "" {
+		parts = append(parts, protocol)
+	}
+
+	forward := directionBpf(srcIp, srcPort, dstIp, dstPort)
+	if forward != "" {
+		reverse := directionBpf(dstIp, dstPort, srcIp, srcPort)
+		parts = append(parts, fmt.Sprintf("((%s) or (%s))", forward, reverse))
+	}
+
+	if bpf := strings.TrimSpace(filter.Bpf); bpf != "" {
+		parts = append(parts, "("+bpf+")")
+	}
+
+	return applyVlanBpf(strings.Join(parts, " and "), filter.Vlans)
+}
+
+// ValidateFilter verifies that the filter fields are well formed and, when a raw BPF
+// expression is provided, that the resulting expression compiles.
+func ValidateFilter(filter *model.Filter) error {
+	if filter == nil {
+		return nil
+	}
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+	if strings.TrimSpace(filter.Bpf) != "" {
+		if _, err := pcap.NewBPF(layers.LinkTypeEthernet, 65535, BuildBpf(filter)); err != nil {
+			return errors.New("Invalid BPF expression: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func directionBpf(fromIp string, fromPort int, toIp string, toPort int) string {
+	terms := make([]string, 0, 4)
+	if fromIp != "" {
+		terms = append(terms, addressBpf("src", fromIp))
+	}
+	if fromPort > 0 {
+		terms = append(terms, fmt.Sprintf("src port %d", fromPort))
+	}
+	if toIp != "" {
+		terms = append(terms, addressBpf("dst", toIp))
+	}
+	if toPort > 0 {
+		terms = append(terms, fmt.Sprintf("dst port %d", toPort))
+	}
+	return strings.Join(terms, " and ")
+}
+
+func addressBpf(direction string, address string) string {
+	if strings.Contains(address, "/") {
+		// libpcap rejects ranges with host bits set, so use the network address
+		if _, network, err := net.ParseCIDR(address); err == nil {
+			return fmt.Sprintf("%s net %s", direction, network.String())
+		}
+		return fmt.Sprintf("%s net %s", direction, address)
+	}
+	return fmt.Sprintf("%s host %s", direction, address)
+}
+
+func isIpv6Address(address string) bool {
+	return strings.Contains(address, ":")
+}
+
+func icmpBpf(srcIp string, dstIp string) string {
+	ipv4 := false
+	ipv6 := false
+	for _, address := range []string{srcIp, dstIp} {
+		if address == "" {
+			continue
+		}
+		if isIpv6Address(address) {
+			ipv6 = true
+		} else {
+			ipv4 = true
+		}
+	}
+	if ipv4 && !ipv6 {
+		return "icmp"
+	}
+	if ipv6 && !ipv4 {
+		return "icmp6"
+	}
+	return "(icmp or icmp6)"
+}
+
+// applyVlanBpf restricts the query to the given VLAN ids. libpcap shifts its offsets for
+// the remainder of the expression each time the vlan keyword appears, so multiple ids are
+// compared against the outer tag directly rather than repeating the keyword.
+func applyVlanBpf(query string, vlans []int) string {
+	var vlanQuery string
+	switch len(vlans) {
+	case 0:
+		if query == "" {
+			return ""
+		}
+		return fmt.Sprintf("(%s) or (vlan and %s)", query, query)
+	case 1:
+		vlanQuery = fmt.Sprintf("vlan %d", vlans[0])
+	default:
+		ids := make([]string, 0, len(vlans))
+		for _, vlan := range vlans {
+			ids = append(ids, fmt.Sprintf("ether[14:2] & 0x0fff = %d", vlan))
+		}
+		vlanQuery = fmt.Sprintf("vlan and (%s)", strings.Join(ids, " or "))
+	}
+
+	if query == "" {
+		return vlanQuery
+	}
+	return vlanQuery + " and " + query
+}
@@ -0,0 +1,97 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildBpf(tester *testing.T) {
+	filter := model.NewFilter()
+	startTime, _ := time.Parse(time.RFC3339, "2024-02-12T00:00:00Z")
+	filter.BeginTime = startTime
+	endTime, _ := time.Parse(time.RFC3339, "2024-02-12T23:59:59Z")
+	filter.EndTime = endTime
+	filter.Protocol = model.PROTOCOL_ICMP
+	filter.SrcIp = "90.151.225.16"
+	filter.SrcPort = 19 // will be ignored since Protocol = ICMP
+	filter.DstIp = "192.168.10.128"
+	filter.DstPort = 34515 // will be ignored since Protocol = ICMP
+
+	actual := BuildBpf(filter)
+	query := "icmp and ((src host 90.151.225.16 and dst host 192.168.10.128) or (src host 192.168.10.128 and dst host 90.151.225.16))"
+	assert.Equal(tester, "("+query+") or (vlan and "+query+")", actual)
+}
+
+func TestBuildBpfEmpty(tester *testing.T) {
+	assert.Equal(tester, "", BuildBpf(nil))
+	assert.Equal(tester, "", BuildBpf(model.NewFilter()))
+}
+
+func TestBuildBpfDirections(tester *testing.T) {
+	filter := model.NewFilter()
+	filter.Protocol = "TCP"
+	filter.SrcIp = "10.1.2.3/8"
+	filter.SrcPort = 33
+	filter.DstIp = "4.3.2.1"
+	filter.DstPort = 53
+
+	query := "tcp and ((src net 10.0.0.0/8 and src port 33 and dst host 4.3.2.1 and dst port 53) or " +
+		"(src host 4.3.2.1 and src port 53 and dst net 10.0.0.0/8 and dst port 33))"
+	assert.Equal(tester, "("+query+") or (vlan and "+query+")", BuildBpf(filter))
+
+	filter = model.NewFilter()
+	filter.DstPort = 443
+	query = "((dst port 443) or (src port 443))"
+	assert.Equal(tester, "("+query+") or (vlan and "+query+")", BuildBpf(filter))
+}
+
+func TestBuildBpfIpv6(tester *testing.T) {
+	filter := model.NewFilter()
+	filter.Protocol = model.PROTOCOL_ICMP
+	filter.SrcIp = "2001:db8::1"
+	filter.DstIp = "2001:db8:1::/48"
+	filter.Vlans = []int{7}
+
+	assert.Equal(tester, "vlan 7 and icmp6 and ((src host 2001:db8::1 and dst net 2001:db8:1::/48) or "+
+		"(src net 2001:db8:1::/48 and dst host 2001:db8::1))", BuildBpf(filter))
+
+	filter.SrcIp = ""
+	filter.DstIp = ""
+	assert.Equal(tester, "vlan 7 and (icmp or icmp6)", BuildBpf(filter))
+}
+
+func TestBuildBpfVlansAndRaw(tester *testing.T) {
+	filter := model.NewFilter()
+	filter.Vlans = []int{10, 20}
+	assert.Equal(tester, "vlan and (ether[14:2] & 0x0fff = 10 or ether[14:2] & 0x0fff = 20)", BuildBpf(filter))
+
+	filter.Protocol = model.PROTOCOL_UDP
+	filter.Bpf = " udp[8] = 0x45 "
+	assert.Equal(tester, "vlan and (ether[14:2] & 0x0fff = 10 or ether[14:2] & 0x0fff = 20) and udp and (udp[8] = 0x45)", BuildBpf(filter))
+
+	filter.Vlans = nil
+	assert.Equal(tester, "(udp and (udp[8] = 0x45)) or (vlan and udp and (udp[8] = 0x45))", BuildBpf(filter))
+}
+
+func TestValidateFilter(tester *testing.T) {
+	assert.NoError(tester, ValidateFilter(nil))
+
+	filter := model.NewFilter()
+	filter.SrcIp = "10.0.0.0/8"
+	assert.NoError(tester, ValidateFilter(filter))
+
+	filter.SrcIp = "10.0.0.300"
+	assert.EqualError(tester, ValidateFilter(filter), "Invalid source IP address or CIDR range: 10.0.0.300")
+
+	filter.SrcIp = ""
+	filter.Bpf = "tcp and and"
+	assert.ErrorContains(tester, ValidateFilter(filter), "Invalid BPF expression")
+}
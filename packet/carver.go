@@ -0,0 +1,602 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+const smb2CommandRead = 8
+const smb2FlagResponse = 0x1
+const smb2FlagRelated = 0x4
+const maxHttpRequestLine = 8192
+const maxSmb2FileSize = 1 << 28
+const maxHttpDecodedSize = 1 << 28
+
+var ftpPassivePattern = regexp.MustCompile(`(\d+),(\d+),(\d+),(\d+),(\d+),(\d+)`)
+var ftpExtendedPassivePattern = regexp.MustCompile(`\(([^0-9])([^0-9])([^0-9])(\d+)([^0-9])\)`)
+
+// directionData is the data captured from one side of a connection, up to the first gap.
+// Complete is false if data is missing after that point, or if the stream was truncated.
+type directionData struct {
+	data     []byte
+	complete bool
+	offsets  []int
+	times    []time.Time
+}
+
+func collectDirection(stream *model.TcpStream, direction string) *directionData {
+	collected := &directionData{complete: !stream.Truncated}
+	for _, chunk := range stream.Chunks {
+		if chunk.Direction != direction {
+			continue
+		}
+		if chunk.Missing > 0 {
+			collected.complete = false
+			break
+		}
+		collected.offsets = append(collected.offsets, len(collected.data))
+		collected.times = append(collected.times, chunk.Timestamp)
+		collected.data = append(collected.data, chunk.Bytes...)
+	}
+	return collected
+}
+
+// timestampAt returns the capture time of the chunk containing the given position.
+func (collected *directionData) timestampAt(position int) time.Time {
+	var timestamp time.Time
+	for idx, offset := range collected.offsets {
+		if offset > position {
+			break
+		}
+		timestamp = collected.times[idx]
+	}
+	return timestamp
+}
+
+// endsIncomplete reports whether an object ending at the given position may be missing
+// data that was not captured.
+func (collected *directionData) endsIncomplete(position int) bool {
+	return !collected.complete && position >= len(collected.data)
+}
+
+// fileCarver collects the carved files, keeping the content only of the files accepted
+// by the retained selector. Data expanded while carving, such as decompressed bodies, is
+// taken from what remains of the budget after reassembly.
+type fileCarver struct {
+	files    []*model.CarvedFile
+	retained func(index int) bool
+	budget   *tcpBudget
+}
+
+func (carver *fileCarver) add(stream *model.TcpStream, protocol string, name string, mimeType string, data []byte, incomplete bool, timestamp time.Time) {
+	if len(data) == 0 {
+		return
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	md5sum := md5.Sum(data)
+	sha1sum := sha1.Sum(data)
+	sha256sum := sha256.Sum256(data)
+	index := len(carver.files)
+	size := len(data)
+	if carver.retained == nil || !carver.retained(index) {
+		data = nil
+	}
+	carver.files = append(carver.files, &model.CarvedFile{
+		Index:       index,
+		Protocol:    protocol,
+		Name:        name,
+		MimeType:    mimeType,
+		Size:        size,
+		Incomplete:  incomplete,
+		Timestamp:   timestamp,
+		StreamIndex: stream.Index,
+		ClientIp:    stream.ClientIp,
+		ClientPort:  stream.ClientPort,
+		ServerIp:    stream.ServerIp,
+		ServerPort:  stream.ServerPort,
+		Md5:         hex.EncodeToString(md5sum[:]),
+		Sha1:        hex.EncodeToString(sha1sum[:]),
+		Sha256:      hex.EncodeToString(sha256sum[:]),
+		Bytes:       data,
+	})
+}
+
+// CarveFiles reads a PCAP or pcapng stream and extracts the objects transferred over its
+// TCP connections: HTTP request and response bodies, FTP data connections and files read
+// over SMB2. At most maxBytes of data are reassembled across all connections, unless
+// maxBytes is zero. The content of a file is only kept if its index is accepted by the
+// retained selector, which may be nil to keep none.
+func CarveFiles(reader io.Reader, unwrap bool, maxBytes int, retained func(index int) bool) ([]*model.CarvedFile, error) {
+	budget := newTcpBudget(maxBytes)
+	streams, err := reassembleTcpStreams(reader, unwrap, budget, func(index int) bool {
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := findFtpTransfers(streams)
+	carver := &fileCarver{files: make([]*model.CarvedFile, 0), retained: retained, budget: budget}
+	for idx, stream := range streams {
+		client := collectDirection(stream, model.TcpStreamDirectionClient)
+		server := collectDirection(stream, model.TcpStreamDirectionServer)
+
+		if name, found := transfers.find(stream); found {
+			carveFtpData(carver, stream, client, server, name)
+		} else if stream.ServerPort == 445 || stream.ServerPort == 139 {
+			carveSmb2(carver, stream, client, server)
+		} else if isHttpRequest(client.data) {
+			carveHttp(carver, stream, client, server)
+		}
+		// Release each connection once carved, keeping only the retained files
+		streams[idx] = nil
+	}
+	return carver.files, nil
+}
+
+func isHttpRequest(data []byte) bool {
+	if len(data) > maxHttpRequestLine {
+		data = data[:maxHttpRequestLine]
+	}
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return false
+	}
+	line := strings.TrimRight(string(data[:end]), "\r")
+	return strings.HasSuffix(line, " HTTP/1.1") || strings.HasSuffix(line, " HTTP/1.0")
+}
+
+// cleanFilename strips any directories from a filename taken from the traffic.
+func cleanFilename(name string, fallback string) string {
+	name = strings.TrimSpace(name)
+	if idx := strings.LastIndexAny(name, "/\\"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if name == "" || name == "." || name == ".." {
+		return fallback
+	}
+	return name
+}
+
+func httpFilename(header http.Header, request *http.Request) string {
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		if name := cleanFilename(params["filename"], ""); name != "" {
+			return name
+		}
+	}
+	if request != nil && request.URL != nil {
+		return cleanFilename(path.Base(request.URL.Path), "http-object")
+	}
+	return "http-object"
+}
+
+func httpMimeType(header http.Header) string {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// decodeHttpBody removes the content encoding applied by the server, leaving the body
+// unchanged if the encoding is unsupported or the data is corrupt. The decoded body may
+// only grow beyond the encoded body by what remains of the budget, and is truncated
+// otherwise, in which case truncated is true.
+func (carver *fileCarver) decodeHttpBody(body []byte, encoding string) (decoded []byte, truncated bool) {
+	var decoder io.Reader
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		decoder, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		decoder, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			decoder, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return body, false
+	}
+	if err != nil {
+		return body, false
+	}
+
+	limit := maxHttpDecodedSize
+	if carver.budget.limited {
+		limit = len(body) + max(carver.budget.remaining, 0)
+	}
+	decoded, err = io.ReadAll(io.LimitReader(decoder, int64(limit)+1))
+	if err != nil && len(decoded) == 0 {
+		return body, false
+	}
+	if len(decoded) > limit {
+		decoded = decoded[:limit]
+		truncated = true
+	}
+	if carver.budget.limited && len(decoded) > len(body) {
+		carver.budget.remaining -= len(decoded) - len(body)
+	}
+	return decoded, truncated
+}
+
+func carveHttp(carver *fileCarver, stream *model.TcpStream, client *directionData, server *directionData) {
+	requests := make([]*http.Request, 0)
+	source := bytes.NewReader(client.data)
+	reader := bufio.NewReader(source)
+	for {
+		position := len(client.data) - source.Len() - reader.Buffered()
+		request, err := http.ReadRequest(reader)
+		if err != nil {
+			break
+		}
+		body, bodyErr := io.ReadAll(request.Body)
+		requests = append(requests, request)
+		incomplete := bodyErr != nil || client.endsIncomplete(len(client.data)-source.Len()-reader.Buffered())
+		carveHttpUpload(carver, stream, request, body, incomplete, client.timestampAt(position))
+		if bodyErr != nil {
+			break
+		}
+	}
+
+	source = bytes.NewReader(server.data)
+	reader = bufio.NewReader(source)
+	for next := 0; ; {
+		position := len(server.data) - source.Len() - reader.Buffered()
+		var request *http.Request
+		if next < len(requests) {
+			request = requests[next]
+		}
+		response, err := http.ReadResponse(reader, request)
+		if err != nil {
+			break
+		}
+		body, bodyErr := io.ReadAll(response.Body)
+		if response.StatusCode >= 100 && response.StatusCode < 200 && response.StatusCode != http.StatusSwitchingProtocols {
+			// Interim responses precede the final response to the same request
+			continue
+		}
+		next++
+		incomplete := bodyErr != nil || server.endsIncomplete(len(server.data)-source.Len()-reader.Buffered())
+		body, truncated := carver.decodeHttpBody(body, response.Header.Get("Content-Encoding"))
+		incomplete = incomplete || truncated
+		carver.add(stream, model.CarvedFileProtocolHttp, httpFilename(response.Header, request), httpMimeType(response.Header), body, incomplete, server.timestampAt(position))
+		if bodyErr != nil || response.StatusCode == http.StatusSwitchingProtocols {
+			break
+		}
+	}
+}
+
+// carveHttpUpload carves the body of a request, extracting each file of a multipart
+// form upload separately.
+func carveHttpUpload(carver *fileCarver, stream *model.TcpStream, request *http.Request, body []byte, incomplete bool, timestamp time.Time) {
+	if len(body) == 0 {
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err == nil && strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		found := false
+		for {
+			part, partErr := reader.NextPart()
+			if partErr != nil {
+				break
+			}
+			if part.FileName() == "" {
+				continue
+			}
+			data, dataErr := io.ReadAll(part)
+			carver.add(stream, model.CarvedFileProtocolHttp, cleanFilename(part.FileName(), "http-upload"), httpMimeType(http.Header(part.Header)), data, incomplete || dataErr != nil, timestamp)
+			found = true
+		}
+		if found {
+			return
+		}
+	}
+	carver.add(stream, model.CarvedFileProtocolHttp, httpFilename(request.Header, request), httpMimeType(request.Header), body, incomplete, timestamp)
+}
+
+// ftpTransfers maps the data connection endpoints announced on FTP control connections
+// to the name of the file transferred. Endpoints are also indexed by port alone, since
+// the address given in a passive mode reply is often rewritten by NAT.
+type ftpTransfers struct {
+	endpoints map[string]string
+	ports     map[int]string
+}
+
+func (transfers *ftpTransfers) find(stream *model.TcpStream) (string, bool) {
+	if name, found := transfers.endpoints[tcpEndpoint(stream.ServerIp, layers.TCPPort(stream.ServerPort))]; found {
+		return name, true
+	}
+	if name, found := transfers.ports[stream.ServerPort]; found {
+		return name, true
+	}
+	if stream.ClientPort == 20 {
+		// Active mode data connection whose control connection was not captured
+		return "ftp-data", true
+	}
+	return "", false
+}
+
+func findFtpTransfers(streams []*model.TcpStream) *ftpTransfers {
+	transfers := &ftpTransfers{
+		endpoints: make(map[string]string),
+		ports:     make(map[int]string),
+	}
+	for _, stream := range streams {
+		if stream.ServerPort != 21 {
+			continue
+		}
+		pending := ""
+		pendingPort := 0
+		lines := map[string]*bytes.Buffer{
+			model.TcpStreamDirectionClient: &bytes.Buffer{},
+			model.TcpStreamDirectionServer: &bytes.Buffer{},
+		}
+		for _, chunk := range stream.Chunks {
+			buffer := lines[chunk.Direction]
+			buffer.Write(chunk.Bytes)
+			for {
+				line, err := buffer.ReadString('\n')
+				if err != nil {
+					// Keep the partial line until the rest arrives
+					remainder := []byte(line)
+					buffer.Reset()
+					buffer.Write(remainder)
+					break
+				}
+				line = strings.TrimSpace(line)
+				if chunk.Direction == model.TcpStreamDirectionServer {
+					if ip, port, ok := parseFtpPassive(line, stream.ServerIp); ok {
+						pending, pendingPort = tcpEndpoint(ip, layers.TCPPort(port)), port
+					}
+					continue
+				}
+				command, argument, _ := strings.Cut(line, " ")
+				switch strings.ToUpper(command) {
+				case "PORT", "EPRT":
+					if ip, port, ok := parseFtpPort(strings.ToUpper(command), argument); ok {
+						pending, pendingPort = tcpEndpoint(ip, layers.TCPPort(port)), port
+					}
+				case "RETR", "STOR", "STOU", "APPE":
+					if pending != "" {
+						name := cleanFilename(argument, "ftp-data")
+						transfers.endpoints[pending] = name
+						transfers.ports[pendingPort] = name
+						pending, pendingPort = "", 0
+					}
+				}
+			}
+		}
+	}
+	return transfers
+}
+
+func parseFtpHostPort(fields []string) (string, int, bool) {
+	values := make([]int, 6)
+	for idx, field := range fields {
+		value, err := strconv.Atoi(field)
+		if err != nil || value > 255 {
+			return "", 0, false
+		}
+		values[idx] = value
+	}
+	ip := fmt.Sprintf("%d.%d.%d.%d", values[0], values[1], values[2], values[3])
+	return ip, values[4]<<8 | values[5], true
+}
+
+// parseFtpPassive reads the data endpoint from a PASV or EPSV reply.
+func parseFtpPassive(line string, serverIp string) (string, int, bool) {
+	if strings.HasPrefix(line, "227") {
+		if match := ftpPassivePattern.FindStringSubmatch(line); match != nil {
+			return parseFtpHostPort(match[1:])
+		}
+	} else if strings.HasPrefix(line, "229") {
+		if match := ftpExtendedPassivePattern.FindStringSubmatch(line); match != nil {
+			port, err := strconv.Atoi(match[4])
+			if err == nil && port <= 65535 {
+				return serverIp, port, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+// parseFtpPort reads the data endpoint from a PORT or EPRT command.
+func parseFtpPort(command string, argument string) (string, int, bool) {
+	if command == "PORT" {
+		fields := strings.Split(strings.TrimSpace(argument), ",")
+		if len(fields) != 6 {
+			return "", 0, false
+		}
+		return parseFtpHostPort(fields)
+	}
+
+	argument = strings.TrimSpace(argument)
+	if len(argument) < 2 {
+		return "", 0, false
+	}
+	fields := strings.Split(argument[1:len(argument)-1], argument[:1])
+	if len(fields) != 3 {
+		return "", 0, false
+	}
+	ip := net.ParseIP(fields[1])
+	port, err := strconv.Atoi(fields[2])
+	if ip == nil || err != nil || port > 65535 {
+		return "", 0, false
+	}
+	return ip.String(), port, true
+}
+
+func carveFtpData(carver *fileCarver, stream *model.TcpStream, client *directionData, server *directionData, name string) {
+	// A data connection carries the file in whichever direction it was sent
+	data := server
+	if len(client.data) > len(server.data) {
+		data = client
+	}
+	carver.add(stream, model.CarvedFileProtocolFtpData, name, "", data.data, !data.complete, data.timestampAt(0))
+}
+
+type smb2Request struct {
+	command uint16
+	related bool
+	name    string
+	fileId  string
+	offset  uint64
+}
+
+type smb2File struct {
+	name      string
+	size      uint64
+	data      []byte
+	received  int
+	timestamp time.Time
+}
+
+// forEachSmb2Message calls the handler with each SMB2 message found in the data, which
+// is framed by direct TCP or NetBIOS session headers. Compounded messages are split so
+// that offsets within each message remain relative to its own header.
+func forEachSmb2Message(data []byte, handler func(message []byte, position int)) {
+	for position := 0; position+4 <= len(data); {
+		if data[position] != 0x00 {
+			return
+		}
+		length := int(data[position+1])<<16 | int(data[position+2])<<8 | int(data[position+3])
+		end := position + 4 + length
+		if end > len(data) {
+			return
+		}
+		frame := data[position+4 : end]
+		for len(frame) >= smb2HeaderSize && bytes.HasPrefix(frame, []byte("\xFESMB")) {
+			next := int(binary.LittleEndian.Uint32(frame[20:24]))
+			if next < smb2HeaderSize || next > len(frame) {
+				handler(frame, position)
+				break
+			}
+			handler(frame[:next], position)
+			frame = frame[next:]
+		}
+		position = end
+	}
+}
+
+func carveSmb2(carver *fileCarver, stream *model.TcpStream, client *directionData, server *directionData) {
+	requests := make(map[uint64]*smb2Request)
+	forEachSmb2Message(client.data, func(message []byte, position int) {
+		flags := binary.LittleEndian.Uint32(message[16:20])
+		if flags&smb2FlagResponse != 0 {
+			return
+		}
+		request := &smb2Request{
+			command: binary.LittleEndian.Uint16(message[12:14]),
+			related: flags&smb2FlagRelated != 0,
+		}
+		switch request.command {
+		case smb2CommandCreate:
+			request.name = readSmb2String(message, smb2HeaderSize+44)
+		case smb2CommandRead:
+			if len(message) < smb2HeaderSize+32 {
+				return
+			}
+			request.offset = binary.LittleEndian.Uint64(message[smb2HeaderSize+8:])
+			request.fileId = hex.EncodeToString(message[smb2HeaderSize+16 : smb2HeaderSize+32])
+		default:
+			return
+		}
+		requests[binary.LittleEndian.Uint64(message[24:32])] = request
+	})
+
+	files := make(map[string]*smb2File)
+	order := make([]*smb2File, 0)
+	lastFileId := ""
+	forEachSmb2Message(server.data, func(message []byte, position int) {
+		flags := binary.LittleEndian.Uint32(message[16:20])
+		status := binary.LittleEndian.Uint32(message[8:12])
+		request := requests[binary.LittleEndian.Uint64(message[24:32])]
+		if flags&smb2FlagResponse == 0 || status != 0 || request == nil {
+			return
+		}
+		switch request.command {
+		case smb2CommandCreate:
+			if len(message) < smb2HeaderSize+80 {
+				return
+			}
+			lastFileId = hex.EncodeToString(message[smb2HeaderSize+64 : smb2HeaderSize+80])
+			file := &smb2File{
+				name: cleanFilename(request.name, "smb2-file"),
+				size: binary.LittleEndian.Uint64(message[smb2HeaderSize+48:]),
+			}
+			files[lastFileId] = file
+			order = append(order, file)
+		case smb2CommandRead:
+			if len(message) < smb2HeaderSize+8 {
+				return
+			}
+			dataOffset := int(message[smb2HeaderSize+2])
+			dataLength := int(binary.LittleEndian.Uint32(message[smb2HeaderSize+4:]))
+			if dataOffset+dataLength > len(message) {
+				return
+			}
+			fileId := request.fileId
+			if request.related && fileId == "ffffffffffffffffffffffffffffffff" {
+				fileId = lastFileId
+			}
+			file := files[fileId]
+			if file == nil {
+				// The file was opened before the capture began
+				file = &smb2File{name: "smb2-file"}
+				files[fileId] = file
+				order = append(order, file)
+			}
+			if file.received == 0 {
+				file.timestamp = server.timestampAt(position)
+			}
+			// The offset comes from the capture and may be large enough to wrap around
+			end := request.offset + uint64(dataLength)
+			if request.offset > maxSmb2FileSize || end < request.offset || end > maxSmb2FileSize || (file.size > 0 && end > file.size) {
+				return
+			}
+			if int(end) > len(file.data) {
+				file.data = append(file.data, make([]byte, int(end)-len(file.data))...)
+			}
+			copy(file.data[request.offset:], message[dataOffset:dataOffset+dataLength])
+			file.received += dataLength
+		}
+	})
+
+	for _, file := range order {
+		if file.received == 0 {
+			continue
+		}
+		// Without the size from the open response, only a truncated capture is known to be missing data
+		incomplete := file.received < len(file.data) || uint64(len(file.data)) < file.size || (file.size == 0 && !server.complete)
+		carver.add(stream, model.CarvedFileProtocolSmb2, file.name, "", file.data, incomplete, file.timestamp)
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConnection builds the segments of a TCP connection, splitting data into full
+// sized segments and tracking the sequence numbers of both sides.
+type testConnection struct {
+	clientPort int
+	serverPort int
+	clientSeq  uint32
+	serverSeq  uint32
+	segments   []testSegment
+}
+
+func newTestConnection(clientPort int, serverPort int) *testConnection {
+	conn := &testConnection{
+		clientPort: clientPort,
+		serverPort: serverPort,
+		clientSeq:  testClientIsn + 1,
+		serverSeq:  testServerIsn + 1,
+	}
+	for _, segment := range handshake() {
+		segment.clientPort, segment.serverPort = clientPort, serverPort
+		conn.segments = append(conn.segments, segment)
+	}
+	return conn
+}
+
+func (conn *testConnection) send(fromClient bool, data []byte) *testConnection {
+	for start := 0; start < len(data); start += 1400 {
+		end := start + 1400
+		if end > len(data) {
+			end = len(data)
+		}
+		segment := testSegment{
+			fromClient: fromClient,
+			clientPort: conn.clientPort,
+			serverPort: conn.serverPort,
+			flags:      "A",
+			payload:    string(data[start:end]),
+		}
+		if fromClient {
+			segment.seq, segment.ack = conn.clientSeq, conn.serverSeq
+			conn.clientSeq += uint32(end - start)
+		} else {
+			segment.seq, segment.ack = conn.serverSeq, conn.clientSeq
+			conn.serverSeq += uint32(end - start)
+		}
+		conn.segments = append(conn.segments, segment)
+	}
+	return conn
+}
+
+func carveConnections(tester *testing.T, connections ...*testConnection) []*model.CarvedFile {
+	return carveConnectionsWithin(tester, 0, func(index int) bool { return true }, connections...)
+}
+
+func carveConnectionsWithin(tester *testing.T, maxBytes int, retained func(index int) bool, connections ...*testConnection) []*model.CarvedFile {
+	frames := make([][]byte, 0)
+	for _, conn := range connections {
+		for _, segment := range conn.segments {
+			frames = append(frames, serializeTcp(tester, segment))
+		}
+	}
+	files, err := CarveFiles(buildTcpPcap(tester, frames), false, maxBytes, retained)
+	assert.NoError(tester, err)
+	return files
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCarveHttp(tester *testing.T) {
+	pdf := []byte("%PDF-1.4 carved report")
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte("plain text body"))
+	writer.Close()
+
+	upload := "--XYZ\r\nContent-Disposition: form-data; name=\"comment\"\r\n\r\nhi\r\n" +
+		"--XYZ\r\nContent-Disposition: form-data; name=\"file\"; filename=\"C:\\\\temp\\\\notes.txt\"\r\nContent-Type: text/plain\r\n\r\nsecret notes\r\n" +
+		"--XYZ--\r\n"
+
+	conn := newTestConnection(40000, 8080).
+		send(true, []byte("GET /files/report.pdf HTTP/1.1\r\nHost: a\r\n\r\n")).
+		send(false, []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/pdf\r\nContent-Length: %d\r\n\r\n%s", len(pdf), pdf))).
+		send(true, []byte(fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: a\r\nContent-Type: multipart/form-data; boundary=XYZ\r\nContent-Length: %d\r\n\r\n%s", len(upload), upload))).
+		send(false, []byte("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n")).
+		send(true, []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n")).
+		send(false, []byte("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Disposition: attachment; filename=\"../../page.txt\"\r\nTransfer-Encoding: chunked\r\n\r\n"))
+	conn.send(false, []byte(fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", compressed.Len(), compressed.Bytes())))
+
+	files := carveConnections(tester, conn)
+	if assert.Len(tester, files, 3) {
+		assert.Equal(tester, 0, files[0].Index)
+		assert.Equal(tester, "notes.txt", files[0].Name)
+		assert.Equal(tester, "text/plain", files[0].MimeType)
+		assert.Equal(tester, "secret notes", string(files[0].Bytes))
+
+		assert.Equal(tester, model.CarvedFileProtocolHttp, files[1].Protocol)
+		assert.Equal(tester, "report.pdf", files[1].Name)
+		assert.Equal(tester, "application/pdf", files[1].MimeType)
+		assert.Equal(tester, len(pdf), files[1].Size)
+		assert.Equal(tester, sha256Hex(pdf), files[1].Sha256)
+		assert.Len(tester, files[1].Md5, 32)
+		assert.Len(tester, files[1].Sha1, 40)
+		assert.False(tester, files[1].Incomplete)
+		assert.Equal(tester, "10.0.0.2", files[1].ServerIp)
+		assert.Equal(tester, 8080, files[1].ServerPort)
+		assert.False(tester, files[1].Timestamp.IsZero())
+
+		assert.Equal(tester, "page.txt", files[2].Name)
+		assert.Equal(tester, "plain text body", string(files[2].Bytes))
+		assert.Equal(tester, "text/plain; charset=utf-8", files[2].MimeType)
+	}
+}
+
+func TestCarveHttpIncomplete(tester *testing.T) {
+	conn := newTestConnection(40000, 80).
+		send(true, []byte("GET /big.bin HTTP/1.1\r\n\r\n")).
+		send(false, []byte("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\npartial"))
+
+	files := carveConnections(tester, conn)
+	if assert.Len(tester, files, 1) {
+		assert.Equal(tester, "big.bin", files[0].Name)
+		assert.Equal(tester, "partial", string(files[0].Bytes))
+		assert.True(tester, files[0].Incomplete)
+	}
+}
+
+func TestCarveFilesBudget(tester *testing.T) {
+	request := []byte("GET /a.txt HTTP/1.1\r\n\r\n")
+	response := []byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello")
+	first := newTestConnection(40000, 80).send(true, request).send(false, response)
+	second := newTestConnection(40001, 80).send(true, request).send(false, response)
+
+	// The budget is shared, leaving only part of the second response
+	budget := 2*len(request) + len(response) + len(response) - 2
+	files := carveConnectionsWithin(tester, budget, func(index int) bool { return index == 1 }, first, second)
+	if assert.Len(tester, files, 2) {
+		assert.False(tester, files[0].Incomplete)
+		assert.Equal(tester, 5, files[0].Size)
+		assert.Equal(tester, sha256Hex([]byte("hello")), files[0].Sha256)
+		assert.Nil(tester, files[0].Bytes)
+
+		assert.True(tester, files[1].Incomplete)
+		assert.Equal(tester, 3, files[1].Size)
+		assert.Equal(tester, "hel", string(files[1].Bytes))
+	}
+}
+
+func TestCarveFtpData(tester *testing.T) {
+	control := newTestConnection(40000, 21).
+		send(false, []byte("220 Welcome\r\n")).
+		send(true, []byte("PASV\r\n")).
+		send(false, []byte("227 Entering Passive Mode (10,0,0,2,195,80).\r\n")).
+		send(true, []byte("RETR /pub/secret.zip\r\n")).
+		send(true, []byte("EPRT |1|10.0.0.2|50001|\r\nSTOR upload.bin\r\n"))
+	download := newTestConnection(40001, 50000).send(false, []byte("PK\x03\x04zip data"))
+	stored := newTestConnection(40002, 50001).send(true, []byte("uploaded bytes"))
+	unrelated := newTestConnection(40003, 50002).send(false, []byte("not ftp"))
+
+	files := carveConnections(tester, control, download, stored, unrelated)
+	if assert.Len(tester, files, 2) {
+		assert.Equal(tester, model.CarvedFileProtocolFtpData, files[0].Protocol)
+		assert.Equal(tester, "secret.zip", files[0].Name)
+		assert.Equal(tester, "PK\x03\x04zip data", string(files[0].Bytes))
+		assert.Equal(tester, "application/zip", files[0].MimeType)
+		assert.Equal(tester, 1, files[0].StreamIndex)
+
+		assert.Equal(tester, "upload.bin", files[1].Name)
+		assert.Equal(tester, "uploaded bytes", string(files[1].Bytes))
+	}
+}
+
+func TestParseFtpPort(tester *testing.T) {
+	ip, port, ok := parseFtpPort("PORT", "192,168,1,5,4,1")
+	assert.True(tester, ok)
+	assert.Equal(tester, "192.168.1.5", ip)
+	assert.Equal(tester, 1025, port)
+
+	ip, port, ok = parseFtpPort("EPRT", "|2|2001:db8::0001|6446|")
+	assert.True(tester, ok)
+	assert.Equal(tester, "2001:db8::1", ip)
+	assert.Equal(tester, 6446, port)
+
+	_, _, ok = parseFtpPort("PORT", "1,2,3")
+	assert.False(tester, ok)
+	_, _, ok = parseFtpPort("EPRT", "|1|bogus|21|")
+	assert.False(tester, ok)
+
+	ip, port, ok = parseFtpPassive("229 Entering Extended Passive Mode (|||6446|)", "10.0.0.2")
+	assert.True(tester, ok)
+	assert.Equal(tester, "10.0.0.2", ip)
+	assert.Equal(tester, 6446, port)
+}
+
+func TestCarveHttpDecodedBudget(tester *testing.T) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(make([]byte, 100000))
+	writer.Close()
+
+	request := []byte("GET /zeros.bin HTTP/1.1\r\n\r\n")
+	response := []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", compressed.Len(), compressed.Bytes()))
+	conn := newTestConnection(40000, 80).send(true, request).send(false, response)
+
+	// The decompressed body may only grow into what remains of the budget
+	files := carveConnectionsWithin(tester, len(request)+len(response)+100, nil, conn)
+	if assert.Len(tester, files, 1) {
+		assert.Equal(tester, compressed.Len()+100, files[0].Size)
+		assert.True(tester, files[0].Incomplete)
+	}
+
+	files = carveConnections(tester, conn)
+	if assert.Len(tester, files, 1) {
+		assert.Equal(tester, 100000, files[0].Size)
+		assert.False(tester, files[0].Incomplete)
+	}
+}
+
+func newSmb2Message(command uint16, response bool, messageId uint64, body []byte) []byte {
+	header := make([]byte, smb2HeaderSize)
+	copy(header, "\xFESMB")
+	binary.LittleEndian.PutUint16(header[4:], smb2HeaderSize)
+	binary.LittleEndian.PutUint16(header[12:], command)
+	if response {
+		binary.LittleEndian.PutUint32(header[16:], smb2FlagResponse)
+	}
+	binary.LittleEndian.PutUint64(header[24:], messageId)
+	return append(header, body...)
+}
+
+func netbiosFrame(messages ...[]byte) []byte {
+	payload := bytes.Join(messages, nil)
+	return append([]byte{0, byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload))}, payload...)
+}
+
+func smb2Create(messageId uint64, name string) []byte {
+	encoded := utf16.Encode([]rune(name))
+	body := make([]byte, 56, 56+len(encoded)*2)
+	binary.LittleEndian.PutUint16(body[44:], smb2HeaderSize+56)
+	binary.LittleEndian.PutUint16(body[46:], uint16(len(encoded)*2))
+	for _, char := range encoded {
+		body = binary.LittleEndian.AppendUint16(body, char)
+	}
+	return newSmb2Message(smb2CommandCreate, false, messageId, body)
+}
+
+func smb2CreateResponse(messageId uint64, fileId []byte, size uint64) []byte {
+	body := make([]byte, 88)
+	binary.LittleEndian.PutUint64(body[48:], size)
+	copy(body[64:], fileId)
+	return newSmb2Message(smb2CommandCreate, true, messageId, body)
+}
+
+func smb2Read(messageId uint64, fileId []byte, offset uint64, length uint32) []byte {
+	body := make([]byte, 48)
+	binary.LittleEndian.PutUint32(body[4:], length)
+	binary.LittleEndian.PutUint64(body[8:], offset)
+	copy(body[16:], fileId)
+	return newSmb2Message(smb2CommandRead, false, messageId, body)
+}
+
+func smb2ReadResponse(messageId uint64, data []byte) []byte {
+	body := make([]byte, 16)
+	body[2] = smb2HeaderSize + 16
+	binary.LittleEndian.PutUint32(body[4:], uint32(len(data)))
+	return newSmb2Message(smb2CommandRead, true, messageId, append(body, data...))
+}
+
+func TestCarveSmb2(tester *testing.T) {
+	fileId := bytes.Repeat([]byte{7}, 16)
+	otherId := bytes.Repeat([]byte{9}, 16)
+
+	conn := newTestConnection(40000, 445).
+		send(true, netbiosFrame(smb2Create(1, "share\\dir\\doc.txt"))).
+		send(false, netbiosFrame(smb2CreateResponse(1, fileId, 11))).
+		send(true, netbiosFrame(smb2Read(2, fileId, 6, 5))).
+		send(false, netbiosFrame(smb2ReadResponse(2, []byte("world")))).
+		send(true, netbiosFrame(smb2Read(3, fileId, 0, 6))).
+		send(false, netbiosFrame(smb2ReadResponse(3, []byte("hello ")))).
+		send(true, netbiosFrame(smb2Create(4, "big.iso"))).
+		send(false, netbiosFrame(smb2CreateResponse(4, otherId, 100))).
+		send(true, netbiosFrame(smb2Read(5, otherId, 0, 4))).
+		send(false, netbiosFrame(smb2ReadResponse(5, []byte("ISO!"))))
+
+	files := carveConnections(tester, conn)
+	if assert.Len(tester, files, 2) {
+		assert.Equal(tester, model.CarvedFileProtocolSmb2, files[0].Protocol)
+		assert.Equal(tester, "doc.txt", files[0].Name)
+		assert.Equal(tester, "hello world", string(files[0].Bytes))
+		assert.False(tester, files[0].Incomplete)
+		assert.Equal(tester, 445, files[0].ServerPort)
+
+		assert.Equal(tester, "big.iso", files[1].Name)
+		assert.Equal(tester, "ISO!", string(files[1].Bytes))
+		assert.True(tester, files[1].Incomplete)
+	}
+}
+
+func TestCarveSmb2OffsetOverflow(tester *testing.T) {
+	fileId := bytes.Repeat([]byte{7}, 16)
+
+	// The file was opened before the capture began, so its size is unknown
+	conn := newTestConnection(40000, 445).
+		send(true, netbiosFrame(smb2Read(1, fileId, math.MaxUint64-1, 4))).
+		send(false, netbiosFrame(smb2ReadResponse(1, []byte("wrap")))).
+		send(true, netbiosFrame(smb2Read(2, fileId, maxSmb2FileSize+1, 4))).
+		send(false, netbiosFrame(smb2ReadResponse(2, []byte("huge")))).
+		send(true, netbiosFrame(smb2Read(3, fileId, 0, 4))).
+		send(false, netbiosFrame(smb2ReadResponse(3, []byte("data"))))
+
+	files := carveConnections(tester, conn)
+	if assert.Len(tester, files, 1) {
+		assert.Equal(tester, "data", string(files[0].Bytes))
+	}
+}
+
+func TestCleanFilename(tester *testing.T) {
+	assert.Equal(tester, "a.txt", cleanFilename("../../a.txt", "x"))
+	assert.Equal(tester, "b.exe", cleanFilename("C:\\Users\\b.exe", "x"))
+	assert.Equal(tester, "x", cleanFilename("dir/", "x"))
+	assert.Equal(tester, "x", cleanFilename(" .. ", "x"))
+}
+
+func TestCarveFilesInvalid(tester *testing.T) {
+	_, err := CarveFiles(bytes.NewReader([]byte("bad")), false, 0, nil)
+	assert.Error(tester, err)
+
+	files, err := CarveFiles(buildTcpPcap(tester, nil), false, 0, nil)
+	assert.NoError(tester, err)
+	assert.Empty(tester, files)
+}
@@ -0,0 +1,550 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+var httpMethods = []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+var smb1Commands = map[byte]string{
+	0x04: "CLOSE",
+	0x25: "TRANSACTION",
+	0x2E: "READ_ANDX",
+	0x2F: "WRITE_ANDX",
+	0x32: "TRANSACTION2",
+	0x71: "TREE_DISCONNECT",
+	0x72: "NEGOTIATE",
+	0x73: "SESSION_SETUP_ANDX",
+	0x74: "LOGOFF_ANDX",
+	0x75: "TREE_CONNECT_ANDX",
+	0xA2: "NT_CREATE_ANDX",
+}
+
+var smb2Commands = []string{
+	"NEGOTIATE",
+	"SESSION_SETUP",
+	"LOGOFF",
+	"TREE_CONNECT",
+	"TREE_DISCONNECT",
+	"CREATE",
+	"CLOSE",
+	"FLUSH",
+	"READ",
+	"WRITE",
+	"LOCK",
+	"IOCTL",
+	"CANCEL",
+	"ECHO",
+	"QUERY_DIRECTORY",
+	"CHANGE_NOTIFY",
+	"QUERY_INFO",
+	"SET_INFO",
+	"OPLOCK_BREAK",
+}
+
+const smb2HeaderSize = 64
+const smb2CommandTreeConnect = 3
+const smb2CommandCreate = 5
+
+// dissectApplication decodes the application layer protocols analysts most often need
+// to see without downloading the PCAP. Returns nil if the packet does not carry a
+// recognized protocol, or if it cannot be decoded.
+func dissectApplication(pcapPacket gopacket.Packet) *model.PacketApplication {
+	if layer := pcapPacket.Layer(layers.LayerTypeDHCPv4); layer != nil {
+		return &model.PacketApplication{Protocol: "dhcp", Dhcp: dissectDhcp(layer.(*layers.DHCPv4))}
+	}
+
+	if layer := pcapPacket.Layer(layers.LayerTypeUDP); layer != nil {
+		if dns, ok := pcapPacket.Layer(layers.LayerTypeDNS).(*layers.DNS); ok {
+			return &model.PacketApplication{Protocol: "dns", Dns: dissectDns(dns)}
+		}
+		return nil
+	}
+
+	tcp, ok := pcapPacket.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok || len(tcp.Payload) == 0 {
+		return nil
+	}
+	payload := tcp.Payload
+
+	if tcp.SrcPort == 53 || tcp.DstPort == 53 {
+		// DNS over TCP prefixes each message with its length
+		if len(payload) > 2 {
+			dns := &layers.DNS{}
+			if dns.DecodeFromBytes(payload[2:], gopacket.NilDecodeFeedback) == nil {
+				return &model.PacketApplication{Protocol: "dns", Dns: dissectDns(dns)}
+			}
+		}
+		return nil
+	}
+	if http := dissectHttp(payload); http != nil {
+		return &model.PacketApplication{Protocol: "http", Http: http}
+	}
+	if tls := dissectTls(payload); tls != nil {
+		return &model.PacketApplication{Protocol: "tls", Tls: tls}
+	}
+	if smb := dissectSmb(payload); smb != nil {
+		return &model.PacketApplication{Protocol: "smb", Smb: smb}
+	}
+	return nil
+}
+
+func dissectDns(dns *layers.DNS) *model.PacketDns {
+	decoded := &model.PacketDns{
+		Id:           int(dns.ID),
+		Response:     dns.QR,
+		Opcode:       dns.OpCode.String(),
+		ResponseCode: dns.ResponseCode.String(),
+		Questions:    make([]*model.PacketDnsQuestion, 0, len(dns.Questions)),
+		Answers:      make([]*model.PacketDnsRecord, 0, len(dns.Answers)),
+	}
+	for _, question := range dns.Questions {
+		decoded.Questions = append(decoded.Questions, &model.PacketDnsQuestion{
+			Name:  string(question.Name),
+			Type:  question.Type.String(),
+			Class: question.Class.String(),
+		})
+	}
+	for _, answer := range dns.Answers {
+		decoded.Answers = append(decoded.Answers, &model.PacketDnsRecord{
+			Name:  string(answer.Name),
+			Type:  answer.Type.String(),
+			Class: answer.Class.String(),
+			Ttl:   int(answer.TTL),
+			Data:  formatDnsData(&answer),
+		})
+	}
+	return decoded
+}
+
+func formatDnsData(record *layers.DNSResourceRecord) string {
+	switch record.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		return record.IP.String()
+	case layers.DNSTypeNS:
+		return string(record.NS)
+	case layers.DNSTypeCNAME:
+		return string(record.CNAME)
+	case layers.DNSTypePTR:
+		return string(record.PTR)
+	case layers.DNSTypeMX:
+		return fmt.Sprintf("%d %s", record.MX.Preference, record.MX.Name)
+	case layers.DNSTypeSRV:
+		return fmt.Sprintf("%d %d %d %s", record.SRV.Priority, record.SRV.Weight, record.SRV.Port, record.SRV.Name)
+	case layers.DNSTypeSOA:
+		return fmt.Sprintf("%s %s %d", record.SOA.MName, record.SOA.RName, record.SOA.Serial)
+	case layers.DNSTypeTXT:
+		txts := make([]string, 0, len(record.TXTs))
+		for _, txt := range record.TXTs {
+			txts = append(txts, string(txt))
+		}
+		return strings.Join(txts, " ")
+	}
+	return ""
+}
+
+// dissectHttp decodes the start line and headers of an HTTP/1.x request or response.
+// Headers that continue into the next segment are not included.
+func dissectHttp(payload []byte) *model.PacketHttp {
+	end := bytes.Index(payload, []byte("\r\n\r\n"))
+	if end < 0 {
+		end = bytes.LastIndex(payload, []byte("\r\n"))
+	}
+	if end < 0 {
+		return nil
+	}
+	lines := strings.Split(string(payload[:end]), "\r\n")
+
+	http := &model.PacketHttp{
+		Headers: make([]*model.PacketHttpHeader, 0),
+	}
+	parts := strings.SplitN(lines[0], " ", 3)
+	if len(parts) < 2 {
+		return nil
+	}
+	if strings.HasPrefix(parts[0], "HTTP/1.") {
+		status, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil
+		}
+		http.Version = parts[0]
+		http.StatusCode = status
+		if len(parts) == 3 {
+			http.Reason = parts[2]
+		}
+	} else if isHttpMethod(parts[0]) && len(parts) == 3 && strings.HasPrefix(parts[2], "HTTP/1.") {
+		http.Method = parts[0]
+		http.Uri = parts[1]
+		http.Version = parts[2]
+	} else {
+		return nil
+	}
+
+	for _, line := range lines[1:] {
+		name, value, found := strings.Cut(line, ":")
+		if found {
+			http.Headers = append(http.Headers, &model.PacketHttpHeader{
+				Name:  strings.TrimSpace(name),
+				Value: strings.TrimSpace(value),
+			})
+		}
+	}
+	return http
+}
+
+func isHttpMethod(method string) bool {
+	for _, candidate := range httpMethods {
+		if method == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsReader reads big endian fields from a TLS handshake, remembering whether any
+// read ran past the end of the data.
+type tlsReader struct {
+	data   []byte
+	failed bool
+}
+
+func (reader *tlsReader) bytes(count int) []byte {
+	if reader.failed || count > len(reader.data) {
+		reader.failed = true
+		return nil
+	}
+	value := reader.data[:count]
+	reader.data = reader.data[count:]
+	return value
+}
+
+func (reader *tlsReader) uint8() int {
+	if value := reader.bytes(1); value != nil {
+		return int(value[0])
+	}
+	return 0
+}
+
+func (reader *tlsReader) uint16() int {
+	if value := reader.bytes(2); value != nil {
+		return int(binary.BigEndian.Uint16(value))
+	}
+	return 0
+}
+
+func (reader *tlsReader) uint24() int {
+	if value := reader.bytes(3); value != nil {
+		return int(value[0])<<16 | int(value[1])<<8 | int(value[2])
+	}
+	return 0
+}
+
+func (reader *tlsReader) vector(lengthSize int) *tlsReader {
+	length := reader.uint8()
+	if lengthSize == 2 {
+		length = length<<8 | reader.uint8()
+	}
+	data := reader.bytes(length)
+	return &tlsReader{data: data, failed: reader.failed}
+}
+
+func formatTlsVersion(version int) string {
+	switch version {
+	case 0x0300:
+		return "SSL 3.0"
+	case 0x0301:
+		return "TLS 1.0"
+	case 0x0302:
+		return "TLS 1.1"
+	case 0x0303:
+		return "TLS 1.2"
+	case 0x0304:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// isGreaseValue returns true for the reserved values clients advertise to keep servers
+// tolerant of unknown values (RFC 8701).
+func isGreaseValue(value int) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+// tlsHello holds the fields of a ClientHello or ServerHello needed to describe and
+// fingerprint a TLS handshake. Lists keep the order and GREASE values sent on the wire.
+// A ServerHello carries the selected cipher and version as single entries.
+type tlsHello struct {
+	version             int
+	ciphers             []int
+	extensions          []int
+	groups              []int
+	pointFormats        []int
+	signatureAlgorithms []int
+	supportedVersions   []int
+	serverName          string
+	alpn                []string
+}
+
+func (reader *tlsReader) uint8s() []int {
+	values := make([]int, 0, len(reader.data))
+	for len(reader.data) > 0 && !reader.failed {
+		values = append(values, reader.uint8())
+	}
+	return values
+}
+
+func (reader *tlsReader) uint16s() []int {
+	values := make([]int, 0, len(reader.data)/2)
+	for len(reader.data) > 1 && !reader.failed {
+		values = append(values, reader.uint16())
+	}
+	return values
+}
+
+// parseTlsHello decodes the body of a ClientHello or ServerHello handshake message.
+// Returns nil if the fixed fields are incomplete. Extensions running past the end of
+// the data are decoded as far as possible.
+func parseTlsHello(hello *tlsReader, client bool) *tlsHello {
+	parsed := &tlsHello{version: hello.uint16()}
+	hello.bytes(32)
+	hello.vector(1)
+	if client {
+		parsed.ciphers = hello.vector(2).uint16s()
+		hello.vector(1)
+	} else {
+		parsed.ciphers = []int{hello.uint16()}
+		hello.uint8()
+	}
+	if hello.failed {
+		return nil
+	}
+
+	extensions := hello.vector(2)
+	if extensions.failed {
+		// The extensions continue into the next segment
+		extensions = &tlsReader{data: hello.data}
+	}
+	for len(extensions.data) >= 4 && !extensions.failed {
+		extensionType := extensions.uint16()
+		extension := extensions.vector(2)
+		if extension.failed {
+			break
+		}
+		parsed.extensions = append(parsed.extensions, extensionType)
+		switch extensionType {
+		case 0:
+			names := extension.vector(2)
+			for len(names.data) > 0 && !names.failed {
+				nameType := names.uint8()
+				name := names.vector(2)
+				if nameType == 0 && !name.failed {
+					parsed.serverName = string(name.data)
+				}
+			}
+		case 10:
+			parsed.groups = extension.vector(2).uint16s()
+		case 11:
+			parsed.pointFormats = extension.vector(1).uint8s()
+		case 13:
+			parsed.signatureAlgorithms = extension.vector(2).uint16s()
+		case 16:
+			protocols := extension.vector(2)
+			for len(protocols.data) > 0 && !protocols.failed {
+				protocol := protocols.vector(1)
+				if !protocol.failed {
+					parsed.alpn = append(parsed.alpn, string(protocol.data))
+				}
+			}
+		case 43:
+			if client {
+				parsed.supportedVersions = extension.vector(1).uint16s()
+			} else {
+				parsed.supportedVersions = []int{extension.uint16()}
+			}
+		}
+	}
+	return parsed
+}
+
+// dissectTls decodes a TLS ClientHello. The ClientHello must begin in this segment,
+// though extensions beyond the end of the segment are not included.
+func dissectTls(payload []byte) *model.PacketTls {
+	record := &tlsReader{data: payload}
+	if record.uint8() != 0x16 || record.uint8() != 0x03 {
+		return nil
+	}
+	record.bytes(1)
+	length := record.uint16()
+	if record.failed || length < 4 {
+		return nil
+	}
+	if length < len(record.data) {
+		record.data = record.data[:length]
+	}
+
+	if record.uint8() != 0x01 {
+		return nil
+	}
+	record.uint24()
+	hello := parseTlsHello(record, true)
+	if hello == nil {
+		return nil
+	}
+
+	tls := &model.PacketTls{
+		Handshake:  "ClientHello",
+		Version:    formatTlsVersion(hello.version),
+		ServerName: hello.serverName,
+		Alpn:       hello.alpn,
+	}
+	highest := 0
+	for _, supported := range hello.supportedVersions {
+		if !isGreaseValue(supported) {
+			tls.SupportedVersions = append(tls.SupportedVersions, formatTlsVersion(supported))
+			if supported > highest {
+				highest = supported
+			}
+		}
+	}
+	if highest > 0 {
+		tls.Version = formatTlsVersion(highest)
+	}
+	return tls
+}
+
+// dissectSmb decodes the header of an SMB1 or SMB2/3 message carried over direct TCP or
+// NetBIOS session service.
+func dissectSmb(payload []byte) *model.PacketSmb {
+	if len(payload) < 8 || payload[0] != 0x00 {
+		return nil
+	}
+	message := payload[4:]
+
+	if bytes.HasPrefix(message, []byte("\xFFSMB")) && len(message) >= 32 {
+		command, known := smb1Commands[message[4]]
+		if !known {
+			command = fmt.Sprintf("0x%02X", message[4])
+		}
+		return &model.PacketSmb{
+			Version:  1,
+			Command:  command,
+			Response: message[9]&0x80 != 0,
+			Status:   fmt.Sprintf("0x%08X", binary.LittleEndian.Uint32(message[5:9])),
+		}
+	}
+
+	if !bytes.HasPrefix(message, []byte("\xFESMB")) || len(message) < smb2HeaderSize {
+		return nil
+	}
+	commandId := int(binary.LittleEndian.Uint16(message[12:14]))
+	command := fmt.Sprintf("0x%04X", commandId)
+	if commandId < len(smb2Commands) {
+		command = smb2Commands[commandId]
+	}
+	smb := &model.PacketSmb{
+		Version:   2,
+		Command:   command,
+		Response:  binary.LittleEndian.Uint32(message[16:20])&0x1 != 0,
+		Status:    fmt.Sprintf("0x%08X", binary.LittleEndian.Uint32(message[8:12])),
+		MessageId: binary.LittleEndian.Uint64(message[24:32]),
+		TreeId:    binary.LittleEndian.Uint32(message[36:40]),
+		SessionId: binary.LittleEndian.Uint64(message[40:48]),
+	}
+	if !smb.Response {
+		switch commandId {
+		case smb2CommandTreeConnect:
+			smb.Path = readSmb2String(message, smb2HeaderSize+4)
+		case smb2CommandCreate:
+			smb.Filename = readSmb2String(message, smb2HeaderSize+44)
+		}
+	}
+	return smb
+}
+
+// readSmb2String reads the UTF-16 string referenced by the offset and length fields at
+// the given position in the SMB2 message.
+func readSmb2String(message []byte, position int) string {
+	if len(message) < position+4 {
+		return ""
+	}
+	offset := int(binary.LittleEndian.Uint16(message[position:]))
+	length := int(binary.LittleEndian.Uint16(message[position+2:]))
+	if length%2 != 0 || offset+length > len(message) {
+		return ""
+	}
+	chars := make([]uint16, length/2)
+	for idx := range chars {
+		chars[idx] = binary.LittleEndian.Uint16(message[offset+idx*2:])
+	}
+	return string(utf16.Decode(chars))
+}
+
+func dissectDhcp(dhcp *layers.DHCPv4) *model.PacketDhcp {
+	decoded := &model.PacketDhcp{
+		TransactionId: fmt.Sprintf("0x%08x", dhcp.Xid),
+		ClientMac:     dhcp.ClientHWAddr.String(),
+		ClientIp:      formatDhcpIp(dhcp.ClientIP),
+		YourIp:        formatDhcpIp(dhcp.YourClientIP),
+		ServerIp:      formatDhcpIp(dhcp.NextServerIP),
+		RelayIp:       formatDhcpIp(dhcp.RelayAgentIP),
+	}
+	for _, option := range dhcp.Options {
+		switch option.Type {
+		case layers.DHCPOptMessageType:
+			if len(option.Data) == 1 {
+				decoded.MessageType = layers.DHCPMsgType(option.Data[0]).String()
+			}
+		case layers.DHCPOptHostname:
+			decoded.Hostname = string(option.Data)
+		case layers.DHCPOptRequestIP:
+			decoded.RequestedIp = formatDhcpIp(option.Data)
+		case layers.DHCPOptServerID:
+			decoded.ServerId = formatDhcpIp(option.Data)
+		case layers.DHCPOptLeaseTime:
+			if len(option.Data) == 4 {
+				decoded.LeaseSeconds = int(binary.BigEndian.Uint32(option.Data))
+			}
+		case layers.DHCPOptRouter:
+			decoded.Routers = formatDhcpIps(option.Data)
+		case layers.DHCPOptDNS:
+			decoded.DnsServers = formatDhcpIps(option.Data)
+		case layers.DHCPOptDomainName:
+			decoded.DomainName = string(option.Data)
+		case layers.DHCPOptClassID:
+			decoded.VendorClass = string(option.Data)
+		}
+	}
+	return decoded
+}
+
+// formatDhcpIp returns an empty string for unset addresses.
+func formatDhcpIp(ip net.IP) string {
+	if len(ip) != net.IPv4len || ip.Equal(net.IPv4zero) {
+		return ""
+	}
+	return ip.String()
+}
+
+func formatDhcpIps(data []byte) []string {
+	ips := make([]string, 0, len(data)/net.IPv4len)
+	for len(data) >= net.IPv4len {
+		ips = append(ips, net.IP(data[:net.IPv4len]).String())
+		data = data[net.IPv4len:]
+	}
+	return ips
+}
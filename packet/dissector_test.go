@@ -0,0 +1,293 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildPacket(tester *testing.T, transport gopacket.SerializableLayer, payload gopacket.SerializableLayer) gopacket.Packet {
+	ethernet := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version: 4,
+		TTL:     64,
+		SrcIP:   net.IP{10, 0, 0, 1},
+		DstIP:   net.IP{10, 0, 0, 2},
+	}
+	switch layer := transport.(type) {
+	case *layers.TCP:
+		ip.Protocol = layers.IPProtocolTCP
+		layer.SetNetworkLayerForChecksum(ip)
+	case *layers.UDP:
+		ip.Protocol = layers.IPProtocolUDP
+		layer.SetNetworkLayerForChecksum(ip)
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	assert.NoError(tester, gopacket.SerializeLayers(buffer, options, ethernet, ip, transport, payload))
+	return gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+}
+
+func buildTcpPacket(tester *testing.T, port int, payload []byte) gopacket.Packet {
+	tcp := &layers.TCP{SrcPort: 50000, DstPort: layers.TCPPort(port), PSH: true, ACK: true, Window: 1024}
+	return buildPacket(tester, tcp, gopacket.Payload(payload))
+}
+
+func TestDissectDns(tester *testing.T) {
+	dns := &layers.DNS{
+		ID:           42,
+		QR:           true,
+		OpCode:       layers.DNSOpCodeQuery,
+		ResponseCode: layers.DNSResponseCodeNoErr,
+		Questions: []layers.DNSQuestion{
+			{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+		},
+		Answers: []layers.DNSResourceRecord{
+			{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 300, IP: net.IP{93, 184, 216, 34}},
+		},
+	}
+	udp := &layers.UDP{SrcPort: 53, DstPort: 50000}
+	application := dissectApplication(buildPacket(tester, udp, dns))
+	if assert.NotNil(tester, application) {
+		assert.Equal(tester, "dns", application.Protocol)
+		assert.Equal(tester, 42, application.Dns.Id)
+		assert.True(tester, application.Dns.Response)
+		assert.Equal(tester, "Query", application.Dns.Opcode)
+		assert.Equal(tester, "No Error", application.Dns.ResponseCode)
+		assert.Len(tester, application.Dns.Questions, 1)
+		assert.Equal(tester, "example.com", application.Dns.Questions[0].Name)
+		assert.Equal(tester, "A", application.Dns.Questions[0].Type)
+		assert.Len(tester, application.Dns.Answers, 1)
+		assert.Equal(tester, "93.184.216.34", application.Dns.Answers[0].Data)
+		assert.Equal(tester, 300, application.Dns.Answers[0].Ttl)
+	}
+
+	// DNS over TCP carries a two byte length prefix
+	buffer := gopacket.NewSerializeBuffer()
+	assert.NoError(tester, dns.SerializeTo(buffer, gopacket.SerializeOptions{FixLengths: true}))
+	message := append([]byte{0, byte(len(buffer.Bytes()))}, buffer.Bytes()...)
+	application = dissectApplication(buildTcpPacket(tester, 53, message))
+	if assert.NotNil(tester, application) {
+		assert.Equal(tester, "dns", application.Protocol)
+		assert.Equal(tester, "example.com", application.Dns.Questions[0].Name)
+	}
+}
+
+func TestDissectHttp(tester *testing.T) {
+	request := "GET /index.html HTTP/1.1\r\nHost: example.com\r\nUser-Agent: curl/8.0\r\n\r\n"
+	application := dissectApplication(buildTcpPacket(tester, 80, []byte(request)))
+	if assert.NotNil(tester, application) {
+		assert.Equal(tester, "http", application.Protocol)
+		assert.Equal(tester, "GET", application.Http.Method)
+		assert.Equal(tester, "/index.html", application.Http.Uri)
+		assert.Equal(tester, "HTTP/1.1", application.Http.Version)
+		assert.Len(tester, application.Http.Headers, 2)
+		assert.Equal(tester, "Host", application.Http.Headers[0].Name)
+		assert.Equal(tester, "example.com", application.Http.Headers[0].Value)
+	}
+
+	response := "HTTP/1.0 404 Not Found\r\nContent-Length: 0\r\nServer: tes"
+	http := dissectHttp([]byte(response))
+	if assert.NotNil(tester, http) {
+		assert.Equal(tester, 404, http.StatusCode)
+		assert.Equal(tester, "Not Found", http.Reason)
+		assert.Equal(tester, "HTTP/1.0", http.Version)
+		assert.Len(tester, http.Headers, 1)
+	}
+
+	assert.Nil(tester, dissectHttp([]byte("FOO / HTTP/1.1\r\n\r\n")))
+	assert.Nil(tester, dissectHttp([]byte("GET / HTTP/1.1")))
+	assert.Nil(tester, dissectHttp([]byte("HTTP/1.1 abc OK\r\n")))
+}
+
+func buildClientHello(extensions []byte) []byte {
+	hello := []byte{0x03, 0x03}
+	hello = append(hello, make([]byte, 32)...)
+	hello = append(hello, 0)
+	hello = append(hello, 0, 4, 0x13, 0x01, 0xC0, 0x2F)
+	hello = append(hello, 1, 0)
+	hello = binary.BigEndian.AppendUint16(hello, uint16(len(extensions)))
+	hello = append(hello, extensions...)
+
+	handshake := []byte{0x01, 0, byte(len(hello) >> 8), byte(len(hello))}
+	handshake = append(handshake, hello...)
+	record := []byte{0x16, 0x03, 0x01}
+	record = binary.BigEndian.AppendUint16(record, uint16(len(handshake)))
+	return append(record, handshake...)
+}
+
+func buildExtension(extensionType uint16, data []byte) []byte {
+	extension := binary.BigEndian.AppendUint16(nil, extensionType)
+	extension = binary.BigEndian.AppendUint16(extension, uint16(len(data)))
+	return append(extension, data...)
+}
+
+func TestDissectTls(tester *testing.T) {
+	name := "www.example.com"
+	sni := binary.BigEndian.AppendUint16(nil, uint16(len(name)+3))
+	sni = append(sni, 0)
+	sni = binary.BigEndian.AppendUint16(sni, uint16(len(name)))
+	sni = append(sni, name...)
+	alpn := []byte{0, 12, 2, 'h', '2', 8, 'h', 't', 't', 'p', '/', '1', '.', '1'}
+	versions := []byte{6, 0x3A, 0x3A, 0x03, 0x04, 0x03, 0x03}
+
+	extensions := buildExtension(0, sni)
+	extensions = append(extensions, buildExtension(16, alpn)...)
+	extensions = append(extensions, buildExtension(43, versions)...)
+	payload := buildClientHello(extensions)
+
+	application := dissectApplication(buildTcpPacket(tester, 443, payload))
+	if assert.NotNil(tester, application) {
+		assert.Equal(tester, "tls", application.Protocol)
+		assert.Equal(tester, "ClientHello", application.Tls.Handshake)
+		assert.Equal(tester, "TLS 1.3", application.Tls.Version)
+		assert.Equal(tester, "www.example.com", application.Tls.ServerName)
+		assert.Equal(tester, []string{"h2", "http/1.1"}, application.Tls.Alpn)
+		assert.Equal(tester, []string{"TLS 1.3", "TLS 1.2"}, application.Tls.SupportedVersions)
+	}
+
+	// Extensions continuing into the next segment are decoded as far as possible
+	tls := dissectTls(payload[:len(payload)-len(buildExtension(43, versions))])
+	if assert.NotNil(tester, tls) {
+		assert.Equal(tester, "TLS 1.2", tls.Version)
+		assert.Equal(tester, "www.example.com", tls.ServerName)
+		assert.Empty(tester, tls.SupportedVersions)
+	}
+
+	assert.Nil(tester, dissectTls(payload[:20]))
+	assert.Nil(tester, dissectTls([]byte{0x17, 0x03, 0x03, 0, 5, 1, 2, 3, 4, 5}))
+}
+
+func buildSmb2(command uint16, flags uint32, body []byte) []byte {
+	header := make([]byte, smb2HeaderSize)
+	copy(header, "\xFESMB")
+	binary.LittleEndian.PutUint16(header[4:], smb2HeaderSize)
+	binary.LittleEndian.PutUint16(header[12:], command)
+	binary.LittleEndian.PutUint32(header[16:], flags)
+	binary.LittleEndian.PutUint64(header[24:], 7)
+	binary.LittleEndian.PutUint32(header[36:], 3)
+	binary.LittleEndian.PutUint64(header[40:], 99)
+	message := append(header, body...)
+
+	netbios := []byte{0, 0}
+	netbios = binary.BigEndian.AppendUint16(netbios, uint16(len(message)))
+	return append(netbios, message...)
+}
+
+func encodeUtf16(value string) []byte {
+	encoded := make([]byte, 0)
+	for _, char := range utf16.Encode([]rune(value)) {
+		encoded = binary.LittleEndian.AppendUint16(encoded, char)
+	}
+	return encoded
+}
+
+func TestDissectSmb(tester *testing.T) {
+	path := encodeUtf16(`\\server\share`)
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:], 9)
+	binary.LittleEndian.PutUint16(body[4:], smb2HeaderSize+8)
+	binary.LittleEndian.PutUint16(body[6:], uint16(len(path)))
+	body = append(body, path...)
+
+	application := dissectApplication(buildTcpPacket(tester, 445, buildSmb2(smb2CommandTreeConnect, 0, body)))
+	if assert.NotNil(tester, application) {
+		assert.Equal(tester, "smb", application.Protocol)
+		assert.Equal(tester, 2, application.Smb.Version)
+		assert.Equal(tester, "TREE_CONNECT", application.Smb.Command)
+		assert.False(tester, application.Smb.Response)
+		assert.Equal(tester, "0x00000000", application.Smb.Status)
+		assert.Equal(tester, uint64(7), application.Smb.MessageId)
+		assert.Equal(tester, uint32(3), application.Smb.TreeId)
+		assert.Equal(tester, uint64(99), application.Smb.SessionId)
+		assert.Equal(tester, `\\server\share`, application.Smb.Path)
+	}
+
+	filename := encodeUtf16(`docs\report.docx`)
+	body = make([]byte, 56)
+	binary.LittleEndian.PutUint16(body[44:], smb2HeaderSize+56)
+	binary.LittleEndian.PutUint16(body[46:], uint16(len(filename)))
+	body = append(body, filename...)
+	smb := dissectSmb(buildSmb2(smb2CommandCreate, 0, body))
+	if assert.NotNil(tester, smb) {
+		assert.Equal(tester, "CREATE", smb.Command)
+		assert.Equal(tester, `docs\report.docx`, smb.Filename)
+	}
+
+	smb = dissectSmb(buildSmb2(smb2CommandCreate, 1, nil))
+	if assert.NotNil(tester, smb) {
+		assert.True(tester, smb.Response)
+		assert.Empty(tester, smb.Filename)
+	}
+
+	smb1 := make([]byte, 32)
+	copy(smb1, "\xFFSMB\x72")
+	binary.LittleEndian.PutUint32(smb1[5:], 0xC0000022)
+	smb1[9] = 0x80
+	smb = dissectSmb(append([]byte{0, 0, 0, 32}, smb1...))
+	if assert.NotNil(tester, smb) {
+		assert.Equal(tester, 1, smb.Version)
+		assert.Equal(tester, "NEGOTIATE", smb.Command)
+		assert.True(tester, smb.Response)
+		assert.Equal(tester, "0xC0000022", smb.Status)
+	}
+
+	assert.Nil(tester, dissectSmb([]byte{0, 0, 0, 4, 'n', 'o', 'p', 'e'}))
+}
+
+func TestDissectDhcp(tester *testing.T) {
+	dhcp := &layers.DHCPv4{
+		Operation:    layers.DHCPOpReply,
+		HardwareType: layers.LinkTypeEthernet,
+		Xid:          0x1234,
+		YourClientIP: net.IP{10, 0, 0, 50},
+		ClientHWAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		Options: layers.DHCPOptions{
+			layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeOffer)}),
+			layers.NewDHCPOption(layers.DHCPOptServerID, []byte{10, 0, 0, 1}),
+			layers.NewDHCPOption(layers.DHCPOptLeaseTime, []byte{0, 0, 0x0E, 0x10}),
+			layers.NewDHCPOption(layers.DHCPOptRouter, []byte{10, 0, 0, 1}),
+			layers.NewDHCPOption(layers.DHCPOptDNS, []byte{8, 8, 8, 8, 1, 1, 1, 1}),
+			layers.NewDHCPOption(layers.DHCPOptDomainName, []byte("example.com")),
+			layers.NewDHCPOption(layers.DHCPOptHostname, []byte("workstation")),
+		},
+	}
+	udp := &layers.UDP{SrcPort: 67, DstPort: 68}
+	application := dissectApplication(buildPacket(tester, udp, dhcp))
+	if assert.NotNil(tester, application) {
+		assert.Equal(tester, "dhcp", application.Protocol)
+		assert.Equal(tester, "Offer", application.Dhcp.MessageType)
+		assert.Equal(tester, "0x00001234", application.Dhcp.TransactionId)
+		assert.Equal(tester, "00:01:02:03:04:05", application.Dhcp.ClientMac)
+		assert.Equal(tester, "10.0.0.50", application.Dhcp.YourIp)
+		assert.Empty(tester, application.Dhcp.ClientIp)
+		assert.Equal(tester, "10.0.0.1", application.Dhcp.ServerId)
+		assert.Equal(tester, 3600, application.Dhcp.LeaseSeconds)
+		assert.Equal(tester, []string{"10.0.0.1"}, application.Dhcp.Routers)
+		assert.Equal(tester, []string{"8.8.8.8", "1.1.1.1"}, application.Dhcp.DnsServers)
+		assert.Equal(tester, "example.com", application.Dhcp.DomainName)
+		assert.Equal(tester, "workstation", application.Dhcp.Hostname)
+	}
+}
+
+func TestDissectUnknown(tester *testing.T) {
+	assert.Nil(tester, dissectApplication(buildTcpPacket(tester, 9999, []byte("hello"))))
+	assert.Nil(tester, dissectApplication(buildTcpPacket(tester, 80, nil)))
+	udp := &layers.UDP{SrcPort: 9999, DstPort: 9998}
+	assert.Nil(tester, dissectApplication(buildPacket(tester, udp, gopacket.Payload("hello"))))
+}
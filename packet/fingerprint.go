@@ -0,0 +1,489 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+// maxFingerprintBytes bounds the data buffered from the start of each direction of a
+// connection while waiting for a complete handshake message.
+const maxFingerprintBytes = 65536
+const maxSshPacketLength = 35000
+const sshMsgKexInit = 20
+
+// fingerprintQueryFields maps each fingerprint type to the event field holding it.
+var fingerprintQueryFields = map[string]string{
+	model.FingerprintTypeJa3:         "tls.client.ja3",
+	model.FingerprintTypeJa3s:        "tls.server.ja3s",
+	model.FingerprintTypeJa4:         "tls.client.ja4",
+	model.FingerprintTypeHassh:       "ssh.hassh",
+	model.FingerprintTypeHasshServer: "ssh.hassh_server",
+}
+
+// observedFingerprint is a fingerprint along with the connection it was observed on.
+type observedFingerprint struct {
+	fingerprint *model.PacketFingerprint
+	clientIp    string
+	serverIp    string
+	serverName  string
+}
+
+type fingerprintDirection struct {
+	started bool
+	done    bool
+	nextSeq uint32
+	data    []byte
+}
+
+type fingerprintConnection struct {
+	client     string
+	serverName string
+	directions map[string]*fingerprintDirection
+}
+
+// fingerprinter follows the start of each direction of the TCP connections in a
+// capture, fingerprinting the first TLS or SSH handshake message sent. Messages split
+// across segments are fingerprinted once the segment completing them is added.
+type fingerprinter struct {
+	connections map[string]*fingerprintConnection
+}
+
+func newFingerprinter() *fingerprinter {
+	return &fingerprinter{
+		connections: make(map[string]*fingerprintConnection),
+	}
+}
+
+func (fp *fingerprinter) add(pcapPacket gopacket.Packet) []*observedFingerprint {
+	network, tcp := findTcpLayers(pcapPacket)
+	if tcp == nil {
+		return nil
+	}
+	srcIp := network.NetworkFlow().Src().String()
+	dstIp := network.NetworkFlow().Dst().String()
+	src := tcpEndpoint(srcIp, tcp.SrcPort)
+	dst := tcpEndpoint(dstIp, tcp.DstPort)
+	key := tcpConnectionKey(src, dst)
+
+	connection, found := fp.connections[key]
+	if !found || (tcp.SYN && !tcp.ACK && connection.restarted(src)) {
+		connection = &fingerprintConnection{directions: make(map[string]*fingerprintDirection)}
+		fp.connections[key] = connection
+	}
+	if tcp.SYN && connection.client == "" {
+		if tcp.ACK {
+			connection.client = dst
+		} else {
+			connection.client = src
+		}
+	}
+
+	direction, found := connection.directions[src]
+	if !found {
+		direction = &fingerprintDirection{}
+		connection.directions[src] = direction
+	}
+	if tcp.SYN {
+		direction.started = true
+		direction.nextSeq = tcp.Seq + 1
+	}
+	if direction.done || len(tcp.Payload) == 0 {
+		return nil
+	}
+
+	payload := tcp.Payload
+	if !direction.started {
+		direction.started = true
+		direction.nextSeq = tcp.Seq
+	}
+	diff := seqDiff(tcp.Seq, direction.nextSeq)
+	if diff > 0 {
+		// Data is missing from the start of the handshake
+		direction.done = true
+		direction.data = nil
+		return nil
+	}
+	if -diff >= len(payload) {
+		return nil
+	}
+	payload = payload[-diff:]
+	direction.data = append(direction.data, payload...)
+	direction.nextSeq += uint32(len(payload))
+
+	fromClient := connection.isClient(src, int(tcp.SrcPort), int(tcp.DstPort))
+	fingerprints, finished := connection.fingerprint(direction.data, fromClient)
+	if finished || len(direction.data) > maxFingerprintBytes {
+		direction.done = true
+		direction.data = nil
+	}
+
+	observed := make([]*observedFingerprint, 0, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		entry := &observedFingerprint{
+			fingerprint: fingerprint,
+			clientIp:    srcIp,
+			serverIp:    dstIp,
+			serverName:  connection.serverName,
+		}
+		if !fromClient {
+			entry.clientIp, entry.serverIp = dstIp, srcIp
+		}
+		observed = append(observed, entry)
+	}
+	return observed
+}
+
+// restarted returns true if a SYN from the endpoint opens a new connection reusing the
+// same addresses and ports, rather than retransmitting the original SYN.
+func (connection *fingerprintConnection) restarted(src string) bool {
+	if connection.client != src {
+		return true
+	}
+	direction := connection.directions[src]
+	return direction != nil && (direction.done || len(direction.data) > 0)
+}
+
+// isClient determines whether the endpoint opened the connection. When the handshake
+// was not captured, the endpoint using the well known or lower port is the server.
+func (connection *fingerprintConnection) isClient(src string, srcPort int, dstPort int) bool {
+	if connection.client != "" {
+		return connection.client == src
+	}
+	if srcPort == 22 || dstPort == 22 {
+		return dstPort == 22
+	}
+	return srcPort > dstPort
+}
+
+// fingerprint attempts to fingerprint the first handshake message at the start of the
+// data sent in one direction. Returns finished once the message has been handled, or
+// when the data is not a TLS or SSH handshake.
+func (connection *fingerprintConnection) fingerprint(data []byte, fromClient bool) ([]*model.PacketFingerprint, bool) {
+	if data[0] == 0x16 {
+		message, isTls := tlsHandshakeMessage(data)
+		if !isTls || message == nil {
+			return nil, !isTls
+		}
+		fingerprints := make([]*model.PacketFingerprint, 0)
+		switch message[0] {
+		case 0x01:
+			if hello := parseTlsHello(&tlsReader{data: message[4:]}, true); hello != nil {
+				connection.serverName = hello.serverName
+				fingerprints = append(fingerprints, ja3(hello), ja4(hello))
+			}
+		case 0x02:
+			if hello := parseTlsHello(&tlsReader{data: message[4:]}, false); hello != nil {
+				fingerprints = append(fingerprints, ja3s(hello))
+			}
+		}
+		return fingerprints, true
+	}
+
+	if len(data) < 4 && bytes.HasPrefix([]byte("SSH-"), data) {
+		return nil, false
+	}
+	if bytes.HasPrefix(data, []byte("SSH-")) {
+		kexInit, isSsh := sshKexInit(data)
+		if !isSsh || kexInit == nil {
+			return nil, !isSsh
+		}
+		return []*model.PacketFingerprint{hassh(kexInit, fromClient)}, true
+	}
+	return nil, true
+}
+
+// tlsHandshakeMessage returns the first handshake message carried by the TLS records at
+// the start of the data, joining fragments split across records. Returns a nil message
+// if more data is needed, and isTls false if the data is not a TLS handshake.
+func tlsHandshakeMessage(data []byte) ([]byte, bool) {
+	handshake := make([]byte, 0)
+	for len(data) >= 5 {
+		if data[0] != 0x16 || data[1] != 0x03 {
+			return nil, false
+		}
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			break
+		}
+		handshake = append(handshake, data[5:5+length]...)
+		data = data[5+length:]
+		if len(handshake) >= 4 {
+			size := 4 + (int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3]))
+			if len(handshake) >= size {
+				return handshake[:size], true
+			}
+		}
+	}
+	return nil, true
+}
+
+// sshKexInit returns the payload of the key exchange initialization following the
+// version banner at the start of the data. Returns a nil payload if more data is
+// needed, and isSsh false if the data does not follow the SSH protocol.
+func sshKexInit(data []byte) ([]byte, bool) {
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, len(data) <= 255
+	}
+	data = data[end+1:]
+	if len(data) < 5 {
+		return nil, true
+	}
+	length := int(binary.BigEndian.Uint32(data))
+	padding := int(data[4])
+	if length > maxSshPacketLength || padding+1 >= length {
+		return nil, false
+	}
+	if len(data) < 4+length {
+		return nil, true
+	}
+	payload := data[5 : 4+length-padding]
+	if len(payload) < 17 || payload[0] != sshMsgKexInit {
+		return nil, false
+	}
+	return payload, true
+}
+
+func md5Hex(raw string) string {
+	sum := md5.Sum([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// formatTlsValues joins the values, omitting GREASE values, using the given format for
+// each value.
+func formatTlsValues(values []int, format string) []string {
+	formatted := make([]string, 0, len(values))
+	for _, value := range values {
+		if !isGreaseValue(value) {
+			formatted = append(formatted, fmt.Sprintf(format, value))
+		}
+	}
+	return formatted
+}
+
+func ja3(hello *tlsHello) *model.PacketFingerprint {
+	raw := strings.Join([]string{
+		strconv.Itoa(hello.version),
+		strings.Join(formatTlsValues(hello.ciphers, "%d"), "-"),
+		strings.Join(formatTlsValues(hello.extensions, "%d"), "-"),
+		strings.Join(formatTlsValues(hello.groups, "%d"), "-"),
+		strings.Join(formatTlsValues(hello.pointFormats, "%d"), "-"),
+	}, ",")
+	return &model.PacketFingerprint{Type: model.FingerprintTypeJa3, Value: md5Hex(raw), Raw: raw}
+}
+
+func ja3s(hello *tlsHello) *model.PacketFingerprint {
+	raw := strings.Join([]string{
+		strconv.Itoa(hello.version),
+		strings.Join(formatTlsValues(hello.ciphers, "%d"), "-"),
+		strings.Join(formatTlsValues(hello.extensions, "%d"), "-"),
+	}, ",")
+	return &model.PacketFingerprint{Type: model.FingerprintTypeJa3s, Value: md5Hex(raw), Raw: raw}
+}
+
+func ja4Version(version int) string {
+	switch version {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	case 0xfeff:
+		return "d1"
+	case 0xfefd:
+		return "d2"
+	case 0xfefc:
+		return "d3"
+	}
+	return "00"
+}
+
+func isAlphanumeric(char byte) bool {
+	return (char >= '0' && char <= '9') || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+// ja4Alpn abbreviates the first ALPN value to its first and last characters, or to the
+// first and last hex digits of the value if either character is not alphanumeric.
+func ja4Alpn(alpn []string) string {
+	if len(alpn) == 0 || len(alpn[0]) == 0 {
+		return "00"
+	}
+	value := alpn[0]
+	first, last := value[0], value[len(value)-1]
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		encoded := hex.EncodeToString([]byte(value))
+		first, last = encoded[0], encoded[len(encoded)-1]
+	}
+	return string([]byte{first, last})
+}
+
+func ja4Hash(raw string) string {
+	if raw == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func ja4(hello *tlsHello) *model.PacketFingerprint {
+	version := hello.version
+	for _, supported := range hello.supportedVersions {
+		if !isGreaseValue(supported) && supported > version {
+			version = supported
+		}
+	}
+	sni := "i"
+	for _, extension := range hello.extensions {
+		if extension == 0 {
+			sni = "d"
+		}
+	}
+
+	ciphers := formatTlsValues(hello.ciphers, "%04x")
+	extensions := formatTlsValues(hello.extensions, "%04x")
+	prefix := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), sni, min(len(ciphers), 99), min(len(extensions), 99), ja4Alpn(hello.alpn))
+
+	sort.Strings(ciphers)
+	hashedExtensions := make([]string, 0, len(extensions))
+	for _, extension := range extensions {
+		// The server name and ALPN are already represented in the prefix
+		if extension != "0000" && extension != "0010" {
+			hashedExtensions = append(hashedExtensions, extension)
+		}
+	}
+	sort.Strings(hashedExtensions)
+	cipherList := strings.Join(ciphers, ",")
+	extensionList := strings.Join(hashedExtensions, ",")
+	if algorithms := formatTlsValues(hello.signatureAlgorithms, "%04x"); len(hashedExtensions) > 0 && len(algorithms) > 0 {
+		extensionList += "_" + strings.Join(algorithms, ",")
+	}
+
+	return &model.PacketFingerprint{
+		Type:  model.FingerprintTypeJa4,
+		Value: prefix + "_" + ja4Hash(cipherList) + "_" + ja4Hash(extensionList),
+		Raw:   prefix + "_" + cipherList + "_" + extensionList,
+	}
+}
+
+// hassh fingerprints the algorithms offered in an SSH key exchange initialization. The
+// client offers are used for HASSH and the server offers for HASSHServer.
+func hassh(kexInit []byte, fromClient bool) *model.PacketFingerprint {
+	reader := &tlsReader{data: kexInit[17:]}
+	lists := make([]string, 0, 8)
+	for idx := 0; idx < 8; idx++ {
+		length := 0
+		if value := reader.bytes(4); value != nil {
+			length = int(binary.BigEndian.Uint32(value))
+		}
+		lists = append(lists, string(reader.bytes(length)))
+	}
+
+	// The name-lists are kex and host key algorithms, then encryption, mac and compression
+	// algorithms each listed client to server followed by server to client
+	raw := strings.Join([]string{lists[0], lists[2], lists[4], lists[6]}, ";")
+	fingerprintType := model.FingerprintTypeHassh
+	if !fromClient {
+		raw = strings.Join([]string{lists[0], lists[3], lists[5], lists[7]}, ";")
+		fingerprintType = model.FingerprintTypeHasshServer
+	}
+	return &model.PacketFingerprint{Type: fingerprintType, Value: md5Hex(raw), Raw: raw}
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// ComputeFingerprints reads a PCAP or pcapng stream and summarizes the JA3, JA3S, JA4,
+// HASSH and HASSHServer fingerprints of the TLS and SSH handshakes it contains.
+func ComputeFingerprints(reader io.Reader, unwrap bool) (*model.FingerprintSummary, error) {
+	capture, err := newCaptureReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &model.FingerprintSummary{
+		Fingerprints: make([]*model.FingerprintSummaryEntry, 0),
+	}
+	entries := make(map[string]*model.FingerprintSummaryEntry)
+	fp := newFingerprinter()
+	for {
+		data, ci, readErr := capture.ReadPacketData()
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		pcapPacket := capture.decode(data, ci, gopacket.Default)
+		if unwrap {
+			pcapPacket = unwrapPacket(pcapPacket, nil)
+		}
+		for _, observed := range fp.add(pcapPacket) {
+			fingerprint := observed.fingerprint
+			key := fingerprint.Type + "|" + fingerprint.Value
+			entry, found := entries[key]
+			if !found {
+				entry = &model.FingerprintSummaryEntry{
+					Type:      fingerprint.Type,
+					Value:     fingerprint.Value,
+					Raw:       fingerprint.Raw,
+					FirstSeen: ci.Timestamp,
+					ClientIps: make([]string, 0),
+					ServerIps: make([]string, 0),
+					Query:     fmt.Sprintf(`%s:"%s"`, fingerprintQueryFields[fingerprint.Type], fingerprint.Value),
+				}
+				entries[key] = entry
+				summary.Fingerprints = append(summary.Fingerprints, entry)
+			}
+			entry.Count++
+			entry.LastSeen = ci.Timestamp
+			entry.ClientIps = appendUnique(entry.ClientIps, observed.clientIp)
+			entry.ServerIps = appendUnique(entry.ServerIps, observed.serverIp)
+			entry.ServerNames = appendUnique(entry.ServerNames, observed.serverName)
+		}
+	}
+
+	return summary, nil
+}
+
+// fingerprintPacket returns the fingerprints of the handshake messages completed by
+// the packet.
+func fingerprintPacket(fp *fingerprinter, pcapPacket gopacket.Packet) []*model.PacketFingerprint {
+	var fingerprints []*model.PacketFingerprint
+	for _, observed := range fp.add(pcapPacket) {
+		fingerprints = append(fingerprints, observed.fingerprint)
+	}
+	return fingerprints
+}
@@ -0,0 +1,263 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func uint16List(lengthSize int, values ...uint16) []byte {
+	list := make([]byte, 0)
+	for _, value := range values {
+		list = binary.BigEndian.AppendUint16(list, value)
+	}
+	if lengthSize == 1 {
+		return append([]byte{byte(len(list))}, list...)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(list))), list...)
+}
+
+func buildHandshakeRecord(handshakeType byte, body []byte) []byte {
+	handshake := []byte{handshakeType, 0, byte(len(body) >> 8), byte(len(body))}
+	handshake = append(handshake, body...)
+	record := []byte{0x16, 0x03, 0x01}
+	record = binary.BigEndian.AppendUint16(record, uint16(len(handshake)))
+	return append(record, handshake...)
+}
+
+func buildFingerprintClientHello() []byte {
+	name := "www.example.com"
+	sni := binary.BigEndian.AppendUint16(nil, uint16(len(name)+3))
+	sni = append(sni, 0)
+	sni = binary.BigEndian.AppendUint16(sni, uint16(len(name)))
+	sni = append(sni, name...)
+
+	extensions := buildExtension(0x1a1a, nil)
+	extensions = append(extensions, buildExtension(0x0000, sni)...)
+	extensions = append(extensions, buildExtension(0x0017, nil)...)
+	extensions = append(extensions, buildExtension(0xff01, []byte{0})...)
+	extensions = append(extensions, buildExtension(0x000a, uint16List(2, 0x2a2a, 29, 23, 24))...)
+	extensions = append(extensions, buildExtension(0x000b, []byte{1, 0})...)
+	extensions = append(extensions, buildExtension(0x0023, nil)...)
+	extensions = append(extensions, buildExtension(0x0010, []byte{0, 12, 2, 'h', '2', 8, 'h', 't', 't', 'p', '/', '1', '.', '1'})...)
+	extensions = append(extensions, buildExtension(0x0005, []byte{1, 0, 0, 0, 0})...)
+	extensions = append(extensions, buildExtension(0x000d, uint16List(2, 0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601))...)
+	extensions = append(extensions, buildExtension(0x0012, nil)...)
+	extensions = append(extensions, buildExtension(0x0033, nil)...)
+	extensions = append(extensions, buildExtension(0x002d, []byte{1, 1})...)
+	extensions = append(extensions, buildExtension(0x002b, uint16List(1, 0x3a3a, 0x0304, 0x0303))...)
+	extensions = append(extensions, buildExtension(0x001b, nil)...)
+	extensions = append(extensions, buildExtension(0x0015, nil)...)
+	extensions = append(extensions, buildExtension(0x4469, nil)...)
+
+	hello := []byte{0x03, 0x03}
+	hello = append(hello, make([]byte, 32)...)
+	hello = append(hello, 0)
+	hello = append(hello, uint16List(2, 0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030,
+		0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035)...)
+	hello = append(hello, 1, 0)
+	hello = append(hello, binary.BigEndian.AppendUint16(nil, uint16(len(extensions)))...)
+	hello = append(hello, extensions...)
+	return buildHandshakeRecord(0x01, hello)
+}
+
+func buildFingerprintServerHello() []byte {
+	extensions := buildExtension(0xff01, []byte{0})
+	extensions = append(extensions, buildExtension(0x0000, nil)...)
+	extensions = append(extensions, buildExtension(0x000b, []byte{1, 0})...)
+	extensions = append(extensions, buildExtension(0x0010, []byte{0, 3, 2, 'h', '2'})...)
+
+	hello := []byte{0x03, 0x03}
+	hello = append(hello, make([]byte, 32)...)
+	hello = append(hello, 0, 0xc0, 0x2f, 0)
+	hello = append(hello, binary.BigEndian.AppendUint16(nil, uint16(len(extensions)))...)
+	hello = append(hello, extensions...)
+	return buildHandshakeRecord(0x02, hello)
+}
+
+func buildSshKexInit(banner string, kex string, encryption string, mac string, compression string) []byte {
+	payload := []byte{sshMsgKexInit}
+	payload = append(payload, make([]byte, 16)...)
+	for _, list := range []string{kex, "ssh-ed25519", encryption, encryption, mac, mac, compression, compression, "", ""} {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(list)))
+		payload = append(payload, list...)
+	}
+	payload = append(payload, 0, 0, 0, 0, 0)
+
+	packet := []byte(banner + "\r\n")
+	packet = binary.BigEndian.AppendUint32(packet, uint32(len(payload)+5))
+	packet = append(packet, 4)
+	packet = append(packet, payload...)
+	return append(packet, 0, 0, 0, 0)
+}
+
+func TestFingerprintTls(tester *testing.T) {
+	clientHello := buildFingerprintClientHello()
+	hello := parseTlsHello(&tlsReader{data: clientHello[9:]}, true)
+	if assert.NotNil(tester, hello) {
+		fingerprint := ja3(hello)
+		assert.Equal(tester, model.FingerprintTypeJa3, fingerprint.Type)
+		assert.Equal(tester, "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,"+
+			"0-23-65281-10-11-35-16-5-13-18-51-45-43-27-21-17513,29-23-24,0", fingerprint.Raw)
+		assert.Equal(tester, "50a0e1f8c13ee9e5521e3f374a63a021", fingerprint.Value)
+
+		fingerprint = ja4(hello)
+		assert.Equal(tester, model.FingerprintTypeJa4, fingerprint.Type)
+		assert.Equal(tester, "t13d1516h2_8daaf6152771_e5627efa2ab1", fingerprint.Value)
+		assert.Equal(tester, "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_"+
+			"0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_"+
+			"0403,0804,0401,0503,0805,0501,0806,0601", fingerprint.Raw)
+	}
+
+	serverHello := buildFingerprintServerHello()
+	hello = parseTlsHello(&tlsReader{data: serverHello[9:]}, false)
+	if assert.NotNil(tester, hello) {
+		fingerprint := ja3s(hello)
+		assert.Equal(tester, model.FingerprintTypeJa3s, fingerprint.Type)
+		assert.Equal(tester, "771,49199,65281-0-11-16", fingerprint.Raw)
+		assert.Equal(tester, "ae53107a2e47ea20c72ac44821a728bf", fingerprint.Value)
+	}
+}
+
+func TestJa4Alpn(tester *testing.T) {
+	assert.Equal(tester, "00", ja4Alpn(nil))
+	assert.Equal(tester, "00", ja4Alpn([]string{""}))
+	assert.Equal(tester, "h2", ja4Alpn([]string{"h2", "http/1.1"}))
+	assert.Equal(tester, "h1", ja4Alpn([]string{"http/1.1"}))
+	assert.Equal(tester, "6f", ja4Alpn([]string{"a\xff"}))
+	assert.Equal(tester, "00", ja4Version(0x9999))
+}
+
+func TestFingerprintSsh(tester *testing.T) {
+	kexInit, isSsh := sshKexInit(buildSshKexInit("SSH-2.0-OpenSSH_9.6", "curve25519-sha256,ecdh-sha2-nistp256",
+		"aes128-ctr,chacha20-poly1305@openssh.com", "hmac-sha2-256", "none"))
+	if assert.True(tester, isSsh) && assert.NotNil(tester, kexInit) {
+		fingerprint := hassh(kexInit, true)
+		assert.Equal(tester, model.FingerprintTypeHassh, fingerprint.Type)
+		assert.Equal(tester, "curve25519-sha256,ecdh-sha2-nistp256;aes128-ctr,chacha20-poly1305@openssh.com;hmac-sha2-256;none", fingerprint.Raw)
+		assert.Equal(tester, "990cad29b8201cd4b0ecceeb8770587c", fingerprint.Value)
+	}
+
+	kexInit, isSsh = sshKexInit([]byte("SSH-2.0-OpenSSH_9.6\r\n\x00\x00"))
+	assert.True(tester, isSsh)
+	assert.Nil(tester, kexInit)
+
+	_, isSsh = sshKexInit([]byte("SSH-2.0-OpenSSH_9.6\r\n\x00\x01\x00\x00\x04"))
+	assert.False(tester, isSsh)
+	_, isSsh = sshKexInit([]byte("SSH-" + strings.Repeat("x", 300)))
+	assert.False(tester, isSsh)
+}
+
+func TestTlsHandshakeMessage(tester *testing.T) {
+	record := buildFingerprintServerHello()
+	message, isTls := tlsHandshakeMessage(record)
+	assert.True(tester, isTls)
+	assert.Equal(tester, record[5:], message)
+
+	message, isTls = tlsHandshakeMessage(record[:20])
+	assert.True(tester, isTls)
+	assert.Nil(tester, message)
+
+	// The handshake message is fragmented across two records
+	fragmented := append([]byte{0x16, 0x03, 0x03, 0, 10}, record[5:15]...)
+	fragmented = append(fragmented, 0x16, 0x03, 0x03, 0, byte(len(record)-15))
+	fragmented = append(fragmented, record[15:]...)
+	message, isTls = tlsHandshakeMessage(fragmented)
+	assert.True(tester, isTls)
+	assert.Equal(tester, record[5:], message)
+
+	_, isTls = tlsHandshakeMessage([]byte{0x17, 0x03, 0x03, 0, 1, 0})
+	assert.False(tester, isTls)
+}
+
+func TestParsePcapFingerprints(tester *testing.T) {
+	clientHello := buildFingerprintClientHello()
+	serverHello := buildFingerprintServerHello()
+	split := 100
+	segments := append(handshake(),
+		testSegment{fromClient: true, serverPort: 443, seq: 1001, ack: 5001, flags: "A", payload: string(clientHello[:split])},
+		testSegment{fromClient: true, serverPort: 443, seq: 1001 + uint32(split), ack: 5001, flags: "A", payload: string(clientHello[split:])},
+		testSegment{fromClient: false, serverPort: 443, seq: 5001, ack: 1001 + uint32(len(clientHello)), flags: "A", payload: string(serverHello)},
+	)
+	for idx := range segments[:3] {
+		segments[idx].serverPort = 443
+	}
+	filename := filepath.Join(tester.TempDir(), "tls.bin")
+	assert.NoError(tester, os.WriteFile(filename, buildMixedPcapNg(tester, segments...), 0600))
+
+	packets, err := ParsePcap(filename, 3, 10, false)
+	if assert.NoError(tester, err) && assert.Len(tester, packets, 3) {
+		// The fingerprint belongs to the segment completing the ClientHello
+		assert.Empty(tester, packets[0].Fingerprints)
+		if assert.Len(tester, packets[1].Fingerprints, 2) {
+			assert.Equal(tester, model.FingerprintTypeJa3, packets[1].Fingerprints[0].Type)
+			assert.Equal(tester, "50a0e1f8c13ee9e5521e3f374a63a021", packets[1].Fingerprints[0].Value)
+			assert.Equal(tester, "t13d1516h2_8daaf6152771_e5627efa2ab1", packets[1].Fingerprints[1].Value)
+		}
+		if assert.Len(tester, packets[2].Fingerprints, 1) {
+			assert.Equal(tester, model.FingerprintTypeJa3s, packets[2].Fingerprints[0].Type)
+		}
+	}
+}
+
+func TestComputeFingerprints(tester *testing.T) {
+	clientHello := string(buildFingerprintClientHello())
+	clientKex := string(buildSshKexInit("SSH-2.0-OpenSSH_9.6", "curve25519-sha256,ecdh-sha2-nistp256",
+		"aes128-ctr,chacha20-poly1305@openssh.com", "hmac-sha2-256", "none"))
+	serverKex := string(buildSshKexInit("SSH-2.0-OpenSSH_8.0", "curve25519-sha256,ecdh-sha2-nistp256",
+		"aes256-gcm@openssh.com", "hmac-sha2-512", "zlib@openssh.com"))
+	segments := []testSegment{
+		{fromClient: true, clientPort: 40000, serverPort: 443, seq: 1, flags: "A", payload: clientHello},
+		{fromClient: true, clientPort: 40001, serverPort: 443, seq: 1, flags: "A", payload: clientHello},
+		// A retransmission is not fingerprinted again
+		{fromClient: true, clientPort: 40001, serverPort: 443, seq: 1, flags: "A", payload: clientHello},
+		// SSH without a captured handshake, with the server identified by its port
+		{fromClient: false, clientPort: 40002, serverPort: 22, seq: 1, flags: "A", payload: serverKex},
+		{fromClient: true, clientPort: 40002, serverPort: 22, seq: 1, flags: "A", payload: clientKex[:30]},
+		{fromClient: true, clientPort: 40002, serverPort: 22, seq: 31, flags: "A", payload: clientKex[30:]},
+		// Not a handshake
+		{fromClient: true, clientPort: 40003, seq: 1, flags: "A", payload: "GET / HTTP/1.1\r\n\r\n"},
+	}
+
+	summary, err := ComputeFingerprints(buildConversation(tester, segments...), false)
+	if assert.NoError(tester, err) && assert.Len(tester, summary.Fingerprints, 4) {
+		entry := summary.Fingerprints[0]
+		assert.Equal(tester, model.FingerprintTypeJa3, entry.Type)
+		assert.Equal(tester, 2, entry.Count)
+		assert.Equal(tester, []string{"10.0.0.1"}, entry.ClientIps)
+		assert.Equal(tester, []string{"10.0.0.2"}, entry.ServerIps)
+		assert.Equal(tester, []string{"www.example.com"}, entry.ServerNames)
+		assert.Equal(tester, `tls.client.ja3:"50a0e1f8c13ee9e5521e3f374a63a021"`, entry.Query)
+		assert.Equal(tester, 0, entry.FirstSeen.Second())
+		assert.Equal(tester, 1, entry.LastSeen.Second())
+
+		assert.Equal(tester, model.FingerprintTypeJa4, summary.Fingerprints[1].Type)
+		assert.Equal(tester, `tls.client.ja4:"t13d1516h2_8daaf6152771_e5627efa2ab1"`, summary.Fingerprints[1].Query)
+
+		entry = summary.Fingerprints[2]
+		assert.Equal(tester, model.FingerprintTypeHasshServer, entry.Type)
+		assert.Equal(tester, "702343d79e93ca97f74a97e80d952b26", entry.Value)
+		assert.Equal(tester, []string{"10.0.0.2"}, entry.ServerIps)
+		assert.Nil(tester, entry.ServerNames)
+
+		entry = summary.Fingerprints[3]
+		assert.Equal(tester, model.FingerprintTypeHassh, entry.Type)
+		assert.Equal(tester, "990cad29b8201cd4b0ecceeb8770587c", entry.Value)
+		assert.Equal(tester, []string{"10.0.0.1"}, entry.ClientIps)
+		assert.Equal(tester, `ssh.hassh:"990cad29b8201cd4b0ecceeb8770587c"`, entry.Query)
+	}
+
+	_, err = ComputeFingerprints(bytes.NewReader([]byte("invalid")), false)
+	assert.Error(tester, err)
+}
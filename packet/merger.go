@@ -0,0 +1,189 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+
+	"github.com/apex/log"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+type mergeSource struct {
+	reader *captureReader
+	data   []byte
+	ci     gopacket.CaptureInfo
+}
+
+// next advances the source to its following packet. Returns false once the source is
+// exhausted or unreadable.
+func (source *mergeSource) next() bool {
+	data, ci, err := source.reader.ReadPacketData()
+	if err != nil {
+		if err != io.EOF {
+			log.WithError(err).Warn("Stopped reading truncated PCAP stream during merge")
+		}
+		return false
+	}
+	source.data = data
+	source.ci = ci
+	return true
+}
+
+type mergeQueue []*mergeSource
+
+func (queue mergeQueue) Len() int { return len(queue) }
+
+func (queue mergeQueue) Less(i, j int) bool {
+	return queue[i].ci.Timestamp.Before(queue[j].ci.Timestamp)
+}
+
+func (queue mergeQueue) Swap(i, j int) { queue[i], queue[j] = queue[j], queue[i] }
+
+func (queue *mergeQueue) Push(x any) { *queue = append(*queue, x.(*mergeSource)) }
+
+func (queue *mergeQueue) Pop() any {
+	old := *queue
+	source := old[len(old)-1]
+	*queue = old[:len(old)-1]
+	return source
+}
+
+// MergePcaps writes the packets from all of the given PCAP streams to the writer as a
+// single PCAP stream, ordered by timestamp. Each input is expected to already be in
+// time order. Unreadable inputs are skipped. Inputs may be classic PCAP or pcapng; the
+// output is classic PCAP when every input is classic PCAP sharing one link type, and
+// otherwise pcapng. Returns the number of packets written.
+func MergePcaps(writer io.Writer, readers []io.Reader) (int, error) {
+	queue := make(mergeQueue, 0, len(readers))
+	linkType := layers.LinkTypeEthernet
+	var snaplen uint32
+	classic := 0
+	pcapNg := false
+
+	for _, reader := range readers {
+		capture, err := newCaptureReader(reader)
+		if err != nil {
+			log.WithError(err).Warn("Skipping unreadable PCAP stream during merge")
+			continue
+		}
+		if capture.isPcapNg() {
+			pcapNg = true
+		} else {
+			if classic == 0 {
+				linkType = capture.pcap.LinkType()
+			} else if capture.pcap.LinkType() != linkType {
+				pcapNg = true
+			}
+			classic++
+			if capture.pcap.Snaplen() > snaplen {
+				snaplen = capture.pcap.Snaplen()
+			}
+		}
+		source := &mergeSource{reader: capture}
+		if source.next() {
+			queue = append(queue, source)
+		}
+	}
+
+	if snaplen == 0 {
+		snaplen = 65536
+	}
+
+	var writePacket func(source *mergeSource) error
+	var closeOutput func() error
+	if pcapNg {
+		ngOutput := newPcapNgOutput(writer, nil)
+		writePacket = func(source *mergeSource) error {
+			return ngOutput.writePacket(source.reader.ngInterface(source.ci), source.ci, source.data)
+		}
+		closeOutput = ngOutput.close
+	} else {
+		pcapWriter := pcapgo.NewWriter(writer)
+		if err := pcapWriter.WriteFileHeader(snaplen, linkType); err != nil {
+			return 0, err
+		}
+		writePacket = func(source *mergeSource) error {
+			return pcapWriter.WritePacket(source.ci, source.data)
+		}
+		closeOutput = func() error { return nil }
+	}
+
+	var err error
+	count := 0
+	heap.Init(&queue)
+	for err == nil && queue.Len() > 0 {
+		source := queue[0]
+		err = writePacket(source)
+		if err == nil {
+			count++
+			if source.next() {
+				heap.Fix(&queue, 0)
+			} else {
+				heap.Pop(&queue)
+			}
+		}
+	}
+
+	if err == nil {
+		err = closeOutput()
+	}
+	return count, err
+}
+
+// MergePcapFiles merges the given PCAP files into the output file, replacing it only
+// once the merge succeeds. Missing input files are skipped. Returns the size of the
+// merged file in bytes.
+func MergePcapFiles(outputFilename string, filenames []string) (int64, error) {
+	return (&StreamCodec{}).MergeFiles(outputFilename, filenames)
+}
+
+// MergeFiles merges the given stored streams into the output stream, replacing it only
+// once the merge succeeds. Missing input streams are skipped. Returns the plain size of
+// the merged stream in bytes.
+func (codec *StreamCodec) MergeFiles(outputFilename string, filenames []string) (int64, error) {
+	readers := make([]io.Reader, 0, len(filenames))
+	for _, filename := range filenames {
+		reader, _, err := codec.OpenFile(filename)
+		if err != nil {
+			log.WithError(err).WithField("filename", filename).Warn("Skipping missing PCAP file during merge")
+			continue
+		}
+		defer reader.Close()
+		readers = append(readers, reader)
+	}
+
+	if len(readers) == 0 {
+		return 0, errors.New("No PCAP files available to merge")
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	var count int
+	go func() {
+		var err error
+		count, err = MergePcaps(pipeWriter, readers)
+		pipeWriter.CloseWithError(err)
+	}()
+
+	size, err := codec.WriteFile(outputFilename, pipeReader)
+	pipeReader.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	log.WithFields(log.Fields{
+		"outputFilename": outputFilename,
+		"inputCount":     len(readers),
+		"packetCount":    count,
+		"size":           size,
+	}).Info("Merged PCAP files")
+
+	return size, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildPcap(tester *testing.T, seconds ...int) []byte {
+	var buf bytes.Buffer
+	writer := pcapgo.NewWriter(&buf)
+	assert.NoError(tester, writer.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	for _, second := range seconds {
+		data := []byte{byte(second), 1, 2, 3}
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(int64(second), 0),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		assert.NoError(tester, writer.WritePacket(ci, data))
+	}
+	return buf.Bytes()
+}
+
+func readTimestamps(tester *testing.T, reader io.Reader) []int64 {
+	pcapReader, err := pcapgo.NewReader(reader)
+	assert.NoError(tester, err)
+	timestamps := make([]int64, 0)
+	for {
+		_, ci, err := pcapReader.ReadPacketData()
+		if err != nil {
+			break
+		}
+		timestamps = append(timestamps, ci.Timestamp.Unix())
+	}
+	return timestamps
+}
+
+func TestMergePcaps(tester *testing.T) {
+	readers := []io.Reader{
+		bytes.NewReader(buildPcap(tester, 1, 4, 7)),
+		bytes.NewReader([]byte("garbage")),
+		bytes.NewReader(buildPcap(tester, 2, 3, 9)),
+		bytes.NewReader(buildPcap(tester)),
+	}
+
+	var output bytes.Buffer
+	count, err := MergePcaps(&output, readers)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 6, count)
+	assert.Equal(tester, []int64{1, 2, 3, 4, 7, 9}, readTimestamps(tester, &output))
+}
+
+func TestMergePcapFiles(tester *testing.T) {
+	dir := tester.TempDir()
+	first := filepath.Join(dir, "1.bin")
+	second := filepath.Join(dir, "2.bin")
+	os.WriteFile(first, buildPcap(tester, 5, 6), 0644)
+	os.WriteFile(second, buildPcap(tester, 1), 0644)
+	output := filepath.Join(dir, "merged.bin")
+
+	size, err := MergePcapFiles(output, []string{first, filepath.Join(dir, "missing.bin"), second})
+	assert.NoError(tester, err)
+	assert.Equal(tester, int64(24+3*(16+4)), size)
+
+	file, err := os.Open(output)
+	if assert.NoError(tester, err) {
+		defer file.Close()
+		assert.Equal(tester, []int64{1, 5, 6}, readTimestamps(tester, file))
+	}
+	leftovers, _ := filepath.Glob(output + ".*")
+	assert.Empty(tester, leftovers)
+
+	_, err = MergePcapFiles(output, []string{filepath.Join(dir, "missing.bin")})
+	assert.EqualError(tester, err, "No PCAP files available to merge")
+}
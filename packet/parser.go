@@ -8,8 +8,8 @@ package packet
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
-	"fmt"
 	"io"
 	"os"
 
@@ -17,7 +17,6 @@ import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
-	"github.com/google/gopacket/pcapgo"
 	"github.com/security-onion-solutions/securityonion-soc/model"
 )
 
@@ -32,38 +31,112 @@ var SupportedLayerTypes = [...]gopacket.LayerType{
 	layers.LayerTypeTLS,
 }
 
+// ParsePcap decodes count packets of the PCAP file, beginning at the given offset. The
+// packets before the offset are still followed so that handshakes completed within the
+// requested packets can be fingerprinted.
 func ParsePcap(filename string, offset int, count int, unwrap bool) ([]*model.Packet, error) {
-	packets := make([]*model.Packet, 0)
-	parsePcapFile(filename, "", func(index int, pcapPacket gopacket.Packet) bool {
-		if index >= offset {
-			packet := model.NewPacket(index)
-			parseData(pcapPacket, packet, unwrap)
-			packets = append(packets, packet)
+	page := newPacketPage(offset, count, unwrap)
+	parsePcapFile(filename, "", page.add)
+	return page.packets, nil
+}
+
+// ParsePcapStream is ParsePcap for a PCAP or pcapng stream, such as the decoded contents
+// of a stored packet stream, so that the packets never need to be written to disk.
+func ParsePcapStream(reader io.Reader, offset int, count int, unwrap bool) ([]*model.Packet, error) {
+	capture, err := newCaptureReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	page := newPacketPage(offset, count, unwrap)
+	options := gopacket.DecodeOptions{Lazy: true, NoCopy: true, SkipDecodeRecovery: true}
+	for index := 0; ; index++ {
+		data, ci, readErr := capture.ReadPacketData()
+		if readErr == io.EOF {
+			break
 		}
-		return len(packets) < count
-	})
-	return packets, nil
+		if readErr != nil {
+			return nil, readErr
+		}
+		if !page.add(index, capture.decode(data, ci, options)) {
+			break
+		}
+	}
+	return page.packets, nil
+}
+
+// packetPage collects a page of parsed packets.
+type packetPage struct {
+	offset  int
+	count   int
+	unwrap  bool
+	fp      *fingerprinter
+	packets []*model.Packet
 }
 
+func newPacketPage(offset int, count int, unwrap bool) *packetPage {
+	return &packetPage{
+		offset:  offset,
+		count:   count,
+		unwrap:  unwrap,
+		fp:      newFingerprinter(),
+		packets: make([]*model.Packet, 0),
+	}
+}
+
+// add parses the packet at the given index if it falls within the page. Returns false
+// once the page is full.
+func (page *packetPage) add(index int, pcapPacket gopacket.Packet) bool {
+	var packet *model.Packet
+	if index >= page.offset {
+		packet = model.NewPacket(index)
+	}
+	if page.unwrap {
+		pcapPacket = unwrapPacket(pcapPacket, packet)
+	}
+	fingerprints := fingerprintPacket(page.fp, pcapPacket)
+	if packet != nil {
+		parseData(pcapPacket, packet, false)
+		packet.Fingerprints = fingerprints
+		page.packets = append(page.packets, packet)
+	}
+	return len(page.packets) < page.count
+}
+
+// ToStream writes the packets to a PCAP stream. Packets captured with differing link
+// types, such as those read from a multi-interface pcapng capture, are written as
+// pcapng so that each keeps its own link type.
 func ToStream(packets []gopacket.Packet) (io.ReadCloser, int, error) {
-	var snaplen uint32 = 65536
 	var full bytes.Buffer
+	if err := WritePackets(&full, packets); err != nil {
+		return nil, 0, err
+	}
+	return io.NopCloser(bytes.NewReader(full.Bytes())), full.Len(), nil
+}
+
+// WritePackets writes the packets to the output in the same format as ToStream, without
+// buffering the whole stream in memory.
+func WritePackets(output io.Writer, packets []gopacket.Packet) error {
+	var snaplen uint32 = 65536
 
-	writer := pcapgo.NewWriter(&full)
-	writer.WriteFileHeader(snaplen, layers.LinkTypeEthernet)
+	linkType, mixed := commonLinkType(packets)
+	writer, err := newPacketWriter(output, snaplen, linkType, mixed)
+	if err != nil {
+		return err
+	}
 
 	opts := gopacket.SerializeOptions{}
 
 	buf := gopacket.NewSerializeBuffer()
 	for _, packet := range packets {
 		buf.Clear()
-		err := gopacket.SerializePacket(buf, opts, packet)
+		err = gopacket.SerializePacket(buf, opts, packet)
 		if err != nil {
-			return nil, 0, err
+			return err
 		}
 		writer.WritePacket(packet.Metadata().CaptureInfo, buf.Bytes())
 	}
-	return io.NopCloser(bytes.NewReader(full.Bytes())), full.Len(), nil
+	return writer.Close()
 }
 
 func filterPacket(filter *model.Filter, packet gopacket.Packet) bool {
@@ -76,10 +149,16 @@ func filterPacket(filter *model.Filter, packet gopacket.Packet) bool {
 	return true
 }
 
-func ParseRawPcap(filename string, maxCount int, filter *model.Filter) ([]gopacket.Packet, error) {
+// ParseRawPcap returns the packets in the given file matching the filter. Parsing stops
+// early, returning the context error, if the context is cancelled.
+func ParseRawPcap(ctx context.Context, filename string, maxCount int, filter *model.Filter) ([]gopacket.Packet, error) {
 	packets := make([]gopacket.Packet, 0)
 	currentCount := 0
-	err := parsePcapFile(filename, createBpf(filter), func(index int, pcapPacket gopacket.Packet) bool {
+	err := parsePcapFile(filename, BuildBpf(filter), func(index int, pcapPacket gopacket.Packet) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+
 		if filterPacket(filter, pcapPacket) {
 			packets = append(packets, pcapPacket)
 			currentCount += 1
@@ -88,6 +167,10 @@ func ParseRawPcap(filename string, maxCount int, filter *model.Filter) ([]gopack
 		return currentCount < maxCount
 	})
 
+	if err == nil {
+		err = ctx.Err()
+	}
+
 	if currentCount == maxCount {
 		log.WithFields(log.Fields{
 			"packetCount": len(packets),
@@ -97,47 +180,33 @@ func ParseRawPcap(filename string, maxCount int, filter *model.Filter) ([]gopack
 	return packets, err
 }
 
-func addBpf(bpf string, part string) string {
-	newBpf := bpf
-
-	if len(newBpf) > 0 {
-		newBpf = newBpf + " and "
+// UnwrapPcapStream writes the packets of a PCAP or pcapng stream to the output with their
+// tunnel encapsulation removed, in the same format as the stream.
+func UnwrapPcapStream(reader io.Reader, output io.Writer) error {
+	capture, err := newCaptureReader(reader)
+	if err != nil {
+		return err
 	}
-
-	newBpf = newBpf + part
-
-	return newBpf
-
-}
-
-func createBpf(filter *model.Filter) string {
-	query := filter.Protocol
-
-	if len(filter.SrcIp) > 0 {
-		query = addBpf(query, fmt.Sprintf("host %s", filter.SrcIp))
-	}
-
-	if len(filter.DstIp) > 0 {
-		query = addBpf(query, fmt.Sprintf("host %s", filter.DstIp))
+	writer, err := newPacketWriter(output, 65535, layers.LinkTypeEthernet, capture.isPcapNg())
+	if err != nil {
+		return err
 	}
 
-	// Some legacy jobs won't have the protocol provided
-	if filter.Protocol != model.PROTOCOL_ICMP {
-		if filter.SrcPort > 0 {
-			query = addBpf(query, fmt.Sprintf("port %d", filter.SrcPort))
+	options := gopacket.DecodeOptions{Lazy: true, NoCopy: true, SkipDecodeRecovery: true}
+	for {
+		data, ci, readErr := capture.ReadPacketData()
+		if readErr == io.EOF {
+			break
 		}
-
-		if filter.DstPort > 0 {
-			query = addBpf(query, fmt.Sprintf("port %d", filter.DstPort))
+		if readErr != nil {
+			return readErr
+		}
+		newPacket := unwrapPacket(capture.decode(data, ci, options), nil)
+		if err = writer.WritePacket(newPacket.Metadata().CaptureInfo, newPacket.Data()); err != nil {
+			return err
 		}
 	}
-
-	// Repeat the query but with vlan applied
-	if len(query) > 0 {
-		query = fmt.Sprintf("(%s) or (vlan and %s)", query, query)
-	}
-
-	return query
+	return writer.Close()
 }
 
 func UnwrapPcap(filename string, unwrappedFilename string) bool {
@@ -148,14 +217,13 @@ func UnwrapPcap(filename string, unwrappedFilename string) bool {
 		if err != nil {
 			log.WithError(err).WithField("unwrappedFilename", unwrappedFilename).Error("Unable to create unwrapped file")
 		} else {
-			writer := pcapgo.NewWriter(unwrappedFile)
-			err = writer.WriteFileHeader(65535, layers.LinkTypeEthernet)
+			writer, err := newPacketWriter(unwrappedFile, 65535, layers.LinkTypeEthernet, IsPcapNgFile(filename))
 			if err != nil {
 				log.WithError(err).WithField("unwrappedFilename", unwrappedFilename).Error("Unable to write unwrapped file header")
 			} else {
 				defer unwrappedFile.Close()
 				err = parsePcapFile(filename, "", func(index int, pcapPacket gopacket.Packet) bool {
-					newPacket := unwrapVxlanPacket(pcapPacket, nil)
+					newPacket := unwrapPacket(pcapPacket, nil)
 					err = writer.WritePacket(newPacket.Metadata().CaptureInfo, newPacket.Data())
 					if err != nil {
 						log.WithError(err).WithFields(log.Fields{
@@ -166,6 +234,9 @@ func UnwrapPcap(filename string, unwrappedFilename string) bool {
 					}
 					return true
 				})
+				if err == nil {
+					err = writer.Close()
+				}
 				if err != nil {
 					log.WithError(err).WithField("filename", filename).Error("Unable to parse PCAP into unwrapped PCAP")
 				} else {
@@ -184,6 +255,10 @@ func UnwrapPcap(filename string, unwrappedFilename string) bool {
 }
 
 func parsePcapFile(filename string, bpf string, handler func(int, gopacket.Packet) bool) error {
+	if IsPcapNgFile(filename) {
+		return parsePcapNgFile(filename, bpf, handler)
+	}
+
 	handle, err := pcap.OpenOffline(filename)
 	if err == nil {
 		defer handle.Close()
@@ -217,28 +292,9 @@ func overrideType(packet *model.Packet, layerType gopacket.LayerType) {
 	}
 }
 
-func unwrapVxlanPacket(pcapPacket gopacket.Packet, packet *model.Packet) gopacket.Packet {
-	vxlan := pcapPacket.Layer(layers.LayerTypeVXLAN)
-	if vxlan != nil {
-		vxlan, _ := vxlan.(*layers.VXLAN)
-		if vxlan.Payload != nil && len(vxlan.Payload) > 0 {
-			oldData := pcapPacket.Metadata()
-			pcapPacket = gopacket.NewPacket(vxlan.Payload, layers.LayerTypeEthernet, gopacket.Default)
-			newData := pcapPacket.Metadata()
-			newData.Timestamp = oldData.Timestamp
-			newData.CaptureLength = len(vxlan.Payload)
-			newData.Length = newData.CaptureLength
-			if packet != nil {
-				packet.Flags = append(packet.Flags, "VXLAN")
-			}
-		}
-	}
-	return pcapPacket
-}
-
 func parseData(pcapPacket gopacket.Packet, packet *model.Packet, unwrap bool) {
 	if unwrap {
-		pcapPacket = unwrapVxlanPacket(pcapPacket, packet)
+		pcapPacket = unwrapPacket(pcapPacket, packet)
 	}
 
 	packet.Timestamp = pcapPacket.Metadata().Timestamp
@@ -321,4 +377,5 @@ func parseData(pcapPacket gopacket.Packet, packet *model.Packet, unwrap bool) {
 	if appLayer != nil {
 		packet.PayloadOffset = len(pcapPacket.Data()) - len(appLayer.Payload())
 	}
+	packet.Application = dissectApplication(pcapPacket)
 }
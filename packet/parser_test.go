@@ -7,6 +7,7 @@
 package packet
 
 import (
+	"context"
 	"os"
 	"testing"
 	"time"
@@ -49,7 +50,7 @@ func TestParseAndStream(tester *testing.T) {
 	filter.DstIp = "176.126.243.198"
 	filter.DstPort = 34515
 
-	packets, perr := ParseRawPcap(path, 999, filter)
+	packets, perr := ParseRawPcap(context.Background(), path, 999, filter)
 	assert.Nil(tester, perr)
 	assert.Len(tester, packets, 22)
 
@@ -75,7 +76,7 @@ func TestParseWrongProtocol(tester *testing.T) {
 	filter.SrcIp = "185.47.63.113"
 	filter.DstIp = "176.126.243.198"
 
-	packets, perr := ParseRawPcap(path, 999, filter)
+	packets, perr := ParseRawPcap(context.Background(), path, 999, filter)
 	assert.Nil(tester, perr)
 	assert.Len(tester, packets, 0)
 }
@@ -84,10 +85,21 @@ func TestParseAndStreamFail(tester *testing.T) {
 	path := "test_resources/so-pcap.nonexistent"
 	filter := model.NewFilter()
 
-	_, perr := ParseRawPcap(path, 999, filter)
+	_, perr := ParseRawPcap(context.Background(), path, 999, filter)
 	assert.ErrorContains(tester, perr, "No such file")
 }
 
+func TestParseRawPcapCancelled(tester *testing.T) {
+	path := "test_resources/so-pcap.1575817346"
+	filter := model.NewFilter()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	packets, perr := ParseRawPcap(ctx, path, 999, filter)
+	assert.ErrorIs(tester, perr, context.Canceled)
+	assert.Len(tester, packets, 0)
+}
+
 func TestParseAndStreamIcmp(tester *testing.T) {
 	path := "test_resources/icmp.pcap"
 	filter := model.NewFilter()
@@ -101,7 +113,7 @@ func TestParseAndStreamIcmp(tester *testing.T) {
 	filter.DstIp = "192.168.10.128"
 	filter.DstPort = 34515 // will be ignored since Protocol = ICMP
 
-	packets, perr := ParseRawPcap(path, 999, filter)
+	packets, perr := ParseRawPcap(context.Background(), path, 999, filter)
 	assert.Nil(tester, perr)
 	assert.Len(tester, packets, 2)
 
@@ -115,20 +127,3 @@ func TestParseAndStreamIcmp(tester *testing.T) {
 	assert.Equal(tester, pcap_length, count)
 	assert.Equal(tester, pcap_length, size)
 }
-
-func TestCreateBpf(tester *testing.T) {
-	filter := model.NewFilter()
-	startTime, _ := time.Parse(time.RFC3339, "2024-02-12T00:00:00Z")
-	filter.BeginTime = startTime
-	endTime, _ := time.Parse(time.RFC3339, "2024-02-12T23:59:59Z")
-	filter.EndTime = endTime
-	filter.Protocol = model.PROTOCOL_ICMP
-	filter.SrcIp = "90.151.225.16"
-	filter.SrcPort = 19 // will be ignored since Protocol = ICMP
-	filter.DstIp = "192.168.10.128"
-	filter.DstPort = 34515 // will be ignored since Protocol = ICMP
-
-	actual := createBpf(filter)
-	expected := "(icmp and host 90.151.225.16 and host 192.168.10.128) or (vlan and icmp and host 90.151.225.16 and host 192.168.10.128)"
-	assert.Equal(tester, expected, actual)
-}
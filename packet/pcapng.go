@@ -0,0 +1,388 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+const PcapFormatPcap = "pcap"
+const PcapFormatPcapNg = "pcapng"
+
+// Section header block type, which is also the first four bytes of every pcapng file
+// regardless of byte order.
+var pcapNgMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// IsPcapNg reports whether the given leading bytes of a capture are in pcapng format.
+func IsPcapNg(header []byte) bool {
+	return bytes.HasPrefix(header, pcapNgMagic)
+}
+
+// IsPcapNgFile reports whether the given capture file is in pcapng format.
+func IsPcapNgFile(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, len(pcapNgMagic))
+	_, err = io.ReadFull(file, header)
+	return err == nil && IsPcapNg(header)
+}
+
+// captureReader reads packets from either a classic PCAP or a pcapng stream. Packets
+// from pcapng captures keep the link type of the interface they were captured on, so
+// captures mixing several link types decode correctly.
+type captureReader struct {
+	pcap *pcapgo.Reader
+	ng   *pcapgo.NgReader
+}
+
+func newCaptureReader(reader io.Reader) (*captureReader, error) {
+	buffered := bufio.NewReader(reader)
+	header, err := buffered.Peek(len(pcapNgMagic))
+	if err != nil {
+		return nil, err
+	}
+
+	capture := &captureReader{}
+	if IsPcapNg(header) {
+		capture.ng, err = pcapgo.NewNgReader(buffered, pcapgo.NgReaderOptions{
+			WantMixedLinkType:  true,
+			SkipUnknownVersion: true,
+		})
+	} else {
+		capture.pcap, err = pcapgo.NewReader(buffered)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return capture, nil
+}
+
+func (capture *captureReader) isPcapNg() bool {
+	return capture.ng != nil
+}
+
+func (capture *captureReader) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if capture.ng != nil {
+		return capture.ng.ReadPacketData()
+	}
+	return capture.pcap.ReadPacketData()
+}
+
+// linkType returns the link type of the given packet read from this capture.
+func (capture *captureReader) linkType(ci gopacket.CaptureInfo) layers.LinkType {
+	if capture.ng != nil {
+		return packetLinkType(ci)
+	}
+	return capture.pcap.LinkType()
+}
+
+// snaplen returns the snapshot length of the given packet's interface, or zero if
+// unknown or unlimited.
+func (capture *captureReader) snaplen(ci gopacket.CaptureInfo) uint32 {
+	if capture.ng != nil {
+		intf, err := capture.ng.Interface(ci.InterfaceIndex)
+		if err != nil {
+			return 0
+		}
+		return intf.SnapLength
+	}
+	return capture.pcap.Snaplen()
+}
+
+// ngInterface describes the given packet's interface for pcapng output.
+func (capture *captureReader) ngInterface(ci gopacket.CaptureInfo) pcapgo.NgInterface {
+	if capture.ng != nil {
+		intf, err := capture.ng.Interface(ci.InterfaceIndex)
+		if err == nil {
+			return pcapgo.NgInterface{
+				Name:        intf.Name,
+				Description: intf.Description,
+				Filter:      intf.Filter,
+				OS:          intf.OS,
+				LinkType:    intf.LinkType,
+				SnapLength:  intf.SnapLength,
+			}
+		}
+	}
+	return pcapgo.NgInterface{
+		LinkType:   capture.linkType(ci),
+		SnapLength: capture.snaplen(ci),
+	}
+}
+
+// decode parses the packet data using the link type it was captured with.
+func (capture *captureReader) decode(data []byte, ci gopacket.CaptureInfo, options gopacket.DecodeOptions) gopacket.Packet {
+	pcapPacket := gopacket.NewPacket(data, capture.linkType(ci), options)
+	metadata := pcapPacket.Metadata()
+	metadata.CaptureInfo = ci
+	metadata.Truncated = metadata.Truncated || ci.CaptureLength < ci.Length
+	return pcapPacket
+}
+
+// packetLinkType returns the link type recorded with a packet read from a pcapng
+// capture, defaulting to Ethernet.
+func packetLinkType(ci gopacket.CaptureInfo) layers.LinkType {
+	if len(ci.AncillaryData) > 0 {
+		if linkType, ok := ci.AncillaryData[0].(layers.LinkType); ok {
+			return linkType
+		}
+	}
+	return layers.LinkTypeEthernet
+}
+
+// parsePcapNgFile reads every packet in a pcapng file, handing those matching the
+// optional BPF to the handler until it returns false. The BPF is compiled separately
+// for each link type present in the capture.
+func parsePcapNgFile(filename string, bpf string, handler func(int, gopacket.Packet) bool) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	capture, err := newCaptureReader(file)
+	if err != nil {
+		return err
+	}
+
+	options := gopacket.DecodeOptions{Lazy: true, NoCopy: true, SkipDecodeRecovery: true}
+	filters := make(map[layers.LinkType]*pcap.BPF)
+	index := 0
+	for {
+		data, ci, readErr := capture.ReadPacketData()
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+
+		if bpf != "" {
+			linkType := capture.linkType(ci)
+			filter, exists := filters[linkType]
+			if !exists {
+				filter, err = pcap.NewBPF(linkType, 65535, bpf)
+				if err != nil {
+					log.WithError(err).WithField("pcapBpf", bpf).Error("Invalid BPF")
+					return err
+				}
+				filters[linkType] = filter
+			}
+			if !filter.Matches(ci, data) {
+				continue
+			}
+		}
+
+		if !handler(index, capture.decode(data, ci, options)) {
+			break
+		}
+		index++
+	}
+	return nil
+}
+
+// pcapNgOutput writes packets to a pcapng stream, adding an interface description for
+// each distinct interface or link type as it is first encountered.
+type pcapNgOutput struct {
+	output     io.Writer
+	options    pcapgo.NgWriterOptions
+	writer     *pcapgo.NgWriter
+	interfaces map[string]int
+}
+
+func newPcapNgOutput(output io.Writer, comments []string) *pcapNgOutput {
+	options := pcapgo.NgWriterOptions{
+		SectionInfo: pcapgo.NgSectionInfo{
+			Application: "Security Onion",
+			Comment:     strings.Join(comments, "\n"),
+		},
+	}
+	return &pcapNgOutput{
+		output:     output,
+		options:    options,
+		interfaces: make(map[string]int),
+	}
+}
+
+func (ng *pcapNgOutput) addInterface(intf pcapgo.NgInterface) (int, error) {
+	key := fmt.Sprintf("%d|%s|%s", intf.LinkType, intf.Name, intf.Description)
+	if id, exists := ng.interfaces[key]; exists {
+		return id, nil
+	}
+
+	// Output timestamps are always written in nanoseconds
+	intf.TimestampResolution = 9
+	intf.TimestampOffset = 0
+	if intf.Name == "" {
+		intf.Name = fmt.Sprintf("intf%d", len(ng.interfaces))
+	}
+
+	var id int
+	var err error
+	if ng.writer == nil {
+		ng.writer, err = pcapgo.NewNgWriterInterface(ng.output, intf, ng.options)
+	} else {
+		id, err = ng.writer.AddInterface(intf)
+	}
+	if err == nil {
+		ng.interfaces[key] = id
+	}
+	return id, err
+}
+
+func (ng *pcapNgOutput) writePacket(intf pcapgo.NgInterface, ci gopacket.CaptureInfo, data []byte) error {
+	id, err := ng.addInterface(intf)
+	if err == nil {
+		ci.InterfaceIndex = id
+		ci.AncillaryData = nil
+		err = ng.writer.WritePacket(ci, data)
+	}
+	return err
+}
+
+// close flushes the output, first writing an Ethernet interface if no packets were
+// written so the result is still a valid capture.
+func (ng *pcapNgOutput) close() error {
+	if ng.writer == nil {
+		if _, err := ng.addInterface(pcapgo.NgInterface{LinkType: layers.LinkTypeEthernet}); err != nil {
+			return err
+		}
+	}
+	return ng.writer.Flush()
+}
+
+// packetWriter writes packets as classic PCAP with a single link type, or as pcapng
+// with an interface for each link type encountered.
+type packetWriter struct {
+	pcap *pcapgo.Writer
+	ng   *pcapNgOutput
+}
+
+func newPacketWriter(output io.Writer, snaplen uint32, linkType layers.LinkType, pcapNg bool) (*packetWriter, error) {
+	writer := &packetWriter{}
+	if pcapNg {
+		writer.ng = newPcapNgOutput(output, nil)
+		return writer, nil
+	}
+	writer.pcap = pcapgo.NewWriter(output)
+	return writer, writer.pcap.WriteFileHeader(snaplen, linkType)
+}
+
+func (writer *packetWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	if writer.ng != nil {
+		return writer.ng.writePacket(pcapgo.NgInterface{LinkType: packetLinkType(ci)}, ci, data)
+	}
+	return writer.pcap.WritePacket(ci, data)
+}
+
+func (writer *packetWriter) Close() error {
+	if writer.ng != nil {
+		return writer.ng.close()
+	}
+	return nil
+}
+
+// commonLinkType returns the link type shared by all of the packets, and whether the
+// packets have differing link types.
+func commonLinkType(packets []gopacket.Packet) (layers.LinkType, bool) {
+	linkType := layers.LinkTypeEthernet
+	for index, packet := range packets {
+		packetType := packetLinkType(packet.Metadata().CaptureInfo)
+		if index == 0 {
+			linkType = packetType
+		} else if packetType != linkType {
+			return linkType, true
+		}
+	}
+	return linkType, false
+}
+
+// ConvertToPcapNg copies every packet of the given classic PCAP or pcapng stream to
+// the writer in pcapng format, keeping each packet's interface and link type. The
+// comments are embedded in the section header. Returns the number of packets written.
+func ConvertToPcapNg(writer io.Writer, reader io.Reader, comments []string) (int, error) {
+	capture, err := newCaptureReader(reader)
+	if err != nil {
+		return 0, err
+	}
+
+	output := newPcapNgOutput(writer, comments)
+	count := 0
+	for {
+		data, ci, readErr := capture.ReadPacketData()
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return count, readErr
+		}
+		if err = output.writePacket(capture.ngInterface(ci), ci, data); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, output.close()
+}
+
+// NewPcapNgReader returns a reader producing the given capture stream in pcapng format,
+// converting it as it is read. The source is closed once the conversion finishes.
+func NewPcapNgReader(source io.ReadCloser, comments []string) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := ConvertToPcapNg(pipeWriter, source, comments)
+		source.Close()
+		pipeWriter.CloseWithError(err)
+	}()
+	return pipeReader
+}
+
+// JobCustodyComments describes the origin of a job's packets, for embedding in exported
+// captures as a chain-of-custody record.
+func JobCustodyComments(job *model.Job, exportedBy string) []string {
+	comments := []string{fmt.Sprintf("Job ID: %d", job.Id)}
+	if job.GetNodeId() != "" {
+		comments = append(comments, "Sensor: "+job.GetNodeId())
+	}
+	if job.Filter != nil {
+		if job.Filter.ImportId != "" {
+			comments = append(comments, "Import ID: "+job.Filter.ImportId)
+		}
+		if !job.Filter.BeginTime.IsZero() {
+			comments = append(comments, "Begin Time: "+job.Filter.BeginTime.UTC().Format(time.RFC3339))
+		}
+		if !job.Filter.EndTime.IsZero() {
+			comments = append(comments, "End Time: "+job.Filter.EndTime.UTC().Format(time.RFC3339))
+		}
+		if bpf := BuildBpf(job.Filter); bpf != "" {
+			comments = append(comments, "Filter: "+bpf)
+		}
+	}
+	if job.UserId != "" {
+		comments = append(comments, "Requested By: "+job.UserId)
+	}
+	if exportedBy != "" {
+		comments = append(comments, "Exported By: "+exportedBy)
+	}
+	return comments
+}
@@ -0,0 +1,265 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// buildMixedPcapNg writes a pcapng capture with an Ethernet interface and a raw IP
+// interface, alternating packets between the two.
+func buildMixedPcapNg(tester *testing.T, segments ...testSegment) []byte {
+	var buf bytes.Buffer
+	writer, err := pcapgo.NewNgWriterInterface(&buf, pcapgo.NgInterface{
+		Name:                "eth0",
+		LinkType:            layers.LinkTypeEthernet,
+		TimestampResolution: 9,
+	}, pcapgo.NgWriterOptions{})
+	assert.NoError(tester, err)
+	_, err = writer.AddInterface(pcapgo.NgInterface{
+		Name:                "tun0",
+		LinkType:            layers.LinkTypeRaw,
+		TimestampResolution: 9,
+	})
+	assert.NoError(tester, err)
+
+	for idx, segment := range segments {
+		if segment.clientPort == 0 {
+			segment.clientPort = 40000
+		}
+		frame := serializeTcp(tester, segment)
+		if idx%2 == 1 {
+			// Strip the Ethernet header and any padding for the raw IP interface
+			frame = frame[14 : 14+int(binary.BigEndian.Uint16(frame[16:18]))]
+		}
+		ci := gopacket.CaptureInfo{
+			Timestamp:      time.Unix(int64(idx), 0),
+			CaptureLength:  len(frame),
+			Length:         len(frame),
+			InterfaceIndex: idx % 2,
+		}
+		assert.NoError(tester, writer.WritePacket(ci, frame))
+	}
+	assert.NoError(tester, writer.Flush())
+	return buf.Bytes()
+}
+
+func mixedSegments() []testSegment {
+	return append(handshake(),
+		testSegment{fromClient: true, seq: testClientIsn + 1, ack: testServerIsn + 1, flags: "A", payload: "GET / HTTP/1.1\r\n\r\n"},
+		testSegment{fromClient: false, seq: testServerIsn + 1, ack: testClientIsn + 19, flags: "A", payload: "HTTP/1.1 200 OK\r\n\r\n"},
+	)
+}
+
+func readPcapNg(tester *testing.T, data []byte) (*pcapgo.NgReader, []gopacket.CaptureInfo) {
+	reader, err := pcapgo.NewNgReader(bytes.NewReader(data), pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	assert.NoError(tester, err)
+	infos := make([]gopacket.CaptureInfo, 0)
+	for {
+		_, ci, err := reader.ReadPacketData()
+		if err != nil {
+			assert.Equal(tester, io.EOF, err)
+			break
+		}
+		infos = append(infos, ci)
+	}
+	return reader, infos
+}
+
+func TestIsPcapNg(tester *testing.T) {
+	assert.True(tester, IsPcapNg(buildMixedPcapNg(tester)))
+	assert.False(tester, IsPcapNg(buildPcap(tester, 1)))
+	assert.False(tester, IsPcapNg([]byte{0x0a}))
+
+	dir := tester.TempDir()
+	filename := filepath.Join(dir, "mixed.bin")
+	assert.NoError(tester, os.WriteFile(filename, buildMixedPcapNg(tester), 0600))
+	assert.True(tester, IsPcapNgFile(filename))
+	assert.False(tester, IsPcapNgFile(filepath.Join(dir, "missing.bin")))
+}
+
+func TestParsePcapNgMixedLinkTypes(tester *testing.T) {
+	filename := filepath.Join(tester.TempDir(), "mixed.bin")
+	assert.NoError(tester, os.WriteFile(filename, buildMixedPcapNg(tester, mixedSegments()...), 0600))
+
+	packets, err := ParsePcap(filename, 0, 10, false)
+	assert.NoError(tester, err)
+	if assert.Len(tester, packets, 5) {
+		// Ethernet and raw IP packets alternate
+		assert.Equal(tester, "00:01:02:03:04:05", packets[0].SrcMac)
+		assert.Equal(tester, "", packets[1].SrcMac)
+		assert.Equal(tester, "10.0.0.2", packets[1].SrcIp)
+		assert.Equal(tester, 80, packets[1].SrcPort)
+		assert.Equal(tester, 40000, packets[1].DstPort)
+
+		data, err := base64.StdEncoding.DecodeString(packets[4].Payload)
+		assert.NoError(tester, err)
+		assert.Equal(tester, "HTTP/1.1 200 OK\r\n\r\n", string(data[packets[4].PayloadOffset:]))
+	}
+}
+
+func TestParsePcapStream(tester *testing.T) {
+	data := buildMixedPcapNg(tester, mixedSegments()...)
+
+	packets, err := ParsePcapStream(bytes.NewReader(data), 1, 2, false)
+	assert.NoError(tester, err)
+	if assert.Len(tester, packets, 2) {
+		assert.Equal(tester, 1, packets[0].Number)
+		assert.Equal(tester, "10.0.0.2", packets[0].SrcIp)
+		assert.Equal(tester, 80, packets[0].SrcPort)
+		assert.Equal(tester, "00:01:02:03:04:05", packets[1].SrcMac)
+	}
+
+	_, err = ParsePcapStream(bytes.NewReader([]byte("nope")), 0, 10, false)
+	assert.Error(tester, err)
+}
+
+func TestReassembleTcpStreamPcapNg(tester *testing.T) {
+	stream, err := ReassembleTcpStream(bytes.NewReader(buildMixedPcapNg(tester, mixedSegments()...)), 0, false, 0)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, []string{"client:GET / HTTP/1.1\r\n\r\n", "server:HTTP/1.1 200 OK\r\n\r\n"}, chunkData(stream))
+	}
+}
+
+func TestConvertToPcapNg(tester *testing.T) {
+	var output bytes.Buffer
+	count, err := ConvertToPcapNg(&output, bytes.NewReader(buildPcap(tester, 1, 2, 3)), []string{"Job ID: 5", "Requested By: analyst"})
+	assert.NoError(tester, err)
+	assert.Equal(tester, 3, count)
+
+	reader, infos := readPcapNg(tester, output.Bytes())
+	assert.Equal(tester, "Job ID: 5\nRequested By: analyst", reader.SectionInfo().Comment)
+	assert.Equal(tester, 1, reader.NInterfaces())
+	intf, _ := reader.Interface(0)
+	assert.Equal(tester, layers.LinkTypeEthernet, intf.LinkType)
+	if assert.Len(tester, infos, 3) {
+		assert.Equal(tester, int64(2), infos[1].Timestamp.Unix())
+	}
+}
+
+func TestConvertToPcapNgKeepsInterfaces(tester *testing.T) {
+	var output bytes.Buffer
+	count, err := ConvertToPcapNg(&output, bytes.NewReader(buildMixedPcapNg(tester, mixedSegments()...)), nil)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 5, count)
+
+	reader, infos := readPcapNg(tester, output.Bytes())
+	assert.Equal(tester, 2, reader.NInterfaces())
+	intf, _ := reader.Interface(1)
+	assert.Equal(tester, "tun0", intf.Name)
+	assert.Equal(tester, layers.LinkTypeRaw, intf.LinkType)
+	if assert.Len(tester, infos, 5) {
+		assert.Equal(tester, 0, infos[0].InterfaceIndex)
+		assert.Equal(tester, 1, infos[1].InterfaceIndex)
+	}
+}
+
+func TestConvertToPcapNgEmpty(tester *testing.T) {
+	var output bytes.Buffer
+	count, err := ConvertToPcapNg(&output, bytes.NewReader(buildPcap(tester)), nil)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 0, count)
+
+	reader, infos := readPcapNg(tester, output.Bytes())
+	assert.Equal(tester, 1, reader.NInterfaces())
+	assert.Empty(tester, infos)
+
+	_, err = ConvertToPcapNg(&output, bytes.NewReader([]byte("bad")), nil)
+	assert.Error(tester, err)
+}
+
+func TestMergePcapsPcapNg(tester *testing.T) {
+	var output bytes.Buffer
+	count, err := MergePcaps(&output, []io.Reader{
+		bytes.NewReader(buildMixedPcapNg(tester, mixedSegments()...)),
+		bytes.NewReader(buildPcap(tester, 2)),
+	})
+	assert.NoError(tester, err)
+	assert.Equal(tester, 6, count)
+
+	assert.True(tester, IsPcapNg(output.Bytes()))
+	// The classic input gets an interface of its own
+	reader, infos := readPcapNg(tester, output.Bytes())
+	assert.Equal(tester, 3, reader.NInterfaces())
+	if assert.Len(tester, infos, 6) {
+		assert.Equal(tester, int64(2), infos[2].Timestamp.Unix())
+		assert.Equal(tester, int64(2), infos[3].Timestamp.Unix())
+	}
+}
+
+func TestToStreamMixedLinkTypes(tester *testing.T) {
+	capture, err := newCaptureReader(bytes.NewReader(buildMixedPcapNg(tester, mixedSegments()...)))
+	assert.NoError(tester, err)
+	packets := make([]gopacket.Packet, 0)
+	for {
+		data, ci, err := capture.ReadPacketData()
+		if err != nil {
+			break
+		}
+		packets = append(packets, capture.decode(data, ci, gopacket.Default))
+	}
+
+	reader, length, err := ToStream(packets)
+	assert.NoError(tester, err)
+	data, _ := io.ReadAll(reader)
+	assert.Len(tester, data, length)
+	assert.True(tester, IsPcapNg(data))
+	ngReader, infos := readPcapNg(tester, data)
+	assert.Equal(tester, 2, ngReader.NInterfaces())
+	assert.Len(tester, infos, 5)
+
+	// A single link type still produces classic PCAP
+	reader, _, err = ToStream(packets[:1])
+	assert.NoError(tester, err)
+	data, _ = io.ReadAll(reader)
+	assert.False(tester, IsPcapNg(data))
+}
+
+func TestUnwrapPcapNg(tester *testing.T) {
+	dir := tester.TempDir()
+	filename := filepath.Join(dir, "mixed.bin")
+	unwrappedFilename := filename + ".unwrapped"
+	assert.NoError(tester, os.WriteFile(filename, buildMixedPcapNg(tester, mixedSegments()...), 0600))
+
+	assert.True(tester, UnwrapPcap(filename, unwrappedFilename))
+	data, err := os.ReadFile(unwrappedFilename)
+	assert.NoError(tester, err)
+	assert.True(tester, IsPcapNg(data))
+	_, infos := readPcapNg(tester, data)
+	assert.Len(tester, infos, 5)
+}
+
+func TestJobCustodyComments(tester *testing.T) {
+	job := model.NewJob()
+	job.Id = 12
+	job.NodeId = "sensor1"
+	job.UserId = "analyst-id"
+	job.Filter.BeginTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	job.Filter.SrcIp = "10.0.0.1"
+	job.Filter.Protocol = "tcp"
+
+	assert.Equal(tester, []string{
+		"Job ID: 12",
+		"Sensor: sensor1",
+		"Begin Time: 2024-01-02T03:04:05Z",
+		"Filter: (tcp and ((src host 10.0.0.1) or (dst host 10.0.0.1))) or (vlan and tcp and ((src host 10.0.0.1) or (dst host 10.0.0.1)))",
+		"Requested By: analyst-id",
+		"Exported By: reviewer@example.com",
+	}, JobCustodyComments(job, "reviewer@example.com"))
+}
@@ -0,0 +1,449 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+type tcpSegment struct {
+	seq       uint32
+	data      []byte
+	timestamp time.Time
+}
+
+// tcpDirection tracks the data sent by one side of a connection. Next is the sequence
+// number of the first byte not yet delivered, and offset is the number of bytes
+// delivered so far, including bytes missing from the capture.
+type tcpDirection struct {
+	name    string
+	started bool
+	next    uint32
+	offset  int
+	finSeen bool
+	finSeq  uint32
+	pending []*tcpSegment
+}
+
+// seqDiff compares sequence numbers, allowing for wraparound. The result is positive if
+// a comes after b.
+func seqDiff(a uint32, b uint32) int {
+	return int(int32(a - b))
+}
+
+// tcpBudget is the number of bytes that may still be retained, shared by all of the
+// connections being reassembled. Unlimited budgets never run out. Pending is the number
+// of out-of-order bytes held until the data before them arrives.
+type tcpBudget struct {
+	limited   bool
+	remaining int
+	pending   int
+}
+
+// canHold reports whether another size bytes may be held pending without exceeding the
+// bytes that may still be retained.
+func (budget *tcpBudget) canHold(size int) bool {
+	return !budget.limited || budget.pending+size <= budget.remaining
+}
+
+func newTcpBudget(maxBytes int) *tcpBudget {
+	return &tcpBudget{
+		limited:   maxBytes > 0,
+		remaining: maxBytes,
+	}
+}
+
+type tcpReassembler struct {
+	stream    *model.TcpStream
+	budget    *tcpBudget
+	clientKey string
+	client    *tcpDirection
+	server    *tcpDirection
+}
+
+func newTcpReassembler(stream *model.TcpStream, budget *tcpBudget) *tcpReassembler {
+	return &tcpReassembler{
+		stream: stream,
+		budget: budget,
+		client: &tcpDirection{name: model.TcpStreamDirectionClient},
+		server: &tcpDirection{name: model.TcpStreamDirectionServer},
+	}
+}
+
+func (reassembler *tcpReassembler) add(srcKey string, tcp *layers.TCP, timestamp time.Time) {
+	sender, receiver := reassembler.client, reassembler.server
+	if srcKey != reassembler.clientKey {
+		sender, receiver = reassembler.server, reassembler.client
+	}
+
+	// Data acknowledged by the receiver but never seen was lost from the capture. The
+	// acknowledged data was sent before this packet's own data.
+	if tcp.ACK && receiver.started && seqDiff(tcp.Ack, receiver.next) > 0 {
+		reassembler.flush(receiver, tcp.Ack, timestamp)
+	}
+
+	if tcp.SYN && !sender.started {
+		sender.started = true
+		sender.next = tcp.Seq + 1
+	}
+	// The SYN occupies the first sequence number, so any data sent with it follows
+	seq := tcp.Seq
+	if tcp.SYN {
+		seq++
+	}
+	if len(tcp.Payload) > 0 {
+		if !sender.started {
+			sender.started = true
+			sender.next = seq
+		}
+		reassembler.receive(sender, &tcpSegment{seq: seq, data: tcp.Payload, timestamp: timestamp})
+	}
+	if tcp.FIN && !sender.finSeen {
+		sender.finSeen = true
+		sender.finSeq = seq + uint32(len(tcp.Payload))
+	}
+}
+
+func (reassembler *tcpReassembler) receive(direction *tcpDirection, segment *tcpSegment) {
+	if seqDiff(segment.seq, direction.next) > 0 {
+		if reassembler.budget.canHold(len(segment.data)) {
+			direction.pending = append(direction.pending, segment)
+			reassembler.budget.pending += len(segment.data)
+			return
+		}
+		// Holding on to more data than can be retained is pointless, so stop waiting for
+		// the missing data and record it as a gap.
+		reassembler.flush(direction, segment.seq, segment.timestamp)
+		if seqDiff(segment.seq, direction.next) > 0 {
+			// Data past the end of the connection
+			return
+		}
+	}
+	reassembler.deliver(direction, segment)
+	reassembler.drain(direction)
+}
+
+// deliver emits the part of the segment that has not already been delivered, so that
+// retransmitted and overlapping data only appears once.
+func (reassembler *tcpReassembler) deliver(direction *tcpDirection, segment *tcpSegment) {
+	skip := seqDiff(direction.next, segment.seq)
+	if skip >= len(segment.data) {
+		return
+	}
+	data := segment.data[skip:]
+	reassembler.emit(direction, data, segment.timestamp)
+	direction.next += uint32(len(data))
+}
+
+// drain delivers pending out-of-order segments that are now contiguous.
+func (reassembler *tcpReassembler) drain(direction *tcpDirection) {
+	for delivered := true; delivered; {
+		delivered = false
+		for idx, segment := range direction.pending {
+			if seqDiff(segment.seq, direction.next) <= 0 {
+				direction.pending = append(direction.pending[:idx], direction.pending[idx+1:]...)
+				reassembler.budget.pending -= len(segment.data)
+				reassembler.deliver(direction, segment)
+				delivered = true
+				break
+			}
+		}
+	}
+}
+
+// flush delivers all pending segments before the given sequence number, recording gaps
+// for data that was never captured.
+func (reassembler *tcpReassembler) flush(direction *tcpDirection, until uint32, timestamp time.Time) {
+	if direction.finSeen && seqDiff(until, direction.finSeq) > 0 {
+		until = direction.finSeq
+	}
+
+	sort.SliceStable(direction.pending, func(i, j int) bool {
+		return seqDiff(direction.pending[i].seq, direction.pending[j].seq) < 0
+	})
+	remaining := make([]*tcpSegment, 0)
+	for _, segment := range direction.pending {
+		if seqDiff(segment.seq, until) >= 0 {
+			remaining = append(remaining, segment)
+			continue
+		}
+		if missing := seqDiff(segment.seq, direction.next); missing > 0 {
+			reassembler.emitGap(direction, missing, segment.timestamp)
+			direction.next = segment.seq
+		}
+		reassembler.budget.pending -= len(segment.data)
+		reassembler.deliver(direction, segment)
+	}
+	direction.pending = remaining
+
+	if missing := seqDiff(until, direction.next); missing > 0 {
+		reassembler.emitGap(direction, missing, timestamp)
+		direction.next = until
+	}
+	reassembler.drain(direction)
+}
+
+// finish delivers whatever remains pending once the capture ends.
+func (reassembler *tcpReassembler) finish() {
+	for _, direction := range []*tcpDirection{reassembler.client, reassembler.server} {
+		if len(direction.pending) == 0 {
+			continue
+		}
+		last := direction.pending[0]
+		for _, segment := range direction.pending {
+			if seqDiff(segment.seq+uint32(len(segment.data)), last.seq+uint32(len(last.data))) > 0 {
+				last = segment
+			}
+		}
+		reassembler.flush(direction, last.seq+uint32(len(last.data)), last.timestamp)
+	}
+}
+
+func (reassembler *tcpReassembler) emit(direction *tcpDirection, data []byte, timestamp time.Time) {
+	stream := reassembler.stream
+	if direction == reassembler.client {
+		stream.ClientBytes += len(data)
+	} else {
+		stream.ServerBytes += len(data)
+	}
+	offset := direction.offset
+	direction.offset += len(data)
+
+	budget := reassembler.budget
+	if budget.limited {
+		if budget.remaining < len(data) {
+			stream.Truncated = true
+			if budget.remaining <= 0 {
+				return
+			}
+			data = data[:budget.remaining]
+		}
+		budget.remaining -= len(data)
+	}
+
+	if count := len(stream.Chunks); count > 0 {
+		last := stream.Chunks[count-1]
+		if last.Direction == direction.name && last.Missing == 0 {
+			last.Bytes = append(last.Bytes, data...)
+			last.Length = len(last.Bytes)
+			return
+		}
+	}
+	stream.Chunks = append(stream.Chunks, &model.TcpStreamChunk{
+		Direction: direction.name,
+		Timestamp: timestamp,
+		Offset:    offset,
+		Length:    len(data),
+		Bytes:     append([]byte(nil), data...),
+	})
+}
+
+func (reassembler *tcpReassembler) emitGap(direction *tcpDirection, missing int, timestamp time.Time) {
+	reassembler.stream.MissingBytes += missing
+	reassembler.stream.Chunks = append(reassembler.stream.Chunks, &model.TcpStreamChunk{
+		Direction: direction.name,
+		Timestamp: timestamp,
+		Offset:    direction.offset,
+		Missing:   missing,
+	})
+	direction.offset += missing
+}
+
+func tcpEndpoint(ip string, port layers.TCPPort) string {
+	return ip + "/" + strconv.Itoa(int(port))
+}
+
+// tcpConnectionKey identifies a connection regardless of the direction of the packet.
+func tcpConnectionKey(src string, dst string) string {
+	if src < dst {
+		return src + "|" + dst
+	}
+	return dst + "|" + src
+}
+
+// findTcpLayers returns the TCP layer and the network layer carrying it, which may be
+// inside a tunnel.
+func findTcpLayers(pcapPacket gopacket.Packet) (gopacket.NetworkLayer, *layers.TCP) {
+	var network gopacket.NetworkLayer
+	for _, layer := range pcapPacket.Layers() {
+		switch typed := layer.(type) {
+		case gopacket.NetworkLayer:
+			network = typed
+		case *layers.TCP:
+			if network != nil {
+				return network, typed
+			}
+			return nil, nil
+		}
+	}
+	return nil, nil
+}
+
+// ReassembleTcpStream reads a PCAP or pcapng stream and returns the reassembled
+// conversation of the TCP connection with the given index. Retransmitted data is
+// delivered once, out-of-order segments are put back in order, and data missing from
+// the capture is marked with gaps. At most maxBytes of data are retained, unless
+// maxBytes is zero.
+func ReassembleTcpStream(reader io.Reader, index int, unwrap bool, maxBytes int) (*model.TcpStream, error) {
+	streams, err := reassembleTcpStreams(reader, unwrap, newTcpBudget(maxBytes), func(candidate int) bool {
+		return candidate == index
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, errors.New("TCP stream not found")
+	}
+	return streams[0], nil
+}
+
+// reassembleTcpStreams reassembles every TCP connection whose index is accepted by the
+// selector, retaining data across all of them from the budget. Connections are
+// truncated once the budget is spent.
+func reassembleTcpStreams(reader io.Reader, unwrap bool, budget *tcpBudget, selected func(index int) bool) ([]*model.TcpStream, error) {
+	capture, err := newCaptureReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	streams := make([]*model.TcpStream, 0)
+	reassemblers := make([]*tcpReassembler, 0)
+	connections := make(map[string]*tcpReassembler)
+
+	for {
+		data, ci, readErr := capture.ReadPacketData()
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		pcapPacket := capture.decode(data, ci, gopacket.Default)
+		if unwrap {
+			pcapPacket = unwrapPacket(pcapPacket, nil)
+		}
+
+		network, tcp := findTcpLayers(pcapPacket)
+		if tcp == nil {
+			continue
+		}
+		src := tcpEndpoint(network.NetworkFlow().Src().String(), tcp.SrcPort)
+		dst := tcpEndpoint(network.NetworkFlow().Dst().String(), tcp.DstPort)
+		key := tcpConnectionKey(src, dst)
+
+		reassembler, known := connections[key]
+		if !known {
+			if index := len(connections); selected(index) {
+				stream := model.NewTcpStream(index)
+				reassembler = newTcpReassembler(stream, budget)
+				// The client is the side opening the connection, or else the first to send
+				clientIp, clientPort := network.NetworkFlow().Src().String(), tcp.SrcPort
+				serverIp, serverPort := network.NetworkFlow().Dst().String(), tcp.DstPort
+				if tcp.SYN && tcp.ACK {
+					clientIp, clientPort, serverIp, serverPort = serverIp, serverPort, clientIp, clientPort
+				}
+				reassembler.clientKey = tcpEndpoint(clientIp, clientPort)
+				stream.ClientIp, stream.ClientPort = clientIp, int(clientPort)
+				stream.ServerIp, stream.ServerPort = serverIp, int(serverPort)
+				streams = append(streams, stream)
+				reassemblers = append(reassemblers, reassembler)
+			}
+			connections[key] = reassembler
+		}
+
+		if reassembler != nil {
+			reassembler.add(src, tcp, ci.Timestamp)
+		}
+	}
+
+	for _, reassembler := range reassemblers {
+		reassembler.finish()
+		reassembler.stream.Count = len(connections)
+	}
+	return streams, nil
+}
+
+// FormatTcpStream renders the data of each chunk in the requested format.
+func FormatTcpStream(stream *model.TcpStream, format string) error {
+	if format == "" {
+		format = model.TcpStreamFormatAscii
+	}
+	for _, chunk := range stream.Chunks {
+		switch format {
+		case model.TcpStreamFormatAscii:
+			chunk.Data = formatAscii(chunk.Bytes)
+		case model.TcpStreamFormatHex:
+			chunk.Data = formatHexDump(chunk.Bytes, chunk.Offset)
+		case model.TcpStreamFormatRaw:
+			chunk.Data = base64.StdEncoding.EncodeToString(chunk.Bytes)
+		default:
+			return errors.New("Unsupported stream format: " + format)
+		}
+	}
+	stream.Format = format
+	return nil
+}
+
+func isPrintable(char byte) bool {
+	return char >= 32 && char <= 126
+}
+
+func formatAscii(data []byte) string {
+	var builder strings.Builder
+	for _, char := range data {
+		if isPrintable(char) || char == '\n' || char == '\r' || char == '\t' {
+			builder.WriteByte(char)
+		} else {
+			builder.WriteByte('.')
+		}
+	}
+	return builder.String()
+}
+
+// formatHexDump renders the data sixteen bytes to a line, numbering each line by its
+// offset within the direction's stream.
+func formatHexDump(data []byte, offset int) string {
+	var builder strings.Builder
+	for start := 0; start < len(data); start += 16 {
+		end := start + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		fmt.Fprintf(&builder, "%08X ", offset+start)
+		for idx := start; idx < start+16; idx++ {
+			if idx%8 == 0 {
+				builder.WriteByte(' ')
+			}
+			if idx < end {
+				fmt.Fprintf(&builder, "%02X ", data[idx])
+			} else {
+				builder.WriteString("   ")
+			}
+		}
+		builder.WriteByte(' ')
+		for _, char := range data[start:end] {
+			if isPrintable(char) {
+				builder.WriteByte(char)
+			} else {
+				builder.WriteByte('.')
+			}
+		}
+		builder.WriteByte('\n')
+	}
+	return builder.String()
+}
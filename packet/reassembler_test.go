@@ -0,0 +1,284 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSegment struct {
+	fromClient bool
+	clientPort int
+	serverPort int
+	seq        uint32
+	ack        uint32
+	flags      string
+	payload    string
+}
+
+const testClientIsn = 1000
+const testServerIsn = 5000
+
+func serializeTcp(tester *testing.T, segment testSegment) []byte {
+	clientIp, serverIp := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	if segment.serverPort == 0 {
+		segment.serverPort = 80
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(segment.clientPort),
+		DstPort: layers.TCPPort(segment.serverPort),
+		Seq:     segment.seq,
+		Ack:     segment.ack,
+		Window:  1024,
+		SYN:     bytes.ContainsRune([]byte(segment.flags), 'S'),
+		ACK:     bytes.ContainsRune([]byte(segment.flags), 'A'),
+		FIN:     bytes.ContainsRune([]byte(segment.flags), 'F'),
+		PSH:     len(segment.payload) > 0,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: clientIp, DstIP: serverIp}
+	if !segment.fromClient {
+		tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	ethernet := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	assert.NoError(tester, gopacket.SerializeLayers(buffer, options, ethernet, ip, tcp, gopacket.Payload(segment.payload)))
+	return buffer.Bytes()
+}
+
+func buildTcpPcap(tester *testing.T, frames [][]byte) *bytes.Buffer {
+	var buf bytes.Buffer
+	writer := pcapgo.NewWriter(&buf)
+	assert.NoError(tester, writer.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	for idx, frame := range frames {
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(int64(idx), 0),
+			CaptureLength: len(frame),
+			Length:        len(frame),
+		}
+		assert.NoError(tester, writer.WritePacket(ci, frame))
+	}
+	return &buf
+}
+
+func buildConversation(tester *testing.T, segments ...testSegment) *bytes.Buffer {
+	frames := make([][]byte, 0, len(segments))
+	for _, segment := range segments {
+		if segment.clientPort == 0 {
+			segment.clientPort = 40000
+		}
+		frames = append(frames, serializeTcp(tester, segment))
+	}
+	return buildTcpPcap(tester, frames)
+}
+
+func handshake() []testSegment {
+	return []testSegment{
+		{fromClient: true, seq: testClientIsn, flags: "S"},
+		{fromClient: false, seq: testServerIsn, ack: testClientIsn + 1, flags: "SA"},
+		{fromClient: true, seq: testClientIsn + 1, ack: testServerIsn + 1, flags: "A"},
+	}
+}
+
+func chunkData(stream *model.TcpStream) []string {
+	data := make([]string, 0, len(stream.Chunks))
+	for _, chunk := range stream.Chunks {
+		if chunk.Missing > 0 {
+			data = append(data, chunk.Direction+":<gap>")
+		} else {
+			data = append(data, chunk.Direction+":"+string(chunk.Bytes))
+		}
+	}
+	return data
+}
+
+func TestReassembleTcpStream(tester *testing.T) {
+	segments := append(handshake(),
+		testSegment{fromClient: true, seq: 1001, ack: 5001, flags: "A", payload: "GET / "},
+		testSegment{fromClient: true, seq: 1007, ack: 5001, flags: "A", payload: "HTTP/1.1\r\n"},
+		// Retransmission overlapping data already delivered
+		testSegment{fromClient: true, seq: 1004, ack: 5001, flags: "A", payload: "/ HTTP/1.1\r\n"},
+		// Server segments arrive out of order
+		testSegment{fromClient: false, seq: 5006, ack: 1017, flags: "A", payload: " OK\r\n"},
+		testSegment{fromClient: false, seq: 5001, ack: 1017, flags: "A", payload: "200"},
+		testSegment{fromClient: false, seq: 5004, ack: 1017, flags: "A", payload: "  "},
+		testSegment{fromClient: false, seq: 5011, ack: 1017, flags: "FA"},
+		testSegment{fromClient: true, seq: 1017, ack: 5012, flags: "FA"},
+	)
+
+	stream, err := ReassembleTcpStream(buildConversation(tester, segments...), 0, false, 0)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, 1, stream.Count)
+		assert.Equal(tester, "10.0.0.1", stream.ClientIp)
+		assert.Equal(tester, 40000, stream.ClientPort)
+		assert.Equal(tester, "10.0.0.2", stream.ServerIp)
+		assert.Equal(tester, 80, stream.ServerPort)
+		assert.Equal(tester, []string{"client:GET / HTTP/1.1\r\n", "server:200   OK\r\n"}, chunkData(stream))
+		assert.Equal(tester, 16, stream.ClientBytes)
+		assert.Equal(tester, 10, stream.ServerBytes)
+		assert.Equal(tester, 0, stream.MissingBytes)
+		assert.False(tester, stream.Truncated)
+		assert.Equal(tester, int64(3), stream.Chunks[0].Timestamp.Unix())
+	}
+}
+
+func TestReassembleTcpStreamGaps(tester *testing.T) {
+	segments := append(handshake(),
+		testSegment{fromClient: true, seq: 1001, ack: 5001, flags: "A", payload: "one"},
+		// The server's first segment was never captured
+		testSegment{fromClient: false, seq: 5006, ack: 1004, flags: "A", payload: "three"},
+		testSegment{fromClient: true, seq: 1004, ack: 5011, flags: "A", payload: "four"},
+		// A trailing segment that was never acknowledged
+		testSegment{fromClient: false, seq: 5014, ack: 1008, flags: "A", payload: "six"},
+	)
+
+	stream, err := ReassembleTcpStream(buildConversation(tester, segments...), 0, false, 0)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, []string{"client:one", "server:<gap>", "server:three", "client:four", "server:<gap>", "server:six"}, chunkData(stream))
+		assert.Equal(tester, 5+3, stream.MissingBytes)
+		assert.Equal(tester, 5, stream.Chunks[1].Missing)
+		assert.Equal(tester, 0, stream.Chunks[1].Offset)
+		assert.Equal(tester, 5, stream.Chunks[2].Offset)
+		assert.Equal(tester, 13, stream.Chunks[5].Offset)
+	}
+}
+
+func TestReassembleTcpStreamWithoutHandshake(tester *testing.T) {
+	stream, err := ReassembleTcpStream(buildConversation(tester,
+		testSegment{fromClient: false, seq: 0xFFFFFFFE, ack: 1, flags: "A", payload: "wrap"},
+		testSegment{fromClient: false, seq: 2, ack: 1, flags: "A", payload: "ped"},
+	), 0, false, 0)
+	if assert.NoError(tester, err) {
+		// The first sender is assumed to be the client
+		assert.Equal(tester, "10.0.0.2", stream.ClientIp)
+		assert.Equal(tester, []string{"client:wrapped"}, chunkData(stream))
+	}
+}
+
+func TestReassembleTcpStreamSynPayload(tester *testing.T) {
+	stream, err := ReassembleTcpStream(buildConversation(tester,
+		testSegment{fromClient: true, seq: testClientIsn, flags: "S", payload: "early"},
+		testSegment{fromClient: false, seq: testServerIsn, ack: testClientIsn + 6, flags: "SA"},
+		testSegment{fromClient: true, seq: testClientIsn + 6, ack: testServerIsn + 1, flags: "A", payload: "-more"},
+	), 0, false, 0)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, []string{"client:early-more"}, chunkData(stream))
+		assert.Equal(tester, 0, stream.MissingBytes)
+	}
+}
+
+func TestReassembleTcpStreamPendingLimit(tester *testing.T) {
+	stream, err := ReassembleTcpStream(buildConversation(tester,
+		testSegment{fromClient: true, seq: 1, flags: "A", payload: "ab"},
+		// Holding this until the missing data arrives would exceed the budget
+		testSegment{fromClient: true, seq: 10, flags: "A", payload: "0123456789"},
+		testSegment{fromClient: true, seq: 3, flags: "A", payload: "late"},
+	), 0, false, 8)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, []string{"client:ab", "client:<gap>", "client:012345"}, chunkData(stream))
+		assert.Equal(tester, 7, stream.MissingBytes)
+		assert.Equal(tester, 12, stream.ClientBytes)
+		assert.True(tester, stream.Truncated)
+	}
+}
+
+func TestReassembleTcpStreamSelection(tester *testing.T) {
+	pcap := buildConversation(tester,
+		testSegment{fromClient: true, clientPort: 40000, seq: 1, flags: "A", payload: "first"},
+		testSegment{fromClient: true, clientPort: 40001, seq: 1, flags: "A", payload: "second"},
+		testSegment{fromClient: false, clientPort: 40000, seq: 1, flags: "A", payload: "reply"},
+	)
+	stream, err := ReassembleTcpStream(bytes.NewReader(pcap.Bytes()), 1, false, 0)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, 2, stream.Count)
+		assert.Equal(tester, 1, stream.Index)
+		assert.Equal(tester, 40001, stream.ClientPort)
+		assert.Equal(tester, []string{"client:second"}, chunkData(stream))
+	}
+
+	_, err = ReassembleTcpStream(bytes.NewReader(pcap.Bytes()), 2, false, 0)
+	assert.EqualError(tester, err, "TCP stream not found")
+
+	_, err = ReassembleTcpStream(bytes.NewReader([]byte("garbage")), 0, false, 0)
+	assert.Error(tester, err)
+}
+
+func TestReassembleTcpStreamTruncated(tester *testing.T) {
+	stream, err := ReassembleTcpStream(buildConversation(tester,
+		testSegment{fromClient: true, seq: 1, flags: "A", payload: "abcdef"},
+		testSegment{fromClient: false, seq: 1, ack: 7, flags: "A", payload: "ghijkl"},
+	), 0, false, 8)
+	if assert.NoError(tester, err) {
+		assert.True(tester, stream.Truncated)
+		assert.Equal(tester, []string{"client:abcdef", "server:gh"}, chunkData(stream))
+		assert.Equal(tester, 6, stream.ServerBytes)
+	}
+}
+
+func TestReassembleTcpStreamUnwrap(tester *testing.T) {
+	inner := serializeTcp(tester, testSegment{fromClient: true, clientPort: 40000, seq: 1, flags: "A", payload: "tunneled"})
+	ethernet := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 7},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 8},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{192, 168, 0, 1}, DstIP: net.IP{192, 168, 0, 2}}
+	udp := &layers.UDP{SrcPort: 50000, DstPort: 4789}
+	udp.SetNetworkLayerForChecksum(ip)
+	vxlan := &layers.VXLAN{ValidIDFlag: true, VNI: 10}
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	assert.NoError(tester, gopacket.SerializeLayers(buffer, options, ethernet, ip, udp, vxlan, gopacket.Payload(inner)))
+	pcap := buildTcpPcap(tester, [][]byte{buffer.Bytes()})
+
+	for _, unwrap := range []bool{false, true} {
+		stream, err := ReassembleTcpStream(bytes.NewReader(pcap.Bytes()), 0, unwrap, 0)
+		if assert.NoError(tester, err) {
+			assert.Equal(tester, "10.0.0.1", stream.ClientIp)
+			assert.Equal(tester, "10.0.0.2", stream.ServerIp)
+			assert.Equal(tester, []string{"client:tunneled"}, chunkData(stream))
+		}
+	}
+}
+
+func TestFormatTcpStream(tester *testing.T) {
+	stream := model.NewTcpStream(0)
+	stream.Chunks = append(stream.Chunks,
+		&model.TcpStreamChunk{Direction: model.TcpStreamDirectionClient, Offset: 16, Bytes: []byte("GET /\r\n\x00\x01ABCDEFGHIJKLMNOP")},
+		&model.TcpStreamChunk{Direction: model.TcpStreamDirectionServer, Missing: 4},
+	)
+
+	assert.NoError(tester, FormatTcpStream(stream, ""))
+	assert.Equal(tester, model.TcpStreamFormatAscii, stream.Format)
+	assert.Equal(tester, "GET /\r\n..ABCDEFGHIJKLMNOP", stream.Chunks[0].Data)
+	assert.Equal(tester, "", stream.Chunks[1].Data)
+
+	assert.NoError(tester, FormatTcpStream(stream, model.TcpStreamFormatHex))
+	assert.Equal(tester, ""+
+		"00000010  47 45 54 20 2F 0D 0A 00  01 41 42 43 44 45 46 47  GET /....ABCDEFG\n"+
+		"00000020  48 49 4A 4B 4C 4D 4E 4F  50                       HIJKLMNOP\n", stream.Chunks[0].Data)
+
+	assert.NoError(tester, FormatTcpStream(stream, model.TcpStreamFormatRaw))
+	assert.Equal(tester, "R0VUIC8NCgABQUJDREVGR0hJSktMTU5PUA==", stream.Chunks[0].Data)
+
+	assert.EqualError(tester, FormatTcpStream(stream, "pdf"), "Unsupported stream format: pdf")
+}
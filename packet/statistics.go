@@ -0,0 +1,308 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+const DEFAULT_STATISTICS_LIMIT = 25
+const maxTimelineBuckets = 3600
+
+type statisticsCollector struct {
+	stats         *model.PacketStatistics
+	talkers       map[string]*model.PacketTalker
+	conversations map[string]*model.PacketConversation
+	seconds       map[int64]*model.PacketTimelineBucket
+}
+
+func newStatisticsCollector() *statisticsCollector {
+	return &statisticsCollector{
+		stats: &model.PacketStatistics{
+			TopTalkers:    make([]*model.PacketTalker, 0),
+			Conversations: make([]*model.PacketConversation, 0),
+			Protocols:     &model.PacketProtocolNode{Protocol: "Frame"},
+			Timeline:      make([]*model.PacketTimelineBucket, 0),
+		},
+		talkers:       make(map[string]*model.PacketTalker),
+		conversations: make(map[string]*model.PacketConversation),
+		seconds:       make(map[int64]*model.PacketTimelineBucket),
+	}
+}
+
+func (collector *statisticsCollector) add(pcapPacket gopacket.Packet, timestamp time.Time, length int) {
+	stats := collector.stats
+	if stats.PacketCount == 0 || timestamp.Before(stats.StartTime) {
+		stats.StartTime = timestamp
+	}
+	if stats.PacketCount == 0 || timestamp.After(stats.EndTime) {
+		stats.EndTime = timestamp
+	}
+	stats.PacketCount++
+	stats.ByteCount += length
+
+	second := timestamp.Unix()
+	bucket, found := collector.seconds[second]
+	if !found {
+		bucket = &model.PacketTimelineBucket{}
+		collector.seconds[second] = bucket
+	}
+	bucket.Packets++
+	bucket.Bytes += length
+
+	collector.addProtocols(pcapPacket, length)
+	collector.addConversation(pcapPacket, timestamp, length)
+}
+
+// protocolPath lists the protocols of the packet from the outermost inwards, ending with
+// the application protocol when one is recognized.
+func protocolPath(pcapPacket gopacket.Packet) []string {
+	path := make([]string, 0)
+	for _, layer := range pcapPacket.Layers() {
+		switch layer.LayerType() {
+		case gopacket.LayerTypePayload, gopacket.LayerTypeFragment, gopacket.LayerTypeDecodeFailure:
+			continue
+		}
+		path = append(path, layer.LayerType().String())
+	}
+	if application := dissectApplication(pcapPacket); application != nil {
+		name := strings.ToUpper(application.Protocol)
+		if len(path) == 0 || !strings.EqualFold(path[len(path)-1], name) {
+			path = append(path, name)
+		}
+	}
+	return path
+}
+
+func (collector *statisticsCollector) addProtocols(pcapPacket gopacket.Packet, length int) {
+	node := collector.stats.Protocols
+	node.Packets++
+	node.Bytes += length
+	for _, protocol := range protocolPath(pcapPacket) {
+		var child *model.PacketProtocolNode
+		for _, existing := range node.Children {
+			if existing.Protocol == protocol {
+				child = existing
+				break
+			}
+		}
+		if child == nil {
+			child = &model.PacketProtocolNode{Protocol: protocol}
+			node.Children = append(node.Children, child)
+		}
+		child.Packets++
+		child.Bytes += length
+		node = child
+	}
+}
+
+func transportProtocol(pcapPacket gopacket.Packet) (string, int, int) {
+	switch transport := pcapPacket.TransportLayer().(type) {
+	case *layers.TCP:
+		return "tcp", int(transport.SrcPort), int(transport.DstPort)
+	case *layers.UDP:
+		return "udp", int(transport.SrcPort), int(transport.DstPort)
+	case *layers.SCTP:
+		return "sctp", int(transport.SrcPort), int(transport.DstPort)
+	}
+	if pcapPacket.Layer(layers.LayerTypeICMPv4) != nil {
+		return "icmp", 0, 0
+	}
+	if pcapPacket.Layer(layers.LayerTypeICMPv6) != nil {
+		return "icmp6", 0, 0
+	}
+	switch network := pcapPacket.NetworkLayer().(type) {
+	case *layers.IPv4:
+		return strings.ToLower(network.Protocol.String()), 0, 0
+	case *layers.IPv6:
+		return strings.ToLower(network.NextHeader.String()), 0, 0
+	}
+	return "", 0, 0
+}
+
+func conversationKey(protocol string, srcIp string, srcPort int, dstIp string, dstPort int) string {
+	return strings.Join([]string{protocol, tcpEndpoint(srcIp, layers.TCPPort(srcPort)), tcpEndpoint(dstIp, layers.TCPPort(dstPort))}, "|")
+}
+
+func (collector *statisticsCollector) talker(ip string) *model.PacketTalker {
+	talker, found := collector.talkers[ip]
+	if !found {
+		talker = &model.PacketTalker{Ip: ip}
+		collector.talkers[ip] = talker
+	}
+	return talker
+}
+
+func (collector *statisticsCollector) addConversation(pcapPacket gopacket.Packet, timestamp time.Time, length int) {
+	network := pcapPacket.NetworkLayer()
+	if network == nil {
+		return
+	}
+	srcIp := network.NetworkFlow().Src().String()
+	dstIp := network.NetworkFlow().Dst().String()
+
+	sender := collector.talker(srcIp)
+	sender.Packets++
+	sender.Bytes += length
+	sender.SentPackets++
+	sender.SentBytes += length
+	receiver := collector.talker(dstIp)
+	receiver.ReceivedPackets++
+	receiver.ReceivedBytes += length
+	if receiver != sender {
+		receiver.Packets++
+		receiver.Bytes += length
+	}
+
+	protocol, srcPort, dstPort := transportProtocol(pcapPacket)
+	forward := true
+	conversation, found := collector.conversations[conversationKey(protocol, srcIp, srcPort, dstIp, dstPort)]
+	if !found {
+		conversation, found = collector.conversations[conversationKey(protocol, dstIp, dstPort, srcIp, srcPort)]
+		forward = !found
+	}
+	if !found {
+		conversation = &model.PacketConversation{
+			Protocol:  protocol,
+			SrcIp:     srcIp,
+			SrcPort:   srcPort,
+			DstIp:     dstIp,
+			DstPort:   dstPort,
+			StartTime: timestamp,
+			EndTime:   timestamp,
+		}
+		collector.conversations[conversationKey(protocol, srcIp, srcPort, dstIp, dstPort)] = conversation
+	}
+
+	conversation.Packets++
+	conversation.Bytes += length
+	if forward {
+		conversation.ForwardPackets++
+		conversation.ForwardBytes += length
+	} else {
+		conversation.ReversePackets++
+		conversation.ReverseBytes += length
+	}
+	if timestamp.Before(conversation.StartTime) {
+		conversation.StartTime = timestamp
+	}
+	if timestamp.After(conversation.EndTime) {
+		conversation.EndTime = timestamp
+	}
+	conversation.Duration = conversation.EndTime.Sub(conversation.StartTime).Seconds()
+}
+
+func sortProtocols(node *model.PacketProtocolNode) {
+	sort.SliceStable(node.Children, func(i, j int) bool {
+		return node.Children[i].Packets > node.Children[j].Packets
+	})
+	for _, child := range node.Children {
+		sortProtocols(child)
+	}
+}
+
+// buildTimeline spreads the per-second counts across the capture, widening the interval
+// of each bucket when the capture is too long to chart one bucket per second.
+func (collector *statisticsCollector) buildTimeline() {
+	stats := collector.stats
+	if stats.PacketCount == 0 {
+		return
+	}
+	start := stats.StartTime.Unix()
+	span := stats.EndTime.Unix() - start + 1
+	interval := int64(1)
+	if span > maxTimelineBuckets {
+		interval = (span + maxTimelineBuckets - 1) / maxTimelineBuckets
+	}
+	stats.TimelineInterval = int(interval)
+
+	for bucketStart := start; bucketStart < start+span; bucketStart += interval {
+		stats.Timeline = append(stats.Timeline, &model.PacketTimelineBucket{Time: time.Unix(bucketStart, 0).UTC()})
+	}
+	// Only the seconds with packets are visited, however long the capture spans
+	for second, counts := range collector.seconds {
+		bucket := stats.Timeline[(second-start)/interval]
+		bucket.Packets += counts.Packets
+		bucket.Bytes += counts.Bytes
+	}
+}
+
+func (collector *statisticsCollector) finish(limit int) *model.PacketStatistics {
+	stats := collector.stats
+	stats.Duration = stats.EndTime.Sub(stats.StartTime).Seconds()
+
+	for _, talker := range collector.talkers {
+		stats.TopTalkers = append(stats.TopTalkers, talker)
+	}
+	sort.Slice(stats.TopTalkers, func(i, j int) bool {
+		if stats.TopTalkers[i].Bytes != stats.TopTalkers[j].Bytes {
+			return stats.TopTalkers[i].Bytes > stats.TopTalkers[j].Bytes
+		}
+		return stats.TopTalkers[i].Ip < stats.TopTalkers[j].Ip
+	})
+	stats.TotalTalkers = len(stats.TopTalkers)
+
+	for _, conversation := range collector.conversations {
+		stats.Conversations = append(stats.Conversations, conversation)
+	}
+	sort.Slice(stats.Conversations, func(i, j int) bool {
+		left, right := stats.Conversations[i], stats.Conversations[j]
+		if left.Bytes != right.Bytes {
+			return left.Bytes > right.Bytes
+		}
+		return left.StartTime.Before(right.StartTime)
+	})
+	stats.TotalConversations = len(stats.Conversations)
+
+	if limit > 0 {
+		if len(stats.TopTalkers) > limit {
+			stats.TopTalkers = stats.TopTalkers[:limit]
+		}
+		if len(stats.Conversations) > limit {
+			stats.Conversations = stats.Conversations[:limit]
+		}
+	}
+
+	sortProtocols(stats.Protocols)
+	collector.buildTimeline()
+	return stats
+}
+
+// ComputeStatistics reads a PCAP or pcapng stream and summarizes its top talkers,
+// conversations, protocol hierarchy and packet rate over time. The talkers and
+// conversations are limited to the given number of entries, unless limit is zero.
+func ComputeStatistics(reader io.Reader, unwrap bool, limit int) (*model.PacketStatistics, error) {
+	capture, err := newCaptureReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := newStatisticsCollector()
+	for {
+		data, ci, readErr := capture.ReadPacketData()
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		pcapPacket := capture.decode(data, ci, gopacket.Default)
+		if unwrap {
+			pcapPacket = unwrapPacket(pcapPacket, nil)
+		}
+		collector.add(pcapPacket, ci.Timestamp, ci.Length)
+	}
+
+	return collector.finish(limit), nil
+}
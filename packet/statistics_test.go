@@ -0,0 +1,142 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeStatistics(tester *testing.T) {
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	segments := append(handshake(),
+		testSegment{fromClient: true, seq: 1001, ack: 5001, flags: "A", payload: request},
+		testSegment{fromClient: false, seq: 5001, ack: 1001 + uint32(len(request)), flags: "A"},
+		testSegment{fromClient: true, clientPort: 40001, seq: 9000, flags: "S"},
+	)
+	stats, err := ComputeStatistics(buildConversation(tester, segments...), false, DEFAULT_STATISTICS_LIMIT)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, 6, stats.PacketCount)
+		assert.Equal(tester, time.Unix(0, 0).UTC(), stats.StartTime.UTC())
+		assert.Equal(tester, time.Unix(5, 0).UTC(), stats.EndTime.UTC())
+		assert.Equal(tester, float64(5), stats.Duration)
+
+		assert.Equal(tester, 2, stats.TotalTalkers)
+		assert.Equal(tester, "10.0.0.1", stats.TopTalkers[0].Ip)
+		assert.Equal(tester, 4, stats.TopTalkers[0].SentPackets)
+		assert.Equal(tester, 2, stats.TopTalkers[0].ReceivedPackets)
+		assert.Equal(tester, 6, stats.TopTalkers[0].Packets)
+		assert.Equal(tester, stats.ByteCount, stats.TopTalkers[0].Bytes)
+
+		assert.Equal(tester, 2, stats.TotalConversations)
+		conversation := stats.Conversations[0]
+		assert.Equal(tester, "tcp", conversation.Protocol)
+		assert.Equal(tester, "10.0.0.1", conversation.SrcIp)
+		assert.Equal(tester, 40000, conversation.SrcPort)
+		assert.Equal(tester, "10.0.0.2", conversation.DstIp)
+		assert.Equal(tester, 80, conversation.DstPort)
+		assert.Equal(tester, 5, conversation.Packets)
+		assert.Equal(tester, 3, conversation.ForwardPackets)
+		assert.Equal(tester, 2, conversation.ReversePackets)
+		assert.Equal(tester, conversation.Bytes, conversation.ForwardBytes+conversation.ReverseBytes)
+		assert.Equal(tester, float64(4), conversation.Duration)
+		assert.Equal(tester, 40001, stats.Conversations[1].SrcPort)
+		assert.Equal(tester, 1, stats.Conversations[1].Packets)
+
+		root := stats.Protocols
+		assert.Equal(tester, "Frame", root.Protocol)
+		assert.Equal(tester, 6, root.Packets)
+		assert.Len(tester, root.Children, 1)
+		assert.Equal(tester, "Ethernet", root.Children[0].Protocol)
+		ip := root.Children[0].Children[0]
+		assert.Equal(tester, "IPv4", ip.Protocol)
+		tcp := ip.Children[0]
+		assert.Equal(tester, "TCP", tcp.Protocol)
+		assert.Equal(tester, 6, tcp.Packets)
+		assert.Len(tester, tcp.Children, 1)
+		assert.Equal(tester, "HTTP", tcp.Children[0].Protocol)
+		assert.Equal(tester, 1, tcp.Children[0].Packets)
+
+		assert.Equal(tester, 1, stats.TimelineInterval)
+		assert.Len(tester, stats.Timeline, 6)
+		assert.Equal(tester, time.Unix(0, 0).UTC(), stats.Timeline[0].Time)
+		assert.Equal(tester, 1, stats.Timeline[5].Packets)
+	}
+}
+
+func TestComputeStatisticsLimit(tester *testing.T) {
+	segments := []testSegment{
+		{fromClient: true, clientPort: 40000, seq: 1, flags: "S"},
+		{fromClient: true, clientPort: 40001, seq: 1, flags: "S"},
+		{fromClient: true, clientPort: 40002, seq: 1, flags: "S", payload: "larger than the minimum frame padding"},
+	}
+	stats, err := ComputeStatistics(buildConversation(tester, segments...), false, 1)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, 3, stats.TotalConversations)
+		assert.Len(tester, stats.Conversations, 1)
+		assert.Equal(tester, 40002, stats.Conversations[0].SrcPort)
+		assert.Equal(tester, 2, stats.TotalTalkers)
+		assert.Len(tester, stats.TopTalkers, 1)
+	}
+}
+
+func TestComputeStatisticsTimelineInterval(tester *testing.T) {
+	frame := serializeTcp(tester, testSegment{fromClient: true, clientPort: 40000, flags: "S"})
+	var buf bytes.Buffer
+	writer := pcapgo.NewWriter(&buf)
+	assert.NoError(tester, writer.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	for _, seconds := range []int64{1000, 1001, 8199} {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(seconds, 0), CaptureLength: len(frame), Length: len(frame)}
+		assert.NoError(tester, writer.WritePacket(ci, frame))
+	}
+
+	stats, err := ComputeStatistics(&buf, false, 0)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, 2, stats.TimelineInterval)
+		assert.Len(tester, stats.Timeline, 3600)
+		assert.Equal(tester, 2, stats.Timeline[0].Packets)
+		assert.Equal(tester, 1, stats.Timeline[3599].Packets)
+	}
+}
+
+func TestComputeStatisticsTimelineLongCapture(tester *testing.T) {
+	frame := serializeTcp(tester, testSegment{fromClient: true, clientPort: 40000, flags: "S"})
+	var buf bytes.Buffer
+	writer := pcapgo.NewWriter(&buf)
+	assert.NoError(tester, writer.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	// Packets decades apart, as with a sensor whose clock was reset
+	for _, seconds := range []int64{0, 1, 3600000000} {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(seconds, 0), CaptureLength: len(frame), Length: len(frame)}
+		assert.NoError(tester, writer.WritePacket(ci, frame))
+	}
+
+	stats, err := ComputeStatistics(&buf, false, 0)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, 1000001, stats.TimelineInterval)
+		assert.Len(tester, stats.Timeline, 3600)
+		assert.Equal(tester, 2, stats.Timeline[0].Packets)
+		assert.Equal(tester, 1, stats.Timeline[3599].Packets)
+	}
+}
+
+func TestComputeStatisticsEmpty(tester *testing.T) {
+	stats, err := ComputeStatistics(buildTcpPcap(tester, nil), false, 0)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, 0, stats.PacketCount)
+		assert.Empty(tester, stats.Conversations)
+		assert.Empty(tester, stats.Timeline)
+		assert.Equal(tester, 0, stats.TimelineInterval)
+	}
+
+	_, err = ComputeStatistics(bytes.NewReader([]byte("invalid")), false, 0)
+	assert.Error(tester, err)
+}
@@ -0,0 +1,228 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/security-onion-solutions/securityonion-soc/util"
+)
+
+// Chunked uploads stored by a codec that compresses or encrypts are kept as a sequence of
+// records, one per chunk, so that no part of the upload is held on disk as plaintext:
+//
+//	record length (8) | encoded stream of the chunk (record length)
+//
+// Each record is a complete stream, header included. A record left incomplete by an
+// interrupted write is ignored and overwritten by the next chunk. Codecs that store
+// streams as plain PCAP files store the upload as is.
+const chunkRecordPrefixSize = 8
+
+type chunkRecord struct {
+	start  int64
+	length int64
+	header *streamHeader
+}
+
+// readChunkRecords returns the complete records of the upload, along with the position
+// following the last complete record.
+func readChunkRecords(file *os.File) ([]*chunkRecord, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := make([]*chunkRecord, 0)
+	var end int64
+	prefix := make([]byte, chunkRecordPrefixSize)
+	for {
+		if _, err := file.ReadAt(prefix, end); err != nil {
+			break
+		}
+		start := end + chunkRecordPrefixSize
+		length := int64(binary.BigEndian.Uint64(prefix))
+		if length < streamHeaderSize || length > info.Size()-start {
+			break
+		}
+		header, err := readStreamHeader(io.NewSectionReader(file, start, streamHeaderSize))
+		if err != nil || header == nil {
+			break
+		}
+		records = append(records, &chunkRecord{start: start, length: length, header: header})
+		end = start + length
+	}
+	return records, end, nil
+}
+
+func chunkRecordsSize(records []*chunkRecord) int64 {
+	var size int64
+	for _, record := range records {
+		size += int64(record.header.size)
+	}
+	return size
+}
+
+// FileChunksSize returns the number of plain bytes received by a chunked upload.
+func (codec *StreamCodec) FileChunksSize(filename string) int64 {
+	if codec.IsPlain() {
+		return util.FileSize(filename)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	records, _, _ := readChunkRecords(file)
+	return chunkRecordsSize(records)
+}
+
+// AppendFileChunk encodes the chunk and adds it to the chunked upload. The offset must
+// equal the plain size of the upload, or zero to start the upload over. The chunk is
+// verified against its hex encoded SHA256 checksum, and discarded if it does not match
+// or is only partially received. Returns the plain size of the upload afterwards.
+func (codec *StreamCodec) AppendFileChunk(filename string, offset int64, checksum string, reader io.Reader) (int64, error) {
+	if codec.IsPlain() {
+		return util.AppendFileChunk(filename, offset, checksum, reader)
+	}
+
+	os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	records, end, err := readChunkRecords(file)
+	if err != nil {
+		return 0, err
+	}
+	size := chunkRecordsSize(records)
+	if offset != 0 && offset != size {
+		return size, errors.New("Chunk offset does not match the upload offset")
+	}
+	if offset == 0 {
+		end = 0
+	}
+
+	start := end + chunkRecordPrefixSize
+	var count int64
+	hasher := sha256.New()
+	header, err := codec.newHeader()
+	if err == nil {
+		err = file.Truncate(end)
+	}
+	if err == nil {
+		_, err = file.Seek(start, io.SeekStart)
+	}
+	if err == nil {
+		count, err = codec.encode(file, start, header, io.TeeReader(reader, hasher))
+	}
+	if err == nil && !strings.EqualFold(hex.EncodeToString(hasher.Sum(nil)), checksum) {
+		err = errors.New("Chunk checksum does not match the received data")
+	}
+	if err == nil {
+		var position int64
+		position, err = file.Seek(0, io.SeekCurrent)
+		prefix := make([]byte, chunkRecordPrefixSize)
+		binary.BigEndian.PutUint64(prefix, uint64(position-start))
+		if err == nil {
+			_, err = file.WriteAt(prefix, end)
+		}
+	}
+	if err != nil {
+		file.Truncate(end)
+		return offset, err
+	}
+	return offset + count, nil
+}
+
+// openFileChunks returns a reader of the plain contents of the chunked upload.
+func (codec *StreamCodec) openFileChunks(filename string) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	records, _, err := readChunkRecords(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &chunkReader{codec: codec, file: file, records: records}, nil
+}
+
+// verifyFileChunks checks that the plain contents of the chunked upload add up to the
+// expected size and checksum.
+func (codec *StreamCodec) verifyFileChunks(filename string, size int64, checksum string) error {
+	reader, err := codec.openFileChunks(filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	hasher := sha256.New()
+	actual, err := io.Copy(hasher, reader)
+	if err != nil {
+		return err
+	}
+	if actual != size {
+		return errors.New("Upload size does not match the expected size")
+	}
+	if !strings.EqualFold(hex.EncodeToString(hasher.Sum(nil)), checksum) {
+		return errors.New("Upload checksum does not match the expected checksum")
+	}
+	return nil
+}
+
+// chunkReader decodes the records of a chunked upload one after another.
+type chunkReader struct {
+	codec   *StreamCodec
+	file    *os.File
+	records []*chunkRecord
+	current *streamReader
+}
+
+func (reader *chunkReader) Read(data []byte) (int, error) {
+	for {
+		if reader.current == nil {
+			if len(reader.records) == 0 {
+				return 0, io.EOF
+			}
+			record := reader.records[0]
+			reader.records = reader.records[1:]
+			body := io.NewSectionReader(reader.file, record.start+streamHeaderSize, record.length-streamHeaderSize)
+			current, err := reader.codec.decode(body, record.header)
+			if err != nil {
+				return 0, err
+			}
+			reader.current = current
+		}
+
+		count, err := reader.current.Read(data)
+		if err == io.EOF {
+			reader.current.Close()
+			reader.current = nil
+			if count == 0 {
+				continue
+			}
+			err = nil
+		}
+		return count, err
+	}
+}
+
+func (reader *chunkReader) Close() error {
+	if reader.current != nil {
+		reader.current.Close()
+	}
+	return reader.file.Close()
+}
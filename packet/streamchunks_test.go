@@ -0,0 +1,116 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/security-onion-solutions/securityonion-soc/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func appendChunk(tester *testing.T, codec *StreamCodec, filename string, offset int64, chunk string) (int64, error) {
+	return codec.AppendFileChunk(filename, offset, util.Checksum([]byte(chunk)), strings.NewReader(chunk))
+}
+
+func TestFileChunks(tester *testing.T) {
+	for _, compress := range []bool{false, true} {
+		for _, key := range [][]byte{nil, testKey(1)} {
+			codec, _ := NewStreamCodec(compress, key)
+			dir := tester.TempDir()
+			filename := filepath.Join(dir, "1.bin.part")
+			destination := filepath.Join(dir, "1.bin")
+
+			assert.Equal(tester, int64(0), codec.FileChunksSize(filename))
+			size, err := appendChunk(tester, codec, filename, 0, "secret-")
+			assert.NoError(tester, err)
+			assert.Equal(tester, int64(7), size)
+
+			size, err = appendChunk(tester, codec, filename, 3, "pcap")
+			assert.Error(tester, err)
+			assert.Equal(tester, int64(7), size)
+
+			size, err = codec.AppendFileChunk(filename, 7, util.Checksum([]byte("nope")), strings.NewReader("pcap"))
+			assert.Error(tester, err)
+			assert.Equal(tester, int64(7), size)
+			assert.Equal(tester, int64(7), codec.FileChunksSize(filename))
+
+			size, err = appendChunk(tester, codec, filename, 7, "pcap")
+			assert.NoError(tester, err)
+			assert.Equal(tester, int64(11), size)
+			assert.Equal(tester, int64(11), codec.FileChunksSize(filename))
+
+			stored, _ := os.ReadFile(filename)
+			assert.Equal(tester, key == nil, bytes.Contains(stored, []byte("secret")))
+
+			checksum := util.Checksum([]byte("secret-pcap"))
+			assert.Error(tester, codec.CompleteFileChunks(filename, destination, 12, checksum))
+			assert.Error(tester, codec.CompleteFileChunks(filename, destination, 11, util.Checksum([]byte("nope"))))
+			assert.NoError(tester, codec.CompleteFileChunks(filename, destination, 11, checksum))
+			assert.NoError(tester, codec.CompleteFileChunks(filename, destination, 11, checksum))
+			_, err = os.Stat(filename)
+			assert.True(tester, os.IsNotExist(err))
+
+			data, size := readStream(tester, codec, destination)
+			assert.Equal(tester, "secret-pcap", string(data))
+			assert.Equal(tester, int64(11), size)
+		}
+	}
+}
+
+func TestFileChunksRestart(tester *testing.T) {
+	codec, _ := NewStreamCodec(true, testKey(1))
+	filename := filepath.Join(tester.TempDir(), "1.bin.part")
+
+	appendChunk(tester, codec, filename, 0, "stale")
+	size, err := appendChunk(tester, codec, filename, 0, "fresh")
+	assert.NoError(tester, err)
+	assert.Equal(tester, int64(5), size)
+
+	reader, err := codec.openFileChunks(filename)
+	if assert.NoError(tester, err) {
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		assert.Equal(tester, "fresh", string(data))
+	}
+}
+
+func TestFileChunksInterrupted(tester *testing.T) {
+	codec, _ := NewStreamCodec(true, testKey(1))
+	filename := filepath.Join(tester.TempDir(), "1.bin.part")
+
+	appendChunk(tester, codec, filename, 0, "first")
+	complete := util.FileSize(filename)
+	appendChunk(tester, codec, filename, 5, "second")
+
+	// A record cut short, or whose length was never written, is ignored
+	assert.NoError(tester, os.Truncate(filename, util.FileSize(filename)-1))
+	assert.Equal(tester, int64(5), codec.FileChunksSize(filename))
+	file, _ := os.OpenFile(filename, os.O_WRONLY, 0600)
+	file.WriteAt(make([]byte, chunkRecordPrefixSize), complete)
+	file.Close()
+	assert.Equal(tester, int64(5), codec.FileChunksSize(filename))
+
+	size, err := appendChunk(tester, codec, filename, 5, "second")
+	assert.NoError(tester, err)
+	assert.Equal(tester, int64(11), size)
+	assert.NoError(tester, codec.CompleteFileChunks(filename, filename+".done", 11, util.Checksum([]byte("firstsecond"))))
+}
+
+func TestFileChunksWrongKey(tester *testing.T) {
+	codec, _ := NewStreamCodec(false, testKey(1))
+	other, _ := NewStreamCodec(false, testKey(2))
+	dir := tester.TempDir()
+	filename := filepath.Join(dir, "1.bin.part")
+
+	appendChunk(tester, codec, filename, 0, "pcap")
+	assert.Error(tester, other.CompleteFileChunks(filename, filepath.Join(dir, "1.bin"), 4, util.Checksum([]byte("pcap"))))
+}
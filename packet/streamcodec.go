@@ -0,0 +1,575 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/klauspost/compress/zstd"
+	"github.com/security-onion-solutions/securityonion-soc/util"
+)
+
+// Stored streams that are compressed or encrypted begin with a fixed size header:
+//
+//	magic (4) | version (1) | flags (1) | reserved (2) | plaintext size (8) |
+//	key id (8) | nonce (12) | reserved (4)
+//
+// Encrypted streams follow the header with a sequence of AES-GCM sealed segments, each
+// framed as a final flag (1) and ciphertext length (4). The header, the segment index
+// and the final flag are authenticated with each segment so that segments cannot be
+// reordered or truncated. Streams that are neither compressed nor encrypted are stored
+// as plain PCAP files without a header.
+const streamMagic = "SOPS"
+const streamVersion = 1
+const streamHeaderSize = 40
+const streamFlagCompressed = 1
+const streamFlagEncrypted = 2
+const streamSegmentSize = 65536
+
+var streamSuffixes = []string{".bin", ".bin.unwrapped"}
+
+type streamHeader struct {
+	flags byte
+	size  uint64
+	keyId []byte
+	nonce []byte
+}
+
+func (header *streamHeader) marshal() []byte {
+	buf := make([]byte, streamHeaderSize)
+	copy(buf[0:4], streamMagic)
+	buf[4] = streamVersion
+	buf[5] = header.flags
+	binary.BigEndian.PutUint64(buf[8:16], header.size)
+	copy(buf[16:24], header.keyId)
+	copy(buf[24:36], header.nonce)
+	return buf
+}
+
+// authenticatedData excludes the plaintext size, which is only known once the stream
+// has been fully written.
+func (header *streamHeader) authenticatedData() []byte {
+	buf := header.marshal()
+	return append(buf[0:8], buf[16:36]...)
+}
+
+func (header *streamHeader) isCompressed() bool {
+	return header.flags&streamFlagCompressed != 0
+}
+
+func (header *streamHeader) isEncrypted() bool {
+	return header.flags&streamFlagEncrypted != 0
+}
+
+// readStreamHeader returns nil without error if the file is a plain PCAP file.
+func readStreamHeader(file io.Reader) (*streamHeader, error) {
+	buf := make([]byte, streamHeaderSize)
+	count, err := io.ReadFull(file, buf)
+	if count < len(streamMagic) || string(buf[0:4]) != streamMagic {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("Packet stream header is truncated")
+	}
+	if buf[4] != streamVersion {
+		return nil, errors.New("Unsupported packet stream version")
+	}
+	return &streamHeader{
+		flags: buf[5],
+		size:  binary.BigEndian.Uint64(buf[8:16]),
+		keyId: buf[16:24],
+		nonce: buf[24:36],
+	}, nil
+}
+
+// StreamCodec compresses and encrypts packet streams as they are stored, and restores
+// them as they are read. Plain streams written before compression or encryption was
+// enabled remain readable. The zero value stores streams as plain PCAP files.
+type StreamCodec struct {
+	compress bool
+	aead     cipher.AEAD
+	keyId    []byte
+}
+
+// NewStreamCodec returns a codec that compresses streams with zstd if compress is true,
+// and encrypts streams with AES-GCM if a 16, 24 or 32 byte key is given.
+func NewStreamCodec(compress bool, key []byte) (*StreamCodec, error) {
+	codec := &StreamCodec{
+		compress: compress,
+	}
+	if len(key) > 0 {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.New("Invalid packet stream encryption key: " + err.Error())
+		}
+		codec.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(key)
+		codec.keyId = digest[:8]
+	}
+	return codec, nil
+}
+
+// ParseStreamKey decodes a base64 encoded encryption key. An empty string disables
+// encryption.
+func ParseStreamKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("Packet stream encryption key must be base64 encoded")
+	}
+	return key, nil
+}
+
+// IsPlain returns true if streams are stored as plain PCAP files.
+func (codec *StreamCodec) IsPlain() bool {
+	return !codec.compress && codec.aead == nil
+}
+
+func (codec *StreamCodec) newHeader() (*streamHeader, error) {
+	header := &streamHeader{}
+	if codec.compress {
+		header.flags |= streamFlagCompressed
+	}
+	if codec.aead != nil {
+		header.flags |= streamFlagEncrypted
+		header.keyId = codec.keyId
+		header.nonce = make([]byte, codec.aead.NonceSize())
+		if _, err := rand.Read(header.nonce); err != nil {
+			return nil, err
+		}
+	}
+	return header, nil
+}
+
+func (codec *StreamCodec) matches(header *streamHeader) bool {
+	if header == nil {
+		return codec.IsPlain()
+	}
+	return header.isCompressed() == codec.compress &&
+		header.isEncrypted() == (codec.aead != nil) &&
+		(codec.aead == nil || bytes.Equal(header.keyId, codec.keyId))
+}
+
+// WriteFile stores the reader's contents in the named file, replacing the file only
+// once the stream has been completely written. Returns the number of plaintext bytes
+// written.
+func (codec *StreamCodec) WriteFile(filename string, reader io.Reader) (int64, error) {
+	if codec.IsPlain() {
+		return util.WriteFileAtomically(filename, reader)
+	}
+
+	os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	header, err := codec.newHeader()
+	if err == nil {
+		count, err = codec.encode(file, 0, header, reader)
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		count = 0
+	}
+	return count, err
+}
+
+// encode writes the header and the encoded contents of the reader to the file, starting
+// at the given position, and then fills in the plaintext size in the header.
+func (codec *StreamCodec) encode(file *os.File, start int64, header *streamHeader, reader io.Reader) (int64, error) {
+	if _, err := file.Write(header.marshal()); err != nil {
+		return 0, err
+	}
+
+	var output io.WriteCloser = nopWriteCloser{file}
+	if header.isEncrypted() {
+		output = &segmentWriter{
+			output: file,
+			aead:   codec.aead,
+			header: header,
+			buffer: make([]byte, 0, streamSegmentSize),
+		}
+	}
+	sealer := output
+	if header.isCompressed() {
+		encoder, err := zstd.NewWriter(output)
+		if err != nil {
+			return 0, err
+		}
+		output = encoder
+	}
+
+	count, err := io.Copy(output, reader)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if sealer != output {
+		if closeErr := sealer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(count))
+	_, err = file.WriteAt(size, start+8)
+	return count, err
+}
+
+// CompleteFileChunks verifies the chunked upload and stores its contents as the
+// destination stream, removing the upload. Completing an upload that was already stored
+// succeeds.
+func (codec *StreamCodec) CompleteFileChunks(filename string, destination string, size int64, checksum string) error {
+	if codec.IsPlain() {
+		return util.CompleteFileChunks(filename, destination, size, checksum)
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		if actual, err := codec.StreamSize(destination); err == nil && actual == size {
+			return nil
+		}
+	}
+	if err := codec.verifyFileChunks(filename, size, checksum); err != nil {
+		return err
+	}
+	reader, err := codec.openFileChunks(filename)
+	if err != nil {
+		return err
+	}
+	_, err = codec.WriteFile(destination, reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+// OpenFile returns a reader of the plain contents of a stored stream, along with the
+// length of the plain contents.
+func (codec *StreamCodec) OpenFile(filename string) (io.ReadCloser, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	header, err := readStreamHeader(file)
+	if err == nil && header == nil {
+		var info os.FileInfo
+		if info, err = file.Stat(); err == nil {
+			if _, err = file.Seek(0, io.SeekStart); err == nil {
+				return file, info.Size(), nil
+			}
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	reader, err := codec.decode(file, header)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	reader.file = file
+	return reader, int64(header.size), nil
+}
+
+// decode returns a reader of the plain contents of the encoded input following the
+// header.
+func (codec *StreamCodec) decode(input io.Reader, header *streamHeader) (*streamReader, error) {
+	if header.isEncrypted() {
+		if codec.aead == nil {
+			return nil, errors.New("Packet stream is encrypted but no encryption key is configured")
+		}
+		if !bytes.Equal(header.keyId, codec.keyId) {
+			return nil, errors.New("Packet stream is encrypted with a different key")
+		}
+		input = &segmentReader{
+			input:  input,
+			aead:   codec.aead,
+			header: header,
+		}
+	}
+
+	reader := &streamReader{Reader: input}
+	if header.isCompressed() {
+		decoder, err := zstd.NewReader(input, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		reader.Reader = decoder
+		reader.decoder = decoder
+	}
+	return reader, nil
+}
+
+// StreamSize returns the length of the plain contents of a stored stream.
+func (codec *StreamCodec) StreamSize(filename string) (int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	header, err := readStreamHeader(file)
+	if err != nil {
+		return 0, err
+	}
+	if header == nil {
+		return util.FileSize(filename), nil
+	}
+	return int64(header.size), nil
+}
+
+// UnwrapFile stores the unwrapped form of a stored stream in the unwrapped file, unless
+// it already exists. Returns true if the unwrapped file is available. Encoded streams
+// are unwrapped as they are decoded, so that their plain contents never reach the disk.
+func (codec *StreamCodec) UnwrapFile(filename string, unwrappedFilename string) bool {
+	if codec.IsPlain() {
+		return UnwrapPcap(filename, unwrappedFilename)
+	}
+	if _, err := os.Stat(unwrappedFilename); err == nil {
+		return true
+	}
+
+	reader, _, err := codec.OpenFile(filename)
+	if err != nil {
+		log.WithError(err).WithField("filename", filename).Error("Unable to decode packet stream for unwrapping")
+		return false
+	}
+	defer reader.Close()
+
+	pipeReader, pipeWriter := io.Pipe()
+	unwrapped := make(chan error, 1)
+	go func() {
+		err := UnwrapPcapStream(reader, pipeWriter)
+		pipeWriter.CloseWithError(err)
+		unwrapped <- err
+	}()
+	_, err = codec.WriteFile(unwrappedFilename, pipeReader)
+	pipeReader.Close()
+	if unwrapErr := <-unwrapped; unwrapErr != nil && err == nil {
+		err = unwrapErr
+	}
+	if err != nil {
+		log.WithError(err).WithField("unwrappedFilename", unwrappedFilename).Error("Unable to unwrap packet stream")
+		return false
+	}
+	return true
+}
+
+// ConvertFile re-encodes a stored stream in place if it is not already stored the way
+// this codec stores streams. Returns true if the file was converted.
+func (codec *StreamCodec) ConvertFile(filename string) (bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	header, err := readStreamHeader(file)
+	file.Close()
+	if err != nil || codec.matches(header) {
+		return false, err
+	}
+
+	reader, _, err := codec.OpenFile(filename)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	_, err = codec.WriteFile(filename, reader)
+	return err == nil, err
+}
+
+// ConvertFiles converts every stored stream beneath the directory. Streams that fail
+// to convert are logged and skipped. Returns the number of streams converted.
+func (codec *StreamCodec) ConvertFiles(dir string) (int, error) {
+	count := 0
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !isStreamFilename(path) {
+			return err
+		}
+		converted, convertErr := codec.ConvertFile(path)
+		if convertErr != nil {
+			log.WithError(convertErr).WithField("filename", path).Error("Unable to convert packet stream")
+		} else if converted {
+			log.WithField("filename", path).Info("Converted packet stream")
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func isStreamFilename(filename string) bool {
+	for _, suffix := range streamSuffixes {
+		if strings.HasSuffix(filename, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func segmentNonce(header *streamHeader, index uint64) []byte {
+	nonce := append([]byte(nil), header.nonce...)
+	counter := binary.BigEndian.Uint64(nonce[len(nonce)-8:]) ^ index
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+func segmentAuthenticatedData(header *streamHeader, index uint64, final bool) []byte {
+	data := header.authenticatedData()
+	data = binary.BigEndian.AppendUint64(data, index)
+	if final {
+		return append(data, 1)
+	}
+	return append(data, 0)
+}
+
+// segmentWriter seals the stream in fixed size segments. A full segment is held back
+// until more data arrives, so that the last segment can be marked as final on Close.
+type segmentWriter struct {
+	output io.Writer
+	aead   cipher.AEAD
+	header *streamHeader
+	buffer []byte
+	index  uint64
+}
+
+func (writer *segmentWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		if len(writer.buffer) == streamSegmentSize {
+			if err := writer.seal(false); err != nil {
+				return written, err
+			}
+		}
+		count := copy(writer.buffer[len(writer.buffer):streamSegmentSize], data)
+		writer.buffer = writer.buffer[:len(writer.buffer)+count]
+		data = data[count:]
+		written += count
+	}
+	return written, nil
+}
+
+func (writer *segmentWriter) seal(final bool) error {
+	sealed := writer.aead.Seal(nil, segmentNonce(writer.header, writer.index), writer.buffer, segmentAuthenticatedData(writer.header, writer.index, final))
+	frame := make([]byte, 5)
+	if final {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(sealed)))
+	if _, err := writer.output.Write(append(frame, sealed...)); err != nil {
+		return err
+	}
+	writer.index++
+	writer.buffer = writer.buffer[:0]
+	return nil
+}
+
+func (writer *segmentWriter) Close() error {
+	return writer.seal(true)
+}
+
+type segmentReader struct {
+	input  io.Reader
+	aead   cipher.AEAD
+	header *streamHeader
+	buffer []byte
+	index  uint64
+	done   bool
+}
+
+func (reader *segmentReader) Read(data []byte) (int, error) {
+	for len(reader.buffer) == 0 {
+		if reader.done {
+			return 0, io.EOF
+		}
+		if err := reader.open(); err != nil {
+			return 0, err
+		}
+	}
+	count := copy(data, reader.buffer)
+	reader.buffer = reader.buffer[count:]
+	return count, nil
+}
+
+func (reader *segmentReader) open() error {
+	frame := make([]byte, 5)
+	if _, err := io.ReadFull(reader.input, frame); err != nil {
+		return errors.New("Packet stream is truncated")
+	}
+	length := binary.BigEndian.Uint32(frame[1:])
+	if length > streamSegmentSize+uint32(reader.aead.Overhead()) {
+		return errors.New("Packet stream segment is corrupt")
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(reader.input, sealed); err != nil {
+		return errors.New("Packet stream is truncated")
+	}
+
+	final := frame[0] == 1
+	opened, err := reader.aead.Open(sealed[:0], segmentNonce(reader.header, reader.index), sealed, segmentAuthenticatedData(reader.header, reader.index, final))
+	if err != nil {
+		return errors.New("Packet stream failed authentication")
+	}
+	reader.buffer = opened
+	reader.index++
+	reader.done = final
+	return nil
+}
+
+type streamReader struct {
+	io.Reader
+	file    *os.File
+	decoder *zstd.Decoder
+}
+
+func (reader *streamReader) Close() error {
+	if reader.decoder != nil {
+		reader.decoder.Close()
+	}
+	if reader.file == nil {
+		return nil
+	}
+	return reader.file.Close()
+}
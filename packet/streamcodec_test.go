@@ -0,0 +1,170 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, 32)
+}
+
+func readStream(tester *testing.T, codec *StreamCodec, filename string) ([]byte, int64) {
+	reader, size, err := codec.OpenFile(filename)
+	if !assert.NoError(tester, err) {
+		return nil, 0
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	assert.NoError(tester, err)
+	return data, size
+}
+
+func TestParseStreamKey(tester *testing.T) {
+	key, err := ParseStreamKey("")
+	assert.NoError(tester, err)
+	assert.Nil(tester, key)
+
+	key, err = ParseStreamKey(base64.StdEncoding.EncodeToString(testKey(1)))
+	assert.NoError(tester, err)
+	assert.Equal(tester, testKey(1), key)
+
+	_, err = ParseStreamKey("not base64!")
+	assert.EqualError(tester, err, "Packet stream encryption key must be base64 encoded")
+
+	_, err = NewStreamCodec(false, []byte("short"))
+	assert.Error(tester, err)
+}
+
+func TestStreamCodecRoundTrip(tester *testing.T) {
+	// Large enough to span several encrypted segments
+	data := bytes.Repeat(buildPcap(tester, 1, 2, 3), 3000)
+
+	for _, compress := range []bool{false, true} {
+		for _, key := range [][]byte{nil, testKey(1)} {
+			codec, err := NewStreamCodec(compress, key)
+			assert.NoError(tester, err)
+			filename := filepath.Join(tester.TempDir(), "1.bin")
+
+			count, err := codec.WriteFile(filename, bytes.NewReader(data))
+			assert.NoError(tester, err)
+			assert.Equal(tester, int64(len(data)), count)
+
+			stored, _ := os.ReadFile(filename)
+			assert.Equal(tester, codec.IsPlain(), bytes.Equal(data, stored))
+			if compress {
+				assert.Less(tester, len(stored), len(data))
+			}
+
+			actual, size := readStream(tester, codec, filename)
+			assert.Equal(tester, data, actual)
+			assert.Equal(tester, int64(len(data)), size)
+
+			size, err = codec.StreamSize(filename)
+			assert.NoError(tester, err)
+			assert.Equal(tester, int64(len(data)), size)
+		}
+	}
+}
+
+func TestStreamCodecEmpty(tester *testing.T) {
+	codec, _ := NewStreamCodec(true, testKey(1))
+	filename := filepath.Join(tester.TempDir(), "1.bin")
+	_, err := codec.WriteFile(filename, bytes.NewReader(nil))
+	assert.NoError(tester, err)
+
+	data, size := readStream(tester, codec, filename)
+	assert.Empty(tester, data)
+	assert.Equal(tester, int64(0), size)
+}
+
+func TestStreamCodecWrongKey(tester *testing.T) {
+	codec, _ := NewStreamCodec(false, testKey(1))
+	filename := filepath.Join(tester.TempDir(), "1.bin")
+	codec.WriteFile(filename, bytes.NewReader(buildPcap(tester, 1)))
+
+	other, _ := NewStreamCodec(false, testKey(2))
+	_, _, err := other.OpenFile(filename)
+	assert.EqualError(tester, err, "Packet stream is encrypted with a different key")
+
+	_, _, err = (&StreamCodec{}).OpenFile(filename)
+	assert.EqualError(tester, err, "Packet stream is encrypted but no encryption key is configured")
+}
+
+func TestStreamCodecTampered(tester *testing.T) {
+	codec, _ := NewStreamCodec(false, testKey(1))
+	data := bytes.Repeat(buildPcap(tester, 1), streamSegmentSize/10)
+	filename := filepath.Join(tester.TempDir(), "1.bin")
+	codec.WriteFile(filename, bytes.NewReader(data))
+	stored, _ := os.ReadFile(filename)
+
+	tampered := append([]byte(nil), stored...)
+	tampered[streamHeaderSize+20] ^= 0xFF
+	os.WriteFile(filename, tampered, 0644)
+	reader, _, err := codec.OpenFile(filename)
+	if assert.NoError(tester, err) {
+		_, err = io.ReadAll(reader)
+		assert.EqualError(tester, err, "Packet stream failed authentication")
+		reader.Close()
+	}
+
+	// Dropping the final segment must not go unnoticed
+	firstSegment := streamHeaderSize + 5 + streamSegmentSize + codec.aead.Overhead()
+	os.WriteFile(filename, stored[:firstSegment], 0644)
+	reader, _, err = codec.OpenFile(filename)
+	if assert.NoError(tester, err) {
+		_, err = io.ReadAll(reader)
+		assert.EqualError(tester, err, "Packet stream is truncated")
+		reader.Close()
+	}
+}
+
+func TestStreamCodecConvertFiles(tester *testing.T) {
+	dir := tester.TempDir()
+	data := buildPcap(tester, 1, 2)
+	os.MkdirAll(filepath.Join(dir, "node1"), os.ModePerm)
+	os.WriteFile(filepath.Join(dir, "node1", "1.bin"), data, 0644)
+	os.WriteFile(filepath.Join(dir, "node1", "1.bin.unwrapped"), data, 0644)
+	os.WriteFile(filepath.Join(dir, "node1", "1.json"), []byte("{}"), 0644)
+
+	codec, _ := NewStreamCodec(true, testKey(1))
+	count, err := codec.ConvertFiles(dir)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 2, count)
+
+	actual, _ := readStream(tester, codec, filepath.Join(dir, "node1", "1.bin"))
+	assert.Equal(tester, data, actual)
+	actual, _ = readStream(tester, codec, filepath.Join(dir, "node1", "1.bin.unwrapped"))
+	assert.Equal(tester, data, actual)
+	json, _ := os.ReadFile(filepath.Join(dir, "node1", "1.json"))
+	assert.Equal(tester, "{}", string(json))
+
+	count, err = codec.ConvertFiles(dir)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 0, count)
+
+	// Streams encrypted with another key cannot be converted
+	other, _ := NewStreamCodec(false, testKey(2))
+	count, err = other.ConvertFiles(dir)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 0, count)
+
+	// Streams can be re-encoded with different settings using the same key
+	uncompressed, _ := NewStreamCodec(false, testKey(1))
+	count, err = uncompressed.ConvertFiles(dir)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 2, count)
+	actual, _ = readStream(tester, uncompressed, filepath.Join(dir, "node1", "1.bin"))
+	assert.Equal(tester, data, actual)
+}
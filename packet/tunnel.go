@@ -0,0 +1,212 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"encoding/binary"
+	"slices"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+const maxTunnelDepth = 16
+
+const ethernetTypeErspanIII layers.EthernetType = 0x22eb
+
+// unwrapPacket strips every recognized encapsulation from the packet, outermost first,
+// returning the innermost Ethernet frame. Tunnels carrying IP rather than Ethernet are
+// given an Ethernet header built from the outer frame's addresses. The stripped
+// encapsulations are recorded on the model packet, if one is given.
+func unwrapPacket(pcapPacket gopacket.Packet, packet *model.Packet) gopacket.Packet {
+	for depth := 0; depth < maxTunnelDepth; depth++ {
+		frame, tunnels := decapsulate(pcapPacket)
+		if len(frame) == 0 {
+			break
+		}
+
+		oldData := pcapPacket.Metadata()
+		pcapPacket = gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+		newData := pcapPacket.Metadata()
+		newData.Timestamp = oldData.Timestamp
+		newData.InterfaceIndex = oldData.InterfaceIndex
+		newData.CaptureLength = len(frame)
+		newData.Length = newData.CaptureLength
+
+		if packet != nil {
+			for _, tunnel := range tunnels {
+				if !slices.Contains(packet.Flags, tunnel.Type) {
+					packet.Flags = append(packet.Flags, tunnel.Type)
+				}
+				packet.Tunnels = append(packet.Tunnels, tunnel)
+			}
+		}
+	}
+	return pcapPacket
+}
+
+// decapsulate returns the frame carried by the outermost encapsulation in the packet,
+// along with a description of each header stripped to reach it. Returns nil if the
+// packet is not encapsulated.
+func decapsulate(pcapPacket gopacket.Packet) ([]byte, []*model.PacketTunnel) {
+	var ethernet *layers.Ethernet
+	var network gopacket.NetworkLayer
+	var udp *layers.UDP
+
+	packetLayers := pcapPacket.Layers()
+	for index, layer := range packetLayers {
+		switch typed := layer.(type) {
+		case *layers.Ethernet:
+			ethernet = typed
+		case *layers.IPv4:
+			network = typed
+		case *layers.IPv6:
+			network = typed
+		case *layers.UDP:
+			udp = typed
+		case *layers.Dot1Q:
+			tunnel := newTunnel(model.PacketTunnelVlan, int(typed.VLANIdentifier), ethernet, network, nil)
+			return encapsulatedFrame(ethernet, typed.Type, typed.Payload), []*model.PacketTunnel{tunnel}
+		case *layers.MPLS:
+			return decapsulateMpls(packetLayers[index:], ethernet)
+		case *layers.GRE:
+			return decapsulateGre(typed, ethernet, network)
+		case *layers.VXLAN:
+			tunnel := newTunnel(model.PacketTunnelVxlan, int(typed.VNI), ethernet, network, udp)
+			return typed.Payload, []*model.PacketTunnel{tunnel}
+		case *layers.Geneve:
+			tunnel := newTunnel(model.PacketTunnelGeneve, int(typed.VNI), ethernet, network, udp)
+			return encapsulatedFrame(ethernet, typed.Protocol, typed.Payload), []*model.PacketTunnel{tunnel}
+		}
+	}
+	return nil, nil
+}
+
+func newTunnel(tunnelType string, id int, ethernet *layers.Ethernet, network gopacket.NetworkLayer, udp *layers.UDP) *model.PacketTunnel {
+	tunnel := &model.PacketTunnel{
+		Type: tunnelType,
+		Id:   id,
+	}
+	if ethernet != nil {
+		tunnel.SrcMac = ethernet.SrcMAC.String()
+		tunnel.DstMac = ethernet.DstMAC.String()
+	}
+	if network != nil {
+		tunnel.SrcIp = network.NetworkFlow().Src().String()
+		tunnel.DstIp = network.NetworkFlow().Dst().String()
+	}
+	if udp != nil {
+		tunnel.SrcPort = int(udp.SrcPort)
+		tunnel.DstPort = int(udp.DstPort)
+	}
+	return tunnel
+}
+
+// encapsulatedFrame returns the payload of an encapsulation as an Ethernet frame. Bridged
+// Ethernet payloads are returned as is, while others are given an Ethernet header of the
+// given type, addressed as the outer frame was.
+func encapsulatedFrame(outer *layers.Ethernet, etherType layers.EthernetType, payload []byte) []byte {
+	if len(payload) == 0 {
+		return nil
+	}
+	if etherType == layers.EthernetTypeTransparentEthernetBridging {
+		return payload
+	}
+
+	frame := make([]byte, 14+len(payload))
+	if outer != nil {
+		copy(frame[0:6], outer.DstMAC)
+		copy(frame[6:12], outer.SrcMAC)
+	}
+	binary.BigEndian.PutUint16(frame[12:14], uint16(etherType))
+	copy(frame[14:], payload)
+	return frame
+}
+
+// decapsulateMpls strips the MPLS label stack beginning at the first of the given
+// layers. The payload beneath the stack is recognized by its first nibble as IPv4, IPv6
+// or an Ethernet pseudowire led by a control word.
+func decapsulateMpls(stack []gopacket.Layer, ethernet *layers.Ethernet) ([]byte, []*model.PacketTunnel) {
+	tunnels := make([]*model.PacketTunnel, 0)
+	var payload []byte
+	for _, layer := range stack {
+		mpls, ok := layer.(*layers.MPLS)
+		if !ok {
+			break
+		}
+		tunnels = append(tunnels, newTunnel(model.PacketTunnelMpls, int(mpls.Label), ethernet, nil, nil))
+		if mpls.StackBottom {
+			payload = mpls.Payload
+			break
+		}
+	}
+
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	switch payload[0] >> 4 {
+	case 4:
+		return encapsulatedFrame(ethernet, layers.EthernetTypeIPv4, payload), tunnels
+	case 6:
+		return encapsulatedFrame(ethernet, layers.EthernetTypeIPv6, payload), tunnels
+	case 0:
+		if len(payload) > 4 {
+			return payload[4:], tunnels
+		}
+	}
+	return nil, nil
+}
+
+// decapsulateGre strips a GRE header, along with the ERSPAN header of mirrored traffic.
+func decapsulateGre(gre *layers.GRE, ethernet *layers.Ethernet, network gopacket.NetworkLayer) ([]byte, []*model.PacketTunnel) {
+	key := 0
+	if gre.KeyPresent {
+		key = int(gre.Key)
+	}
+	tunnels := []*model.PacketTunnel{newTunnel(model.PacketTunnelGre, key, ethernet, network, nil)}
+
+	switch gre.Protocol {
+	case layers.EthernetTypeERSPAN, ethernetTypeErspanIII:
+		frame, erspan := decapsulateErspan(gre, ethernet, network)
+		if erspan == nil {
+			return nil, nil
+		}
+		return frame, append(tunnels, erspan)
+	}
+	return encapsulatedFrame(ethernet, gre.Protocol, gre.Payload), tunnels
+}
+
+// decapsulateErspan strips the ERSPAN header from a GRE payload. Type I has no header and
+// is distinguished from type II by the absence of a GRE sequence number. Type III headers
+// may be followed by an optional platform specific subheader.
+func decapsulateErspan(gre *layers.GRE, ethernet *layers.Ethernet, network gopacket.NetworkLayer) ([]byte, *model.PacketTunnel) {
+	data := gre.Payload
+	erspan := newTunnel(model.PacketTunnelErspan, 0, ethernet, network, nil)
+
+	headerLength := 0
+	switch {
+	case gre.Protocol == layers.EthernetTypeERSPAN && !gre.SeqPresent:
+		erspan.Version = 1
+	case gre.Protocol == layers.EthernetTypeERSPAN:
+		erspan.Version = 2
+		headerLength = 8
+	default:
+		erspan.Version = 3
+		headerLength = 12
+		if len(data) >= headerLength && data[11]&0x01 != 0 {
+			headerLength += 8
+		}
+	}
+
+	if len(data) <= headerLength {
+		return nil, nil
+	}
+	if headerLength > 0 {
+		erspan.Id = int(binary.BigEndian.Uint16(data[2:4]) & 0x03ff)
+	}
+	return data[headerLength:], erspan
+}
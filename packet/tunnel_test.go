@@ -0,0 +1,312 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+var tapSrcMac = net.HardwareAddr{2, 0, 0, 0, 0, 1}
+var tapDstMac = net.HardwareAddr{2, 0, 0, 0, 0, 2}
+
+func innerFrame(tester *testing.T) []byte {
+	return serializeTcp(tester, testSegment{fromClient: true, clientPort: 40000, seq: 1, flags: "A", payload: "inner"})
+}
+
+// innerIp returns the IPv4 packet of the inner frame, without its Ethernet header.
+func innerIp(tester *testing.T) []byte {
+	frame := innerFrame(tester)
+	return frame[14 : 14+int(binary.BigEndian.Uint16(frame[16:18]))]
+}
+
+func outerFrame(etherType layers.EthernetType, payload []byte) []byte {
+	frame := make([]byte, 14, 14+len(payload))
+	copy(frame[0:6], tapDstMac)
+	copy(frame[6:12], tapSrcMac)
+	binary.BigEndian.PutUint16(frame[12:14], uint16(etherType))
+	return append(frame, payload...)
+}
+
+func outerIp(tester *testing.T, protocol layers.IPProtocol, payload []byte) []byte {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: protocol,
+		SrcIP:    net.IP{192, 168, 1, 1},
+		DstIP:    net.IP{192, 168, 1, 2},
+	}
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	assert.NoError(tester, gopacket.SerializeLayers(buffer, options, ip, gopacket.Payload(payload)))
+	return outerFrame(layers.EthernetTypeIPv4, buffer.Bytes())
+}
+
+func outerUdp(tester *testing.T, port int, payload []byte) []byte {
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], 50000)
+	binary.BigEndian.PutUint16(udp[2:4], uint16(port))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(payload)))
+	return outerIp(tester, layers.IPProtocolUDP, append(udp, payload...))
+}
+
+func greHeader(flags uint16, protocol layers.EthernetType, fields ...uint32) []byte {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint16(header[0:2], flags)
+	binary.BigEndian.PutUint16(header[2:4], uint16(protocol))
+	for _, field := range fields {
+		header = binary.BigEndian.AppendUint32(header, field)
+	}
+	return header
+}
+
+func mplsLabel(label uint32, bottom bool) []byte {
+	entry := label<<12 | 64
+	if bottom {
+		entry |= 0x100
+	}
+	return binary.BigEndian.AppendUint32(nil, entry)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func parseTunneled(tester *testing.T, frame []byte) *model.Packet {
+	pcapPacket := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+	pcapPacket.Metadata().Timestamp = time.Unix(10, 0)
+	packet := model.NewPacket(1)
+	parseData(pcapPacket, packet, true)
+
+	assert.Equal(tester, "10.0.0.1", packet.SrcIp)
+	assert.Equal(tester, "10.0.0.2", packet.DstIp)
+	assert.Equal(tester, 40000, packet.SrcPort)
+	assert.Equal(tester, 80, packet.DstPort)
+	assert.Equal(tester, int64(10), packet.Timestamp.Unix())
+	return packet
+}
+
+func TestUnwrapVxlan(tester *testing.T) {
+	vxlan := []byte{0x08, 0, 0, 0, 0, 0, 42, 0}
+	packet := parseTunneled(tester, outerUdp(tester, 4789, concat(vxlan, innerFrame(tester))))
+
+	assert.Equal(tester, []*model.PacketTunnel{{
+		Type:    model.PacketTunnelVxlan,
+		Id:      42,
+		SrcMac:  tapSrcMac.String(),
+		DstMac:  tapDstMac.String(),
+		SrcIp:   "192.168.1.1",
+		DstIp:   "192.168.1.2",
+		SrcPort: 50000,
+		DstPort: 4789,
+	}}, packet.Tunnels)
+	assert.Contains(tester, packet.Flags, "VXLAN")
+	assert.Equal(tester, "00:01:02:03:04:05", packet.SrcMac)
+}
+
+func TestUnwrapGeneve(tester *testing.T) {
+	geneve := []byte{0, 0, 0x65, 0x58, 0, 0, 7, 0}
+	packet := parseTunneled(tester, outerUdp(tester, 6081, concat(geneve, innerFrame(tester))))
+
+	if assert.Len(tester, packet.Tunnels, 1) {
+		assert.Equal(tester, model.PacketTunnelGeneve, packet.Tunnels[0].Type)
+		assert.Equal(tester, 7, packet.Tunnels[0].Id)
+		assert.Equal(tester, 6081, packet.Tunnels[0].DstPort)
+	}
+}
+
+func TestUnwrapGreIp(tester *testing.T) {
+	gre := greHeader(0x2000, layers.EthernetTypeIPv4, 99)
+	packet := parseTunneled(tester, outerIp(tester, layers.IPProtocolGRE, concat(gre, innerIp(tester))))
+
+	if assert.Len(tester, packet.Tunnels, 1) {
+		assert.Equal(tester, model.PacketTunnelGre, packet.Tunnels[0].Type)
+		assert.Equal(tester, 99, packet.Tunnels[0].Id)
+		assert.Equal(tester, "192.168.1.1", packet.Tunnels[0].SrcIp)
+	}
+	// The synthesized Ethernet header keeps the outer addresses
+	assert.Equal(tester, tapSrcMac.String(), packet.SrcMac)
+	assert.Equal(tester, tapDstMac.String(), packet.DstMac)
+}
+
+func TestUnwrapErspan(tester *testing.T) {
+	tests := []struct {
+		name    string
+		gre     []byte
+		header  []byte
+		version int
+		session int
+	}{
+		{"type I", greHeader(0, layers.EthernetTypeERSPAN), nil, 1, 0},
+		{"type II", greHeader(0x1000, layers.EthernetTypeERSPAN, 5), []byte{0x10, 0, 0, 100, 0, 0, 0, 1}, 2, 100},
+		{"type III", greHeader(0x1000, ethernetTypeErspanIII, 5), []byte{0x20, 0, 0x01, 0x2c, 0, 0, 0, 1, 0, 0, 0, 0}, 3, 300},
+		{"type III with subheader", greHeader(0x1000, ethernetTypeErspanIII, 5), []byte{0x20, 0, 0, 7, 0, 0, 0, 1, 0, 0, 0, 1, 1, 2, 3, 4, 5, 6, 7, 8}, 3, 7},
+	}
+
+	for _, test := range tests {
+		tester.Run(test.name, func(tester *testing.T) {
+			packet := parseTunneled(tester, outerIp(tester, layers.IPProtocolGRE, concat(test.gre, test.header, innerFrame(tester))))
+			if assert.Len(tester, packet.Tunnels, 2) {
+				assert.Equal(tester, model.PacketTunnelGre, packet.Tunnels[0].Type)
+				assert.Equal(tester, model.PacketTunnelErspan, packet.Tunnels[1].Type)
+				assert.Equal(tester, test.version, packet.Tunnels[1].Version)
+				assert.Equal(tester, test.session, packet.Tunnels[1].Id)
+				assert.Equal(tester, "192.168.1.2", packet.Tunnels[1].DstIp)
+			}
+			assert.Equal(tester, []string{"GRE", "ERSPAN", "PSH", "ACK"}, packet.Flags)
+		})
+	}
+}
+
+func TestUnwrapMpls(tester *testing.T) {
+	packet := parseTunneled(tester, outerFrame(layers.EthernetTypeMPLSUnicast, concat(mplsLabel(100, false), mplsLabel(200, true), innerIp(tester))))
+	if assert.Len(tester, packet.Tunnels, 2) {
+		assert.Equal(tester, 100, packet.Tunnels[0].Id)
+		assert.Equal(tester, 200, packet.Tunnels[1].Id)
+		assert.Equal(tester, model.PacketTunnelMpls, packet.Tunnels[1].Type)
+	}
+	assert.Equal(tester, []string{"MPLS", "PSH", "ACK"}, packet.Flags)
+
+	// Ethernet pseudowire with a control word
+	packet = parseTunneled(tester, outerFrame(layers.EthernetTypeMPLSUnicast, concat(mplsLabel(300, true), []byte{0, 0, 0, 0}, innerFrame(tester))))
+	if assert.Len(tester, packet.Tunnels, 1) {
+		assert.Equal(tester, 300, packet.Tunnels[0].Id)
+	}
+	assert.Equal(tester, "00:01:02:03:04:05", packet.SrcMac)
+}
+
+func TestUnwrapQinQ(tester *testing.T) {
+	inner := innerIp(tester)
+	tags := []byte{0x00, 0x64, 0x81, 0x00, 0x00, 0xc8, 0x08, 0x00}
+	packet := parseTunneled(tester, outerFrame(layers.EthernetTypeQinQ, concat(tags, inner)))
+	if assert.Len(tester, packet.Tunnels, 2) {
+		assert.Equal(tester, model.PacketTunnelVlan, packet.Tunnels[0].Type)
+		assert.Equal(tester, 100, packet.Tunnels[0].Id)
+		assert.Equal(tester, 200, packet.Tunnels[1].Id)
+	}
+	assert.Equal(tester, []string{"VLAN", "PSH", "ACK"}, packet.Flags)
+}
+
+func TestUnwrapNested(tester *testing.T) {
+	vxlan := []byte{0x08, 0, 0, 0, 0, 0, 42, 0}
+	taggedInner := outerFrame(layers.EthernetTypeDot1Q, concat([]byte{0x00, 0x0a, 0x08, 0x00}, innerIp(tester)))
+	outer := outerUdp(tester, 4789, concat(vxlan, taggedInner))
+	outer = concat(outer[:12], []byte{0x81, 0x00, 0x00, 0x05}, outer[12:])
+
+	packet := parseTunneled(tester, outer)
+	types := make([]string, 0)
+	ids := make([]int, 0)
+	for _, tunnel := range packet.Tunnels {
+		types = append(types, tunnel.Type)
+		ids = append(ids, tunnel.Id)
+	}
+	assert.Equal(tester, []string{"VLAN", "VXLAN", "VLAN"}, types)
+	assert.Equal(tester, []int{5, 42, 10}, ids)
+	assert.Equal(tester, []string{"VLAN", "VXLAN", "PSH", "ACK"}, packet.Flags)
+}
+
+func TestUnwrapNotTunneled(tester *testing.T) {
+	pcapPacket := gopacket.NewPacket(innerFrame(tester), layers.LayerTypeEthernet, gopacket.Default)
+	packet := model.NewPacket(1)
+	assert.Same(tester, pcapPacket, unwrapPacket(pcapPacket, packet))
+	assert.Nil(tester, packet.Tunnels)
+
+	// Truncated encapsulations are left in place
+	truncated := outerIp(tester, layers.IPProtocolGRE, greHeader(0x1000, layers.EthernetTypeERSPAN, 5))
+	pcapPacket = gopacket.NewPacket(truncated, layers.LayerTypeEthernet, gopacket.Default)
+	assert.Same(tester, pcapPacket, unwrapPacket(pcapPacket, packet))
+}
+
+func buildTunnelPcapNg(tester *testing.T) ([]byte, int) {
+	var buf bytes.Buffer
+	writer, err := pcapgo.NewNgWriter(&buf, layers.LinkTypeEthernet)
+	assert.NoError(tester, err)
+	frames := [][]byte{
+		outerIp(tester, layers.IPProtocolGRE, concat(greHeader(0, layers.EthernetTypeIPv4), innerIp(tester))),
+		outerFrame(layers.EthernetTypeMPLSUnicast, concat(mplsLabel(100, true), innerIp(tester))),
+	}
+	for idx, frame := range frames {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(idx), 0), CaptureLength: len(frame), Length: len(frame)}
+		assert.NoError(tester, writer.WritePacket(ci, frame))
+	}
+	assert.NoError(tester, writer.Flush())
+	return buf.Bytes(), len(frames)
+}
+
+func assertUnwrappedTunnels(tester *testing.T, data []byte, count int) {
+	capture, err := newCaptureReader(bytes.NewReader(data))
+	assert.NoError(tester, err)
+	for idx := 0; idx < count; idx++ {
+		frame, ci, err := capture.ReadPacketData()
+		if assert.NoError(tester, err) {
+			unwrapped := capture.decode(frame, ci, gopacket.Default)
+			tcp, _ := unwrapped.Layer(layers.LayerTypeTCP).(*layers.TCP)
+			if assert.NotNil(tester, tcp) {
+				assert.Equal(tester, layers.TCPPort(40000), tcp.SrcPort)
+			}
+			assert.Nil(tester, unwrapped.Layer(layers.LayerTypeGRE))
+			assert.Nil(tester, unwrapped.Layer(layers.LayerTypeMPLS))
+		}
+	}
+}
+
+func TestUnwrapPcapTunnels(tester *testing.T) {
+	dir := tester.TempDir()
+	filename := filepath.Join(dir, "tunnels.bin")
+	unwrappedFilename := filename + ".unwrapped"
+	data, count := buildTunnelPcapNg(tester)
+	assert.NoError(tester, os.WriteFile(filename, data, 0600))
+
+	assert.True(tester, UnwrapPcap(filename, unwrappedFilename))
+	unwrapped, err := os.ReadFile(unwrappedFilename)
+	assert.NoError(tester, err)
+	assertUnwrappedTunnels(tester, unwrapped, count)
+}
+
+func TestUnwrapPcapStream(tester *testing.T) {
+	data, count := buildTunnelPcapNg(tester)
+	var unwrapped bytes.Buffer
+	assert.NoError(tester, UnwrapPcapStream(bytes.NewReader(data), &unwrapped))
+	assert.True(tester, IsPcapNg(unwrapped.Bytes()))
+	assertUnwrappedTunnels(tester, unwrapped.Bytes(), count)
+
+	assert.Error(tester, UnwrapPcapStream(bytes.NewReader([]byte("nope")), &unwrapped))
+}
+
+func TestUnwrapFileEncoded(tester *testing.T) {
+	dir := tester.TempDir()
+	filename := filepath.Join(dir, "tunnels.bin")
+	unwrappedFilename := filename + ".unwrapped"
+	codec, _ := NewStreamCodec(true, bytes.Repeat([]byte{1}, 32))
+	data, count := buildTunnelPcapNg(tester)
+	_, err := codec.WriteFile(filename, bytes.NewReader(data))
+	assert.NoError(tester, err)
+
+	assert.True(tester, codec.UnwrapFile(filename, unwrappedFilename))
+	files, _ := os.ReadDir(dir)
+	assert.Len(tester, files, 2)
+
+	reader, _, err := codec.OpenFile(unwrappedFilename)
+	if assert.NoError(tester, err) {
+		unwrapped, _ := io.ReadAll(reader)
+		reader.Close()
+		assertUnwrappedTunnels(tester, unwrapped, count)
+	}
+
+	assert.False(tester, codec.UnwrapFile(filepath.Join(dir, "missing.bin"), filepath.Join(dir, "missing.bin.unwrapped")))
+}
@@ -9,6 +9,7 @@ package server
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/security-onion-solutions/securityonion-soc/model"
 )
@@ -21,12 +22,31 @@ type Datastore interface {
 	GetNextJob(ctx context.Context, nodeId string) *model.Job
 	CreateJob(ctx context.Context) *model.Job
 	GetJob(ctx context.Context, jobId int) *model.Job
-	GetJobs(ctx context.Context, kind string, parameters map[string]interface{}) []*model.Job
+	GetJobs(ctx context.Context, query *model.JobQuery) (*model.JobPage, error)
 	AddJob(ctx context.Context, job *model.Job) error
 	AddPivotJob(ctx context.Context, job *model.Job) error
 	UpdateJob(ctx context.Context, job *model.Job) error
 	DeleteJob(ctx context.Context, jobId int) (*model.Job, error)
+	CancelJob(ctx context.Context, jobId int) (*model.Job, error)
+	UpdateJobProgress(ctx context.Context, jobId int, progress *model.JobProgress) (*model.Job, error)
+	SetJobPriority(ctx context.Context, jobId int, priority int) (*model.Job, error)
+	RenewJobLease(ctx context.Context, jobId int) (*model.Job, error)
+	ExpireJobLeases(ctx context.Context) ([]*model.Job, error)
 	GetPackets(ctx context.Context, jobId int, offset int, count int, unwrap bool) ([]*model.Packet, error)
+	MergePacketStreams(ctx context.Context, jobId int, sourceJobIds []int) (int, error)
 	SavePacketStream(ctx context.Context, jobId int, reader io.ReadCloser) error
-	GetPacketStream(ctx context.Context, jobId int, unwrap bool) (io.ReadCloser, string, int64, error)
+	GetPacketStreamUpload(ctx context.Context, jobId int) (*model.StreamUpload, error)
+	AppendPacketStreamChunk(ctx context.Context, jobId int, offset int64, checksum string, reader io.Reader) (*model.StreamUpload, error)
+	CompletePacketStreamUpload(ctx context.Context, jobId int, size int64, checksum string) error
+	GetPacketStream(ctx context.Context, jobId int, unwrap bool, format string) (io.ReadCloser, string, int64, error)
+	GetTcpStream(ctx context.Context, jobId int, index int, unwrap bool, maxBytes int) (*model.TcpStream, error)
+	GetCarvedFiles(ctx context.Context, jobId int, unwrap bool, maxBytes int, retained func(index int) bool) ([]*model.CarvedFile, error)
+	GetPacketStatistics(ctx context.Context, jobId int, unwrap bool, limit int) (*model.PacketStatistics, error)
+	GetFingerprints(ctx context.Context, jobId int, unwrap bool) (*model.FingerprintSummary, error)
+	GetJobSchedules(ctx context.Context) []*model.JobSchedule
+	GetJobSchedule(ctx context.Context, scheduleId string) *model.JobSchedule
+	AddJobSchedule(ctx context.Context, schedule *model.JobSchedule) error
+	UpdateJobSchedule(ctx context.Context, schedule *model.JobSchedule) (*model.JobSchedule, error)
+	DeleteJobSchedule(ctx context.Context, scheduleId string) (*model.JobSchedule, error)
+	RecordJobScheduleRun(ctx context.Context, scheduleId string, run *model.JobScheduleRun, nextRunTime time.Time) (*model.JobSchedule, error)
 }
@@ -0,0 +1,197 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+// JobFanout splits a parent PCAP job targeting several nodes into one child job per
+// node, and completes the parent with the merged child streams once every child has
+// settled.
+type JobFanout struct {
+	server *Server
+}
+
+func NewJobFanout(srv *Server) *JobFanout {
+	return &JobFanout{
+		server: srv,
+	}
+}
+
+// resolveNodeIds expands the requested node ids against the known nodes. The
+// JobAllNodes wildcard selects every node with PCAP. Duplicates are removed.
+func resolveNodeIds(nodes []*model.Node, requested []string) []string {
+	nodeIds := make([]string, 0, len(requested))
+	add := func(nodeId string) {
+		nodeId = strings.ToLower(nodeId)
+		if nodeId != "" && !slices.Contains(nodeIds, nodeId) {
+			nodeIds = append(nodeIds, nodeId)
+		}
+	}
+
+	for _, nodeId := range requested {
+		if nodeId == model.JobAllNodes {
+			for _, node := range nodes {
+				if node.HasPcap() {
+					add(node.Id)
+				}
+			}
+		} else {
+			add(nodeId)
+		}
+	}
+	return nodeIds
+}
+
+// AddJobs stores the parent job along with a child job for each of the parent's
+// target nodes. Returns the created child jobs.
+func (fanout *JobFanout) AddJobs(ctx context.Context, parent *model.Job) ([]*model.Job, error) {
+	nodeIds := resolveNodeIds(fanout.server.Datastore.GetNodes(ctx), parent.NodeIds)
+	if len(nodeIds) == 0 {
+		return nil, errors.New("No nodes available for job")
+	}
+
+	parent.NodeId = ""
+	parent.LegacySensorId = ""
+	parent.NodeIds = nodeIds
+	parent.ChildIds = make([]int, 0, len(nodeIds))
+
+	children := make([]*model.Job, 0, len(nodeIds))
+	for _, nodeId := range nodeIds {
+		child := fanout.server.Datastore.CreateJob(ctx)
+		child.SetNodeId(nodeId)
+		child.Kind = parent.Kind
+		child.Filter = parent.Filter
+		child.Priority = parent.Priority
+		child.ParentId = parent.Id
+		parent.ChildIds = append(parent.ChildIds, child.Id)
+		children = append(children, child)
+	}
+
+	stored := make([]*model.Job, 0, len(children)+1)
+	err := fanout.server.Datastore.AddJob(ctx, parent)
+	if err == nil {
+		stored = append(stored, parent)
+		for _, child := range children {
+			if err = fanout.server.Datastore.AddJob(ctx, child); err != nil {
+				break
+			}
+			stored = append(stored, child)
+		}
+	}
+
+	if err != nil {
+		fanout.rollback(ctx, stored)
+	} else {
+		log.WithFields(log.Fields{
+			"jobId":    parent.Id,
+			"childIds": parent.ChildIds,
+			"nodeIds":  nodeIds,
+		}).Info("Created child jobs for parent job")
+		fanout.settleReusedChildren(parent, children)
+	}
+	return children, err
+}
+
+// rollback deletes the jobs stored so far when the parent could not be fanned out to all
+// of its nodes, so that no parent is left waiting on children that don't exist.
+func (fanout *JobFanout) rollback(ctx context.Context, stored []*model.Job) {
+	for idx := len(stored) - 1; idx >= 0; idx-- {
+		if _, err := fanout.server.Datastore.DeleteJob(ctx, stored[idx].Id); err != nil {
+			log.WithError(err).WithField("jobId", stored[idx].Id).Error("Failed to roll back job")
+		}
+	}
+}
+
+// settleReusedChildren settles the parent right away if its children were satisfied
+// from the results of previous jobs, since no agent will report back on those children.
+func (fanout *JobFanout) settleReusedChildren(parent *model.Job, children []*model.Job) {
+	for _, child := range children {
+		if child.Status == model.JobStatusCompleted {
+			settled, err := fanout.ChildUpdated(fanout.server.Context, child)
+			if err != nil {
+				log.WithError(err).WithField("jobId", parent.Id).Error("Failed to settle parent job")
+			} else if settled != nil {
+				*parent = *settled
+			}
+			return
+		}
+	}
+}
+
+// settleParent decides the parent's outcome from its children. Returns false while any
+// child is still waiting to be processed, including children retrying after a failure. Otherwise returns the ids of the completed
+// children whose streams should be merged into the parent.
+func settleParent(children []*model.Job) (bool, []int) {
+	completedIds := make([]int, 0, len(children))
+	for _, child := range children {
+		if child.CanProcess() {
+			return false, nil
+		}
+		if child.Status == model.JobStatusCompleted {
+			completedIds = append(completedIds, child.Id)
+		}
+	}
+	return true, completedIds
+}
+
+// ChildUpdated is called after a child job has been updated by an agent. Once all of
+// the parent's children have either completed, failed or been cancelled, the parent
+// is completed with the merged streams of the completed children. Children that failed
+// are waited on while they retry. Returns the updated parent, or nil if the parent
+// did not change.
+func (fanout *JobFanout) ChildUpdated(ctx context.Context, child *model.Job) (*model.Job, error) {
+	parent := fanout.server.Datastore.GetJob(ctx, child.ParentId)
+	if parent == nil || !parent.CanProcess() {
+		return nil, nil
+	}
+
+	children := make([]*model.Job, 0, len(parent.ChildIds))
+	for _, childId := range parent.ChildIds {
+		if childId == child.Id {
+			children = append(children, child)
+		} else if sibling := fanout.server.Datastore.GetJob(ctx, childId); sibling != nil {
+			children = append(children, sibling)
+		}
+	}
+
+	settled, completedIds := settleParent(children)
+	if !settled {
+		return nil, nil
+	}
+
+	var err error
+	if len(completedIds) > 0 {
+		var size int
+		size, err = fanout.server.Datastore.MergePacketStreams(ctx, parent.Id, completedIds)
+		if err == nil {
+			parent.Size = size
+			parent.Complete()
+		} else {
+			parent.Fail(err)
+		}
+	} else if parent.IsCancelRequested() {
+		parent.Cancel()
+	} else {
+		parent.Fail(errors.New("No child jobs completed successfully"))
+	}
+
+	log.WithFields(log.Fields{
+		"jobId":        parent.Id,
+		"completedIds": completedIds,
+		"status":       parent.Status,
+	}).Info("Settled parent job")
+
+	err = fanout.server.Datastore.UpdateJob(ctx, parent)
+	return parent, err
+}
@@ -0,0 +1,127 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveNodeIds(tester *testing.T) {
+	nodes := []*model.Node{
+		{Id: "sensor1", Role: "so-sensor"},
+		{Id: "manager", Role: "so-manager"},
+		{Id: "standalone", Role: "so-standalone"},
+		{Id: "desktop", Role: model.NodeRoleDesktop},
+	}
+
+	assert.Equal(tester, []string{"sensor1", "standalone"}, resolveNodeIds(nodes, []string{model.JobAllNodes}))
+	assert.Equal(tester, []string{"manager", "sensor1", "standalone"}, resolveNodeIds(nodes, []string{"Manager", "", model.JobAllNodes, "sensor1"}))
+	assert.Empty(tester, resolveNodeIds(nil, []string{model.JobAllNodes}))
+}
+
+func newChildJob(id int, status int) *model.Job {
+	job := model.NewJob()
+	job.Id = id
+	job.Status = status
+	return job
+}
+
+func TestSettleParent(tester *testing.T) {
+	settled, ids := settleParent([]*model.Job{
+		newChildJob(1, model.JobStatusCompleted),
+		newChildJob(2, model.JobStatusPending),
+	})
+	assert.False(tester, settled)
+	assert.Nil(tester, ids)
+
+	settled, _ = settleParent([]*model.Job{
+		newChildJob(1, model.JobStatusCompleted),
+		newChildJob(2, model.JobStatusCancelRequested),
+	})
+	assert.False(tester, settled)
+
+	settled, _ = settleParent([]*model.Job{
+		newChildJob(1, model.JobStatusCompleted),
+		newChildJob(2, model.JobStatusIncomplete),
+	})
+	assert.False(tester, settled)
+
+	settled, ids = settleParent([]*model.Job{
+		newChildJob(1, model.JobStatusCompleted),
+		newChildJob(2, model.JobStatusFailed),
+		newChildJob(3, model.JobStatusCancelled),
+		newChildJob(4, model.JobStatusCompleted),
+	})
+	assert.True(tester, settled)
+	assert.Equal(tester, []int{1, 4}, ids)
+}
+
+type fanoutDatastore struct {
+	*FakeDatastore
+	nextId  int
+	failId  int
+	stored  map[int]*model.Job
+	deleted []int
+}
+
+func (ds *fanoutDatastore) CreateJob(ctx context.Context) *model.Job {
+	ds.nextId++
+	job := model.NewJob()
+	job.Id = ds.nextId
+	return job
+}
+
+func (ds *fanoutDatastore) AddJob(ctx context.Context, job *model.Job) error {
+	if job.Id == ds.failId {
+		return errors.New("Unable to store job")
+	}
+	ds.stored[job.Id] = job
+	return nil
+}
+
+func (ds *fanoutDatastore) DeleteJob(ctx context.Context, jobId int) (*model.Job, error) {
+	job := ds.stored[jobId]
+	delete(ds.stored, jobId)
+	ds.deleted = append(ds.deleted, jobId)
+	return job, nil
+}
+
+func TestAddJobsRollsBackOnFailure(tester *testing.T) {
+	srv := NewFakeAuthorizedServer(nil)
+	ds := &fanoutDatastore{
+		FakeDatastore: NewFakeDatastore(),
+		nextId:        10,
+		stored:        make(map[int]*model.Job),
+	}
+	srv.Datastore = ds
+	fanout := NewJobFanout(srv)
+
+	parent := model.NewJob()
+	parent.Id = 1
+	parent.NodeIds = []string{"sensor1", "sensor2", "sensor3"}
+
+	// Second child fails to store
+	ds.failId = 12
+	_, err := fanout.AddJobs(context.Background(), parent)
+	assert.Error(tester, err)
+	assert.Empty(tester, ds.stored)
+	assert.Equal(tester, []int{11, 1}, ds.deleted)
+
+	ds.failId = 0
+	ds.deleted = nil
+	parent.NodeIds = []string{"sensor1", "sensor2"}
+	children, err := fanout.AddJobs(context.Background(), parent)
+	if assert.NoError(tester, err) {
+		assert.Len(tester, children, 2)
+		assert.Len(tester, ds.stored, 3)
+		assert.Empty(tester, ds.deleted)
+	}
+}
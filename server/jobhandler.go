@@ -7,22 +7,35 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/packet"
 	"github.com/security-onion-solutions/securityonion-soc/web"
 
+	"github.com/apex/log"
 	"github.com/go-chi/chi/v5"
 )
 
+// MaxJobPriority bounds how far a job may be moved ahead of, or behind, the fair share
+// of the other users' jobs.
+const MaxJobPriority = 100
+
+type JobPriority struct {
+	Priority int `json:"priority"`
+}
+
 type JobHandler struct {
 	server *Server
+	fanout *JobFanout
 }
 
 func RegisterJobRoutes(srv *Server, r chi.Router, prefix string) {
 	h := &JobHandler{
 		server: srv,
+		fanout: NewJobFanout(srv),
 	}
 
 	r.Route(prefix, func(r chi.Router) {
@@ -32,6 +45,10 @@ func RegisterJobRoutes(srv *Server, r chi.Router, prefix string) {
 		r.Post("/", h.postJob)
 
 		r.Put("/", h.putJob)
+		r.Put("/{jobId}/priority", h.putJobPriority)
+		r.Put("/{jobId}/cancel", h.putJobCancel)
+		r.Put("/{jobId}/progress", h.putJobProgress)
+		r.Put("/{jobId}/lease", h.putJobLease)
 
 		r.Delete("/{jobId}", h.deleteJob)
 	})
@@ -71,13 +88,30 @@ func (h *JobHandler) postJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.server.Datastore.AddJob(ctx, job)
+	err = packet.ValidateFilter(job.Filter)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// Priority outranks fair-share, so it may only be given through the priority endpoint
+	job.Priority = 0
+
+	var children []*model.Job
+	if len(job.NodeIds) > 0 {
+		children, err = h.fanout.AddJobs(ctx, job)
+	} else {
+		err = h.server.Datastore.AddJob(ctx, job)
+	}
 	if err != nil {
 		web.Respond(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	h.server.Host.Broadcast("job", "jobs", job)
+	for _, child := range children {
+		h.server.Host.Broadcast("job", "jobs", child)
+	}
 
 	web.Respond(w, r, http.StatusCreated, job)
 }
@@ -93,6 +127,15 @@ func (h *JobHandler) putJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = CheckNodeIdentity(ctx, job.GetNodeId())
+	if err == nil {
+		err = h.server.CheckJobNodeIdentity(ctx, job.Id)
+	}
+	if err != nil {
+		web.Respond(w, r, http.StatusForbidden, err)
+		return
+	}
+
 	err = h.server.Datastore.UpdateJob(ctx, job)
 	if err != nil {
 		web.Respond(w, r, http.StatusNotFound, err)
@@ -101,6 +144,16 @@ func (h *JobHandler) putJob(w http.ResponseWriter, r *http.Request) {
 
 	h.server.Host.Broadcast("job", "jobs", job)
 
+	if job.ParentId != 0 {
+		parent, err := h.fanout.ChildUpdated(ctx, job)
+		if err != nil {
+			log.WithError(err).WithField("jobId", job.ParentId).Error("Failed to update parent job")
+		}
+		if parent != nil {
+			h.server.Host.Broadcast("job", "jobs", parent)
+		}
+	}
+
 	web.Respond(w, r, http.StatusOK, job)
 }
 
@@ -123,5 +176,157 @@ func (h *JobHandler) deleteJob(w http.ResponseWriter, r *http.Request) {
 
 	h.server.Host.Broadcast("job", "jobs", job)
 
+	for _, childId := range job.ChildIds {
+		child, err := h.server.Datastore.DeleteJob(ctx, childId)
+		if err != nil {
+			log.WithError(err).WithField("jobId", childId).Warn("Unable to delete child job")
+		} else {
+			h.server.Host.Broadcast("job", "jobs", child)
+		}
+	}
+
 	web.Respond(w, r, http.StatusOK, nil)
 }
+
+func (h *JobHandler) putJobPriority(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.Atoi(chi.URLParam(r, "jobId"))
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.server.CheckAuthorized(ctx, "prioritize", "jobs")
+	if err != nil {
+		web.Respond(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	body := &JobPriority{}
+
+	err = web.ReadJson(r, body)
+	if err == nil && (body.Priority > MaxJobPriority || body.Priority < -MaxJobPriority) {
+		err = errors.New("Job priority must be between -" + strconv.Itoa(MaxJobPriority) + " and " + strconv.Itoa(MaxJobPriority))
+	}
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := h.server.Datastore.SetJobPriority(ctx, jobId, body.Priority)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	h.server.Host.Broadcast("job", "jobs", job)
+
+	web.Respond(w, r, http.StatusOK, job)
+}
+
+func (h *JobHandler) putJobCancel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.Atoi(chi.URLParam(r, "jobId"))
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := h.server.Datastore.CancelJob(ctx, jobId)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	h.server.Host.Broadcast("job", "jobs", job)
+
+	for _, childId := range job.ChildIds {
+		child, err := h.server.Datastore.CancelJob(ctx, childId)
+		if err != nil {
+			log.WithError(err).WithField("jobId", childId).Debug("Unable to cancel child job")
+			continue
+		}
+		h.server.Host.Broadcast("job", "jobs", child)
+
+		// Children that no agent held are cancelled right away, so the parent may now be
+		// settled. Settling processes the parent, which the requestor may not be allowed to.
+		if child.IsFinished() {
+			parent, err := h.fanout.ChildUpdated(h.server.Context, child)
+			if err != nil {
+				log.WithError(err).WithField("jobId", job.Id).Error("Failed to update parent job")
+			}
+			if parent != nil {
+				job = parent
+				h.server.Host.Broadcast("job", "jobs", parent)
+			}
+		}
+	}
+
+	web.Respond(w, r, http.StatusOK, job)
+}
+
+func (h *JobHandler) putJobProgress(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.Atoi(chi.URLParam(r, "jobId"))
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.server.CheckJobNodeIdentity(ctx, jobId)
+	if err != nil {
+		web.Respond(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	progress := model.NewJobProgress()
+
+	err = web.ReadJson(r, progress)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := h.server.Datastore.UpdateJobProgress(ctx, jobId, progress)
+	if job == nil {
+		web.Respond(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	h.server.Host.Broadcast("job", "jobs", job)
+
+	web.Respond(w, r, http.StatusOK, job)
+}
+
+func (h *JobHandler) putJobLease(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.Atoi(chi.URLParam(r, "jobId"))
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.server.CheckJobNodeIdentity(ctx, jobId)
+	if err != nil {
+		web.Respond(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	job, err := h.server.Datastore.RenewJobLease(ctx, jobId)
+	if job == nil {
+		web.Respond(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		// Still respond with the job so that the agent learns the job's final status.
+		log.WithError(err).WithField("jobId", jobId).Info("Unable to renew job lease")
+	}
+
+	web.Respond(w, r, http.StatusOK, job)
+}
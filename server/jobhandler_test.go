@@ -0,0 +1,197 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	"github.com/stretchr/testify/assert"
+)
+
+type jobDatastore struct {
+	*FakeDatastore
+	server *Server
+	added  []*model.Job
+	jobs   map[int]*model.Job
+}
+
+// processorAuthorizer mirrors the roles, where only job processors such as the server's
+// own agent identity may process jobs.
+type processorAuthorizer struct{}
+
+func (authorizer processorAuthorizer) CheckContextOperationAuthorized(ctx context.Context, operation string, target string) error {
+	if operation == "process" && ctx.Value(web.ContextKeyRequestorId) != AGENT_ID {
+		return model.NewUnauthorized("analyst", operation, target)
+	}
+	return nil
+}
+
+func (authorizer processorAuthorizer) CheckUserOperationAuthorized(user *model.User, operation string, target string) error {
+	if operation == "process" && user.Id != AGENT_ID {
+		return model.NewUnauthorized(user.Id, operation, target)
+	}
+	return nil
+}
+
+func (ds *jobDatastore) CreateJob(ctx context.Context) *model.Job {
+	return model.NewJob()
+}
+
+func (ds *jobDatastore) AddJob(ctx context.Context, job *model.Job) error {
+	ds.added = append(ds.added, job)
+	return nil
+}
+
+// GetJob returns a copy, as the datastores do, so that changes only stick once updated.
+func (ds *jobDatastore) GetJob(ctx context.Context, jobId int) *model.Job {
+	job, found := ds.jobs[jobId]
+	if !found {
+		return nil
+	}
+	copied := *job
+	return &copied
+}
+
+func (ds *jobDatastore) UpdateJob(ctx context.Context, job *model.Job) error {
+	if ds.server != nil {
+		if err := ds.server.CheckAuthorized(ctx, "process", "jobs"); err != nil {
+			return err
+		}
+	}
+	ds.jobs[job.Id] = job
+	return nil
+}
+
+// CancelJob mirrors the datastores, which cancel jobs that no agent holds right away.
+func (ds *jobDatastore) CancelJob(ctx context.Context, jobId int) (*model.Job, error) {
+	job := ds.jobs[jobId]
+	if job.LeaseExpireTime.IsZero() && !job.IsParent() {
+		job.Cancel()
+	} else {
+		job.Status = model.JobStatusCancelRequested
+	}
+	return job, nil
+}
+
+func (ds *jobDatastore) SetJobPriority(ctx context.Context, jobId int, priority int) (*model.Job, error) {
+	job := ds.jobs[jobId]
+	job.Priority = priority
+	return job, nil
+}
+
+func sendJobRequest(srv *Server, body string) *httptest.ResponseRecorder {
+	return sendJobRequestTo(srv, http.MethodPost, "/api/job/", body)
+}
+
+func sendJobRequestTo(srv *Server, method string, path string, body string) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	RegisterJobRoutes(srv, r, "/api/job")
+
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request = request.WithContext(context.WithValue(context.Background(), web.ContextKeyRequestStart, time.Now()))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	return w
+}
+
+func TestPostJobFilter(tester *testing.T) {
+	ds := &jobDatastore{FakeDatastore: NewFakeDatastore()}
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	w := sendJobRequest(srv, `{"nodeId":"sensor1","filter":{"srcIp":"10.0.0.0/8","dstIp":"2001:db8::1","vlans":[10,20]}}`)
+	assert.Equal(tester, http.StatusCreated, w.Code)
+	if assert.Len(tester, ds.added, 1) {
+		assert.Equal(tester, "10.0.0.0/8", ds.added[0].Filter.SrcIp)
+		assert.Equal(tester, []int{10, 20}, ds.added[0].Filter.Vlans)
+	}
+
+	w = sendJobRequest(srv, `{"nodeId":"sensor1","filter":{"srcIp":"10.0.0.0/40"}}`)
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+
+	w = sendJobRequest(srv, `{"nodeId":"sensor1","filter":{"vlans":[5000]}}`)
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+
+	w = sendJobRequest(srv, `{"nodeId":"sensor1","filter":{"bpf":"tcp and and"}}`)
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+	assert.Len(tester, ds.added, 1)
+}
+
+func TestPutJobCancelSettlesParent(tester *testing.T) {
+	parent := &model.Job{Id: 1, ChildIds: []int{2, 3}}
+	unleased := &model.Job{Id: 2, ParentId: 1}
+	leased := &model.Job{Id: 3, ParentId: 1, LeaseExpireTime: time.Now().Add(time.Hour)}
+	ds := &jobDatastore{
+		FakeDatastore: NewFakeDatastore(),
+		jobs:          map[int]*model.Job{1: parent, 2: unleased, 3: leased},
+	}
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Authorizer = processorAuthorizer{}
+	srv.Datastore = ds
+	ds.server = srv
+
+	// The parent waits for the agent holding the leased child
+	w := sendJobRequestTo(srv, http.MethodPut, "/api/job/1/cancel", "")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.Equal(tester, model.JobStatusCancelled, unleased.Status)
+	assert.Equal(tester, model.JobStatusCancelRequested, leased.Status)
+	assert.Equal(tester, model.JobStatusCancelRequested, ds.jobs[1].Status)
+
+	// Once no child is held, the parent is settled right away
+	parent.Status = model.JobStatusPending
+	leased.Status = model.JobStatusPending
+	leased.LeaseExpireTime = time.Time{}
+	w = sendJobRequestTo(srv, http.MethodPut, "/api/job/1/cancel", "")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.Equal(tester, model.JobStatusCancelled, ds.jobs[1].Status)
+	assert.Contains(tester, w.Body.String(), `"status":5`)
+}
+
+func TestPostJobIgnoresPriority(tester *testing.T) {
+	ds := &jobDatastore{FakeDatastore: NewFakeDatastore()}
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	w := sendJobRequest(srv, `{"nodeId":"sensor1","priority":1000}`)
+	assert.Equal(tester, http.StatusCreated, w.Code)
+	if assert.Len(tester, ds.added, 1) {
+		assert.Equal(tester, 0, ds.added[0].Priority)
+	}
+}
+
+func TestPutJobPriority(tester *testing.T) {
+	job := &model.Job{Id: 1}
+	ds := &jobDatastore{
+		FakeDatastore: NewFakeDatastore(),
+		jobs:          map[int]*model.Job{1: job},
+	}
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	w := sendJobRequestTo(srv, http.MethodPut, "/api/job/1/priority", `{"priority":101}`)
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+	w = sendJobRequestTo(srv, http.MethodPut, "/api/job/1/priority", `{"priority":-101}`)
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+	assert.Equal(tester, 0, job.Priority)
+
+	w = sendJobRequestTo(srv, http.MethodPut, "/api/job/1/priority", `{"priority":100}`)
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.Equal(tester, 100, job.Priority)
+
+	srv = NewFakeUnauthorizedServer()
+	srv.Datastore = ds
+	w = sendJobRequestTo(srv, http.MethodPut, "/api/job/1/priority", `{"priority":5}`)
+	assert.Equal(tester, http.StatusUnauthorized, w.Code)
+	assert.Equal(tester, 100, job.Priority)
+}
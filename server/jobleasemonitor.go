@@ -0,0 +1,82 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"time"
+
+	"github.com/apex/log"
+)
+
+// JobLeaseMonitor periodically expires the leases of jobs whose agents stopped sending
+// heartbeats, so that jobs abandoned by a crashed agent are retried, or failed once
+// they run out of attempts, even if the agent never polls for work again.
+type JobLeaseMonitor struct {
+	server      *Server
+	fanout      *JobFanout
+	intervalMs  int
+	stopChannel chan bool
+}
+
+func NewJobLeaseMonitor(srv *Server, intervalMs int) *JobLeaseMonitor {
+	return &JobLeaseMonitor{
+		server:     srv,
+		fanout:     NewJobFanout(srv),
+		intervalMs: intervalMs,
+	}
+}
+
+func (monitor *JobLeaseMonitor) Start() {
+	monitor.stopChannel = make(chan bool)
+	go monitor.loop()
+}
+
+func (monitor *JobLeaseMonitor) Stop() {
+	if monitor.stopChannel != nil {
+		close(monitor.stopChannel)
+		monitor.stopChannel = nil
+	}
+}
+
+func (monitor *JobLeaseMonitor) loop() {
+	ticker := time.NewTicker(time.Duration(monitor.intervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	stopChannel := monitor.stopChannel
+	for {
+		select {
+		case <-ticker.C:
+			monitor.ExpireLeases()
+		case <-stopChannel:
+			return
+		}
+	}
+}
+
+// ExpireLeases expires all lapsed job leases and notifies clients of the affected
+// jobs. Returns the number of jobs expired.
+func (monitor *JobLeaseMonitor) ExpireLeases() int {
+	ctx := monitor.server.Context
+	jobs, err := monitor.server.Datastore.ExpireJobLeases(ctx)
+	if err != nil {
+		log.WithError(err).Error("Unable to expire job leases")
+	}
+
+	for _, job := range jobs {
+		monitor.server.Host.Broadcast("job", "jobs", job)
+
+		if job.ParentId != 0 && !job.IsQueued() {
+			parent, err := monitor.fanout.ChildUpdated(ctx, job)
+			if err != nil {
+				log.WithError(err).WithField("jobId", job.ParentId).Error("Failed to update parent job")
+			}
+			if parent != nil {
+				monitor.server.Host.Broadcast("job", "jobs", parent)
+			}
+		}
+	}
+	return len(jobs)
+}
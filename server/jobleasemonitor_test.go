@@ -0,0 +1,57 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type leaseDatastore struct {
+	*FakeDatastore
+	expired   []*model.Job
+	requested []int
+}
+
+func (ds *leaseDatastore) ExpireJobLeases(ctx context.Context) ([]*model.Job, error) {
+	return ds.expired, nil
+}
+
+func (ds *leaseDatastore) GetJob(ctx context.Context, jobId int) *model.Job {
+	ds.requested = append(ds.requested, jobId)
+	return nil
+}
+
+func TestExpireLeases(tester *testing.T) {
+	retried := model.NewJob()
+	retried.Id = 1001
+	retried.ParentId = 1000
+
+	failed := model.NewJob()
+	failed.Id = 1002
+	failed.ParentId = 1000
+	failed.Status = model.JobStatusFailed
+
+	orphan := model.NewJob()
+	orphan.Id = 1003
+	orphan.Status = model.JobStatusFailed
+
+	ds := &leaseDatastore{
+		FakeDatastore: NewFakeDatastore(),
+		expired:       []*model.Job{retried, failed, orphan},
+	}
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	monitor := NewJobLeaseMonitor(srv, 1000)
+	assert.Equal(tester, 3, monitor.ExpireLeases())
+
+	// Only the child that will not be retried can settle its parent
+	assert.Equal(tester, []int{1000}, ds.requested)
+}
@@ -0,0 +1,92 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+// JobNotifier wakes the agents waiting on the server for work as soon as a job is
+// stored, requeued or reprioritized for their node, so that they don't have to wait for
+// their next poll.
+type JobNotifier struct {
+	waiters map[string][]chan bool
+	lock    sync.Mutex
+}
+
+func NewJobNotifier() *JobNotifier {
+	return &JobNotifier{
+		waiters: make(map[string][]chan bool),
+	}
+}
+
+// Subscribe returns a channel that receives a value each time a job is added for the
+// node, along with a function that must be called once the caller stops waiting.
+func (notifier *JobNotifier) Subscribe(nodeId string) (<-chan bool, func()) {
+	nodeId = strings.ToLower(nodeId)
+	waiter := make(chan bool, 1)
+
+	notifier.lock.Lock()
+	notifier.waiters[nodeId] = append(notifier.waiters[nodeId], waiter)
+	notifier.lock.Unlock()
+
+	release := func() {
+		notifier.lock.Lock()
+		defer notifier.lock.Unlock()
+
+		waiters := notifier.waiters[nodeId]
+		for idx, existing := range waiters {
+			if existing == waiter {
+				waiters = append(waiters[:idx], waiters[idx+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(notifier.waiters, nodeId)
+		} else {
+			notifier.waiters[nodeId] = waiters
+		}
+	}
+	return waiter, release
+}
+
+// Notify wakes every subscriber of the node without blocking. Subscribers that have not
+// yet consumed a previous notification are left with that single pending notification.
+func (notifier *JobNotifier) Notify(nodeId string) {
+	if notifier == nil {
+		return
+	}
+
+	notifier.lock.Lock()
+	defer notifier.lock.Unlock()
+
+	for _, waiter := range notifier.waiters[strings.ToLower(nodeId)] {
+		select {
+		case waiter <- true:
+		default:
+		}
+	}
+}
+
+// NotifyQueued wakes the subscribers of the nodes of the given jobs that are queued.
+func (notifier *JobNotifier) NotifyQueued(jobs []*model.Job) {
+	for _, job := range jobs {
+		if job.IsQueued() {
+			notifier.Notify(job.GetNodeId())
+		}
+	}
+}
+
+// Waiting returns the number of subscribers currently waiting for jobs for the node.
+func (notifier *JobNotifier) Waiting(nodeId string) int {
+	notifier.lock.Lock()
+	defer notifier.lock.Unlock()
+
+	return len(notifier.waiters[strings.ToLower(nodeId)])
+}
@@ -0,0 +1,62 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobNotifier(tester *testing.T) {
+	notifier := NewJobNotifier()
+	first, releaseFirst := notifier.Subscribe("Sensor1")
+	second, releaseSecond := notifier.Subscribe("sensor1")
+	other, releaseOther := notifier.Subscribe("sensor2")
+	defer releaseOther()
+	assert.Equal(tester, 2, notifier.Waiting("SENSOR1"))
+
+	// Repeated notifications collapse into one and never block
+	notifier.Notify("SENSOR1")
+	notifier.Notify("sensor1")
+	assert.Len(tester, first, 1)
+	assert.Len(tester, second, 1)
+	assert.Len(tester, other, 0)
+
+	releaseFirst()
+	assert.Equal(tester, 1, notifier.Waiting("sensor1"))
+	releaseSecond()
+	assert.Equal(tester, 0, notifier.Waiting("sensor1"))
+	assert.NotContains(tester, notifier.waiters, "sensor1")
+
+	notifier.Notify("sensor1")
+
+	var missing *JobNotifier
+	missing.Notify("sensor1")
+}
+
+func TestJobNotifierNotifyQueued(tester *testing.T) {
+	notifier := NewJobNotifier()
+	queued, releaseQueued := notifier.Subscribe("sensor1")
+	defer releaseQueued()
+	failed, releaseFailed := notifier.Subscribe("sensor2")
+	defer releaseFailed()
+
+	requeued := model.NewJob()
+	requeued.NodeId = "Sensor1"
+	requeued.Status = model.JobStatusIncomplete
+	exhausted := model.NewJob()
+	exhausted.NodeId = "sensor2"
+	exhausted.Status = model.JobStatusFailed
+
+	notifier.NotifyQueued([]*model.Job{requeued, exhausted})
+	assert.Len(tester, queued, 1)
+	assert.Len(tester, failed, 0)
+
+	var missing *JobNotifier
+	missing.NotifyQueued([]*model.Job{requeued})
+}
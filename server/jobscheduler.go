@@ -0,0 +1,77 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+// JobScheduler decides which of a node's eligible jobs is handed out next. Higher
+// priority jobs always go first. Among jobs of equal priority, the user whose work was
+// least recently dispatched to that node goes first, so that one user queueing a large
+// batch of jobs cannot starve everyone else on the same node.
+type JobScheduler struct {
+	lastDispatch map[string]time.Time
+	lock         sync.Mutex
+}
+
+func NewJobScheduler() *JobScheduler {
+	return &JobScheduler{
+		lastDispatch: make(map[string]time.Time),
+	}
+}
+
+func (scheduler *JobScheduler) dispatchKey(nodeId string, userId string) string {
+	return nodeId + "/" + userId
+}
+
+func (scheduler *JobScheduler) isBefore(nodeId string, job *model.Job, other *model.Job) bool {
+	if job.Priority != other.Priority {
+		return job.Priority > other.Priority
+	}
+
+	if job.UserId != other.UserId {
+		jobLast := scheduler.lastDispatch[scheduler.dispatchKey(nodeId, job.UserId)]
+		otherLast := scheduler.lastDispatch[scheduler.dispatchKey(nodeId, other.UserId)]
+		if !jobLast.Equal(otherLast) {
+			return jobLast.Before(otherLast)
+		}
+	}
+
+	if !job.CreateTime.Equal(other.CreateTime) {
+		return job.CreateTime.Before(other.CreateTime)
+	}
+	return job.Id < other.Id
+}
+
+// SelectNextJob returns the job that should be dispatched next from the given list of
+// eligible candidates, and records the dispatch against the job's user. Returns nil
+// if there are no candidates.
+func (scheduler *JobScheduler) SelectNextJob(nodeId string, candidates []*model.Job) *model.Job {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+
+	var nextJob *model.Job
+	for _, job := range candidates {
+		if nextJob == nil || scheduler.isBefore(nodeId, job, nextJob) {
+			nextJob = job
+		}
+	}
+
+	if nextJob != nil {
+		key := scheduler.dispatchKey(nodeId, nextJob.UserId)
+		now := time.Now()
+		// Guarantee strictly increasing dispatch times even on coarse clocks
+		if last, exists := scheduler.lastDispatch[key]; exists && !now.After(last) {
+			now = last.Add(time.Nanosecond)
+		}
+		scheduler.lastDispatch[key] = now
+	}
+	return nextJob
+}
@@ -0,0 +1,65 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newScheduledJob(id int, userId string, priority int, age time.Duration) *model.Job {
+	job := model.NewJob()
+	job.Id = id
+	job.UserId = userId
+	job.Priority = priority
+	job.CreateTime = time.Now().Add(-age)
+	return job
+}
+
+func TestSelectNextJobEmpty(tester *testing.T) {
+	scheduler := NewJobScheduler()
+	assert.Nil(tester, scheduler.SelectNextJob("foo", nil))
+}
+
+func TestSelectNextJobPriority(tester *testing.T) {
+	scheduler := NewJobScheduler()
+	jobs := []*model.Job{
+		newScheduledJob(1, "a", 0, time.Hour),
+		newScheduledJob(2, "a", 5, time.Minute),
+		newScheduledJob(3, "b", -1, time.Hour*2),
+	}
+	assert.Equal(tester, 2, scheduler.SelectNextJob("foo", jobs).Id)
+}
+
+func TestSelectNextJobFairShare(tester *testing.T) {
+	scheduler := NewJobScheduler()
+	jobs := []*model.Job{
+		newScheduledJob(1, "greedy", 0, time.Hour),
+		newScheduledJob(2, "greedy", 0, time.Hour-time.Second),
+		newScheduledJob(3, "greedy", 0, time.Hour-time.Second*2),
+		newScheduledJob(4, "patient", 0, time.Minute),
+	}
+
+	// Oldest job wins while no user has been served
+	assert.Equal(tester, 1, scheduler.SelectNextJob("foo", jobs).Id)
+	jobs = jobs[1:]
+
+	// The other user now goes ahead of the remaining greedy jobs
+	assert.Equal(tester, 4, scheduler.SelectNextJob("foo", jobs).Id)
+	jobs = jobs[:2]
+
+	assert.Equal(tester, 2, scheduler.SelectNextJob("foo", jobs).Id)
+
+	// Dispatch history is tracked per node
+	jobs = []*model.Job{
+		newScheduledJob(5, "greedy", 0, time.Hour),
+		newScheduledJob(6, "patient", 0, time.Minute),
+	}
+	assert.Equal(tester, 5, scheduler.SelectNextJob("bar", jobs).Id)
+}
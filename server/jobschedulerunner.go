@@ -0,0 +1,179 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/robfig/cron/v3"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/packet"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+)
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ValidateJobSchedule ensures the schedule can be run, returning a user facing error
+// describing the first problem found.
+func ValidateJobSchedule(schedule *model.JobSchedule) error {
+	if strings.TrimSpace(schedule.Name) == "" {
+		return errors.New("Job schedule name is required")
+	}
+	if schedule.Template == nil {
+		return errors.New("Job schedule template is required")
+	}
+	if schedule.Template.GetNodeId() == "" && len(schedule.Template.NodeIds) == 0 {
+		return errors.New("Job schedule template must specify a node")
+	}
+	if schedule.LookbackSeconds < 0 {
+		return errors.New("Job schedule lookback must not be negative")
+	}
+	if _, err := cronParser.Parse(schedule.CronExpression); err != nil {
+		return errors.New("Invalid cron expression: " + err.Error())
+	}
+	if err := packet.ValidateFilter(schedule.Template.Filter); err != nil {
+		return err
+	}
+	return nil
+}
+
+// NextJobScheduleRunTime returns the first time after the given time that the schedule
+// should run.
+func NextJobScheduleRunTime(schedule *model.JobSchedule, after time.Time) (time.Time, error) {
+	parsed, err := cronParser.Parse(schedule.CronExpression)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parsed.Next(after), nil
+}
+
+// JobScheduleRunner periodically checks every enabled schedule and queues a new job for
+// each schedule that has come due. Runs missed while the server was down are caught up
+// with a single job rather than one job per missed run.
+type JobScheduleRunner struct {
+	server      *Server
+	fanout      *JobFanout
+	intervalMs  int
+	stopChannel chan bool
+}
+
+func NewJobScheduleRunner(srv *Server, intervalMs int) *JobScheduleRunner {
+	return &JobScheduleRunner{
+		server:     srv,
+		fanout:     NewJobFanout(srv),
+		intervalMs: intervalMs,
+	}
+}
+
+func (runner *JobScheduleRunner) Start() {
+	runner.stopChannel = make(chan bool)
+	go runner.loop()
+}
+
+func (runner *JobScheduleRunner) Stop() {
+	if runner.stopChannel != nil {
+		close(runner.stopChannel)
+		runner.stopChannel = nil
+	}
+}
+
+func (runner *JobScheduleRunner) loop() {
+	ticker := time.NewTicker(time.Duration(runner.intervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	stopChannel := runner.stopChannel
+	for {
+		select {
+		case now := <-ticker.C:
+			runner.RunDueSchedules(now)
+		case <-stopChannel:
+			return
+		}
+	}
+}
+
+// RunDueSchedules queues a job for every enabled schedule due at or before the given
+// time. Returns the number of schedules run.
+func (runner *JobScheduleRunner) RunDueSchedules(now time.Time) int {
+	count := 0
+	for _, schedule := range runner.server.Datastore.GetJobSchedules(runner.server.Context) {
+		if !schedule.Enabled {
+			continue
+		}
+
+		due := schedule.NextRunTime
+		if due.IsZero() {
+			var err error
+			due, err = NextJobScheduleRunTime(schedule, schedule.UpdateTime)
+			if err != nil {
+				log.WithError(err).WithField("scheduleId", schedule.Id).Warn("Skipping job schedule with invalid cron expression")
+				continue
+			}
+		}
+
+		if !due.After(now) {
+			runner.runSchedule(schedule, now)
+			count++
+		}
+	}
+	return count
+}
+
+// ownerContext builds a request context for the schedule's owner, so that the owner's
+// current permissions apply to the jobs created on their behalf.
+func (runner *JobScheduleRunner) ownerContext(schedule *model.JobSchedule) context.Context {
+	owner := model.NewUser()
+	owner.Id = schedule.UserId
+	owner.Email = schedule.Owner
+
+	ctx := context.WithValue(context.Background(), web.ContextKeyRequestor, owner)
+	return context.WithValue(ctx, web.ContextKeyRequestorId, owner.Id)
+}
+
+func (runner *JobScheduleRunner) runSchedule(schedule *model.JobSchedule, now time.Time) {
+	ctx := runner.ownerContext(schedule)
+
+	job := runner.server.Datastore.CreateJob(ctx)
+	schedule.ApplyTemplate(job)
+
+	var children []*model.Job
+	var err error
+	if len(job.NodeIds) > 0 {
+		children, err = runner.fanout.AddJobs(ctx, job)
+	} else {
+		err = runner.server.Datastore.AddJob(ctx, job)
+	}
+
+	run := &model.JobScheduleRun{
+		Time: now,
+	}
+	if err != nil {
+		run.Error = err.Error()
+		log.WithError(err).WithField("scheduleId", schedule.Id).Error("Unable to create scheduled job")
+	} else {
+		run.JobId = job.Id
+		runner.server.Host.Broadcast("job", "jobs", job)
+		for _, child := range children {
+			runner.server.Host.Broadcast("job", "jobs", child)
+		}
+		log.WithFields(log.Fields{
+			"scheduleId": schedule.Id,
+			"jobId":      job.Id,
+		}).Info("Created scheduled job")
+	}
+
+	nextRunTime, _ := NextJobScheduleRunTime(schedule, now)
+	updated, err := runner.server.Datastore.RecordJobScheduleRun(runner.server.Context, schedule.Id, run, nextRunTime)
+	if err != nil {
+		log.WithError(err).WithField("scheduleId", schedule.Id).Error("Unable to record job schedule run")
+	} else if updated != nil {
+		runner.server.Host.Broadcast("jobSchedule", "jobs", updated)
+	}
+}
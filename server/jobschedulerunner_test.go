@@ -0,0 +1,174 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	"github.com/stretchr/testify/assert"
+)
+
+type scheduleDatastore struct {
+	*FakeDatastore
+	nextJobId int
+	added     []*model.Job
+	addErr    error
+	runs      map[string][]*model.JobScheduleRun
+	nextRuns  map[string]time.Time
+	requestor string
+}
+
+func newScheduleDatastore(schedules ...*model.JobSchedule) *scheduleDatastore {
+	ds := &scheduleDatastore{
+		FakeDatastore: NewFakeDatastore(),
+		nextJobId:     1001,
+		runs:          make(map[string][]*model.JobScheduleRun),
+		nextRuns:      make(map[string]time.Time),
+	}
+	ds.schedules = schedules
+	return ds
+}
+
+func (ds *scheduleDatastore) CreateJob(ctx context.Context) *model.Job {
+	job := model.NewJob()
+	job.Id = ds.nextJobId
+	ds.nextJobId++
+	return job
+}
+
+func (ds *scheduleDatastore) AddJob(ctx context.Context, job *model.Job) error {
+	if user, ok := ctx.Value(web.ContextKeyRequestor).(*model.User); ok {
+		ds.requestor = user.Id
+	}
+	if ds.addErr == nil {
+		ds.added = append(ds.added, job)
+	}
+	return ds.addErr
+}
+
+func (ds *scheduleDatastore) RecordJobScheduleRun(ctx context.Context, scheduleId string, run *model.JobScheduleRun, nextRunTime time.Time) (*model.JobSchedule, error) {
+	ds.runs[scheduleId] = append(ds.runs[scheduleId], run)
+	ds.nextRuns[scheduleId] = nextRunTime
+	return nil, nil
+}
+
+func newTestSchedule(id string, cronExpression string) *model.JobSchedule {
+	schedule := model.NewJobSchedule()
+	schedule.Id = id
+	schedule.Name = "Schedule " + id
+	schedule.UserId = "owner-" + id
+	schedule.CronExpression = cronExpression
+	schedule.Template.SetNodeId("sensor1")
+	return schedule
+}
+
+func TestValidateJobSchedule(tester *testing.T) {
+	schedule := newTestSchedule("a", "*/15 * * * *")
+	assert.NoError(tester, ValidateJobSchedule(schedule))
+
+	schedule.CronExpression = "@daily"
+	assert.NoError(tester, ValidateJobSchedule(schedule))
+
+	schedule.CronExpression = "* * *"
+	assert.ErrorContains(tester, ValidateJobSchedule(schedule), "Invalid cron expression")
+
+	schedule = newTestSchedule("a", "0 * * * *")
+	schedule.Name = " "
+	assert.EqualError(tester, ValidateJobSchedule(schedule), "Job schedule name is required")
+
+	schedule = newTestSchedule("a", "0 * * * *")
+	schedule.Template.SetNodeId("")
+	assert.EqualError(tester, ValidateJobSchedule(schedule), "Job schedule template must specify a node")
+
+	schedule.Template.NodeIds = []string{model.JobAllNodes}
+	assert.NoError(tester, ValidateJobSchedule(schedule))
+
+	schedule.LookbackSeconds = -1
+	assert.Error(tester, ValidateJobSchedule(schedule))
+
+	schedule = newTestSchedule("a", "0 * * * *")
+	schedule.Template.Filter.DstIp = "10.0.0.1/64"
+	assert.EqualError(tester, ValidateJobSchedule(schedule), "Invalid destination IP address or CIDR range: 10.0.0.1/64")
+}
+
+func TestNextJobScheduleRunTime(tester *testing.T) {
+	schedule := newTestSchedule("a", "30 2 * * *")
+	after := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+
+	next, err := NextJobScheduleRunTime(schedule, after)
+	assert.NoError(tester, err)
+	assert.Equal(tester, time.Date(2024, 3, 2, 2, 30, 0, 0, time.Local), next)
+
+	schedule.CronExpression = "bogus"
+	_, err = NextJobScheduleRunTime(schedule, after)
+	assert.Error(tester, err)
+}
+
+func TestRunDueSchedules(tester *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+
+	due := newTestSchedule("due", "0 * * * *")
+	due.NextRunTime = now.Add(-time.Minute)
+
+	notDue := newTestSchedule("notDue", "0 * * * *")
+	notDue.NextRunTime = now.Add(time.Minute)
+
+	disabled := newTestSchedule("disabled", "0 * * * *")
+	disabled.NextRunTime = now.Add(-time.Minute)
+	disabled.Enabled = false
+
+	// Never computed; falls back to the next run after the last update
+	fresh := newTestSchedule("fresh", "0 * * * *")
+	fresh.UpdateTime = now.Add(-2 * time.Hour)
+
+	invalid := newTestSchedule("invalid", "bogus")
+
+	ds := newScheduleDatastore(due, notDue, disabled, fresh, invalid)
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	runner := NewJobScheduleRunner(srv, 1000)
+	assert.Equal(tester, 2, runner.RunDueSchedules(now))
+
+	if assert.Len(tester, ds.added, 2) {
+		assert.Equal(tester, "due", ds.added[0].ScheduleId)
+		assert.Equal(tester, "sensor1", ds.added[0].NodeId)
+		assert.Equal(tester, "fresh", ds.added[1].ScheduleId)
+	}
+	assert.Equal(tester, "owner-fresh", ds.requestor)
+
+	if assert.Len(tester, ds.runs["due"], 1) {
+		assert.Equal(tester, 1001, ds.runs["due"][0].JobId)
+		assert.Empty(tester, ds.runs["due"][0].Error)
+	}
+	assert.Equal(tester, now.Add(time.Hour), ds.nextRuns["due"])
+	assert.Empty(tester, ds.runs["notDue"])
+	assert.Empty(tester, ds.runs["disabled"])
+	assert.Empty(tester, ds.runs["invalid"])
+}
+
+func TestRunDueSchedulesRecordsFailure(tester *testing.T) {
+	now := time.Now()
+	schedule := newTestSchedule("a", "* * * * *")
+	schedule.NextRunTime = now
+
+	ds := newScheduleDatastore(schedule)
+	ds.addErr = errors.New("Permission denied")
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	runner := NewJobScheduleRunner(srv, 1000)
+	assert.Equal(tester, 1, runner.RunDueSchedules(now))
+	if assert.Len(tester, ds.runs["a"], 1) {
+		assert.Equal(tester, 0, ds.runs["a"][0].JobId)
+		assert.Equal(tester, "Permission denied", ds.runs["a"][0].Error)
+	}
+}
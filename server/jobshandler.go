@@ -7,9 +7,15 @@
 package server
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/security-onion-solutions/securityonion-soc/json"
+	"github.com/security-onion-solutions/securityonion-soc/model"
 	"github.com/security-onion-solutions/securityonion-soc/web"
 
 	"github.com/go-chi/chi/v5"
@@ -26,25 +32,187 @@ func RegisterJobsRoutes(srv *Server, r chi.Router, prefix string) {
 
 	r.Route(prefix, func(r chi.Router) {
 		r.Get("/", h.getJobs)
+
+		r.Route("/schedules", func(r chi.Router) {
+			r.Get("/", h.getSchedules)
+			r.Get("/{scheduleId}", h.getSchedule)
+			r.Post("/", h.postSchedule)
+			r.Put("/", h.putSchedule)
+			r.Delete("/{scheduleId}", h.deleteSchedule)
+		})
 	})
 }
 
+// parseJobQuery builds a job query from the request's URL parameters. Statuses are
+// given as a comma separated list, and create times in RFC 3339 format.
+func parseJobQuery(values url.Values) (*model.JobQuery, error) {
+	var err error
+	query := model.NewJobQuery()
+
+	if kind := values.Get("kind"); kind != "" {
+		query.Kind = kind
+	}
+
+	if paramsStr := values.Get("parameters"); paramsStr != "" {
+		query.Parameters = map[string]interface{}{}
+		if err = json.LoadJson([]byte(paramsStr), &query.Parameters); err != nil {
+			return nil, err
+		}
+	}
+
+	if statuses := values.Get("status"); statuses != "" {
+		for _, status := range strings.Split(statuses, ",") {
+			var value int
+			if value, err = strconv.Atoi(strings.TrimSpace(status)); err != nil {
+				return nil, errors.New("Invalid job status: " + status)
+			}
+			query.Statuses = append(query.Statuses, value)
+		}
+	}
+
+	query.UserId = values.Get("userId")
+	if query.UserId == "" {
+		query.UserId = values.Get("owner")
+	}
+	query.NodeId = values.Get("nodeId")
+
+	if begin := values.Get("createTimeBegin"); begin != "" {
+		if query.CreateTimeBegin, err = time.Parse(time.RFC3339, begin); err != nil {
+			return nil, err
+		}
+	}
+	if end := values.Get("createTimeEnd"); end != "" {
+		if query.CreateTimeEnd, err = time.Parse(time.RFC3339, end); err != nil {
+			return nil, err
+		}
+	}
+
+	if sortBy := values.Get("sortBy"); sortBy != "" {
+		query.SortBy = sortBy
+	}
+	query.SortDescending = values.Get("sortDesc") == "true"
+
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, errors.New("Invalid job query limit: " + limit)
+		}
+	}
+	query.Cursor = values.Get("cursor")
+
+	return query, query.Validate()
+}
+
 func (h *JobsHandler) getJobs(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	kind := r.URL.Query().Get("kind")
-	paramsStr := r.URL.Query().Get("parameters")
+	query, err := parseJobQuery(r.URL.Query())
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
 
-	params := map[string]interface{}{}
-	if paramsStr != "" {
-		err := json.LoadJson([]byte(paramsStr), &params)
-		if err != nil {
-			web.Respond(w, r, http.StatusBadRequest, err)
-			return
-		}
+	page, err := h.server.Datastore.GetJobs(ctx, query)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, page)
+}
+
+func (h *JobsHandler) getSchedules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	schedules := h.server.Datastore.GetJobSchedules(ctx)
+
+	web.Respond(w, r, http.StatusOK, schedules)
+}
+
+func (h *JobsHandler) getSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	schedule := h.server.Datastore.GetJobSchedule(ctx, chi.URLParam(r, "scheduleId"))
+	if schedule == nil {
+		web.Respond(w, r, http.StatusNotFound, nil)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, schedule)
+}
+
+// readSchedule parses and validates the schedule in the request body, and computes the
+// time it will first run.
+func (h *JobsHandler) readSchedule(r *http.Request) (*model.JobSchedule, error) {
+	schedule := model.NewJobSchedule()
+
+	err := web.ReadJson(r, schedule)
+	if err == nil {
+		err = ValidateJobSchedule(schedule)
+	}
+	if err == nil {
+		// Scheduled jobs share in the fair-share like any other job
+		schedule.Template.Priority = 0
+	}
+	if err == nil {
+		schedule.NextRunTime, err = NextJobScheduleRunTime(schedule, time.Now())
+	}
+	return schedule, err
+}
+
+func (h *JobsHandler) postSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	schedule, err := h.readSchedule(r)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if user, ok := ctx.Value(web.ContextKeyRequestor).(*model.User); ok {
+		schedule.Owner = user.Email
+	}
+
+	err = h.server.Datastore.AddJobSchedule(ctx, schedule)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	h.server.Host.Broadcast("jobSchedule", "jobs", schedule)
+
+	web.Respond(w, r, http.StatusCreated, schedule)
+}
+
+func (h *JobsHandler) putSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	schedule, err := h.readSchedule(r)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	schedule, err = h.server.Datastore.UpdateJobSchedule(ctx, schedule)
+	if err != nil {
+		web.Respond(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	h.server.Host.Broadcast("jobSchedule", "jobs", schedule)
+
+	web.Respond(w, r, http.StatusOK, schedule)
+}
+
+func (h *JobsHandler) deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	schedule, err := h.server.Datastore.DeleteJobSchedule(ctx, chi.URLParam(r, "scheduleId"))
+	if err != nil {
+		web.Respond(w, r, http.StatusInternalServerError, err)
+		return
 	}
 
-	jobs := h.server.Datastore.GetJobs(ctx, kind, params)
+	h.server.Host.Broadcast("jobScheduleDeleted", "jobs", schedule)
 
-	web.Respond(w, r, http.StatusOK, jobs)
+	web.Respond(w, r, http.StatusOK, nil)
 }
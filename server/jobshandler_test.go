@@ -0,0 +1,59 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJobQueryDefaults(tester *testing.T) {
+	query, err := parseJobQuery(url.Values{})
+	assert.NoError(tester, err)
+	assert.Equal(tester, model.DEFAULT_JOB_KIND, query.Kind)
+	assert.Equal(tester, model.JobSortId, query.SortBy)
+	assert.False(tester, query.SortDescending)
+	assert.Equal(tester, 0, query.Limit)
+	assert.Empty(tester, query.Statuses)
+}
+
+func TestParseJobQuery(tester *testing.T) {
+	values, _ := url.ParseQuery("kind=analyze&parameters=%7B%22artifact%22%3A%7B%22id%22%3A%22a1%22%7D%7D" +
+		"&status=0,%202&owner=user1&nodeId=Sensor1&createTimeBegin=2024-01-01T00:00:00Z&createTimeEnd=2024-01-02T00:00:00Z" +
+		"&sortBy=createTime&sortDesc=true&limit=50&cursor=")
+	query, err := parseJobQuery(values)
+	assert.NoError(tester, err)
+	assert.Equal(tester, "analyze", query.Kind)
+	assert.Equal(tester, map[string]interface{}{"artifact": map[string]interface{}{"id": "a1"}}, query.Parameters)
+	assert.Equal(tester, []int{model.JobStatusPending, model.JobStatusIncomplete}, query.Statuses)
+	assert.Equal(tester, "user1", query.UserId)
+	assert.Equal(tester, "Sensor1", query.NodeId)
+	assert.Equal(tester, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), query.CreateTimeBegin)
+	assert.Equal(tester, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), query.CreateTimeEnd)
+	assert.Equal(tester, model.JobSortCreateTime, query.SortBy)
+	assert.True(tester, query.SortDescending)
+	assert.Equal(tester, 50, query.Limit)
+}
+
+func TestParseJobQueryInvalid(tester *testing.T) {
+	for _, raw := range []string{
+		"status=done",
+		"limit=ten",
+		"limit=-1",
+		"sortBy=failure",
+		"createTimeBegin=yesterday",
+		"cursor=!!!",
+		"parameters=%7B",
+	} {
+		values, _ := url.ParseQuery(raw)
+		_, err := parseJobQuery(values)
+		assert.Error(tester, err, raw)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Jason Ertel (github.com/jertel).
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"errors"
+
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/security-onion-solutions/securityonion-soc/server"
+)
+
+type BoltDatastore struct {
+	config module.ModuleConfig
+	server *server.Server
+	impl   *BoltDatastoreImpl
+}
+
+func NewBoltDatastore(srv *server.Server) *BoltDatastore {
+	return &BoltDatastore{
+		server: srv,
+		impl:   NewBoltDatastoreImpl(srv),
+	}
+}
+
+func (bdmodule *BoltDatastore) PrerequisiteModules() []string {
+	return nil
+}
+
+func (bdmodule *BoltDatastore) Init(cfg module.ModuleConfig) error {
+	bdmodule.config = cfg
+	err := bdmodule.impl.Init(cfg)
+	if err == nil {
+		if bdmodule.server.Datastore != nil {
+			err = errors.New("Multiple datastore modules cannot be enabled concurrently")
+		} else {
+			bdmodule.server.Datastore = bdmodule.impl
+		}
+	}
+	return err
+}
+
+func (bdmodule *BoltDatastore) Start() error {
+	return nil
+}
+
+func (bdmodule *BoltDatastore) Stop() error {
+	return bdmodule.impl.Close()
+}
+
+func (bdmodule *BoltDatastore) IsRunning() bool {
+	return false
+}
@@ -0,0 +1,829 @@
+// Copyright 2019 Jason Ertel (github.com/jertel).
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/security-onion-solutions/securityonion-soc/json"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/security-onion-solutions/securityonion-soc/server"
+	"github.com/security-onion-solutions/securityonion-soc/server/modules/streamstore"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	bolt "go.etcd.io/bbolt"
+)
+
+const DEFAULT_RETRY_FAILURE_INTERVAL_MS = 600000
+const DEFAULT_DB_FILENAME = "jobs.db"
+const DEFAULT_OPEN_TIMEOUT_MS = 5000
+const DEFAULT_PIVOT_PRIORITY_BOOST = 0
+const FIRST_JOB_ID = 1001
+
+var bucketJobs = []byte("jobs")
+var bucketMeta = []byte("meta")
+var bucketIndexNode = []byte("idx_node")
+var bucketIndexStatus = []byte("idx_status")
+var bucketIndexKind = []byte("idx_kind")
+var bucketIndexOwner = []byte("idx_owner")
+var bucketIndexCreateTime = []byte("idx_createTime")
+var bucketIndexFingerprint = []byte("idx_fingerprint")
+var bucketSchedules = []byte("schedules")
+
+var metaKeyNextJobId = []byte("nextJobId")
+var metaKeyMigrated = []byte("migratedJobDir")
+var metaKeyIndexedNodeIds = []byte("indexedNodeIds")
+
+type BoltDatastoreImpl struct {
+	server                 *server.Server
+	db                     *bolt.DB
+	dbFile                 string
+	jobDir                 string
+	retryFailureIntervalMs int
+	pivotPriorityBoost     int
+	scheduleHistoryLength  int
+	reuseResultsEnabled    bool
+	jobLeaseDurationMs     int
+	jobMaxAttempts         int
+	streams                *streamstore.StreamStore
+	scheduler              *server.JobScheduler
+	nodesById              map[string]*model.Node
+	lock                   sync.RWMutex
+}
+
+func NewBoltDatastoreImpl(srv *server.Server) *BoltDatastoreImpl {
+	return &BoltDatastoreImpl{
+		server:    srv,
+		nodesById: make(map[string]*model.Node),
+		scheduler: server.NewJobScheduler(),
+		lock:      sync.RWMutex{},
+	}
+}
+
+func (datastore *BoltDatastoreImpl) Init(cfg module.ModuleConfig) error {
+	var err error
+	datastore.jobDir, err = module.GetString(cfg, "jobDir")
+	if err == nil {
+		datastore.retryFailureIntervalMs = module.GetIntDefault(cfg, "retryFailureIntervalMs", DEFAULT_RETRY_FAILURE_INTERVAL_MS)
+		datastore.pivotPriorityBoost = module.GetIntDefault(cfg, "pivotPriorityBoost", DEFAULT_PIVOT_PRIORITY_BOOST)
+		datastore.scheduleHistoryLength = module.GetIntDefault(cfg, "scheduleHistoryLength", model.DEFAULT_JOB_SCHEDULE_HISTORY_LENGTH)
+		datastore.reuseResultsEnabled = module.GetBoolDefault(cfg, "reuseResults", DEFAULT_REUSE_RESULTS)
+		datastore.jobLeaseDurationMs = module.GetIntDefault(cfg, "jobLeaseDurationMs", DEFAULT_JOB_LEASE_DURATION_MS)
+		datastore.jobMaxAttempts = module.GetIntDefault(cfg, "jobMaxAttempts", DEFAULT_JOB_MAX_ATTEMPTS)
+		datastore.dbFile = module.GetStringDefault(cfg, "dbFile", filepath.Join(datastore.jobDir, DEFAULT_DB_FILENAME))
+		datastore.streams, err = streamstore.NewStreamStoreFromConfig(cfg)
+	}
+	if err == nil {
+		timeoutMs := module.GetIntDefault(cfg, "openTimeoutMs", DEFAULT_OPEN_TIMEOUT_MS)
+		err = datastore.open(time.Duration(timeoutMs) * time.Millisecond)
+	}
+	if err == nil && module.GetBoolDefault(cfg, "migrateJobDir", true) {
+		err = datastore.migrateJobDir()
+	}
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) open(timeout time.Duration) error {
+	err := os.MkdirAll(filepath.Dir(datastore.dbFile), os.ModePerm)
+	if err == nil {
+		datastore.db, err = bolt.Open(datastore.dbFile, 0600, &bolt.Options{Timeout: timeout})
+	}
+	if err == nil {
+		err = datastore.db.Update(func(tx *bolt.Tx) error {
+			backfillFingerprints := tx.Bucket(bucketIndexFingerprint) == nil
+			for _, name := range [][]byte{bucketJobs, bucketMeta, bucketIndexNode, bucketIndexStatus, bucketIndexKind, bucketIndexOwner, bucketIndexCreateTime, bucketIndexFingerprint, bucketSchedules} {
+				if _, bucketErr := tx.CreateBucketIfNotExists(name); bucketErr != nil {
+					return bucketErr
+				}
+			}
+			if backfillFingerprints {
+				if indexErr := datastore.indexFingerprints(tx); indexErr != nil {
+					return indexErr
+				}
+			}
+			if tx.Bucket(bucketMeta).Get(metaKeyIndexedNodeIds) == nil {
+				if indexErr := datastore.indexNodeIds(tx); indexErr != nil {
+					return indexErr
+				}
+			}
+			return nil
+		})
+	}
+	return err
+}
+
+// indexFingerprints adds jobs stored before the fingerprint index existed to the index.
+func (datastore *BoltDatastoreImpl) indexFingerprints(tx *bolt.Tx) error {
+	index := tx.Bucket(bucketIndexFingerprint)
+	return tx.Bucket(bucketJobs).ForEach(func(key []byte, value []byte) error {
+		job := datastore.readJob(tx, btoi(key))
+		if job == nil {
+			return nil
+		}
+		return index.Put(indexKey(job.Fingerprint(), job.Id), []byte{})
+	})
+}
+
+// indexNodeIds adds the parent jobs stored before they were indexed under each of their
+// target nodes to the node index.
+func (datastore *BoltDatastoreImpl) indexNodeIds(tx *bolt.Tx) error {
+	index := tx.Bucket(bucketIndexNode)
+	err := tx.Bucket(bucketJobs).ForEach(func(key []byte, value []byte) error {
+		job := datastore.readJob(tx, btoi(key))
+		if job == nil {
+			return nil
+		}
+		for _, nodeId := range job.NodeIds {
+			if err := index.Put(indexKey(strings.ToLower(nodeId), job.Id), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = tx.Bucket(bucketMeta).Put(metaKeyIndexedNodeIds, []byte(time.Now().Format(time.RFC3339)))
+	}
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) Close() error {
+	var err error
+	if datastore.db != nil {
+		err = datastore.db.Close()
+		datastore.db = nil
+	}
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) CreateNode(ctx context.Context, id string) *model.Node {
+	return model.NewNode(id)
+}
+
+func (datastore *BoltDatastoreImpl) GetNodes(ctx context.Context) []*model.Node {
+	allNodes := make([]*model.Node, 0)
+	if err := datastore.server.CheckAuthorized(ctx, "read", "nodes"); err == nil {
+		datastore.lock.RLock()
+		defer datastore.lock.RUnlock()
+		for _, node := range datastore.nodesById {
+			allNodes = append(allNodes, node)
+		}
+	}
+	return allNodes
+}
+
+func (datastore *BoltDatastoreImpl) AddNode(ctx context.Context, node *model.Node) error {
+	_, err := datastore.UpdateNode(ctx, node)
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) addNode(node *model.Node) *model.Node {
+	datastore.nodesById[node.Id] = node
+	log.WithFields(log.Fields{
+		"id":          node.Id,
+		"description": node.Description,
+	}).Debug("Added node")
+	return node
+}
+
+func (datastore *BoltDatastoreImpl) UpdateNode(ctx context.Context, newNode *model.Node) (*model.Node, error) {
+	var node *model.Node
+	var err error
+	if len(newNode.Id) > 0 {
+		if err = datastore.server.CheckAuthorized(ctx, "write", "nodes"); err == nil {
+			datastore.lock.Lock()
+			defer datastore.lock.Unlock()
+			node = datastore.nodesById[newNode.Id]
+			if node == nil {
+				node = datastore.addNode(newNode)
+			}
+
+			// Only copy the following values from the incoming node. Preserve everything else.
+			node.EpochTime = newNode.EpochTime
+			node.Role = newNode.Role
+			node.Description = newNode.Description
+			node.Address = newNode.Address
+			node.Version = newNode.Version
+
+			// Ensure model parameters are updated
+			node.SetModel(newNode.Model)
+
+			// Mark ConnectionStatus as Ok since this node just checked in
+			node.ConnectionStatus = model.NodeStatusOk
+
+			// Update time is now
+			node.UpdateTime = time.Now()
+
+			// Calculate uptime
+			node.UptimeSeconds = int(node.UpdateTime.Sub(node.OnlineTime).Seconds())
+		}
+	} else {
+		log.WithFields(log.Fields{
+			"description": newNode.Description,
+			"requestId":   ctx.Value(web.ContextKeyRequestId),
+		}).Info("Not adding node with missing id")
+	}
+	return node, err
+}
+
+// GetNextJob selects the next job for the node to process and leases it to the node's
+// agent. Jobs already leased are skipped until their lease expires.
+func (datastore *BoltDatastoreImpl) GetNextJob(ctx context.Context, nodeId string) *model.Job {
+	var nextJob *model.Job
+
+	if err := datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
+		now := time.Now()
+		nodeId = strings.ToLower(nodeId)
+		err = datastore.db.Update(func(tx *bolt.Tx) error {
+			candidates := make([]*model.Job, 0)
+			for _, id := range datastore.queuedJobIds(tx, nodeId) {
+				job := datastore.readJob(tx, id)
+				// Parent jobs are indexed under their target nodes too, but are never processed
+				if job == nil || job.GetNodeId() != nodeId {
+					continue
+				}
+				if _, txErr := datastore.expireLease(tx, job, now); txErr != nil {
+					return txErr
+				}
+				retryTime := job.FailTime.Add(time.Millisecond * time.Duration(datastore.retryFailureIntervalMs))
+				if job.IsQueued() && !job.IsLeased(now) &&
+					(job.Status != model.JobStatusIncomplete || retryTime.Before(now)) {
+					candidates = append(candidates, job)
+				}
+			}
+			nextJob = datastore.scheduler.SelectNextJob(nodeId, candidates)
+			if nextJob == nil {
+				return nil
+			}
+			nextJob.Lease(now, datastore.leaseDuration())
+			return datastore.writeJob(tx, nextJob)
+		})
+		if err != nil {
+			log.WithError(err).WithField("nodeId", nodeId).Error("Unable to read next job")
+			nextJob = nil
+		}
+	}
+	return nextJob
+}
+
+func (datastore *BoltDatastoreImpl) CreateJob(ctx context.Context) *model.Job {
+	job := model.NewJob()
+	err := datastore.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		job.Id = datastore.readNextJobId(tx)
+		return meta.Put(metaKeyNextJobId, itob(job.Id+1))
+	})
+	if err != nil {
+		log.WithError(err).Error("Unable to reserve job id")
+	}
+	log.WithFields(log.Fields{
+		"id":        job.Id,
+		"nextJobId": job.Id + 1,
+	}).Debug("Created job")
+
+	return job
+}
+
+func (datastore *BoltDatastoreImpl) jobIsAllowed(ctx context.Context, job *model.Job, op string) bool {
+	allowed := false
+
+	if job != nil {
+		if err := datastore.server.CheckAuthorized(ctx, op, "jobs"); err == nil {
+			// User can operate on all jobs
+			allowed = true
+		} else {
+			// User is only authorized against their own jobs.
+			if user, ok := ctx.Value(web.ContextKeyRequestor).(*model.User); ok {
+				if job.UserId == user.Id {
+					allowed = true
+				}
+			}
+		}
+	}
+	return allowed
+}
+
+func (datastore *BoltDatastoreImpl) GetJob(ctx context.Context, jobId int) *model.Job {
+	job := datastore.getJobById(jobId)
+	if job != nil {
+		// Agents need to read the jobs they are processing to detect cancellation.
+		if !datastore.jobIsAllowed(ctx, job, "read") && datastore.server.CheckAuthorized(ctx, "process", "jobs") != nil {
+			// Do not return jobs that are not allowed to be viewed by this user.
+			job = nil
+		}
+	}
+
+	return job
+}
+
+func (datastore *BoltDatastoreImpl) filterParameterMatches(parameters map[string]interface{}, jobParams map[string]interface{}) bool {
+	for key, value := range parameters {
+		jobValue, ok := jobParams[key]
+		if !ok {
+			return false // filter param doesn't exist in job
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			jobNested, ok := jobValue.(map[string]interface{})
+			if !ok || !datastore.filterParameterMatches(nested, jobNested) {
+				return false
+			}
+		} else if value != jobValue {
+			return false
+		}
+	}
+	return true
+}
+
+// queuedJobIds returns the ids of the pending and incomplete jobs indexed under the node.
+// Queued jobs are a small fraction of all stored jobs, so each is looked up in the node
+// index rather than walking every job of the node.
+func (datastore *BoltDatastoreImpl) queuedJobIds(tx *bolt.Tx, nodeId string) []int {
+	ids := make([]int, 0)
+	nodes := tx.Bucket(bucketIndexNode)
+	for _, status := range []int{model.JobStatusPending, model.JobStatusIncomplete} {
+		for _, id := range datastore.scanIndex(tx, bucketIndexStatus, statusIndexValue(status)) {
+			if nodes.Get(indexKey(nodeId, id)) != nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// candidateJobIds uses the most selective index available for the query to find the
+// ids of jobs that may match it.
+func (datastore *BoltDatastoreImpl) candidateJobIds(tx *bolt.Tx, query *model.JobQuery) []int {
+	switch {
+	case query.UserId != "":
+		return datastore.scanIndex(tx, bucketIndexOwner, query.UserId)
+	case len(query.Statuses) == 1:
+		return datastore.scanIndex(tx, bucketIndexStatus, statusIndexValue(query.Statuses[0]))
+	case query.NodeId != "":
+		return datastore.scanIndex(tx, bucketIndexNode, strings.ToLower(query.NodeId))
+	case !query.CreateTimeBegin.IsZero() || !query.CreateTimeEnd.IsZero():
+		return datastore.scanTimeIndex(tx, bucketIndexCreateTime, query.CreateTimeBegin, query.CreateTimeEnd)
+	case query.Kind != "":
+		return datastore.scanIndex(tx, bucketIndexKind, query.Kind)
+	}
+
+	ids := make([]int, 0)
+	tx.Bucket(bucketJobs).ForEach(func(key []byte, value []byte) error {
+		ids = append(ids, btoi(key))
+		return nil
+	})
+	return ids
+}
+
+// GetJobs returns the page of jobs visible to the requestor that match the query.
+func (datastore *BoltDatastoreImpl) GetJobs(ctx context.Context, query *model.JobQuery) (*model.JobPage, error) {
+	allJobs := make([]*model.Job, 0)
+	err := datastore.db.View(func(tx *bolt.Tx) error {
+		for _, id := range datastore.candidateJobIds(tx, query) {
+			job := datastore.readJob(tx, id)
+			if job != nil && query.Matches(job) && datastore.jobIsAllowed(ctx, job, "read") && datastore.filterParameterMatches(query.Parameters, job.Filter.Parameters) {
+				allJobs = append(allJobs, job)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).WithField("kind", query.Kind).Error("Unable to read jobs")
+		return nil, err
+	}
+	return query.Paginate(allJobs)
+}
+
+func (datastore *BoltDatastoreImpl) AddJob(ctx context.Context, job *model.Job) error {
+	var err error
+	if err = datastore.server.CheckAuthorized(ctx, "write", "jobs"); err == nil {
+		err = datastore.addAndSaveJob(ctx, job)
+	}
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) AddPivotJob(ctx context.Context, job *model.Job) error {
+	var err error
+	if err = datastore.server.CheckAuthorized(ctx, "pivot", "jobs"); err == nil {
+		job.Priority += datastore.pivotPriorityBoost
+		err = datastore.addAndSaveJob(ctx, job)
+	}
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) addAndSaveJob(ctx context.Context, job *model.Job) error {
+	if user, ok := ctx.Value(web.ContextKeyRequestor).(*model.User); ok {
+		job.UserId = user.Id
+	} else {
+		return errors.New("User not found in context")
+	}
+	datastore.reuseResults(job)
+	err := datastore.addJob(job)
+	if err == nil {
+		datastore.server.JobNotifier.Notify(job.GetNodeId())
+	}
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) UpdateJob(ctx context.Context, job *model.Job) error {
+	var err error
+	requeued := false
+	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
+		err = datastore.db.Update(func(tx *bolt.Tx) error {
+			existingJob := datastore.readJob(tx, job.Id)
+			if existingJob == nil {
+				return errors.New("Job not found")
+			}
+			// The agent replays an update until it is acknowledged, so an update that was
+			// already applied is reported back as-is rather than applied again over any newer state.
+			if job.UpdateKey != "" && job.UpdateKey == existingJob.UpdateKey {
+				*job = *existingJob
+				return nil
+			}
+			job.UserId = existingJob.UserId // Prevent users from altering the creating user
+			job.NodeId = existingJob.NodeId // Do not allow moving a job between nodes due to data file path
+			if !existingJob.CanProcess() {
+				return errors.New("Job is ineligible for processing")
+			}
+			if existingJob.IsCancelRequested() && job.IsQueued() {
+				// Never requeue a job that was cancelled while being processed
+				job.Cancel()
+			}
+			// The agent has reported back, so the job is no longer held by it
+			job.ReleaseLease()
+			if txErr := datastore.deleteIndexes(tx, existingJob); txErr != nil {
+				return txErr
+			}
+			requeued = job.IsQueued()
+			return datastore.writeJob(tx, job)
+		})
+		if err == nil && requeued {
+			// The agent handed the job back, so another attempt may pick it up
+			datastore.server.JobNotifier.Notify(job.GetNodeId())
+		}
+	}
+
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) UpdateJobProgress(ctx context.Context, jobId int, progress *model.JobProgress) (*model.Job, error) {
+	var err error
+	var job *model.Job
+	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
+		err = datastore.db.Update(func(tx *bolt.Tx) error {
+			job = datastore.readJob(tx, jobId)
+			if job == nil {
+				return errors.New("Job not found")
+			}
+			if !job.CanProcess() {
+				return errors.New("Job is ineligible for processing")
+			}
+			job.Progress = progress
+			job.Lease(time.Now(), datastore.leaseDuration())
+			return datastore.writeJob(tx, job)
+		})
+	}
+	return job, err
+}
+
+func (datastore *BoltDatastoreImpl) SetJobPriority(ctx context.Context, jobId int, priority int) (*model.Job, error) {
+	var err error
+	var job *model.Job
+	if err = datastore.server.CheckAuthorized(ctx, "prioritize", "jobs"); err == nil {
+		err = datastore.db.Update(func(tx *bolt.Tx) error {
+			job = datastore.readJob(tx, jobId)
+			if job == nil {
+				return errors.New("Job not found")
+			}
+			if !job.IsQueued() {
+				return errors.New("Job is no longer queued")
+			}
+			job.Priority = priority
+			return datastore.writeJob(tx, job)
+		})
+		if err == nil {
+			log.WithFields(log.Fields{
+				"id":       job.Id,
+				"priority": priority,
+			}).Info("Updated job priority")
+			datastore.server.JobNotifier.Notify(job.GetNodeId())
+		}
+	}
+	return job, err
+}
+
+func (datastore *BoltDatastoreImpl) getJobById(jobId int) *model.Job {
+	var job *model.Job
+	datastore.db.View(func(tx *bolt.Tx) error {
+		job = datastore.readJob(tx, jobId)
+		return nil
+	})
+	return job
+}
+
+func (datastore *BoltDatastoreImpl) DeleteJob(ctx context.Context, jobId int) (*model.Job, error) {
+	var err error
+	job := datastore.getJobById(jobId)
+	if job != nil {
+		if datastore.jobIsAllowed(ctx, job, "delete") {
+			err = datastore.deleteJob(job)
+			if err == nil {
+				job.Status = model.JobStatusDeleted
+				for _, filename := range datastore.streams.Filenames(job) {
+					os.Remove(filename)
+				}
+
+				log.WithFields(log.Fields{
+					"id":     job.Id,
+					"folder": filepath.Dir(datastore.getStreamFilename(job)),
+				}).Info("Permanently deleted job and job files")
+			}
+		} else {
+			err = errors.New("Permission denied attempting to delete job")
+		}
+	} else {
+		err = errors.New("Job not found")
+	}
+	return job, err
+}
+
+// CancelJob asks the agent processing the job to stop. The job remains in a cancel
+// requested state until the agent reports back, and will not be handed out again.
+func (datastore *BoltDatastoreImpl) CancelJob(ctx context.Context, jobId int) (*model.Job, error) {
+	var err error
+	job := datastore.getJobById(jobId)
+	if job != nil {
+		if datastore.jobIsAllowed(ctx, job, "delete") {
+			err = datastore.db.Update(func(tx *bolt.Tx) error {
+				existingJob := datastore.readJob(tx, jobId)
+				if existingJob == nil {
+					return errors.New("Job not found")
+				}
+				if !existingJob.IsQueued() {
+					return errors.New("Job is no longer queued")
+				}
+				if txErr := datastore.deleteIndexes(tx, existingJob); txErr != nil {
+					return txErr
+				}
+				job = existingJob
+				if job.LeaseExpireTime.IsZero() && !job.IsParent() {
+					// No agent holds the job, so nobody would ever report the cancellation
+					job.Cancel()
+				} else {
+					job.Status = model.JobStatusCancelRequested
+				}
+				return datastore.writeJob(tx, job)
+			})
+			if err == nil && job.IsCancelRequested() {
+				log.WithField("id", job.Id).Info("Requested job cancellation")
+			} else if err == nil {
+				log.WithField("id", job.Id).Info("Cancelled job")
+			}
+		} else {
+			err = errors.New("Permission denied attempting to cancel job")
+		}
+	} else {
+		err = errors.New("Job not found")
+	}
+	return job, err
+}
+
+func (datastore *BoltDatastoreImpl) deleteJob(job *model.Job) error {
+	err := datastore.db.Update(func(tx *bolt.Tx) error {
+		existingJob := datastore.readJob(tx, job.Id)
+		if existingJob == nil {
+			return nil
+		}
+		if txErr := datastore.deleteIndexes(tx, existingJob); txErr != nil {
+			return txErr
+		}
+		return tx.Bucket(bucketJobs).Delete(itob(job.Id))
+	})
+	if err == nil {
+		log.WithFields(log.Fields{
+			"id":   job.Id,
+			"node": job.GetNodeId(),
+		}).Debug("Deleted job from database")
+	}
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) addJob(job *model.Job) error {
+	err := datastore.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketJobs).Get(itob(job.Id)) != nil {
+			return errors.New("Job already exists")
+		}
+		if txErr := datastore.writeJob(tx, job); txErr != nil {
+			return txErr
+		}
+		if job.Id >= datastore.readNextJobId(tx) {
+			return tx.Bucket(bucketMeta).Put(metaKeyNextJobId, itob(job.Id+1))
+		}
+		return nil
+	})
+	if err == nil {
+		log.WithFields(log.Fields{
+			"id":   job.Id,
+			"node": job.GetNodeId(),
+		}).Debug("Added job")
+	}
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) readNextJobId(tx *bolt.Tx) int {
+	value := tx.Bucket(bucketMeta).Get(metaKeyNextJobId)
+	if value == nil {
+		return FIRST_JOB_ID
+	}
+	return btoi(value)
+}
+
+func (datastore *BoltDatastoreImpl) readJob(tx *bolt.Tx, jobId int) *model.Job {
+	var job *model.Job
+	content := tx.Bucket(bucketJobs).Get(itob(jobId))
+	if content != nil {
+		job = model.NewJob()
+		if err := json.LoadJson(content, job); err != nil {
+			log.WithError(err).WithField("jobId", jobId).Error("Unable to decode stored job")
+			job = nil
+		}
+	}
+	return job
+}
+
+func (datastore *BoltDatastoreImpl) writeJob(tx *bolt.Tx, job *model.Job) error {
+	content, err := json.WriteJson(job)
+	if err == nil {
+		err = tx.Bucket(bucketJobs).Put(itob(job.Id), content)
+	}
+	if err == nil {
+		err = datastore.putIndexes(tx, job)
+	}
+	return err
+}
+
+// indexEntries returns the keys of the job in each index. Parent jobs are indexed under
+// each of their target nodes, so that node queries find them.
+func (datastore *BoltDatastoreImpl) indexEntries(job *model.Job) map[string][][]byte {
+	nodeKeys := [][]byte{indexKey(job.GetNodeId(), job.Id)}
+	for _, nodeId := range job.NodeIds {
+		nodeKeys = append(nodeKeys, indexKey(strings.ToLower(nodeId), job.Id))
+	}
+	return map[string][][]byte{
+		string(bucketIndexNode):        nodeKeys,
+		string(bucketIndexStatus):      {indexKey(statusIndexValue(job.Status), job.Id)},
+		string(bucketIndexKind):        {indexKey(job.GetKind(), job.Id)},
+		string(bucketIndexOwner):       {indexKey(job.UserId, job.Id)},
+		string(bucketIndexCreateTime):  {timeIndexKey(job.CreateTime, job.Id)},
+		string(bucketIndexFingerprint): {indexKey(job.Fingerprint(), job.Id)},
+	}
+}
+
+func (datastore *BoltDatastoreImpl) putIndexes(tx *bolt.Tx, job *model.Job) error {
+	for bucket, keys := range datastore.indexEntries(job) {
+		for _, key := range keys {
+			if err := tx.Bucket([]byte(bucket)).Put(key, []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (datastore *BoltDatastoreImpl) deleteIndexes(tx *bolt.Tx, job *model.Job) error {
+	for bucket, keys := range datastore.indexEntries(job) {
+		for _, key := range keys {
+			if err := tx.Bucket([]byte(bucket)).Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (datastore *BoltDatastoreImpl) scanIndex(tx *bolt.Tx, bucket []byte, value string) []int {
+	ids := make([]int, 0)
+	prefix := indexPrefix(value)
+	cursor := tx.Bucket(bucket).Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		ids = append(ids, btoi(key[len(prefix):]))
+	}
+	return ids
+}
+
+// scanTimeIndex returns the ids of the jobs whose indexed time is within the range,
+// beginning inclusive and end exclusive. A zero time leaves that side of the range open.
+func (datastore *BoltDatastoreImpl) scanTimeIndex(tx *bolt.Tx, bucket []byte, begin time.Time, end time.Time) []int {
+	ids := make([]int, 0)
+	cursor := tx.Bucket(bucket).Cursor()
+	key, _ := cursor.First()
+	if !begin.IsZero() {
+		key, _ = cursor.Seek(itob(int(begin.UnixNano())))
+	}
+	var limit []byte
+	if !end.IsZero() {
+		limit = itob(int(end.UnixNano()))
+	}
+	for ; key != nil && (limit == nil || bytes.Compare(key[:8], limit) < 0); key, _ = cursor.Next() {
+		ids = append(ids, btoi(key[8:]))
+	}
+	return ids
+}
+
+func (datastore *BoltDatastoreImpl) migrateJobDir() error {
+	var migrated bool
+	err := datastore.db.View(func(tx *bolt.Tx) error {
+		migrated = tx.Bucket(bucketMeta).Get(metaKeyMigrated) != nil
+		return nil
+	})
+	if err != nil || migrated {
+		return err
+	}
+
+	files := make([]string, 0)
+	err = filepath.Walk(datastore.jobDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(info.Name(), ".json") {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	count := 0
+	err = datastore.db.Update(func(tx *bolt.Tx) error {
+		nextJobId := datastore.readNextJobId(tx)
+		for _, file := range files {
+			job := model.NewJob()
+			loadErr := json.LoadJsonFile(file, job)
+			if loadErr != nil {
+				log.WithError(loadErr).WithField("file", file).Error("Unable to migrate job file")
+				continue
+			}
+			if tx.Bucket(bucketJobs).Get(itob(job.Id)) != nil {
+				log.WithField("file", file).Warn("Skipping migration of job file; job already exists")
+				continue
+			}
+			if txErr := datastore.writeJob(tx, job); txErr != nil {
+				return txErr
+			}
+			if job.Id >= nextJobId {
+				nextJobId = job.Id + 1
+			}
+			count++
+		}
+		meta := tx.Bucket(bucketMeta)
+		if txErr := meta.Put(metaKeyNextJobId, itob(nextJobId)); txErr != nil {
+			return txErr
+		}
+		return meta.Put(metaKeyMigrated, []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err == nil {
+		log.WithFields(log.Fields{
+			"jobDir": datastore.jobDir,
+			"count":  count,
+		}).Info("Migrated job files into database")
+	}
+	return err
+}
+
+func itob(value int) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(value))
+	return buf
+}
+
+func btoi(buf []byte) int {
+	return int(binary.BigEndian.Uint64(buf))
+}
+
+func statusIndexValue(status int) string {
+	return fmt.Sprintf("%d", status)
+}
+
+func indexPrefix(value string) []byte {
+	return append([]byte(value), 0)
+}
+
+// Index keys are the indexed value, a NUL separator, and the big-endian job id, so
+// that a prefix scan over a single value yields the matching job ids in id order.
+func indexKey(value string, id int) []byte {
+	return append(indexPrefix(value), itob(id)...)
+}
+
+func timeIndexKey(timestamp time.Time, id int) []byte {
+	return append(itob(int(timestamp.UnixNano())), itob(id)...)
+}
@@ -0,0 +1,570 @@
+// Copyright 2019 Jason Ertel (github.com/jertel).
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/security-onion-solutions/securityonion-soc/server"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+const MY_USER_ID = "123"
+const ANOTHER_USER_ID = "124"
+const JOB_DIR = "/tmp/sensoroni.boltjobs"
+
+func newContext() context.Context {
+	user := model.NewUser()
+	user.Id = MY_USER_ID
+	return context.WithValue(context.Background(), web.ContextKeyRequestor, user)
+}
+
+func cleanup(ds *BoltDatastoreImpl) {
+	if ds != nil {
+		ds.Close()
+	}
+	os.RemoveAll(JOB_DIR)
+}
+
+func createDatastore(authorized bool, legacyJobFiles map[string][]byte) (*BoltDatastoreImpl, error) {
+	os.RemoveAll(JOB_DIR)
+
+	var srv *server.Server
+	if authorized {
+		srv = server.NewFakeAuthorizedServer(nil)
+	} else {
+		srv = server.NewFakeUnauthorizedServer()
+	}
+	ds := NewBoltDatastoreImpl(srv)
+	cfg := make(module.ModuleConfig)
+	cfg["jobDir"] = JOB_DIR
+	os.MkdirAll(JOB_DIR, 0777)
+
+	for name, contents := range legacyJobFiles {
+		path := filepath.Join(JOB_DIR, name)
+		os.MkdirAll(filepath.Dir(path), 0777)
+		os.WriteFile(path, contents, 0644)
+	}
+	err := ds.Init(cfg)
+	node := ds.CreateNode(newContext(), "foo")
+	node.Role = "rolo"
+	ds.addNode(node)
+	return ds, err
+}
+
+func getJobsOfKind(ds *BoltDatastoreImpl, kind string) []*model.Job {
+	query := model.NewJobQuery()
+	if kind != "" {
+		query.Kind = kind
+	}
+	page, _ := ds.GetJobs(newContext(), query)
+	return page.Jobs
+}
+
+func TestBoltDatastoreInit(tester *testing.T) {
+	ds, err := createDatastore(true, nil)
+	defer cleanup(ds)
+	assert.NoError(tester, err)
+	assert.Equal(tester, DEFAULT_RETRY_FAILURE_INTERVAL_MS, ds.retryFailureIntervalMs)
+	assert.Equal(tester, JOB_DIR+"/jobs.db", ds.dbFile)
+}
+
+func TestBoltDatastoreMigrate(tester *testing.T) {
+	files := map[string][]byte{
+		"foo/1005.json":  []byte(`{"id":1005,"nodeId":"foo","status":0,"userId":"123"}`),
+		"foo/1007.json":  []byte(`{"id":1007,"nodeId":"foo","status":1,"kind":"analyze","userId":"124"}`),
+		"bar/bogus.json": []byte("garbage"),
+	}
+	ds, err := createDatastore(true, files)
+	defer cleanup(ds)
+	assert.NoError(tester, err)
+
+	job := ds.GetJob(newContext(), 1005)
+	if assert.NotNil(tester, job) {
+		assert.Equal(tester, "foo", job.GetNodeId())
+	}
+	assert.Len(tester, getJobsOfKind(ds, "analyze"), 1)
+	assert.Equal(tester, 1008, ds.CreateJob(newContext()).Id)
+
+	// Migration only runs once; files added afterwards are ignored on restart
+	os.WriteFile(JOB_DIR+"/foo/1010.json", []byte(`{"id":1010,"nodeId":"foo"}`), 0644)
+	ds.Close()
+	cfg := module.ModuleConfig{"jobDir": JOB_DIR}
+	assert.NoError(tester, ds.Init(cfg))
+	assert.Nil(tester, ds.GetJob(newContext(), 1010))
+	assert.NotNil(tester, ds.GetJob(newContext(), 1005))
+	assert.Equal(tester, 1009, ds.CreateJob(newContext()).Id)
+}
+
+func TestJobs(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	assert.Equal(tester, 1001, job.Id)
+	assert.NoError(tester, ds.addJob(job))
+	job = ds.CreateJob(newContext())
+	assert.Equal(tester, 1002, job.Id)
+	assert.NoError(tester, ds.addJob(job))
+	assert.Error(tester, ds.addJob(job))
+
+	job = ds.CreateJob(newContext())
+	job.Kind = "foo"
+	ds.addJob(job)
+
+	job = ds.GetJob(newContext(), 1003)
+	assert.Equal(tester, "foo", job.GetKind())
+	assert.Nil(tester, ds.GetJob(newContext(), 1004))
+
+	jobs := getJobsOfKind(ds, "")
+	assert.Len(tester, jobs, 2)
+
+	ds.deleteJob(jobs[0])
+	jobs = getJobsOfKind(ds, "")
+	assert.Len(tester, jobs, 1)
+	ds.deleteJob(jobs[0])
+	assert.Len(tester, getJobsOfKind(ds, ""), 0)
+	assert.Len(tester, getJobsOfKind(ds, "foo"), 1)
+}
+
+func TestGetNextJob(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	assert.Nil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	older := ds.CreateJob(newContext())
+	older.SetNodeId("Foo")
+	older.CreateTime = time.Now().Add(-time.Hour)
+	older.Complete()
+	ds.addJob(older)
+
+	failed := ds.CreateJob(newContext())
+	failed.SetNodeId("foo")
+	failed.CreateTime = time.Now().Add(-time.Minute * 30)
+	failed.Status = model.JobStatusIncomplete
+	failed.FailTime = time.Now()
+	ds.addJob(failed)
+
+	pending := ds.CreateJob(newContext())
+	pending.SetNodeId("foo")
+	ds.addJob(pending)
+
+	other := ds.CreateJob(newContext())
+	other.SetNodeId("bar")
+	other.CreateTime = time.Now().Add(-time.Hour * 2)
+	ds.addJob(other)
+
+	parent := ds.CreateJob(newContext())
+	parent.NodeIds = []string{"foo", "bar"}
+	parent.CreateTime = time.Now().Add(-time.Hour * 3)
+	ds.addJob(parent)
+
+	// Only the queued jobs of the node are read, and parents are never handed out
+	ds.db.View(func(tx *bolt.Tx) error {
+		assert.ElementsMatch(tester, []int{pending.Id, failed.Id, parent.Id}, ds.queuedJobIds(tx, "foo"))
+		return nil
+	})
+
+	// Recently failed job is not yet eligible for retry
+	job := ds.GetNextJob(newContext(), "FOO")
+	if assert.NotNil(tester, job) {
+		assert.Equal(tester, pending.Id, job.Id)
+	}
+
+	ds.retryFailureIntervalMs = 0
+	job = ds.GetNextJob(newContext(), "foo")
+	if assert.NotNil(tester, job) {
+		assert.Equal(tester, failed.Id, job.Id)
+	}
+}
+
+func TestJobAddUnauthorized(tester *testing.T) {
+	ds, _ := createDatastore(false, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	err := ds.AddJob(newContext(), job)
+	assert.Error(tester, err)
+	assert.Nil(tester, ds.getJobById(job.Id))
+}
+
+func TestJobAdd(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	err := ds.AddJob(newContext(), job)
+	assert.NoError(tester, err)
+
+	newJob := ds.GetJob(newContext(), job.Id)
+	assert.Equal(tester, MY_USER_ID, newJob.UserId)
+	assert.Equal(tester, []int{job.Id}, ds.scanIndexForTest(bucketIndexOwner, MY_USER_ID))
+}
+
+func TestJobReadAuthorization(tester *testing.T) {
+	ds, _ := createDatastore(false, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	job.UserId = ANOTHER_USER_ID
+	job.Id = 10002
+	ds.addJob(job)
+
+	job = ds.CreateJob(newContext())
+	job.UserId = MY_USER_ID
+	job.Id = 10001
+	ds.addJob(job)
+
+	assert.NotNil(tester, ds.GetJob(newContext(), 10001))
+	assert.Nil(tester, ds.GetJob(newContext(), 10002))
+	assert.Len(tester, getJobsOfKind(ds, ""), 1)
+}
+
+func TestJobDeleteAuthorization(tester *testing.T) {
+	ds, _ := createDatastore(false, nil)
+	defer cleanup(ds)
+
+	anotherJob := ds.CreateJob(newContext())
+	anotherJob.UserId = ANOTHER_USER_ID
+	anotherJob.Id = 10002
+	ds.addJob(anotherJob)
+
+	myJob := ds.CreateJob(newContext())
+	myJob.UserId = MY_USER_ID
+	myJob.Id = 10001
+	ds.addJob(myJob)
+
+	_, err := ds.DeleteJob(newContext(), 10002)
+	assert.Error(tester, err)
+	assert.NotNil(tester, ds.getJobById(10002))
+
+	_, err = ds.DeleteJob(newContext(), 10001)
+	assert.NoError(tester, err)
+	assert.Nil(tester, ds.getJobById(10001))
+	assert.Len(tester, ds.scanIndexForTest(bucketIndexNode, ""), 1)
+}
+
+func TestUpdateInelegible(tester *testing.T) {
+	ds, _ := createDatastore(false, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	job.UserId = MY_USER_ID
+	ds.addJob(job)
+
+	err := ds.UpdateJob(newContext(), job)
+	assert.Error(tester, err)
+}
+
+func TestUpdatePreserveData(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	job.UserId = MY_USER_ID
+	job.NodeId = "some node"
+	ds.addJob(job)
+
+	newJob := ds.CreateJob(newContext())
+	newJob.Id = job.Id
+	newJob.UserId = ANOTHER_USER_ID
+	newJob.NodeId = "some other node"
+	newJob.Complete()
+	err := ds.UpdateJob(newContext(), newJob)
+	assert.NoError(tester, err)
+	assert.Equal(tester, job.UserId, newJob.UserId)
+	assert.Equal(tester, job.NodeId, newJob.NodeId)
+
+	// Indexes must follow the updated status
+	assert.Empty(tester, ds.scanIndexForTest(bucketIndexStatus, statusIndexValue(model.JobStatusPending)))
+	assert.Equal(tester, []int{job.Id}, ds.scanIndexForTest(bucketIndexStatus, statusIndexValue(model.JobStatusCompleted)))
+
+	assert.Error(tester, ds.UpdateJob(newContext(), newJob))
+}
+
+func TestUpdateReplayed(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	job.UserId = MY_USER_ID
+	job.NodeId = "some node"
+	ds.addJob(job)
+
+	update := ds.CreateJob(newContext())
+	update.Id = job.Id
+	update.UpdateKey = "abc"
+	update.Complete()
+	assert.NoError(tester, ds.UpdateJob(newContext(), update))
+
+	// Replaying the same update is acknowledged without changing the stored job
+	replay := ds.CreateJob(newContext())
+	replay.Id = job.Id
+	replay.UpdateKey = "abc"
+	replay.Fail(errors.New("stale"))
+	assert.NoError(tester, ds.UpdateJob(newContext(), replay))
+	assert.Equal(tester, model.JobStatusCompleted, replay.Status)
+	assert.Equal(tester, model.JobStatusCompleted, ds.getJobById(job.Id).Status)
+
+	// A different update can't overwrite the completed job
+	replay.UpdateKey = "def"
+	assert.Error(tester, ds.UpdateJob(newContext(), replay))
+}
+
+func TestGetStreamFilename(tester *testing.T) {
+	ds, _ := createDatastore(false, nil)
+	defer cleanup(ds)
+	filename := ds.getStreamFilename(ds.CreateJob(newContext()))
+	assert.Equal(tester, "/tmp/sensoroni.boltjobs/1001.bin", filename)
+}
+
+func (datastore *BoltDatastoreImpl) scanIndexForTest(bucket []byte, value string) []int {
+	var ids []int
+	datastore.db.View(func(tx *bolt.Tx) error {
+		ids = datastore.scanIndex(tx, bucket, value)
+		return nil
+	})
+	return ids
+}
+
+func TestSetJobPriority(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+	ds.pivotPriorityBoost = 3
+
+	old := ds.CreateJob(newContext())
+	old.SetNodeId("foo")
+	old.CreateTime = time.Now().Add(-time.Hour)
+	ds.addJob(old)
+
+	pivot := ds.CreateJob(newContext())
+	pivot.SetNodeId("foo")
+	assert.NoError(tester, ds.AddPivotJob(newContext(), pivot))
+	assert.Equal(tester, pivot.Id, ds.GetNextJob(newContext(), "foo").Id)
+
+	job, err := ds.SetJobPriority(newContext(), old.Id, 4)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 4, job.Priority)
+	assert.Equal(tester, old.Id, ds.GetNextJob(newContext(), "foo").Id)
+
+	_, err = ds.SetJobPriority(newContext(), 9999, 1)
+	assert.EqualError(tester, err, "Job not found")
+}
+
+func TestCancelJob(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	ds.addJob(job)
+	assert.NotNil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	cancelled, err := ds.CancelJob(newContext(), job.Id)
+	assert.NoError(tester, err)
+	assert.Equal(tester, model.JobStatusCancelRequested, cancelled.Status)
+	assert.Nil(tester, ds.GetNextJob(newContext(), "foo"))
+	assert.Equal(tester, []int{job.Id}, ds.scanIndexForTest(bucketIndexStatus, statusIndexValue(model.JobStatusCancelRequested)))
+
+	_, err = ds.CancelJob(newContext(), job.Id)
+	assert.EqualError(tester, err, "Job is no longer queued")
+
+	// Agent reports the failure caused by cancellation; job must not be requeued
+	update := ds.CreateJob(newContext())
+	update.Id = job.Id
+	update.Fail(errors.New("context canceled"))
+	assert.NoError(tester, ds.UpdateJob(newContext(), update))
+	assert.Equal(tester, model.JobStatusCancelled, ds.getJobById(job.Id).Status)
+	assert.Error(tester, ds.UpdateJob(newContext(), update))
+}
+
+func TestCancelJobNotLeased(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	pending := ds.CreateJob(newContext())
+	pending.SetNodeId("foo")
+	ds.addJob(pending)
+
+	// No agent holds the job, so it is cancelled right away
+	cancelled, err := ds.CancelJob(newContext(), pending.Id)
+	assert.NoError(tester, err)
+	assert.Equal(tester, model.JobStatusCancelled, cancelled.Status)
+	assert.False(tester, cancelled.CompleteTime.IsZero())
+	assert.Nil(tester, ds.GetNextJob(newContext(), "foo"))
+	assert.Equal(tester, []int{pending.Id}, ds.scanIndexForTest(bucketIndexStatus, statusIndexValue(model.JobStatusCancelled)))
+
+	// Same for a failed job waiting to be retried
+	retrying := ds.CreateJob(newContext())
+	retrying.SetNodeId("foo")
+	ds.addJob(retrying)
+	assert.NotNil(tester, ds.GetNextJob(newContext(), "foo"))
+	update := ds.CreateJob(newContext())
+	update.Id = retrying.Id
+	update.Fail(errors.New("agent failure"))
+	assert.NoError(tester, ds.UpdateJob(newContext(), update))
+	cancelled, err = ds.CancelJob(newContext(), retrying.Id)
+	assert.NoError(tester, err)
+	assert.Equal(tester, model.JobStatusCancelled, cancelled.Status)
+
+	// Parents are settled by their children instead
+	parent := ds.CreateJob(newContext())
+	parent.ChildIds = []int{pending.Id}
+	ds.addJob(parent)
+	cancelled, err = ds.CancelJob(newContext(), parent.Id)
+	assert.NoError(tester, err)
+	assert.Equal(tester, model.JobStatusCancelRequested, cancelled.Status)
+}
+
+func TestUpdateJobProgress(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	ds.addJob(job)
+
+	progress := model.NewJobProgress()
+	progress.PacketsMatched = 42
+	_, err := ds.UpdateJobProgress(newContext(), job.Id, progress)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 42, ds.GetJob(newContext(), job.Id).Progress.PacketsMatched)
+
+	updated, err := ds.UpdateJobProgress(newContext(), 9999, progress)
+	assert.EqualError(tester, err, "Job not found")
+	assert.Nil(tester, updated)
+}
+
+func TestGetJobsQuery(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		job := ds.CreateJob(newContext())
+		job.CreateTime = created.Add(time.Duration(i) * time.Hour)
+		job.SetNodeId("foo")
+		if i%2 == 1 {
+			job.SetNodeId("bar")
+			job.Status = model.JobStatusCompleted
+		}
+		if i == 5 {
+			job.Kind = "analyze"
+		}
+		ds.addJob(job)
+	}
+	other := ds.CreateJob(newContext())
+	other.UserId = ANOTHER_USER_ID
+	ds.addJob(other)
+
+	query := model.NewJobQuery()
+	query.Kind = ""
+	query.Statuses = []int{model.JobStatusCompleted}
+	page, err := ds.GetJobs(newContext(), query)
+	assert.NoError(tester, err)
+	assert.Len(tester, page.Jobs, 3)
+
+	query = model.NewJobQuery()
+	query.UserId = ANOTHER_USER_ID
+	page, _ = ds.GetJobs(newContext(), query)
+	if assert.Len(tester, page.Jobs, 1) {
+		assert.Equal(tester, other.Id, page.Jobs[0].Id)
+	}
+
+	query = model.NewJobQuery()
+	query.NodeId = "foo"
+	query.CreateTimeBegin = created.Add(time.Hour)
+	query.SortBy = model.JobSortCreateTime
+	query.SortDescending = true
+	query.Limit = 1
+	page, _ = ds.GetJobs(newContext(), query)
+	assert.Equal(tester, 2, page.TotalCount)
+	if assert.Len(tester, page.Jobs, 1) {
+		assert.Equal(tester, created.Add(4*time.Hour), page.Jobs[0].CreateTime.UTC())
+	}
+	query.Cursor = page.NextCursor
+	page, _ = ds.GetJobs(newContext(), query)
+	if assert.Len(tester, page.Jobs, 1) {
+		assert.Equal(tester, created.Add(2*time.Hour), page.Jobs[0].CreateTime.UTC())
+	}
+	assert.Empty(tester, page.NextCursor)
+}
+
+func (datastore *BoltDatastoreImpl) candidateJobIdsForTest(query *model.JobQuery) []int {
+	var ids []int
+	datastore.db.View(func(tx *bolt.Tx) error {
+		ids = datastore.candidateJobIds(tx, query)
+		return nil
+	})
+	return ids
+}
+
+func TestCandidateJobIds(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ids := make([]int, 0)
+	for i, nodeId := range []string{"foo", "bar", "foo", "bar"} {
+		job := ds.CreateJob(newContext())
+		job.SetNodeId(nodeId)
+		job.CreateTime = created.Add(time.Duration(i) * time.Hour)
+		ds.addJob(job)
+		ids = append(ids, job.Id)
+	}
+	parent := ds.CreateJob(newContext())
+	parent.NodeIds = []string{"Foo", "bar"}
+	parent.CreateTime = created.Add(10 * time.Hour)
+	ds.addJob(parent)
+
+	query := model.NewJobQuery()
+	query.NodeId = "FOO"
+	assert.ElementsMatch(tester, []int{ids[0], ids[2], parent.Id}, ds.candidateJobIdsForTest(query))
+	query.NodeId = "bar"
+	assert.ElementsMatch(tester, []int{ids[1], ids[3], parent.Id}, ds.candidateJobIdsForTest(query))
+
+	query = model.NewJobQuery()
+	query.CreateTimeBegin = created.Add(time.Hour)
+	query.CreateTimeEnd = created.Add(3 * time.Hour)
+	assert.Equal(tester, []int{ids[1], ids[2]}, ds.candidateJobIdsForTest(query))
+	query.CreateTimeBegin = time.Time{}
+	assert.Equal(tester, []int{ids[0], ids[1], ids[2]}, ds.candidateJobIdsForTest(query))
+	query.CreateTimeBegin = created.Add(3 * time.Hour)
+	query.CreateTimeEnd = time.Time{}
+	assert.Equal(tester, []int{ids[3], parent.Id}, ds.candidateJobIdsForTest(query))
+}
+
+func TestIndexNodeIdsBackfill(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	parent := ds.CreateJob(newContext())
+	parent.NodeIds = []string{"foo"}
+	ds.addJob(parent)
+
+	// Simulate a parent stored before its target nodes were indexed
+	assert.NoError(tester, ds.db.Update(func(tx *bolt.Tx) error {
+		tx.Bucket(bucketIndexNode).Delete(indexKey("foo", parent.Id))
+		return tx.Bucket(bucketMeta).Delete(metaKeyIndexedNodeIds)
+	}))
+	assert.Empty(tester, ds.scanIndexForTest(bucketIndexNode, "foo"))
+
+	ds.Close()
+	cfg := make(module.ModuleConfig)
+	cfg["jobDir"] = JOB_DIR
+	assert.NoError(tester, ds.Init(cfg))
+	assert.Equal(tester, []int{parent.Id}, ds.scanIndexForTest(bucketIndexNode, "foo"))
+}
@@ -0,0 +1,178 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/security-onion-solutions/securityonion-soc/json"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	bolt "go.etcd.io/bbolt"
+)
+
+func (datastore *BoltDatastoreImpl) scheduleIsAllowed(ctx context.Context, schedule *model.JobSchedule, op string) bool {
+	allowed := false
+
+	if schedule != nil {
+		if err := datastore.server.CheckAuthorized(ctx, op, "jobs"); err == nil {
+			// User can operate on all schedules
+			allowed = true
+		} else {
+			// User is only authorized against their own schedules.
+			if user, ok := ctx.Value(web.ContextKeyRequestor).(*model.User); ok {
+				if schedule.UserId == user.Id {
+					allowed = true
+				}
+			}
+		}
+	}
+	return allowed
+}
+
+func (datastore *BoltDatastoreImpl) GetJobSchedules(ctx context.Context) []*model.JobSchedule {
+	// The server needs to see every schedule in order to run them.
+	processor := datastore.server.CheckAuthorized(ctx, "process", "jobs") == nil
+
+	schedules := make([]*model.JobSchedule, 0)
+	err := datastore.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSchedules).ForEach(func(key []byte, value []byte) error {
+			schedule := datastore.readJobSchedule(tx, string(key))
+			if schedule != nil && (processor || datastore.scheduleIsAllowed(ctx, schedule, "read")) {
+				schedules = append(schedules, schedule)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.WithError(err).Error("Unable to read job schedules")
+	}
+	return schedules
+}
+
+func (datastore *BoltDatastoreImpl) GetJobSchedule(ctx context.Context, scheduleId string) *model.JobSchedule {
+	var schedule *model.JobSchedule
+	datastore.db.View(func(tx *bolt.Tx) error {
+		schedule = datastore.readJobSchedule(tx, scheduleId)
+		return nil
+	})
+	if !datastore.scheduleIsAllowed(ctx, schedule, "read") {
+		schedule = nil
+	}
+	return schedule
+}
+
+func (datastore *BoltDatastoreImpl) AddJobSchedule(ctx context.Context, schedule *model.JobSchedule) error {
+	var err error
+	if err = datastore.server.CheckAuthorized(ctx, "write", "jobs"); err == nil {
+		if user, ok := ctx.Value(web.ContextKeyRequestor).(*model.User); ok {
+			schedule.UserId = user.Id
+		} else {
+			return errors.New("User not found in context")
+		}
+		schedule.Id = uuid.New().String()
+		schedule.CreateTime = time.Now()
+		schedule.UpdateTime = schedule.CreateTime
+		schedule.LastRunTime = time.Time{}
+		schedule.History = make([]*model.JobScheduleRun, 0)
+
+		err = datastore.db.Update(func(tx *bolt.Tx) error {
+			return datastore.writeJobSchedule(tx, schedule)
+		})
+		if err == nil {
+			log.WithFields(log.Fields{
+				"id":             schedule.Id,
+				"cronExpression": schedule.CronExpression,
+			}).Info("Added job schedule")
+		}
+	}
+	return err
+}
+
+func (datastore *BoltDatastoreImpl) UpdateJobSchedule(ctx context.Context, schedule *model.JobSchedule) (*model.JobSchedule, error) {
+	var existing *model.JobSchedule
+	err := datastore.db.Update(func(tx *bolt.Tx) error {
+		existing = datastore.readJobSchedule(tx, schedule.Id)
+		if existing == nil {
+			return errors.New("Job schedule not found")
+		}
+		if !datastore.scheduleIsAllowed(ctx, existing, "write") {
+			return errors.New("Permission denied attempting to update job schedule")
+		}
+		// Only copy the following values from the incoming schedule. Preserve everything else.
+		existing.Name = schedule.Name
+		existing.CronExpression = schedule.CronExpression
+		existing.Template = schedule.Template
+		existing.LookbackSeconds = schedule.LookbackSeconds
+		existing.Enabled = schedule.Enabled
+		existing.NextRunTime = schedule.NextRunTime
+		existing.UpdateTime = time.Now()
+		return datastore.writeJobSchedule(tx, existing)
+	})
+	return existing, err
+}
+
+func (datastore *BoltDatastoreImpl) DeleteJobSchedule(ctx context.Context, scheduleId string) (*model.JobSchedule, error) {
+	var schedule *model.JobSchedule
+	err := datastore.db.Update(func(tx *bolt.Tx) error {
+		schedule = datastore.readJobSchedule(tx, scheduleId)
+		if schedule == nil {
+			return errors.New("Job schedule not found")
+		}
+		if !datastore.scheduleIsAllowed(ctx, schedule, "delete") {
+			return errors.New("Permission denied attempting to delete job schedule")
+		}
+		return tx.Bucket(bucketSchedules).Delete([]byte(scheduleId))
+	})
+	if err == nil {
+		log.WithField("id", scheduleId).Info("Deleted job schedule")
+	}
+	return schedule, err
+}
+
+// RecordJobScheduleRun appends the run to the schedule's history and sets the time
+// the schedule should next run.
+func (datastore *BoltDatastoreImpl) RecordJobScheduleRun(ctx context.Context, scheduleId string, run *model.JobScheduleRun, nextRunTime time.Time) (*model.JobSchedule, error) {
+	var err error
+	var schedule *model.JobSchedule
+	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
+		err = datastore.db.Update(func(tx *bolt.Tx) error {
+			schedule = datastore.readJobSchedule(tx, scheduleId)
+			if schedule == nil {
+				return errors.New("Job schedule not found")
+			}
+			schedule.AddRun(run, datastore.scheduleHistoryLength)
+			schedule.NextRunTime = nextRunTime
+			return datastore.writeJobSchedule(tx, schedule)
+		})
+	}
+	return schedule, err
+}
+
+func (datastore *BoltDatastoreImpl) readJobSchedule(tx *bolt.Tx, scheduleId string) *model.JobSchedule {
+	var schedule *model.JobSchedule
+	content := tx.Bucket(bucketSchedules).Get([]byte(scheduleId))
+	if content != nil {
+		schedule = model.NewJobSchedule()
+		if err := json.LoadJson(content, schedule); err != nil {
+			log.WithError(err).WithField("scheduleId", scheduleId).Error("Unable to decode stored job schedule")
+			schedule = nil
+		}
+	}
+	return schedule
+}
+
+func (datastore *BoltDatastoreImpl) writeJobSchedule(tx *bolt.Tx, schedule *model.JobSchedule) error {
+	content, err := json.WriteJson(schedule)
+	if err == nil {
+		err = tx.Bucket(bucketSchedules).Put([]byte(schedule.Id), content)
+	}
+	return err
+}
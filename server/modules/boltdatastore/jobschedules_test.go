@@ -0,0 +1,102 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestJobSchedule() *model.JobSchedule {
+	schedule := model.NewJobSchedule()
+	schedule.Name = "Watchlist"
+	schedule.CronExpression = "0 * * * *"
+	schedule.Template.SetNodeId("foo")
+	return schedule
+}
+
+func TestJobScheduleLifecycle(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+	ctx := newContext()
+
+	schedule := newTestJobSchedule()
+	schedule.UserId = ANOTHER_USER_ID
+	assert.NoError(tester, ds.AddJobSchedule(ctx, schedule))
+	assert.NotEmpty(tester, schedule.Id)
+	assert.Equal(tester, MY_USER_ID, schedule.UserId)
+	assert.Len(tester, ds.GetJobSchedules(ctx), 1)
+	assert.Equal(tester, schedule.Name, ds.GetJobSchedule(ctx, schedule.Id).Name)
+
+	run := &model.JobScheduleRun{Time: time.Now(), JobId: 1001}
+	next := time.Now().Add(time.Hour)
+	_, err := ds.RecordJobScheduleRun(ctx, schedule.Id, run, next)
+	assert.NoError(tester, err)
+
+	update := newTestJobSchedule()
+	update.Id = schedule.Id
+	update.Name = "Renamed"
+	update.Enabled = false
+	update.UserId = ANOTHER_USER_ID
+	updated, err := ds.UpdateJobSchedule(ctx, update)
+	assert.NoError(tester, err)
+	assert.Equal(tester, "Renamed", updated.Name)
+	assert.False(tester, updated.Enabled)
+	assert.Equal(tester, MY_USER_ID, updated.UserId)
+	assert.Len(tester, updated.History, 1)
+
+	// Schedules survive a restart
+	ds.Close()
+	cfg := make(module.ModuleConfig)
+	cfg["jobDir"] = JOB_DIR
+	assert.NoError(tester, ds.Init(cfg))
+	loaded := ds.GetJobSchedule(ctx, schedule.Id)
+	if assert.NotNil(tester, loaded) {
+		assert.Equal(tester, "Renamed", loaded.Name)
+		assert.Equal(tester, 1001, loaded.History[0].JobId)
+	}
+
+	_, err = ds.DeleteJobSchedule(ctx, schedule.Id)
+	assert.NoError(tester, err)
+	assert.Empty(tester, ds.GetJobSchedules(ctx))
+	_, err = ds.DeleteJobSchedule(ctx, schedule.Id)
+	assert.EqualError(tester, err, "Job schedule not found")
+}
+
+func TestJobScheduleUnauthorized(tester *testing.T) {
+	ds, _ := createDatastore(false, nil)
+	defer cleanup(ds)
+	ctx := newContext()
+
+	assert.Error(tester, ds.AddJobSchedule(ctx, newTestJobSchedule()))
+
+	mine := newTestJobSchedule()
+	mine.Id = "mine"
+	mine.UserId = MY_USER_ID
+	ds.db.Update(func(tx *bolt.Tx) error { return ds.writeJobSchedule(tx, mine) })
+	theirs := newTestJobSchedule()
+	theirs.Id = "theirs"
+	theirs.UserId = ANOTHER_USER_ID
+	ds.db.Update(func(tx *bolt.Tx) error { return ds.writeJobSchedule(tx, theirs) })
+
+	schedules := ds.GetJobSchedules(ctx)
+	if assert.Len(tester, schedules, 1) {
+		assert.Equal(tester, "mine", schedules[0].Id)
+	}
+	assert.Nil(tester, ds.GetJobSchedule(ctx, "theirs"))
+
+	_, err := ds.UpdateJobSchedule(ctx, theirs)
+	assert.EqualError(tester, err, "Permission denied attempting to update job schedule")
+	_, err = ds.DeleteJobSchedule(ctx, "theirs")
+	assert.EqualError(tester, err, "Permission denied attempting to delete job schedule")
+	_, err = ds.RecordJobScheduleRun(ctx, "mine", &model.JobScheduleRun{}, time.Now())
+	assert.Error(tester, err)
+}
@@ -0,0 +1,98 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+const DEFAULT_JOB_LEASE_DURATION_MS = 120000
+const DEFAULT_JOB_MAX_ATTEMPTS = 3
+
+// RenewJobLease extends the lease of a job that an agent is still processing.
+func (datastore *BoltDatastoreImpl) RenewJobLease(ctx context.Context, jobId int) (*model.Job, error) {
+	var err error
+	var job *model.Job
+	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
+		err = datastore.db.Update(func(tx *bolt.Tx) error {
+			job = datastore.readJob(tx, jobId)
+			if job == nil {
+				return errors.New("Job not found")
+			}
+			if !job.CanProcess() {
+				return errors.New("Job is ineligible for processing")
+			}
+			job.Lease(time.Now(), datastore.leaseDuration())
+			return datastore.writeJob(tx, job)
+		})
+	}
+	return job, err
+}
+
+// ExpireJobLeases returns every job whose lease has expired to pending, or fails the
+// job once it has run out of attempts. Returns the jobs that were changed.
+func (datastore *BoltDatastoreImpl) ExpireJobLeases(ctx context.Context) ([]*model.Job, error) {
+	var err error
+	expired := make([]*model.Job, 0)
+	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
+		now := time.Now()
+		err = datastore.db.Update(func(tx *bolt.Tx) error {
+			for _, status := range []int{model.JobStatusPending, model.JobStatusIncomplete, model.JobStatusCancelRequested} {
+				for _, id := range datastore.scanIndex(tx, bucketIndexStatus, statusIndexValue(status)) {
+					job := datastore.readJob(tx, id)
+					if job == nil {
+						continue
+					}
+					changed, txErr := datastore.expireLease(tx, job, now)
+					if txErr != nil {
+						return txErr
+					}
+					if changed {
+						expired = append(expired, job)
+					}
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			datastore.server.JobNotifier.NotifyQueued(expired)
+		}
+	}
+	return expired, err
+}
+
+func (datastore *BoltDatastoreImpl) leaseDuration() time.Duration {
+	return time.Duration(datastore.jobLeaseDurationMs) * time.Millisecond
+}
+
+// expireLease expires the job's lease if it has lapsed. Returns true if the job was
+// changed.
+func (datastore *BoltDatastoreImpl) expireLease(tx *bolt.Tx, job *model.Job, now time.Time) (bool, error) {
+	if !job.IsLeaseExpired(now) {
+		return false, nil
+	}
+
+	if err := datastore.deleteIndexes(tx, job); err != nil {
+		return false, err
+	}
+	job.ExpireLease(now, datastore.jobMaxAttempts)
+	if err := datastore.writeJob(tx, job); err != nil {
+		return false, err
+	}
+	log.WithFields(log.Fields{
+		"id":        job.Id,
+		"nodeId":    job.GetNodeId(),
+		"status":    job.Status,
+		"failCount": job.FailCount,
+	}).Warn("Job lease expired")
+	return true, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// lapseLease simulates an agent that stopped sending heartbeats.
+func lapseLease(ds *BoltDatastoreImpl, jobId int) {
+	ds.db.Update(func(tx *bolt.Tx) error {
+		job := ds.readJob(tx, jobId)
+		job.LeaseExpireTime = time.Now().Add(-time.Second)
+		return ds.writeJob(tx, job)
+	})
+}
+
+func TestGetNextJobLeases(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+
+	next := ds.GetNextJob(newContext(), "foo")
+	if assert.NotNil(tester, next) {
+		assert.Equal(tester, job.Id, next.Id)
+		assert.True(tester, next.IsLeased(time.Now()))
+		assert.True(tester, ds.GetJob(newContext(), job.Id).IsLeased(time.Now()))
+	}
+
+	// Leased jobs are not handed out again
+	assert.Nil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	renewed, err := ds.RenewJobLease(newContext(), job.Id)
+	assert.NoError(tester, err)
+	assert.False(tester, renewed.LeaseExpireTime.Before(next.LeaseExpireTime))
+
+	// Reporting back releases the lease
+	next.Complete()
+	assert.NoError(tester, ds.UpdateJob(newContext(), next))
+	assert.True(tester, ds.GetJob(newContext(), job.Id).LeaseExpireTime.IsZero())
+
+	_, err = ds.RenewJobLease(newContext(), job.Id)
+	assert.EqualError(tester, err, "Job is ineligible for processing")
+}
+
+func TestExpireJobLeases(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+	ds.jobMaxAttempts = 2
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+	assert.NotNil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	expired, err := ds.ExpireJobLeases(newContext())
+	assert.NoError(tester, err)
+	assert.Len(tester, expired, 0)
+
+	lapseLease(ds, job.Id)
+	expired, err = ds.ExpireJobLeases(newContext())
+	assert.NoError(tester, err)
+	if assert.Len(tester, expired, 1) {
+		assert.Equal(tester, model.JobStatusPending, expired[0].Status)
+		assert.Equal(tester, 1, expired[0].FailCount)
+	}
+
+	// The job is handed out again, and expires a second time while leased to the next
+	// agent, but this time lazily while the node polls for work
+	assert.Equal(tester, job.Id, ds.GetNextJob(newContext(), "foo").Id)
+	lapseLease(ds, job.Id)
+	assert.Nil(tester, ds.GetNextJob(newContext(), "foo"))
+	failed := ds.GetJob(newContext(), job.Id)
+	assert.Equal(tester, model.JobStatusFailed, failed.Status)
+	assert.Equal(tester, 2, failed.FailCount)
+	assert.NotEmpty(tester, failed.Failure)
+
+	// Status index follows the job
+	ds.db.View(func(tx *bolt.Tx) error {
+		assert.Equal(tester, []int{job.Id}, ds.scanIndex(tx, bucketIndexStatus, statusIndexValue(model.JobStatusFailed)))
+		assert.Empty(tester, ds.scanIndex(tx, bucketIndexStatus, statusIndexValue(model.JobStatusPending)))
+		return nil
+	})
+}
+
+func TestExpireJobLeasesUnauthorized(tester *testing.T) {
+	ds, _ := createDatastore(false, nil)
+	defer cleanup(ds)
+
+	_, err := ds.ExpireJobLeases(newContext())
+	assert.Error(tester, err)
+}
+
+func TestAddJobNotifiesWaitingAgents(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	notification, release := ds.server.JobNotifier.Subscribe("foo")
+	defer release()
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("Foo")
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+	assert.Len(tester, notification, 1)
+	<-notification
+
+	pivot := ds.CreateJob(newContext())
+	pivot.SetNodeId("foo")
+	assert.NoError(tester, ds.AddPivotJob(newContext(), pivot))
+	assert.Len(tester, notification, 1)
+}
+
+func TestRequeueNotifiesWaitingAgents(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+	assert.NotNil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	notification, release := ds.server.JobNotifier.Subscribe("foo")
+	defer release()
+
+	lapseLease(ds, job.Id)
+	_, err := ds.ExpireJobLeases(newContext())
+	assert.NoError(tester, err)
+	assert.Len(tester, notification, 1)
+	<-notification
+
+	_, err = ds.SetJobPriority(newContext(), job.Id, 5)
+	assert.NoError(tester, err)
+	assert.Len(tester, notification, 1)
+	<-notification
+
+	// The agent hands the job back to be retried
+	update := ds.GetNextJob(newContext(), "foo")
+	if assert.NotNil(tester, update) {
+		update.Status = model.JobStatusIncomplete
+		assert.NoError(tester, ds.UpdateJob(newContext(), update))
+		assert.Len(tester, notification, 1)
+		<-notification
+
+		completed := *update
+		completed.Complete()
+		assert.NoError(tester, ds.UpdateJob(newContext(), &completed))
+		assert.Len(tester, notification, 0)
+	}
+}
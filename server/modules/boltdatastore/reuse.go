@@ -0,0 +1,54 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"github.com/apex/log"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+const DEFAULT_REUSE_RESULTS = true
+
+// reuseResults completes the job from the stream of an earlier completed job with the
+// same fingerprint, if that stream is still present on disk. Returns true if the job
+// no longer needs to be processed by an agent.
+func (datastore *BoltDatastoreImpl) reuseResults(job *model.Job) bool {
+	if !datastore.reuseResultsEnabled {
+		return false
+	}
+
+	sources := make([]*model.Job, 0)
+	datastore.db.View(func(tx *bolt.Tx) error {
+		for _, id := range datastore.scanIndex(tx, bucketIndexFingerprint, job.Fingerprint()) {
+			if source := datastore.readJob(tx, id); source != nil {
+				sources = append(sources, source)
+			}
+		}
+		return nil
+	})
+
+	for _, source := range sources {
+		if !job.CanReuseResultsOf(source) {
+			continue
+		}
+
+		size, err := datastore.streams.Reuse(job, source)
+		if err != nil {
+			log.WithError(err).WithField("sourceJobId", source.Id).Debug("Unable to reuse packet stream")
+			continue
+		}
+
+		job.ReuseResultsOf(source, int(size))
+		log.WithFields(log.Fields{
+			"id":          job.Id,
+			"sourceJobId": source.Id,
+			"size":        size,
+		}).Info("Reused packet stream of previous job")
+		return true
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newReuseJob(ds *BoltDatastoreImpl, endTime time.Time) *model.Job {
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	job.Filter.BeginTime = endTime.Add(-time.Hour)
+	job.Filter.EndTime = endTime
+	job.Filter.SrcIp = "10.0.0.1"
+	job.Filter.DstPort = 443
+	return job
+}
+
+func completeReuseSource(tester *testing.T, ds *BoltDatastoreImpl, end time.Time) *model.Job {
+	source := newReuseJob(ds, end)
+	assert.NoError(tester, ds.AddJob(newContext(), source))
+	assert.True(tester, source.IsQueued())
+
+	filename := ds.getStreamFilename(source)
+	os.MkdirAll(filepath.Dir(filename), 0777)
+	assert.NoError(tester, os.WriteFile(filename, []byte("pcap"), 0644))
+	source.Complete()
+	assert.NoError(tester, ds.UpdateJob(newContext(), source))
+	return source
+}
+
+func TestReuseResults(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+	end := time.Now().Add(-time.Hour)
+
+	source := completeReuseSource(tester, ds, end)
+
+	job := newReuseJob(ds, end)
+	assert.NoError(tester, ds.AddPivotJob(newContext(), job))
+	assert.Equal(tester, model.JobStatusCompleted, job.Status)
+	assert.Equal(tester, source.Id, job.ReusedJobId)
+	assert.Equal(tester, 4, job.Size)
+	assert.Equal(tester, source.Id, ds.GetJob(newContext(), job.Id).ReusedJobId)
+
+	_, err := ds.DeleteJob(newContext(), source.Id)
+	assert.NoError(tester, err)
+	content, err := os.ReadFile(ds.getStreamFilename(job))
+	assert.NoError(tester, err)
+	assert.Equal(tester, "pcap", string(content))
+
+	different := newReuseJob(ds, end)
+	different.Filter.DstPort = 80
+	assert.NoError(tester, ds.AddJob(newContext(), different))
+	assert.True(tester, different.IsQueued())
+}
+
+func TestReuseResultsBackfillsIndex(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+	end := time.Now().Add(-time.Hour)
+
+	source := completeReuseSource(tester, ds, end)
+
+	// Simulate a database created before the fingerprint index existed
+	assert.NoError(tester, ds.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(bucketIndexFingerprint)
+	}))
+	ds.Close()
+	cfg := make(module.ModuleConfig)
+	cfg["jobDir"] = JOB_DIR
+	assert.NoError(tester, ds.Init(cfg))
+
+	job := newReuseJob(ds, end)
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+	assert.Equal(tester, source.Id, job.ReusedJobId)
+}
@@ -0,0 +1,153 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+// readableJob returns the job if the requestor may read it.
+func (datastore *BoltDatastoreImpl) readableJob(ctx context.Context, jobId int) (*model.Job, error) {
+	job := datastore.GetJob(ctx, jobId)
+	if job == nil {
+		return nil, errors.New("Job not found")
+	}
+	if !datastore.jobIsAllowed(ctx, job, "read") {
+		return nil, errors.New("Job is inaccessible")
+	}
+	return job, nil
+}
+
+// completedJob returns the job if the requestor may read it and its stream is complete.
+func (datastore *BoltDatastoreImpl) completedJob(ctx context.Context, jobId int) (*model.Job, error) {
+	job, err := datastore.readableJob(ctx, jobId)
+	if err == nil && job.Status != model.JobStatusCompleted {
+		err = errors.New("Job is not complete")
+	}
+	return job, err
+}
+
+// processableJob returns the job if the requestor may write the job's packet stream.
+func (datastore *BoltDatastoreImpl) processableJob(ctx context.Context, jobId int) (*model.Job, error) {
+	if err := datastore.server.CheckAuthorized(ctx, "process", "jobs"); err != nil {
+		return nil, err
+	}
+	job := datastore.getJobById(jobId)
+	if job == nil {
+		return nil, errors.New("Job not found")
+	}
+	if !job.CanProcess() {
+		return nil, errors.New("Job is ineligible for processing")
+	}
+	return job, nil
+}
+
+func (datastore *BoltDatastoreImpl) getStreamFilename(job *model.Job) string {
+	return datastore.streams.Filename(job)
+}
+
+func (datastore *BoltDatastoreImpl) GetPackets(ctx context.Context, jobId int, offset int, count int, unwrap bool) ([]*model.Packet, error) {
+	job, err := datastore.readableJob(ctx, jobId)
+	if err != nil || job.Status != model.JobStatusCompleted {
+		return nil, err
+	}
+	return datastore.streams.Packets(job, offset, count, unwrap), nil
+}
+
+func (datastore *BoltDatastoreImpl) SavePacketStream(ctx context.Context, jobId int, reader io.ReadCloser) error {
+	job, err := datastore.processableJob(ctx, jobId)
+	if err != nil {
+		return err
+	}
+	return datastore.streams.Save(job, reader)
+}
+
+// MergePacketStreams replaces the job's packet stream with the time-ordered merge of
+// the streams of the given completed source jobs. Returns the merged stream size.
+func (datastore *BoltDatastoreImpl) MergePacketStreams(ctx context.Context, jobId int, sourceJobIds []int) (int, error) {
+	job, err := datastore.processableJob(ctx, jobId)
+	if err != nil {
+		return 0, err
+	}
+	sources := make([]*model.Job, 0, len(sourceJobIds))
+	for _, sourceJobId := range sourceJobIds {
+		if source := datastore.getJobById(sourceJobId); source != nil {
+			sources = append(sources, source)
+		}
+	}
+	size, err := datastore.streams.Merge(job, sources)
+	return int(size), err
+}
+
+// GetPacketStream opens the job's packet stream for reading. Returns no stream if the
+// job hasn't completed yet.
+func (datastore *BoltDatastoreImpl) GetPacketStream(ctx context.Context, jobId int, unwrap bool, format string) (io.ReadCloser, string, int64, error) {
+	job, err := datastore.readableJob(ctx, jobId)
+	if err != nil || job.Status != model.JobStatusCompleted {
+		return nil, "", 0, err
+	}
+	return datastore.streams.Open(ctx, job, unwrap, format)
+}
+
+func (datastore *BoltDatastoreImpl) GetTcpStream(ctx context.Context, jobId int, index int, unwrap bool, maxBytes int) (*model.TcpStream, error) {
+	job, err := datastore.completedJob(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	return datastore.streams.TcpStream(job, index, unwrap, maxBytes)
+}
+
+func (datastore *BoltDatastoreImpl) GetCarvedFiles(ctx context.Context, jobId int, unwrap bool, maxBytes int, retained func(index int) bool) ([]*model.CarvedFile, error) {
+	job, err := datastore.completedJob(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	return datastore.streams.CarvedFiles(job, unwrap, maxBytes, retained)
+}
+
+func (datastore *BoltDatastoreImpl) GetPacketStatistics(ctx context.Context, jobId int, unwrap bool, limit int) (*model.PacketStatistics, error) {
+	job, err := datastore.completedJob(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	return datastore.streams.Statistics(job, unwrap, limit)
+}
+
+func (datastore *BoltDatastoreImpl) GetFingerprints(ctx context.Context, jobId int, unwrap bool) (*model.FingerprintSummary, error) {
+	job, err := datastore.completedJob(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	return datastore.streams.Fingerprints(job, unwrap)
+}
+
+func (datastore *BoltDatastoreImpl) GetPacketStreamUpload(ctx context.Context, jobId int) (*model.StreamUpload, error) {
+	job, err := datastore.processableJob(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	return datastore.streams.Upload(job), nil
+}
+
+func (datastore *BoltDatastoreImpl) AppendPacketStreamChunk(ctx context.Context, jobId int, offset int64, checksum string, reader io.Reader) (*model.StreamUpload, error) {
+	job, err := datastore.processableJob(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	return datastore.streams.AppendChunk(job, offset, checksum, reader)
+}
+
+func (datastore *BoltDatastoreImpl) CompletePacketStreamUpload(ctx context.Context, jobId int, size int64, checksum string) error {
+	job, err := datastore.processableJob(ctx, jobId)
+	if err != nil {
+		return err
+	}
+	return datastore.streams.CompleteUpload(job, size, checksum)
+}
@@ -0,0 +1,63 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package boltdatastore
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketStreamAccess(tester *testing.T) {
+	ds, _ := createDatastore(true, nil)
+	defer cleanup(ds)
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+
+	_, err := ds.AppendPacketStreamChunk(newContext(), job.Id, 0, util.Checksum([]byte("pcap")), strings.NewReader("pcap"))
+	assert.NoError(tester, err)
+	upload, err := ds.GetPacketStreamUpload(newContext(), job.Id)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, int64(4), upload.Offset)
+	}
+	assert.NoError(tester, ds.CompletePacketStreamUpload(newContext(), job.Id, 4, util.Checksum([]byte("pcap"))))
+
+	reader, _, _, err := ds.GetPacketStream(newContext(), job.Id, false, "")
+	assert.NoError(tester, err)
+	assert.Nil(tester, reader)
+	_, err = ds.GetTcpStream(newContext(), job.Id, 0, false, 0)
+	assert.EqualError(tester, err, "Job is not complete")
+	_, err = ds.GetCarvedFiles(newContext(), job.Id, false, 0, nil)
+	assert.EqualError(tester, err, "Job is not complete")
+	_, err = ds.GetPacketStatistics(newContext(), job.Id, false, 10)
+	assert.EqualError(tester, err, "Job is not complete")
+	_, err = ds.GetFingerprints(newContext(), job.Id, false)
+	assert.EqualError(tester, err, "Job is not complete")
+
+	job.Status = model.JobStatusCompleted
+	assert.NoError(tester, ds.UpdateJob(newContext(), job))
+	reader, _, _, err = ds.GetPacketStream(newContext(), job.Id, false, "")
+	if assert.NoError(tester, err) {
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		assert.Equal(tester, "pcap", string(content))
+	}
+	assert.EqualError(tester, ds.SavePacketStream(newContext(), job.Id, io.NopCloser(strings.NewReader("pcap"))), "Job is ineligible for processing")
+	_, err = ds.GetPacketStreamUpload(newContext(), job.Id)
+	assert.EqualError(tester, err, "Job is ineligible for processing")
+
+	_, err = ds.GetTcpStream(newContext(), 9999, 0, false, 0)
+	assert.EqualError(tester, err, "Job not found")
+	_, _, _, err = ds.GetPacketStream(newContext(), 9999, false, "")
+	assert.EqualError(tester, err, "Job not found")
+	assert.EqualError(tester, ds.SavePacketStream(newContext(), 9999, io.NopCloser(strings.NewReader("pcap"))), "Job not found")
+}
@@ -807,8 +807,15 @@ func (store *ElasticCasestore) DeleteArtifact(ctx context.Context, id string) er
 			idPair["id"] = id
 			params := make(map[string]interface{})
 			params["artifact"] = idPair
-			jobs := store.server.Datastore.GetJobs(ctx, "analyze", params)
-			for _, job := range jobs {
+			query := model.NewJobQuery()
+			query.Kind = "analyze"
+			query.Parameters = params
+			page, err := store.server.Datastore.GetJobs(ctx, query)
+			if err != nil {
+				log.WithError(err).WithField("artifactId", artifact.Id).Error("Unable to find analyze jobs; continuing")
+				page = &model.JobPage{}
+			}
+			for _, job := range page.Jobs {
 				job, err := store.server.Datastore.DeleteJob(ctx, job.Id)
 				if err != nil {
 					log.WithError(err).WithFields(log.Fields{
@@ -7,14 +7,22 @@
 package filedatastore
 
 import (
+	"sync"
+	"time"
+
+	"github.com/apex/log"
 	"github.com/security-onion-solutions/securityonion-soc/module"
 	"github.com/security-onion-solutions/securityonion-soc/server"
 )
 
 type FileDatastore struct {
-	config module.ModuleConfig
-	server *server.Server
-	impl   *FileDatastoreImpl
+	config      module.ModuleConfig
+	server      *server.Server
+	impl        *FileDatastoreImpl
+	retention   *RetentionPolicy
+	stopChannel chan int
+	running     bool
+	lock        sync.Mutex
 }
 
 func NewFileDatastore(srv *server.Server) *FileDatastore {
@@ -30,6 +38,7 @@ func (fdmodule *FileDatastore) PrerequisiteModules() []string {
 
 func (fdmodule *FileDatastore) Init(cfg module.ModuleConfig) error {
 	fdmodule.config = cfg
+	fdmodule.retention = NewRetentionPolicy(cfg)
 	err := fdmodule.impl.Init(cfg)
 	if err == nil {
 		fdmodule.server.Datastore = fdmodule.impl
@@ -38,13 +47,48 @@ func (fdmodule *FileDatastore) Init(cfg module.ModuleConfig) error {
 }
 
 func (fdmodule *FileDatastore) Start() error {
+	if fdmodule.retention.IsEnabled() {
+		fdmodule.stopChannel = make(chan int)
+		fdmodule.setRunning(true)
+		go fdmodule.janitor(fdmodule.stopChannel)
+	} else {
+		log.Info("Job retention policy is not enabled; jobs will only be removed when deleted manually")
+	}
 	return nil
 }
 
+func (fdmodule *FileDatastore) janitor(stopChannel chan int) {
+	ticker := time.NewTicker(time.Duration(fdmodule.retention.IntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	fdmodule.impl.runRetention(fdmodule.retention)
+	for {
+		select {
+		case <-ticker.C:
+			fdmodule.impl.runRetention(fdmodule.retention)
+		case <-stopChannel:
+			fdmodule.setRunning(false)
+			return
+		}
+	}
+}
+
 func (fdmodule *FileDatastore) Stop() error {
+	if fdmodule.stopChannel != nil {
+		close(fdmodule.stopChannel)
+		fdmodule.stopChannel = nil
+	}
 	return nil
 }
 
 func (fdmodule *FileDatastore) IsRunning() bool {
-	return false
+	fdmodule.lock.Lock()
+	defer fdmodule.lock.Unlock()
+	return fdmodule.running
+}
+
+func (fdmodule *FileDatastore) setRunning(running bool) {
+	fdmodule.lock.Lock()
+	defer fdmodule.lock.Unlock()
+	fdmodule.running = running
 }
@@ -10,7 +10,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -22,20 +21,29 @@ import (
 	"github.com/security-onion-solutions/securityonion-soc/json"
 	"github.com/security-onion-solutions/securityonion-soc/model"
 	"github.com/security-onion-solutions/securityonion-soc/module"
-	"github.com/security-onion-solutions/securityonion-soc/packet"
 	"github.com/security-onion-solutions/securityonion-soc/server"
+	"github.com/security-onion-solutions/securityonion-soc/server/modules/streamstore"
 	"github.com/security-onion-solutions/securityonion-soc/web"
 )
 
 const DEFAULT_RETRY_FAILURE_INTERVAL_MS = 600000
+const DEFAULT_PIVOT_PRIORITY_BOOST = 0
 
 type FileDatastoreImpl struct {
 	server                 *server.Server
 	jobDir                 string
 	retryFailureIntervalMs int
+	pivotPriorityBoost     int
+	scheduler              *server.JobScheduler
 	jobsByNodeId           map[string][]*model.Job
 	jobsById               map[int]*model.Job
 	nodesById              map[string]*model.Node
+	schedulesById          map[string]*model.JobSchedule
+	scheduleHistoryLength  int
+	reuseResultsEnabled    bool
+	jobLeaseDurationMs     int
+	jobMaxAttempts         int
+	streams                *streamstore.StreamStore
 	ready                  bool
 	nextJobId              int
 	lock                   sync.RWMutex
@@ -43,11 +51,13 @@ type FileDatastoreImpl struct {
 
 func NewFileDatastoreImpl(srv *server.Server) *FileDatastoreImpl {
 	return &FileDatastoreImpl{
-		server:       srv,
-		jobsByNodeId: make(map[string][]*model.Job),
-		jobsById:     make(map[int]*model.Job),
-		nodesById:    make(map[string]*model.Node),
-		lock:         sync.RWMutex{},
+		server:        srv,
+		jobsByNodeId:  make(map[string][]*model.Job),
+		jobsById:      make(map[int]*model.Job),
+		nodesById:     make(map[string]*model.Node),
+		schedulesById: make(map[string]*model.JobSchedule),
+		scheduler:     server.NewJobScheduler(),
+		lock:          sync.RWMutex{},
 	}
 }
 
@@ -58,8 +68,20 @@ func (datastore *FileDatastoreImpl) Init(cfg module.ModuleConfig) error {
 	}
 	if err == nil {
 		datastore.retryFailureIntervalMs = module.GetIntDefault(cfg, "retryFailureIntervalMs", DEFAULT_RETRY_FAILURE_INTERVAL_MS)
+		datastore.pivotPriorityBoost = module.GetIntDefault(cfg, "pivotPriorityBoost", DEFAULT_PIVOT_PRIORITY_BOOST)
+		datastore.scheduleHistoryLength = module.GetIntDefault(cfg, "scheduleHistoryLength", model.DEFAULT_JOB_SCHEDULE_HISTORY_LENGTH)
+		datastore.reuseResultsEnabled = module.GetBoolDefault(cfg, "reuseResults", DEFAULT_REUSE_RESULTS)
+		datastore.jobLeaseDurationMs = module.GetIntDefault(cfg, "jobLeaseDurationMs", DEFAULT_JOB_LEASE_DURATION_MS)
+		datastore.jobMaxAttempts = module.GetIntDefault(cfg, "jobMaxAttempts", DEFAULT_JOB_MAX_ATTEMPTS)
+		datastore.streams, err = streamstore.NewStreamStoreFromConfig(cfg)
 	}
-	return datastore.loadJobs()
+	if err == nil {
+		err = datastore.loadJobs()
+	}
+	if err == nil {
+		err = datastore.loadJobSchedules()
+	}
+	return err
 }
 
 func (datastore *FileDatastoreImpl) CreateNode(ctx context.Context, id string) *model.Node {
@@ -132,20 +154,32 @@ func (datastore *FileDatastoreImpl) UpdateNode(ctx context.Context, newNode *mod
 	return node, err
 }
 
+// GetNextJob selects the next job for the node to process and leases it to the node's
+// agent. Jobs already leased are skipped until their lease expires.
 func (datastore *FileDatastoreImpl) GetNextJob(ctx context.Context, nodeId string) *model.Job {
-	datastore.lock.RLock()
-	defer datastore.lock.RUnlock()
+	datastore.lock.Lock()
+	defer datastore.lock.Unlock()
 	var nextJob *model.Job
 
 	if err := datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
 		now := time.Now()
-		jobs := datastore.jobsByNodeId[strings.ToLower(nodeId)]
-		for _, job := range jobs {
+		nodeId = strings.ToLower(nodeId)
+		candidates := make([]*model.Job, 0)
+		for _, job := range datastore.jobsByNodeId[nodeId] {
+			if job.CanProcess() {
+				datastore.expireLease(job, now)
+			}
 			retryTime := job.FailTime.Add(time.Millisecond * time.Duration(datastore.retryFailureIntervalMs))
-			if job.Status != model.JobStatusCompleted &&
-				(nextJob == nil || job.CreateTime.Before(nextJob.CreateTime)) &&
+			if job.IsQueued() && !job.IsLeased(now) &&
 				(job.Status != model.JobStatusIncomplete || retryTime.Before(now)) {
-				nextJob = job
+				candidates = append(candidates, job)
+			}
+		}
+		nextJob = datastore.scheduler.SelectNextJob(nodeId, candidates)
+		if nextJob != nil {
+			nextJob.Lease(now, datastore.leaseDuration())
+			if err = datastore.saveJob(nextJob); err != nil {
+				log.WithError(err).WithField("id", nextJob.Id).Error("Unable to save job lease")
 			}
 		}
 	}
@@ -190,7 +224,8 @@ func (datastore *FileDatastoreImpl) GetJob(ctx context.Context, jobId int) *mode
 	defer datastore.lock.RUnlock()
 	job := datastore.getJobById(jobId)
 	if job != nil {
-		if !datastore.jobIsAllowed(ctx, job, "read") {
+		// Agents need to read the jobs they are processing to detect cancellation.
+		if !datastore.jobIsAllowed(ctx, job, "read") && datastore.server.CheckAuthorized(ctx, "process", "jobs") != nil {
 			// Do not return jobs that are not allowed to be viewed by this user.
 			job = nil
 		}
@@ -226,22 +261,19 @@ func (datastore *FileDatastoreImpl) filterParameterMatches(parameters map[string
 	return true // no parameters specified, so all jobs will match
 }
 
-func (datastore *FileDatastoreImpl) GetJobs(ctx context.Context, kind string, parameters map[string]interface{}) []*model.Job {
-	if kind == "" {
-		kind = model.DEFAULT_JOB_KIND
-	}
-
+// GetJobs returns the page of jobs visible to the requestor that match the query.
+func (datastore *FileDatastoreImpl) GetJobs(ctx context.Context, query *model.JobQuery) (*model.JobPage, error) {
 	datastore.lock.RLock()
 	defer datastore.lock.RUnlock()
 	allJobs := make([]*model.Job, 0)
 	for _, job := range datastore.jobsById {
-		if datastore.jobIsAllowed(ctx, job, "read") {
-			if job.GetKind() == kind && datastore.filterParameterMatches(parameters, job.Filter.Parameters) {
+		if query.Matches(job) && datastore.jobIsAllowed(ctx, job, "read") {
+			if datastore.filterParameterMatches(query.Parameters, job.Filter.Parameters) {
 				allJobs = append(allJobs, job)
 			}
 		}
 	}
-	return allJobs
+	return query.Paginate(allJobs)
 }
 
 func (datastore *FileDatastoreImpl) AddJob(ctx context.Context, job *model.Job) error {
@@ -257,6 +289,7 @@ func (datastore *FileDatastoreImpl) AddJob(ctx context.Context, job *model.Job)
 func (datastore *FileDatastoreImpl) AddPivotJob(ctx context.Context, job *model.Job) error {
 	var err error
 	if err = datastore.server.CheckAuthorized(ctx, "pivot", "jobs"); err == nil {
+		job.Priority += datastore.pivotPriorityBoost
 		err = datastore.addAndSaveJob(ctx, job)
 	}
 	return err
@@ -271,10 +304,14 @@ func (datastore *FileDatastoreImpl) addAndSaveJob(ctx context.Context, job *mode
 	}
 	datastore.lock.Lock()
 	defer datastore.lock.Unlock()
+	datastore.reuseResults(job)
 	err = datastore.addJob(job)
 	if err == nil {
 		err = datastore.saveJob(job)
 	}
+	if err == nil {
+		datastore.server.JobNotifier.Notify(job.GetNodeId())
+	}
 	return err
 }
 
@@ -282,10 +319,20 @@ func (datastore *FileDatastoreImpl) UpdateJob(ctx context.Context, job *model.Jo
 	var err error
 	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
 		existingJob := datastore.getJobById(job.Id)
-		if existingJob != nil {
+		if existingJob != nil && job.UpdateKey != "" && job.UpdateKey == existingJob.UpdateKey {
+			// The agent replays an update until it is acknowledged, so an update that was
+			// already applied is reported back as-is rather than applied again over any newer state.
+			*job = *existingJob
+		} else if existingJob != nil {
 			job.UserId = existingJob.UserId // Prevent users from altering the creating user
 			job.NodeId = existingJob.NodeId // Do not allow moving a job between nodes due to data file path
 			if existingJob.CanProcess() {
+				if existingJob.IsCancelRequested() && job.IsQueued() {
+					// Never requeue a job that was cancelled while being processed
+					job.Cancel()
+				}
+				// The agent has reported back, so the job is no longer held by it
+				job.ReleaseLease()
 				datastore.lock.Lock()
 				defer datastore.lock.Unlock()
 				datastore.deleteJob(existingJob)
@@ -293,6 +340,10 @@ func (datastore *FileDatastoreImpl) UpdateJob(ctx context.Context, job *model.Jo
 				if err == nil {
 					err = datastore.saveJob(job)
 				}
+				if err == nil && job.IsQueued() {
+					// The agent handed the job back, so another attempt may pick it up
+					datastore.server.JobNotifier.Notify(job.GetNodeId())
+				}
 			} else {
 				err = errors.New("Job is ineligible for processing")
 			}
@@ -304,6 +355,57 @@ func (datastore *FileDatastoreImpl) UpdateJob(ctx context.Context, job *model.Jo
 	return err
 }
 
+func (datastore *FileDatastoreImpl) UpdateJobProgress(ctx context.Context, jobId int, progress *model.JobProgress) (*model.Job, error) {
+	var err error
+	var job *model.Job
+	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
+		datastore.lock.Lock()
+		defer datastore.lock.Unlock()
+		job = datastore.getJobById(jobId)
+		if job == nil {
+			err = errors.New("Job not found")
+		} else if !job.CanProcess() {
+			err = errors.New("Job is ineligible for processing")
+		} else {
+			job.Progress = progress
+			job.Lease(time.Now(), datastore.leaseDuration())
+			err = datastore.saveJob(job)
+		}
+	}
+	return job, err
+}
+
+func (datastore *FileDatastoreImpl) SetJobPriority(ctx context.Context, jobId int, priority int) (*model.Job, error) {
+	var err error
+	var job *model.Job
+	if err = datastore.server.CheckAuthorized(ctx, "prioritize", "jobs"); err == nil {
+		datastore.lock.Lock()
+		defer datastore.lock.Unlock()
+		job = datastore.getJobById(jobId)
+		if job == nil {
+			err = errors.New("Job not found")
+		} else if !job.IsQueued() {
+			err = errors.New("Job is no longer queued")
+		} else {
+			job.Priority = priority
+			err = datastore.saveJob(job)
+			if err == nil {
+				log.WithFields(log.Fields{
+					"id":       job.Id,
+					"priority": priority,
+				}).Info("Updated job priority")
+				datastore.server.JobNotifier.Notify(job.GetNodeId())
+			} else {
+				log.WithError(err).WithFields(log.Fields{
+					"id":       job.Id,
+					"priority": priority,
+				}).Error("Failed to update job priority")
+			}
+		}
+	}
+	return job, err
+}
+
 func (datastore *FileDatastoreImpl) getJobById(jobId int) *model.Job {
 	return datastore.jobsById[jobId]
 }
@@ -315,25 +417,40 @@ func (datastore *FileDatastoreImpl) DeleteJob(ctx context.Context, jobId int) (*
 		if datastore.jobIsAllowed(ctx, job, "delete") {
 			datastore.lock.Lock()
 			defer datastore.lock.Unlock()
-			datastore.deleteJob(job)
-			job.Status = model.JobStatusDeleted
-			filename := fmt.Sprintf("%d.json", job.Id)
-			folder := filepath.Join(datastore.jobDir, sanitize.Name(job.GetNodeId()))
-			err = os.Remove(filepath.Join(folder, filename))
-			if err == nil {
-				filename = fmt.Sprintf("%d.bin", job.Id)
-				os.Remove(filepath.Join(folder, filename))
-				filename = fmt.Sprintf("%d.bin.unwrapped", job.Id)
-				os.Remove(filepath.Join(folder, filename))
+			_, err = datastore.purgeJob(job)
+		} else {
+			err = errors.New("Permission denied attempting to delete job")
+		}
+	} else {
+		err = errors.New("Job not found")
+	}
+	return job, err
+}
 
-				log.WithFields(log.Fields{
-					"id":       job.Id,
-					"folder":   folder,
-					"filename": filename,
-				}).Info("Permanently deleted job and job files")
+// CancelJob asks the agent processing the job to stop. The job remains in a cancel
+// requested state until the agent reports back, and will not be handed out again.
+func (datastore *FileDatastoreImpl) CancelJob(ctx context.Context, jobId int) (*model.Job, error) {
+	var err error
+	job := datastore.getJobById(jobId)
+	if job != nil {
+		if datastore.jobIsAllowed(ctx, job, "delete") {
+			datastore.lock.Lock()
+			defer datastore.lock.Unlock()
+			if job.IsQueued() {
+				if job.LeaseExpireTime.IsZero() && !job.IsParent() {
+					// No agent holds the job, so nobody would ever report the cancellation
+					job.Cancel()
+					log.WithField("id", job.Id).Info("Cancelled job")
+				} else {
+					job.Status = model.JobStatusCancelRequested
+					log.WithField("id", job.Id).Info("Requested job cancellation")
+				}
+				err = datastore.saveJob(job)
+			} else {
+				err = errors.New("Job is no longer queued")
 			}
 		} else {
-			err = errors.New("Permission denied attempting to delete job")
+			err = errors.New("Permission denied attempting to cancel job")
 		}
 	} else {
 		err = errors.New("Job not found")
@@ -341,6 +458,51 @@ func (datastore *FileDatastoreImpl) DeleteJob(ctx context.Context, jobId int) (*
 	return job, err
 }
 
+// purgeJob removes the job from the in-memory lists and permanently deletes the job
+// file along with any stream files. Returns the number of bytes reclaimed on disk.
+// Caller must hold the write lock.
+func (datastore *FileDatastoreImpl) purgeJob(job *model.Job) (int64, error) {
+	datastore.deleteJob(job)
+	job.Status = model.JobStatusDeleted
+	var reclaimed int64
+	filenames := datastore.getJobFilenames(job)
+	size, err := removeFile(filenames[0])
+	if err == nil {
+		reclaimed += size
+		for _, filename := range filenames[1:] {
+			size, _ = removeFile(filename)
+			reclaimed += size
+		}
+
+		log.WithFields(log.Fields{
+			"id":        job.Id,
+			"folder":    filepath.Dir(filenames[0]),
+			"reclaimed": reclaimed,
+		}).Info("Permanently deleted job and job files")
+	}
+	return reclaimed, err
+}
+
+// getJobFilenames returns every file that may exist on disk for the given job, starting
+// with the job file itself.
+func (datastore *FileDatastoreImpl) getJobFilenames(job *model.Job) []string {
+	folder := filepath.Join(datastore.jobDir, sanitize.Name(job.GetNodeId()))
+	return append([]string{filepath.Join(folder, fmt.Sprintf("%d.json", job.Id))}, datastore.streams.Filenames(job)...)
+}
+
+func removeFile(filename string) (int64, error) {
+	var size int64
+	info, err := os.Stat(filename)
+	if err == nil {
+		size = info.Size()
+	}
+	err = os.Remove(filename)
+	if err != nil {
+		size = 0
+	}
+	return size, err
+}
+
 func (datastore *FileDatastoreImpl) deleteJob(job *model.Job) {
 	jobs := datastore.jobsByNodeId[job.GetNodeId()]
 	newJobs := make([]*model.Job, 0)
@@ -420,111 +582,3 @@ func (datastore *FileDatastoreImpl) loadJobs() error {
 	}
 	return err
 }
-
-func (datastore *FileDatastoreImpl) GetPackets(ctx context.Context, jobId int, offset int, count int, unwrap bool) ([]*model.Packet, error) {
-	var packets []*model.Packet
-	var err error
-	job := datastore.GetJob(ctx, jobId)
-	if job != nil {
-		if datastore.jobIsAllowed(ctx, job, "read") {
-			if job.Status == model.JobStatusCompleted {
-				packets, err = packet.ParsePcap(datastore.getStreamFilename(job), offset, count, unwrap)
-				if err != nil {
-					log.WithError(err).WithField("jobId", job.Id).Warn("Failed to parse captured packets")
-					err = nil
-				}
-			}
-		} else {
-			err = errors.New("Job is inaccessible")
-		}
-	} else {
-		err = errors.New("Job not found")
-	}
-
-	return packets, err
-}
-
-func (datastore *FileDatastoreImpl) SavePacketStream(ctx context.Context, jobId int, reader io.ReadCloser) error {
-	var err error
-	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
-		job := datastore.getJobById(jobId)
-		if job != nil {
-			if job.CanProcess() {
-				var count int64
-				file, err := os.Create(datastore.getStreamFilename(job))
-				if err == nil {
-					defer file.Close()
-					count, err = io.Copy(file, reader)
-				}
-				if err != nil {
-					log.WithError(err).WithField("jobId", jobId).Error("Failed to write packet stream to file")
-				} else {
-					log.WithFields(log.Fields{
-						"bytes": count,
-						"jobId": jobId,
-					}).Info("Saved packet stream to file")
-				}
-			} else {
-				err = errors.New("Job is ineligible for processing")
-			}
-		} else {
-			err = errors.New("Job not found")
-		}
-	}
-	return err
-}
-
-func (datastore *FileDatastoreImpl) GetPacketStream(ctx context.Context, jobId int, unwrap bool) (io.ReadCloser, string, int64, error) {
-	var reader io.ReadCloser
-	var filename string
-	var length int64
-	var err error
-	job := datastore.GetJob(ctx, jobId)
-	if job != nil {
-		if datastore.jobIsAllowed(ctx, job, "read") {
-			if job.Status == model.JobStatusCompleted {
-				filename = fmt.Sprintf("sensoroni_%s_%d.%s", sanitize.Name(job.GetNodeId()), job.Id, sanitize.Name(job.FileExtension))
-				var file *os.File
-				file, err = os.Open(datastore.getModifiedStreamFilename(job, unwrap))
-				if err != nil {
-					log.WithError(err).WithField("jobId", job.Id).Error("Failed to open packet stream")
-				} else {
-					reader = file
-					info, err := file.Stat()
-					length = info.Size()
-					log.WithFields(log.Fields{
-						"streamSize":     length,
-						"streamFilename": info.Name(),
-					}).Info("Streaming file")
-					if err != nil {
-						log.WithError(err).WithField("jobId", job.Id).Error("Failed to open file stats")
-					}
-				}
-			}
-		} else {
-			err = errors.New("Job is inaccessible")
-		}
-	} else {
-		err = errors.New("Job not found")
-	}
-
-	return reader, filename, length, err
-}
-
-func (datastore *FileDatastoreImpl) getStreamFilename(job *model.Job) string {
-	filename := fmt.Sprintf("%d.bin", job.Id)
-	folder := filepath.Join(datastore.jobDir, sanitize.Name(job.GetNodeId()))
-	return filepath.Join(folder, filename)
-}
-
-func (datastore *FileDatastoreImpl) getModifiedStreamFilename(job *model.Job, unwrap bool) string {
-	filename := datastore.getStreamFilename(job)
-	if unwrap {
-		unwrappedFilename := filename + ".unwrapped"
-		unwrapped := packet.UnwrapPcap(filename, unwrappedFilename)
-		if unwrapped {
-			filename = unwrappedFilename
-		}
-	}
-	return filename
-}
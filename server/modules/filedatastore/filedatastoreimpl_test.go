@@ -7,10 +7,17 @@
 package filedatastore
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 	"github.com/security-onion-solutions/securityonion-soc/model"
 	"github.com/security-onion-solutions/securityonion-soc/module"
 	"github.com/security-onion-solutions/securityonion-soc/server"
@@ -58,6 +65,15 @@ func createDatastore(authorized bool, jobFileContents []byte) (*FileDatastoreImp
 	return ds, err
 }
 
+func getJobsOfKind(ds *FileDatastoreImpl, kind string) []*model.Job {
+	query := model.NewJobQuery()
+	if kind != "" {
+		query.Kind = kind
+	}
+	page, _ := ds.GetJobs(newContext(), query)
+	return page.Jobs
+}
+
 func TestFileDatastoreInit(tester *testing.T) {
 	defer cleanup()
 	ds, err := createDatastore(true, []byte(""))
@@ -129,18 +145,18 @@ func TestJobs(tester *testing.T) {
 	assert.Nil(tester, job)
 
 	// Test fetching all default jobs
-	jobs := ds.GetJobs(newContext(), "", nil)
+	jobs := getJobsOfKind(ds, "")
 	assert.Len(tester, jobs, 2)
 
 	// Test deleting jobs
 	ds.deleteJob(jobs[0])
-	jobs = ds.GetJobs(newContext(), "", nil)
+	jobs = getJobsOfKind(ds, "")
 	assert.Len(tester, jobs, 1)
 	ds.deleteJob(jobs[0])
-	jobs = ds.GetJobs(newContext(), "", nil)
+	jobs = getJobsOfKind(ds, "")
 	assert.Len(tester, jobs, 0)
 
-	jobs = ds.GetJobs(newContext(), "foo", nil)
+	jobs = getJobsOfKind(ds, "foo")
 	assert.Len(tester, jobs, 1)
 }
 
@@ -225,7 +241,7 @@ func TestJobReadAuthorization(tester *testing.T) {
 	assert.Nil(tester, job)
 
 	// Test fetching all jobs
-	jobs := ds.GetJobs(newContext(), "", nil)
+	jobs := getJobsOfKind(ds, "")
 	assert.Len(tester, jobs, 1) // Only has my job
 }
 
@@ -300,6 +316,36 @@ func TestUpdatePreserveData(tester *testing.T) {
 	assert.Equal(tester, job.NodeId, newJob.NodeId)
 }
 
+func TestUpdateReplayed(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	job := ds.CreateJob(newContext())
+	job.UserId = MY_USER_ID
+	job.NodeId = "some node"
+	job.Id = 1212
+	ds.addJob(job)
+
+	update := ds.CreateJob(newContext())
+	update.Id = job.Id
+	update.UpdateKey = "abc"
+	update.Complete()
+	assert.NoError(tester, ds.UpdateJob(newContext(), update))
+
+	// Replaying the same update is acknowledged without changing the stored job
+	replay := ds.CreateJob(newContext())
+	replay.Id = job.Id
+	replay.UpdateKey = "abc"
+	replay.Fail(errors.New("stale"))
+	assert.NoError(tester, ds.UpdateJob(newContext(), replay))
+	assert.Equal(tester, model.JobStatusCompleted, replay.Status)
+	assert.Equal(tester, model.JobStatusCompleted, ds.getJobById(job.Id).Status)
+
+	// A different update can't overwrite the completed job
+	replay.UpdateKey = "def"
+	assert.Error(tester, ds.UpdateJob(newContext(), replay))
+}
+
 func TestFilterMatches(tester *testing.T) {
 	defer cleanup()
 	ds, _ := createDatastore(true, []byte(""))
@@ -348,3 +394,248 @@ func TestFilterMatches(tester *testing.T) {
 	jobParams["foo"] = jobNested
 	assert.False(tester, ds.filterParameterMatches(params, jobParams))
 }
+
+func TestGetNextJobPriority(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	old := ds.CreateJob(newContext())
+	old.SetNodeId("foo")
+	old.CreateTime = time.Now().Add(-time.Hour)
+	ds.addJob(old)
+
+	urgent := ds.CreateJob(newContext())
+	urgent.SetNodeId("foo")
+	ds.addJob(urgent)
+
+	assert.Equal(tester, old.Id, ds.GetNextJob(newContext(), "foo").Id)
+
+	job, err := ds.SetJobPriority(newContext(), urgent.Id, 10)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 10, job.Priority)
+	assert.Equal(tester, urgent.Id, ds.GetNextJob(newContext(), "foo").Id)
+
+	urgent.Complete()
+	_, err = ds.SetJobPriority(newContext(), urgent.Id, 1)
+	assert.EqualError(tester, err, "Job is no longer queued")
+
+	_, err = ds.SetJobPriority(newContext(), 9999, 1)
+	assert.EqualError(tester, err, "Job not found")
+}
+
+func TestSetJobPriorityUnauthorized(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(false, []byte(""))
+
+	job := ds.CreateJob(newContext())
+	ds.addJob(job)
+
+	_, err := ds.SetJobPriority(newContext(), job.Id, 10)
+	assert.Error(tester, err)
+	assert.Equal(tester, 0, ds.getJobById(job.Id).Priority)
+}
+
+func TestJobAddPivotBoost(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+	ds.pivotPriorityBoost = 5
+
+	job := ds.CreateJob(newContext())
+	job.Priority = 1
+	err := ds.AddPivotJob(newContext(), job)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 6, ds.GetJob(newContext(), job.Id).Priority)
+}
+
+func TestCancelJob(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	ds.addJob(job)
+	assert.NotNil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	cancelled, err := ds.CancelJob(newContext(), job.Id)
+	assert.NoError(tester, err)
+	assert.Equal(tester, model.JobStatusCancelRequested, cancelled.Status)
+	assert.Nil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	_, err = ds.CancelJob(newContext(), job.Id)
+	assert.EqualError(tester, err, "Job is no longer queued")
+
+	// Agent reports the failure caused by cancellation; job must not be requeued
+	update := ds.CreateJob(newContext())
+	update.Id = job.Id
+	update.Fail(errors.New("context canceled"))
+	assert.NoError(tester, ds.UpdateJob(newContext(), update))
+	assert.Equal(tester, model.JobStatusCancelled, ds.getJobById(job.Id).Status)
+	assert.Error(tester, ds.UpdateJob(newContext(), update))
+
+	_, err = ds.CancelJob(newContext(), 9999)
+	assert.EqualError(tester, err, "Job not found")
+}
+
+func TestCancelJobNotLeased(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	pending := ds.CreateJob(newContext())
+	pending.SetNodeId("foo")
+	ds.addJob(pending)
+
+	// No agent holds the job, so it is cancelled right away
+	cancelled, err := ds.CancelJob(newContext(), pending.Id)
+	assert.NoError(tester, err)
+	assert.Equal(tester, model.JobStatusCancelled, cancelled.Status)
+	assert.False(tester, cancelled.CompleteTime.IsZero())
+	assert.Nil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	// Same for a failed job waiting to be retried
+	retrying := ds.CreateJob(newContext())
+	retrying.SetNodeId("foo")
+	ds.addJob(retrying)
+	assert.NotNil(tester, ds.GetNextJob(newContext(), "foo"))
+	update := ds.CreateJob(newContext())
+	update.Id = retrying.Id
+	update.Fail(errors.New("agent failure"))
+	assert.NoError(tester, ds.UpdateJob(newContext(), update))
+	cancelled, err = ds.CancelJob(newContext(), retrying.Id)
+	assert.NoError(tester, err)
+	assert.Equal(tester, model.JobStatusCancelled, cancelled.Status)
+
+	// Parents are settled by their children instead
+	parent := ds.CreateJob(newContext())
+	parent.ChildIds = []int{pending.Id}
+	ds.addJob(parent)
+	cancelled, err = ds.CancelJob(newContext(), parent.Id)
+	assert.NoError(tester, err)
+	assert.Equal(tester, model.JobStatusCancelRequested, cancelled.Status)
+}
+
+func TestCancelJobUnauthorized(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(false, []byte(""))
+
+	job := ds.CreateJob(newContext())
+	job.UserId = ANOTHER_USER_ID
+	ds.addJob(job)
+
+	_, err := ds.CancelJob(newContext(), job.Id)
+	assert.Error(tester, err)
+	assert.Equal(tester, model.JobStatusPending, ds.getJobById(job.Id).Status)
+}
+
+func TestUpdateJobProgress(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	job := ds.CreateJob(newContext())
+	ds.addJob(job)
+
+	progress := model.NewJobProgress()
+	progress.FilesTotal = 10
+	progress.FilesScanned = 3
+	updated, err := ds.UpdateJobProgress(newContext(), job.Id, progress)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 3, updated.Progress.FilesScanned)
+
+	job.Complete()
+	_, err = ds.UpdateJobProgress(newContext(), job.Id, progress)
+	assert.EqualError(tester, err, "Job is ineligible for processing")
+
+	updated, err = ds.UpdateJobProgress(newContext(), 9999, progress)
+	assert.EqualError(tester, err, "Job not found")
+	assert.Nil(tester, updated)
+}
+
+func writeTestPcap(tester *testing.T, filename string, seconds ...int) {
+	var buf bytes.Buffer
+	writer := pcapgo.NewWriter(&buf)
+	writer.WriteFileHeader(65536, layers.LinkTypeEthernet)
+	for _, second := range seconds {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(second), 0), CaptureLength: 1, Length: 1}
+		writer.WritePacket(ci, []byte{byte(second)})
+	}
+	os.MkdirAll(filepath.Dir(filename), 0777)
+	assert.NoError(tester, os.WriteFile(filename, buf.Bytes(), 0644))
+}
+
+func TestMergePacketStreams(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	parent := ds.CreateJob(newContext())
+	ds.addJob(parent)
+
+	first := ds.CreateJob(newContext())
+	first.SetNodeId("foo")
+	first.Complete()
+	ds.addJob(first)
+	writeTestPcap(tester, ds.getStreamFilename(first), 1, 3)
+
+	second := ds.CreateJob(newContext())
+	second.SetNodeId("bar")
+	second.Complete()
+	ds.addJob(second)
+	writeTestPcap(tester, ds.getStreamFilename(second), 2)
+
+	failed := ds.CreateJob(newContext())
+	failed.SetNodeId("bar")
+	ds.addJob(failed)
+	writeTestPcap(tester, ds.getStreamFilename(failed), 4)
+
+	size, err := ds.MergePacketStreams(newContext(), parent.Id, []int{first.Id, second.Id, failed.Id, 9999})
+	assert.NoError(tester, err)
+	assert.Equal(tester, 24+3*(16+1), size)
+
+	info, err := os.Stat(ds.getStreamFilename(parent))
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, int64(size), info.Size())
+	}
+
+	_, err = ds.MergePacketStreams(newContext(), 9999, []int{first.Id})
+	assert.EqualError(tester, err, "Job not found")
+}
+
+func TestGetJobsQuery(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(false, nil)
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		job := ds.CreateJob(newContext())
+		job.UserId = MY_USER_ID
+		job.CreateTime = created.Add(time.Duration(i) * time.Hour)
+		job.SetNodeId("foo")
+		ds.addJob(job)
+	}
+	other := ds.CreateJob(newContext())
+	other.UserId = ANOTHER_USER_ID
+	ds.addJob(other)
+
+	// Unauthorized users only see their own jobs
+	query := model.NewJobQuery()
+	query.UserId = ANOTHER_USER_ID
+	page, err := ds.GetJobs(newContext(), query)
+	assert.NoError(tester, err)
+	assert.Empty(tester, page.Jobs)
+
+	query = model.NewJobQuery()
+	query.CreateTimeEnd = created.Add(4 * time.Hour)
+	query.Limit = 3
+	page, err = ds.GetJobs(newContext(), query)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 4, page.TotalCount)
+	assert.Len(tester, page.Jobs, 3)
+	assert.NotEmpty(tester, page.NextCursor)
+
+	query.Cursor = page.NextCursor
+	page, _ = ds.GetJobs(newContext(), query)
+	assert.Len(tester, page.Jobs, 1)
+	assert.Empty(tester, page.NextCursor)
+
+	query.Cursor = "bogus!"
+	_, err = ds.GetJobs(newContext(), query)
+	assert.Error(tester, err)
+}
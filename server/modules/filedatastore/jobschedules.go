@@ -0,0 +1,193 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package filedatastore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/security-onion-solutions/securityonion-soc/json"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+)
+
+const SCHEDULE_FOLDER = "schedules"
+const SCHEDULE_FILE_EXTENSION = ".schedule"
+
+func (datastore *FileDatastoreImpl) scheduleIsAllowed(ctx context.Context, schedule *model.JobSchedule, op string) bool {
+	allowed := false
+
+	if schedule != nil {
+		if err := datastore.server.CheckAuthorized(ctx, op, "jobs"); err == nil {
+			// User can operate on all schedules
+			allowed = true
+		} else {
+			// User is only authorized against their own schedules.
+			if user, ok := ctx.Value(web.ContextKeyRequestor).(*model.User); ok {
+				if schedule.UserId == user.Id {
+					allowed = true
+				}
+			}
+		}
+	}
+	return allowed
+}
+
+func (datastore *FileDatastoreImpl) GetJobSchedules(ctx context.Context) []*model.JobSchedule {
+	datastore.lock.RLock()
+	defer datastore.lock.RUnlock()
+
+	// The server needs to see every schedule in order to run them.
+	processor := datastore.server.CheckAuthorized(ctx, "process", "jobs") == nil
+
+	schedules := make([]*model.JobSchedule, 0)
+	for _, schedule := range datastore.schedulesById {
+		if processor || datastore.scheduleIsAllowed(ctx, schedule, "read") {
+			schedules = append(schedules, schedule.Copy())
+		}
+	}
+	return schedules
+}
+
+func (datastore *FileDatastoreImpl) GetJobSchedule(ctx context.Context, scheduleId string) *model.JobSchedule {
+	datastore.lock.RLock()
+	defer datastore.lock.RUnlock()
+	schedule := datastore.schedulesById[scheduleId]
+	if !datastore.scheduleIsAllowed(ctx, schedule, "read") {
+		return nil
+	}
+	return schedule.Copy()
+}
+
+func (datastore *FileDatastoreImpl) AddJobSchedule(ctx context.Context, schedule *model.JobSchedule) error {
+	var err error
+	if err = datastore.server.CheckAuthorized(ctx, "write", "jobs"); err == nil {
+		if user, ok := ctx.Value(web.ContextKeyRequestor).(*model.User); ok {
+			schedule.UserId = user.Id
+		} else {
+			return errors.New("User not found in context")
+		}
+		schedule.Id = uuid.New().String()
+		schedule.CreateTime = time.Now()
+		schedule.UpdateTime = schedule.CreateTime
+		schedule.LastRunTime = time.Time{}
+		schedule.History = make([]*model.JobScheduleRun, 0)
+
+		datastore.lock.Lock()
+		defer datastore.lock.Unlock()
+		err = datastore.saveJobSchedule(schedule)
+		if err == nil {
+			datastore.schedulesById[schedule.Id] = schedule.Copy()
+			log.WithFields(log.Fields{
+				"id":             schedule.Id,
+				"cronExpression": schedule.CronExpression,
+			}).Info("Added job schedule")
+		}
+	}
+	return err
+}
+
+func (datastore *FileDatastoreImpl) UpdateJobSchedule(ctx context.Context, schedule *model.JobSchedule) (*model.JobSchedule, error) {
+	var err error
+	datastore.lock.Lock()
+	defer datastore.lock.Unlock()
+	existing := datastore.schedulesById[schedule.Id]
+	if existing == nil {
+		err = errors.New("Job schedule not found")
+	} else if !datastore.scheduleIsAllowed(ctx, existing, "write") {
+		err = errors.New("Permission denied attempting to update job schedule")
+	} else {
+		// Only copy the following values from the incoming schedule. Preserve everything else.
+		existing.Name = schedule.Name
+		existing.CronExpression = schedule.CronExpression
+		existing.Template = schedule.Template
+		existing.LookbackSeconds = schedule.LookbackSeconds
+		existing.Enabled = schedule.Enabled
+		existing.NextRunTime = schedule.NextRunTime
+		existing.UpdateTime = time.Now()
+		err = datastore.saveJobSchedule(existing)
+		existing = existing.Copy()
+	}
+	return existing, err
+}
+
+func (datastore *FileDatastoreImpl) DeleteJobSchedule(ctx context.Context, scheduleId string) (*model.JobSchedule, error) {
+	var err error
+	datastore.lock.Lock()
+	defer datastore.lock.Unlock()
+	schedule := datastore.schedulesById[scheduleId]
+	if schedule == nil {
+		err = errors.New("Job schedule not found")
+	} else if !datastore.scheduleIsAllowed(ctx, schedule, "delete") {
+		err = errors.New("Permission denied attempting to delete job schedule")
+	} else {
+		err = os.Remove(datastore.getJobScheduleFilename(scheduleId))
+		if err == nil {
+			delete(datastore.schedulesById, scheduleId)
+			log.WithField("id", scheduleId).Info("Deleted job schedule")
+		}
+	}
+	return schedule, err
+}
+
+// RecordJobScheduleRun appends the run to the schedule's history and sets the time
+// the schedule should next run.
+func (datastore *FileDatastoreImpl) RecordJobScheduleRun(ctx context.Context, scheduleId string, run *model.JobScheduleRun, nextRunTime time.Time) (*model.JobSchedule, error) {
+	var err error
+	var schedule *model.JobSchedule
+	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
+		datastore.lock.Lock()
+		defer datastore.lock.Unlock()
+		schedule = datastore.schedulesById[scheduleId]
+		if schedule == nil {
+			err = errors.New("Job schedule not found")
+		} else {
+			schedule.AddRun(run, datastore.scheduleHistoryLength)
+			schedule.NextRunTime = nextRunTime
+			err = datastore.saveJobSchedule(schedule)
+			schedule = schedule.Copy()
+		}
+	}
+	return schedule, err
+}
+
+func (datastore *FileDatastoreImpl) getJobScheduleFilename(scheduleId string) string {
+	return filepath.Join(datastore.jobDir, SCHEDULE_FOLDER, filepath.Base(scheduleId)+SCHEDULE_FILE_EXTENSION)
+}
+
+func (datastore *FileDatastoreImpl) saveJobSchedule(schedule *model.JobSchedule) error {
+	os.MkdirAll(filepath.Join(datastore.jobDir, SCHEDULE_FOLDER), os.ModePerm)
+	return json.WriteJsonFile(datastore.getJobScheduleFilename(schedule.Id), schedule)
+}
+
+func (datastore *FileDatastoreImpl) loadJobSchedules() error {
+	datastore.lock.Lock()
+	defer datastore.lock.Unlock()
+	entries, err := os.ReadDir(filepath.Join(datastore.jobDir, SCHEDULE_FOLDER))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), SCHEDULE_FILE_EXTENSION) {
+			continue
+		}
+		file := filepath.Join(datastore.jobDir, SCHEDULE_FOLDER, entry.Name())
+		schedule := model.NewJobSchedule()
+		loadErr := json.LoadJsonFile(file, schedule)
+		if loadErr == nil {
+			datastore.schedulesById[schedule.Id] = schedule
+		} else {
+			log.WithError(loadErr).WithField("file", file).Error("Unable to load job schedule file")
+		}
+	}
+	return err
+}
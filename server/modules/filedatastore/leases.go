@@ -0,0 +1,81 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package filedatastore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+const DEFAULT_JOB_LEASE_DURATION_MS = 120000
+const DEFAULT_JOB_MAX_ATTEMPTS = 3
+
+// RenewJobLease extends the lease of a job that an agent is still processing.
+func (datastore *FileDatastoreImpl) RenewJobLease(ctx context.Context, jobId int) (*model.Job, error) {
+	var err error
+	var job *model.Job
+	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
+		datastore.lock.Lock()
+		defer datastore.lock.Unlock()
+		job = datastore.getJobById(jobId)
+		if job == nil {
+			err = errors.New("Job not found")
+		} else if !job.CanProcess() {
+			err = errors.New("Job is ineligible for processing")
+		} else {
+			job.Lease(time.Now(), datastore.leaseDuration())
+			err = datastore.saveJob(job)
+		}
+	}
+	return job, err
+}
+
+// ExpireJobLeases returns every job whose lease has expired to pending, or fails the
+// job once it has run out of attempts. Returns the jobs that were changed.
+func (datastore *FileDatastoreImpl) ExpireJobLeases(ctx context.Context) ([]*model.Job, error) {
+	var err error
+	expired := make([]*model.Job, 0)
+	if err = datastore.server.CheckAuthorized(ctx, "process", "jobs"); err == nil {
+		datastore.lock.Lock()
+		defer datastore.lock.Unlock()
+		now := time.Now()
+		for _, job := range datastore.jobsById {
+			if job.CanProcess() && datastore.expireLease(job, now) {
+				expired = append(expired, job)
+			}
+		}
+		datastore.server.JobNotifier.NotifyQueued(expired)
+	}
+	return expired, err
+}
+
+func (datastore *FileDatastoreImpl) leaseDuration() time.Duration {
+	return time.Duration(datastore.jobLeaseDurationMs) * time.Millisecond
+}
+
+// expireLease expires the job's lease if it has lapsed. Returns true if the job was
+// changed. Caller must hold the write lock.
+func (datastore *FileDatastoreImpl) expireLease(job *model.Job, now time.Time) bool {
+	if !job.IsLeaseExpired(now) {
+		return false
+	}
+
+	job.ExpireLease(now, datastore.jobMaxAttempts)
+	if err := datastore.saveJob(job); err != nil {
+		log.WithError(err).WithField("id", job.Id).Error("Unable to save job with expired lease")
+	}
+	log.WithFields(log.Fields{
+		"id":        job.Id,
+		"nodeId":    job.GetNodeId(),
+		"status":    job.Status,
+		"failCount": job.FailCount,
+	}).Warn("Job lease expired")
+	return true
+}
@@ -0,0 +1,142 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package filedatastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNextJobLeases(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, nil)
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+
+	next := ds.GetNextJob(newContext(), "foo")
+	if assert.NotNil(tester, next) {
+		assert.Equal(tester, job.Id, next.Id)
+		assert.True(tester, next.IsLeased(time.Now()))
+	}
+
+	// Leased jobs are not handed out again
+	assert.Nil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	renewed, err := ds.RenewJobLease(newContext(), job.Id)
+	assert.NoError(tester, err)
+	assert.True(tester, renewed.LeaseExpireTime.After(next.CreateTime))
+
+	// Reporting back releases the lease
+	update := *job
+	update.Complete()
+	assert.NoError(tester, ds.UpdateJob(newContext(), &update))
+	assert.True(tester, ds.GetJob(newContext(), job.Id).LeaseExpireTime.IsZero())
+
+	_, err = ds.RenewJobLease(newContext(), job.Id)
+	assert.EqualError(tester, err, "Job is ineligible for processing")
+}
+
+func TestExpireJobLeases(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, nil)
+	ds.jobMaxAttempts = 2
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+	assert.NotNil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	expired, err := ds.ExpireJobLeases(newContext())
+	assert.NoError(tester, err)
+	assert.Len(tester, expired, 0)
+
+	// Agent stops sending heartbeats
+	job.LeaseExpireTime = time.Now().Add(-time.Second)
+	expired, err = ds.ExpireJobLeases(newContext())
+	assert.NoError(tester, err)
+	if assert.Len(tester, expired, 1) {
+		assert.Equal(tester, model.JobStatusPending, expired[0].Status)
+		assert.Equal(tester, 1, expired[0].FailCount)
+	}
+
+	// The job is handed out again, and expires a second time while leased to the next
+	// agent, but this time lazily while the node polls for work
+	assert.Equal(tester, job.Id, ds.GetNextJob(newContext(), "foo").Id)
+	job.LeaseExpireTime = time.Now().Add(-time.Second)
+	assert.Nil(tester, ds.GetNextJob(newContext(), "foo"))
+	assert.Equal(tester, model.JobStatusFailed, job.Status)
+	assert.Equal(tester, 2, job.FailCount)
+	assert.NotEmpty(tester, job.Failure)
+}
+
+func TestExpireJobLeasesUnauthorized(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(false, nil)
+
+	_, err := ds.ExpireJobLeases(newContext())
+	assert.Error(tester, err)
+}
+
+func TestAddJobNotifiesWaitingAgents(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, nil)
+
+	notification, release := ds.server.JobNotifier.Subscribe("foo")
+	defer release()
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("Foo")
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+	assert.Len(tester, notification, 1)
+	<-notification
+
+	pivot := ds.CreateJob(newContext())
+	pivot.SetNodeId("foo")
+	assert.NoError(tester, ds.AddPivotJob(newContext(), pivot))
+	assert.Len(tester, notification, 1)
+}
+
+func TestRequeueNotifiesWaitingAgents(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, nil)
+
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+	assert.NotNil(tester, ds.GetNextJob(newContext(), "foo"))
+
+	notification, release := ds.server.JobNotifier.Subscribe("foo")
+	defer release()
+
+	job.LeaseExpireTime = time.Now().Add(-time.Second)
+	_, err := ds.ExpireJobLeases(newContext())
+	assert.NoError(tester, err)
+	assert.Len(tester, notification, 1)
+	<-notification
+
+	_, err = ds.SetJobPriority(newContext(), job.Id, 5)
+	assert.NoError(tester, err)
+	assert.Len(tester, notification, 1)
+	<-notification
+
+	// The agent hands the job back to be retried
+	assert.NotNil(tester, ds.GetNextJob(newContext(), "foo"))
+	update := *job
+	update.Status = model.JobStatusIncomplete
+	assert.NoError(tester, ds.UpdateJob(newContext(), &update))
+	assert.Len(tester, notification, 1)
+	<-notification
+
+	completed := update
+	completed.Complete()
+	assert.NoError(tester, ds.UpdateJob(newContext(), &completed))
+	assert.Len(tester, notification, 0)
+}
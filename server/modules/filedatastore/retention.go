@@ -0,0 +1,193 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package filedatastore
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/module"
+)
+
+const DEFAULT_RETENTION_INTERVAL_MS = 3600000
+
+// RetentionLimits describes how long finished jobs are kept and how much disk space
+// they may consume. A zero value disables the corresponding limit.
+type RetentionLimits struct {
+	MaxAgeMs      int
+	MaxTotalBytes int64
+}
+
+func (limits *RetentionLimits) IsEnabled() bool {
+	return limits.MaxAgeMs > 0 || limits.MaxTotalBytes > 0
+}
+
+type RetentionPolicy struct {
+	IntervalMs int
+	Limits     RetentionLimits
+	KindLimits map[string]RetentionLimits
+}
+
+type RetentionReport struct {
+	PurgedCount    int
+	ReclaimedBytes int64
+	RemainingBytes int64
+}
+
+func NewRetentionPolicy(cfg module.ModuleConfig) *RetentionPolicy {
+	policy := &RetentionPolicy{
+		IntervalMs: module.GetIntDefault(cfg, "retentionIntervalMs", DEFAULT_RETENTION_INTERVAL_MS),
+		Limits: RetentionLimits{
+			MaxAgeMs:      module.GetIntDefault(cfg, "retentionMaxAgeMs", 0),
+			MaxTotalBytes: int64(module.GetIntDefault(cfg, "retentionMaxTotalBytes", 0)),
+		},
+		KindLimits: make(map[string]RetentionLimits),
+	}
+	if policy.IntervalMs <= 0 {
+		policy.IntervalMs = DEFAULT_RETENTION_INTERVAL_MS
+	}
+
+	// Per-kind overrides fall back to the global limits for any unspecified setting.
+	overrides, ok := cfg["retentionKinds"].(map[string]interface{})
+	if _, found := cfg["retentionKinds"]; found && !ok {
+		log.Warn("Ignoring invalid retention overrides; retentionKinds must map kinds to their limits")
+	}
+	for kind, gen := range overrides {
+		if kindCfg, ok := gen.(map[string]interface{}); ok {
+			policy.KindLimits[kind] = RetentionLimits{
+				MaxAgeMs:      module.GetIntDefault(kindCfg, "maxAgeMs", policy.Limits.MaxAgeMs),
+				MaxTotalBytes: int64(module.GetIntDefault(kindCfg, "maxTotalBytes", 0)),
+			}
+		} else {
+			log.WithField("kind", kind).Warn("Ignoring invalid retention override")
+		}
+	}
+	return policy
+}
+
+func (policy *RetentionPolicy) IsEnabled() bool {
+	if policy.Limits.IsEnabled() {
+		return true
+	}
+	for _, limits := range policy.KindLimits {
+		if limits.IsEnabled() {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *RetentionPolicy) getMaxAgeMs(kind string) int {
+	if limits, ok := policy.KindLimits[kind]; ok {
+		return limits.MaxAgeMs
+	}
+	return policy.Limits.MaxAgeMs
+}
+
+type retainedJob struct {
+	job  *model.Job
+	size int64
+}
+
+func (datastore *FileDatastoreImpl) getJobSize(job *model.Job) int64 {
+	var size int64
+	for _, filename := range datastore.getJobFilenames(job) {
+		if info, err := os.Stat(filename); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// PurgeExpiredJobs deletes finished jobs, whether completed, cancelled or failed, that fall
+// outside the retention policy. Jobs older than the applicable max age are deleted first;
+// afterwards the oldest finished jobs are deleted until each kind, and then all jobs together, fit within their byte
+// limits. Jobs that are still queued are never purged, nor are the children of a parent
+// still being processed, since the parent's results are built from theirs, but their files
+// count towards the total.
+func (datastore *FileDatastoreImpl) PurgeExpiredJobs(policy *RetentionPolicy, now time.Time) *RetentionReport {
+	datastore.lock.Lock()
+	defer datastore.lock.Unlock()
+
+	report := &RetentionReport{}
+	candidates := make([]*retainedJob, 0)
+	kindBytes := make(map[string]int64)
+	for _, job := range datastore.jobsById {
+		size := datastore.getJobSize(job)
+		report.RemainingBytes += size
+		kindBytes[job.GetKind()] += size
+		if job.IsFinished() && !datastore.hasProcessableParent(job) {
+			candidates = append(candidates, &retainedJob{job: job, size: size})
+		}
+	}
+
+	// Oldest first, so byte limits reclaim space from the oldest results
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].job.CompleteTime.Before(candidates[j].job.CompleteTime)
+	})
+
+	purge := func(candidate *retainedJob) {
+		reclaimed, err := datastore.purgeJob(candidate.job)
+		if err != nil {
+			log.WithError(err).WithField("jobId", candidate.job.Id).Error("Unable to purge expired job")
+			return
+		}
+		report.PurgedCount++
+		report.ReclaimedBytes += reclaimed
+		report.RemainingBytes -= candidate.size
+		kindBytes[candidate.job.GetKind()] -= candidate.size
+		candidate.job = nil
+	}
+
+	for _, candidate := range candidates {
+		maxAgeMs := policy.getMaxAgeMs(candidate.job.GetKind())
+		if maxAgeMs > 0 && candidate.job.CompleteTime.Add(time.Duration(maxAgeMs)*time.Millisecond).Before(now) {
+			purge(candidate)
+		}
+	}
+
+	for kind, limits := range policy.KindLimits {
+		for _, candidate := range candidates {
+			if limits.MaxTotalBytes <= 0 || kindBytes[kind] <= limits.MaxTotalBytes {
+				break
+			}
+			if candidate.job != nil && candidate.job.GetKind() == kind {
+				purge(candidate)
+			}
+		}
+	}
+
+	for _, candidate := range candidates {
+		if policy.Limits.MaxTotalBytes <= 0 || report.RemainingBytes <= policy.Limits.MaxTotalBytes {
+			break
+		}
+		if candidate.job != nil {
+			purge(candidate)
+		}
+	}
+
+	return report
+}
+
+func (datastore *FileDatastoreImpl) hasProcessableParent(job *model.Job) bool {
+	if job.ParentId == 0 {
+		return false
+	}
+	parent := datastore.getJobById(job.ParentId)
+	return parent != nil && parent.CanProcess()
+}
+
+func (datastore *FileDatastoreImpl) runRetention(policy *RetentionPolicy) {
+	report := datastore.PurgeExpiredJobs(policy, time.Now())
+	log.WithFields(log.Fields{
+		"purgedCount":    report.PurgedCount,
+		"reclaimedBytes": report.ReclaimedBytes,
+		"remainingBytes": report.RemainingBytes,
+	}).Info("Completed job retention pass")
+}
@@ -0,0 +1,187 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package filedatastore
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/stretchr/testify/assert"
+)
+
+func addRetentionJob(ds *FileDatastoreImpl, kind string, status int, age time.Duration, streamBytes int) *model.Job {
+	job := ds.CreateJob(newContext())
+	job.Kind = kind
+	job.Status = status
+	job.CompleteTime = time.Now().Add(-age)
+	ds.addJob(job)
+	ds.saveJob(job)
+	os.WriteFile(ds.getStreamFilename(job), make([]byte, streamBytes), 0644)
+	return job
+}
+
+func TestNewRetentionPolicy(tester *testing.T) {
+	policy := NewRetentionPolicy(module.ModuleConfig{})
+	assert.False(tester, policy.IsEnabled())
+	assert.Equal(tester, DEFAULT_RETENTION_INTERVAL_MS, policy.IntervalMs)
+
+	cfg := module.ModuleConfig{
+		"retentionMaxAgeMs":      float64(1000),
+		"retentionMaxTotalBytes": float64(5000),
+		"retentionKinds": map[string]interface{}{
+			"analyze": map[string]interface{}{
+				"maxTotalBytes": float64(10),
+			},
+			"bogus": "value",
+		},
+	}
+	policy = NewRetentionPolicy(cfg)
+	assert.True(tester, policy.IsEnabled())
+	assert.Equal(tester, 1000, policy.Limits.MaxAgeMs)
+	assert.Equal(tester, int64(5000), policy.Limits.MaxTotalBytes)
+	assert.Len(tester, policy.KindLimits, 1)
+	assert.Equal(tester, 1000, policy.getMaxAgeMs("analyze"))
+	assert.Equal(tester, int64(10), policy.KindLimits["analyze"].MaxTotalBytes)
+
+	policy = NewRetentionPolicy(module.ModuleConfig{
+		"retentionMaxAgeMs": float64(1000),
+		"retentionKinds":    "analyze",
+	})
+	assert.True(tester, policy.IsEnabled())
+	assert.Empty(tester, policy.KindLimits)
+
+	policy = NewRetentionPolicy(module.ModuleConfig{"retentionIntervalMs": float64(0)})
+	assert.Equal(tester, DEFAULT_RETENTION_INTERVAL_MS, policy.IntervalMs)
+	policy = NewRetentionPolicy(module.ModuleConfig{"retentionIntervalMs": float64(-5)})
+	assert.Equal(tester, DEFAULT_RETENTION_INTERVAL_MS, policy.IntervalMs)
+}
+
+func TestPurgeExpiredJobsByAge(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	old := addRetentionJob(ds, "", model.JobStatusCompleted, time.Hour*48, 100)
+	recent := addRetentionJob(ds, "", model.JobStatusCompleted, time.Hour, 100)
+	pending := addRetentionJob(ds, "", model.JobStatusPending, time.Hour*48, 100)
+	incomplete := addRetentionJob(ds, "", model.JobStatusIncomplete, time.Hour*48, 100)
+	cancelled := addRetentionJob(ds, "", model.JobStatusCancelled, time.Hour*48, 100)
+	failed := addRetentionJob(ds, "", model.JobStatusFailed, time.Hour*48, 100)
+	analyze := addRetentionJob(ds, "analyze", model.JobStatusCompleted, time.Hour*2, 0)
+
+	policy := &RetentionPolicy{
+		Limits:     RetentionLimits{MaxAgeMs: 24 * 3600000},
+		KindLimits: map[string]RetentionLimits{"analyze": {MaxAgeMs: 3600000}},
+	}
+	report := ds.PurgeExpiredJobs(policy, time.Now())
+	assert.Equal(tester, 4, report.PurgedCount)
+	assert.Greater(tester, report.ReclaimedBytes, int64(300))
+	assert.Nil(tester, ds.getJobById(old.Id))
+	assert.Nil(tester, ds.getJobById(analyze.Id))
+	assert.Nil(tester, ds.getJobById(cancelled.Id))
+	assert.Nil(tester, ds.getJobById(failed.Id))
+	assert.NotNil(tester, ds.getJobById(recent.Id))
+	assert.NotNil(tester, ds.getJobById(pending.Id))
+	assert.NotNil(tester, ds.getJobById(incomplete.Id))
+
+	_, err := os.Stat(ds.getStreamFilename(old))
+	assert.True(tester, os.IsNotExist(err))
+	assert.Equal(tester, model.JobStatusDeleted, old.Status)
+}
+
+func TestPurgeExpiredJobsKeepsChildrenOfProcessableParents(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	parent := addRetentionJob(ds, "", model.JobStatusPending, time.Hour*48, 0)
+	child := addRetentionJob(ds, "", model.JobStatusCompleted, time.Hour*48, 100)
+	child.ParentId = parent.Id
+	finishedParent := addRetentionJob(ds, "", model.JobStatusCompleted, time.Hour*48, 0)
+	orphan := addRetentionJob(ds, "", model.JobStatusCompleted, time.Hour*48, 100)
+	orphan.ParentId = finishedParent.Id
+
+	policy := &RetentionPolicy{Limits: RetentionLimits{MaxAgeMs: 24 * 3600000}}
+	report := ds.PurgeExpiredJobs(policy, time.Now())
+	assert.Equal(tester, 2, report.PurgedCount)
+	assert.NotNil(tester, ds.getJobById(parent.Id))
+	assert.NotNil(tester, ds.getJobById(child.Id))
+	assert.Nil(tester, ds.getJobById(finishedParent.Id))
+	assert.Nil(tester, ds.getJobById(orphan.Id))
+
+	parent.Status = model.JobStatusCancelRequested
+	report = ds.PurgeExpiredJobs(policy, time.Now())
+	assert.Equal(tester, 0, report.PurgedCount)
+
+	parent.Status = model.JobStatusCompleted
+	report = ds.PurgeExpiredJobs(policy, time.Now())
+	assert.Equal(tester, 2, report.PurgedCount)
+}
+
+func TestPurgeExpiredJobsBySize(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	oldest := addRetentionJob(ds, "", model.JobStatusCompleted, time.Hour*3, 1000)
+	older := addRetentionJob(ds, "", model.JobStatusCompleted, time.Hour*2, 1000)
+	newest := addRetentionJob(ds, "", model.JobStatusCompleted, time.Hour, 1000)
+
+	// Job files count towards the total, so only the two newest jobs fit
+	limit := ds.getJobSize(older) + ds.getJobSize(newest)
+	policy := &RetentionPolicy{
+		Limits: RetentionLimits{MaxTotalBytes: limit},
+	}
+	report := ds.PurgeExpiredJobs(policy, time.Now())
+	assert.Equal(tester, 1, report.PurgedCount)
+	assert.LessOrEqual(tester, report.RemainingBytes, limit)
+	assert.Nil(tester, ds.getJobById(oldest.Id))
+	assert.NotNil(tester, ds.getJobById(older.Id))
+	assert.NotNil(tester, ds.getJobById(newest.Id))
+}
+
+func TestPurgeExpiredJobsByKindSize(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	pcap := addRetentionJob(ds, "", model.JobStatusCompleted, time.Hour*3, 1000)
+	analyzeOld := addRetentionJob(ds, "analyze", model.JobStatusCompleted, time.Hour*2, 1000)
+	analyzeNew := addRetentionJob(ds, "analyze", model.JobStatusCompleted, time.Hour, 1000)
+
+	policy := &RetentionPolicy{
+		KindLimits: map[string]RetentionLimits{"analyze": {MaxTotalBytes: ds.getJobSize(analyzeNew)}},
+	}
+	report := ds.PurgeExpiredJobs(policy, time.Now())
+	assert.Equal(tester, 1, report.PurgedCount)
+	assert.NotNil(tester, ds.getJobById(pcap.Id))
+	assert.Nil(tester, ds.getJobById(analyzeOld.Id))
+	assert.NotNil(tester, ds.getJobById(analyzeNew.Id))
+}
+
+func TestRetentionJanitor(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, []byte(""))
+
+	fdmodule := NewFileDatastore(ds.server)
+	fdmodule.impl = ds
+	fdmodule.retention = NewRetentionPolicy(module.ModuleConfig{
+		"retentionIntervalMs": float64(0),
+		"retentionMaxAgeMs":   float64(1000),
+	})
+	old := addRetentionJob(ds, "", model.JobStatusCompleted, time.Hour, 0)
+
+	assert.NoError(tester, fdmodule.Start())
+	assert.True(tester, fdmodule.IsRunning())
+	assert.Eventually(tester, func() bool {
+		ds.lock.RLock()
+		defer ds.lock.RUnlock()
+		return ds.getJobById(old.Id) == nil
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(tester, fdmodule.Stop())
+	assert.Eventually(tester, func() bool { return !fdmodule.IsRunning() }, time.Second, time.Millisecond)
+	assert.NoError(tester, fdmodule.Stop())
+}
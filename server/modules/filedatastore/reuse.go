@@ -0,0 +1,43 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package filedatastore
+
+import (
+	"github.com/apex/log"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+const DEFAULT_REUSE_RESULTS = true
+
+// reuseResults completes the job from the stream of an earlier completed job with the
+// same fingerprint, if that stream is still present on disk. Returns true if the job
+// no longer needs to be processed by an agent. Caller must hold the write lock.
+func (datastore *FileDatastoreImpl) reuseResults(job *model.Job) bool {
+	if !datastore.reuseResultsEnabled {
+		return false
+	}
+
+	for _, source := range datastore.jobsByNodeId[job.GetNodeId()] {
+		if !job.CanReuseResultsOf(source) {
+			continue
+		}
+
+		size, err := datastore.streams.Reuse(job, source)
+		if err != nil {
+			log.WithError(err).WithField("sourceJobId", source.Id).Debug("Unable to reuse packet stream")
+			continue
+		}
+
+		job.ReuseResultsOf(source, int(size))
+		log.WithFields(log.Fields{
+			"id":          job.Id,
+			"sourceJobId": source.Id,
+			"size":        size,
+		}).Info("Reused packet stream of previous job")
+		return true
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package filedatastore
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newReuseJob(ds *FileDatastoreImpl, endTime time.Time) *model.Job {
+	job := ds.CreateJob(newContext())
+	job.SetNodeId("foo")
+	job.Filter.BeginTime = endTime.Add(-time.Hour)
+	job.Filter.EndTime = endTime
+	job.Filter.SrcIp = "10.0.0.1"
+	job.Filter.DstPort = 443
+	return job
+}
+
+func TestReuseResults(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, nil)
+	end := time.Now().Add(-time.Hour)
+
+	source := newReuseJob(ds, end)
+	assert.NoError(tester, ds.AddJob(newContext(), source))
+	assert.Equal(tester, 0, source.ReusedJobId)
+	assert.True(tester, source.IsQueued())
+
+	// Identical request while the first is still pending must also be queued
+	pending := newReuseJob(ds, end)
+	assert.NoError(tester, ds.AddJob(newContext(), pending))
+	assert.True(tester, pending.IsQueued())
+
+	assert.NoError(tester, os.WriteFile(ds.getStreamFilename(source), []byte("pcap"), 0644))
+	source.Complete()
+	assert.NoError(tester, ds.saveJob(source))
+
+	job := newReuseJob(ds, end)
+	assert.NoError(tester, ds.AddPivotJob(newContext(), job))
+	assert.Equal(tester, model.JobStatusCompleted, job.Status)
+	assert.Equal(tester, source.Id, job.ReusedJobId)
+	assert.Equal(tester, 4, job.Size)
+
+	// The reused stream outlives the source job
+	_, err := ds.DeleteJob(newContext(), source.Id)
+	assert.NoError(tester, err)
+	content, err := os.ReadFile(ds.getStreamFilename(job))
+	assert.NoError(tester, err)
+	assert.Equal(tester, "pcap", string(content))
+
+	// Jobs whose stream is gone are not reused, but jobs that were themselves reused are
+	other := newReuseJob(ds, end)
+	assert.NoError(tester, ds.AddJob(newContext(), other))
+	assert.Equal(tester, job.Id, other.ReusedJobId)
+
+	different := newReuseJob(ds, end)
+	different.Filter.DstPort = 80
+	assert.NoError(tester, ds.AddJob(newContext(), different))
+	assert.True(tester, different.IsQueued())
+}
+
+func TestReuseResultsDisabled(tester *testing.T) {
+	defer cleanup()
+	ds, _ := createDatastore(true, nil)
+	ds.reuseResultsEnabled = false
+	end := time.Now().Add(-time.Hour)
+
+	source := newReuseJob(ds, end)
+	source.Complete()
+	ds.addJob(source)
+	os.MkdirAll(JOB_DIR+"/foo", 0777)
+	assert.NoError(tester, os.WriteFile(ds.getStreamFilename(source), []byte("pcap"), 0644))
+
+	job := newReuseJob(ds, end)
+	assert.NoError(tester, ds.AddJob(newContext(), job))
+	assert.True(tester, job.IsQueued())
+	assert.Equal(tester, 0, job.ReusedJobId)
+}
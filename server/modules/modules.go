@@ -9,6 +9,7 @@ package modules
 import (
 	"github.com/security-onion-solutions/securityonion-soc/module"
 	"github.com/security-onion-solutions/securityonion-soc/server"
+	"github.com/security-onion-solutions/securityonion-soc/server/modules/boltdatastore"
 	"github.com/security-onion-solutions/securityonion-soc/server/modules/elastalert"
 	"github.com/security-onion-solutions/securityonion-soc/server/modules/elastic"
 	"github.com/security-onion-solutions/securityonion-soc/server/modules/elasticcases"
@@ -16,6 +17,7 @@ import (
 	"github.com/security-onion-solutions/securityonion-soc/server/modules/generichttp"
 	"github.com/security-onion-solutions/securityonion-soc/server/modules/influxdb"
 	"github.com/security-onion-solutions/securityonion-soc/server/modules/kratos"
+	"github.com/security-onion-solutions/securityonion-soc/server/modules/mtlsauth"
 	"github.com/security-onion-solutions/securityonion-soc/server/modules/salt"
 	"github.com/security-onion-solutions/securityonion-soc/server/modules/sostatus"
 	"github.com/security-onion-solutions/securityonion-soc/server/modules/statickeyauth"
@@ -27,10 +29,12 @@ import (
 
 func BuildModuleMap(srv *server.Server) map[string]module.Module {
 	moduleMap := make(map[string]module.Module)
+	moduleMap["boltdatastore"] = boltdatastore.NewBoltDatastore(srv)
 	moduleMap["filedatastore"] = filedatastore.NewFileDatastore(srv)
 	moduleMap["httpcase"] = generichttp.NewHttpCase(srv)
 	moduleMap["influxdb"] = influxdb.NewInfluxDB(srv)
 	moduleMap["kratos"] = kratos.NewKratos(srv)
+	moduleMap["mtlsauth"] = mtlsauth.NewMtlsAuth(srv)
 	moduleMap["elastic"] = elastic.NewElastic(srv)
 	moduleMap["elasticcases"] = elasticcases.NewElasticCases(srv)
 	moduleMap["salt"] = salt.NewSalt(srv)
@@ -17,6 +17,7 @@ func TestBuildModuleMap(t *testing.T) {
 	mm := BuildModuleMap(nil)
 	findModule(t, mm, "elastic")
 	findModule(t, mm, "elasticcases")
+	findModule(t, mm, "boltdatastore")
 	findModule(t, mm, "filedatastore")
 	findModule(t, mm, "salt")
 	findModule(t, mm, "httpcase")
@@ -0,0 +1,63 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package mtlsauth
+
+import (
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/security-onion-solutions/securityonion-soc/server"
+)
+
+const DEFAULT_CRL_PATH = ""
+const DEFAULT_CERT_HEADER = "X-Client-Cert"
+const DEFAULT_PROXY_CIDR = "127.0.0.1/32"
+
+type MtlsAuth struct {
+	config module.ModuleConfig
+	server *server.Server
+	impl   *MtlsAuthImpl
+}
+
+func NewMtlsAuth(srv *server.Server) *MtlsAuth {
+	return &MtlsAuth{
+		server: srv,
+		impl:   NewMtlsAuthImpl(srv),
+	}
+}
+
+func (mtls *MtlsAuth) PrerequisiteModules() []string {
+	return nil
+}
+
+func (mtls *MtlsAuth) Init(cfg module.ModuleConfig) error {
+	mtls.config = cfg
+	caCertPath, err := module.GetString(cfg, "caCertPath")
+	if err == nil {
+		var anonymousCidr string
+		anonymousCidr, err = module.GetString(cfg, "anonymousCidr")
+		if err == nil {
+			crlPath := module.GetStringDefault(cfg, "crlPath", DEFAULT_CRL_PATH)
+			certHeader := module.GetStringDefault(cfg, "certHeader", DEFAULT_CERT_HEADER)
+			proxyCidr := module.GetStringDefault(cfg, "proxyCidr", DEFAULT_PROXY_CIDR)
+			err = mtls.impl.Init(caCertPath, crlPath, certHeader, proxyCidr, anonymousCidr)
+			if err == nil {
+				err = mtls.server.Host.AddPreprocessor(mtls.impl)
+			}
+		}
+	}
+	return err
+}
+
+func (mtls *MtlsAuth) Start() error {
+	return nil
+}
+
+func (mtls *MtlsAuth) Stop() error {
+	return nil
+}
+
+func (mtls *MtlsAuth) IsRunning() bool {
+	return false
+}
@@ -0,0 +1,36 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package mtlsauth
+
+import (
+	"testing"
+
+	"github.com/security-onion-solutions/securityonion-soc/config"
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/security-onion-solutions/securityonion-soc/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthInit(tester *testing.T) {
+	ca := newTestCa(tester, tester.TempDir())
+	scfg := &config.ServerConfig{}
+	srv := server.NewServer(scfg, "")
+	auth := NewMtlsAuth(srv)
+	cfg := make(module.ModuleConfig)
+
+	assert.Error(tester, auth.Init(cfg))
+	cfg["caCertPath"] = ca.path
+	assert.Error(tester, auth.Init(cfg))
+	assert.Len(tester, srv.Host.Preprocessors(), 1)
+
+	cfg["anonymousCidr"] = "172.17.0.0/24"
+	if assert.NoError(tester, auth.Init(cfg)) {
+		assert.Equal(tester, DEFAULT_CERT_HEADER, auth.impl.certHeader)
+		assert.Equal(tester, DEFAULT_PROXY_CIDR, auth.impl.proxyNetwork.String())
+		assert.Equal(tester, "172.17.0.0/24", auth.impl.anonymousNetwork.String())
+		assert.Len(tester, srv.Host.Preprocessors(), 2)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package mtlsauth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/security-onion-solutions/securityonion-soc/server"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+)
+
+type MtlsAuthImpl struct {
+	server           *server.Server
+	roots            *x509.CertPool
+	caCerts          []*x509.Certificate
+	crlPath          string
+	crlModTime       time.Time
+	revoked          map[string]struct{}
+	certHeader       string
+	proxyNetwork     *net.IPNet
+	skipCidrCheck    bool
+	anonymousNetwork *net.IPNet
+	lock             sync.Mutex
+}
+
+func NewMtlsAuthImpl(srv *server.Server) *MtlsAuthImpl {
+	return &MtlsAuthImpl{
+		server:  srv,
+		revoked: make(map[string]struct{}),
+	}
+}
+
+func (auth *MtlsAuthImpl) Init(caCertPath string, crlPath string, certHeader string, proxyCidr string, anonymousCidr string) error {
+	caPem, err := os.ReadFile(caCertPath)
+	if err != nil {
+		return err
+	}
+
+	auth.roots = x509.NewCertPool()
+	auth.caCerts = nil
+	for block, rest := pem.Decode(caPem); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		auth.roots.AddCert(cert)
+		auth.caCerts = append(auth.caCerts, cert)
+	}
+	if len(auth.caCerts) == 0 {
+		return errors.New("No CA certificates found in " + caCertPath)
+	}
+
+	auth.certHeader = certHeader
+	if proxyCidr != "" {
+		_, auth.proxyNetwork, err = net.ParseCIDR(proxyCidr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if anonymousCidr == "*" {
+		auth.skipCidrCheck = true
+		log.Warn("Bypassing all anonymous CIDR traffic checks. This is only intended for development use.")
+	} else {
+		auth.skipCidrCheck = false
+		_, auth.anonymousNetwork, err = net.ParseCIDR(anonymousCidr)
+		if err != nil {
+			return err
+		}
+	}
+
+	auth.crlPath = crlPath
+	auth.crlModTime = time.Time{}
+	auth.revoked = make(map[string]struct{})
+	return auth.refreshRevocations()
+}
+
+func (auth *MtlsAuthImpl) PreprocessPriority() int {
+	return 101
+}
+
+func (auth *MtlsAuthImpl) Preprocess(ctx context.Context, req *http.Request) (context.Context, int, error) {
+	cert, intermediates, err := auth.clientCertificate(req)
+	if err == nil && cert == nil {
+		if auth.isAnonymousIp(ctx, req.RemoteAddr) {
+			return ctx, 0, nil
+		}
+		err = errors.New("Client certificate is required")
+	}
+
+	var nodeId string
+	if err == nil {
+		nodeId, err = auth.verify(cert, intermediates)
+	}
+
+	log.WithFields(log.Fields{
+		"nodeId":    nodeId,
+		"requestId": ctx.Value(web.ContextKeyRequestId),
+	}).WithError(err).Debug("Authorization check via client certificate")
+
+	if err != nil {
+		return ctx, http.StatusUnauthorized, errors.New("Access denied")
+	}
+
+	// Nodes assume the role of this server, as with the static key, but are restricted to
+	// reporting on behalf of the node named in their certificate.
+	ctx = context.WithValue(ctx, web.ContextKeyRequestor, auth.server.Agent)
+	ctx = context.WithValue(ctx, web.ContextKeyRequestorId, auth.server.Agent.Id)
+	ctx = context.WithValue(ctx, web.ContextKeyRequestorNodeId, nodeId)
+	return ctx, 0, nil
+}
+
+// clientCertificate returns the certificate presented by the client, either directly to this
+// host or to a TLS terminating proxy that forwards it, URL-escaped, in the configured header.
+func (auth *MtlsAuthImpl) clientCertificate(req *http.Request) (*x509.Certificate, []*x509.Certificate, error) {
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		return req.TLS.PeerCertificates[0], req.TLS.PeerCertificates[1:], nil
+	}
+
+	if auth.certHeader == "" || auth.proxyNetwork == nil {
+		return nil, nil, nil
+	}
+	value := req.Header.Get(auth.certHeader)
+	if value == "" {
+		return nil, nil, nil
+	}
+	if !auth.proxyNetwork.Contains(parseRemoteIp(req.RemoteAddr)) {
+		return nil, nil, errors.New("Client certificate header was not sent by a trusted proxy")
+	}
+
+	unescaped, err := url.QueryUnescape(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode([]byte(unescaped)); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, nil, errors.New("Client certificate header does not contain a certificate")
+	}
+	return certs[0], certs[1:], nil
+}
+
+// verify checks the certificate chain and revocation status, and returns the node id that the
+// certificate identifies.
+func (auth *MtlsAuthImpl) verify(cert *x509.Certificate, intermediates []*x509.Certificate) (string, error) {
+	opts := x509.VerifyOptions{
+		Roots:         auth.roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, intermediate := range intermediates {
+		opts.Intermediates.AddCert(intermediate)
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		return "", err
+	}
+
+	if err := auth.refreshRevocations(); err != nil {
+		// Keep enforcing the last revocation list that was successfully loaded.
+		log.WithError(err).WithField("crlPath", auth.crlPath).Error("Unable to refresh certificate revocation list")
+	}
+	if auth.isRevoked(cert.SerialNumber) {
+		return "", errors.New("Client certificate has been revoked")
+	}
+
+	nodeId := cert.Subject.CommonName
+	if nodeId == "" && len(cert.DNSNames) > 0 {
+		nodeId = cert.DNSNames[0]
+	}
+	if nodeId == "" {
+		return "", errors.New("Client certificate does not identify a node")
+	}
+	return strings.ToLower(nodeId), nil
+}
+
+func (auth *MtlsAuthImpl) isRevoked(serial *big.Int) bool {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	_, revoked := auth.revoked[serial.String()]
+	return revoked
+}
+
+// refreshRevocations reloads the revocation list whenever the CRL file has been modified. The
+// list must be signed by one of the configured CAs.
+func (auth *MtlsAuthImpl) refreshRevocations() error {
+	if auth.crlPath == "" {
+		return nil
+	}
+
+	info, err := os.Stat(auth.crlPath)
+	if err != nil {
+		return err
+	}
+
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+
+	if info.ModTime().Equal(auth.crlModTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(auth.crlPath)
+	if err != nil {
+		return err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return err
+	}
+
+	err = errors.New("Certificate revocation list is not signed by a trusted CA")
+	for _, caCert := range auth.caCerts {
+		if crl.CheckSignatureFrom(caCert) == nil {
+			err = nil
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	revoked := make(map[string]struct{}, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked[entry.SerialNumber.String()] = struct{}{}
+	}
+	auth.revoked = revoked
+	auth.crlModTime = info.ModTime()
+
+	log.WithFields(log.Fields{
+		"crlPath": auth.crlPath,
+		"revoked": len(revoked),
+	}).Info("Loaded certificate revocation list")
+	return nil
+}
+
+func (auth *MtlsAuthImpl) isAnonymousIp(ctx context.Context, ipStr string) bool {
+	if auth.skipCidrCheck {
+		return true
+	}
+
+	remoteIp := parseRemoteIp(ipStr)
+	isAnonymousIp := auth.anonymousNetwork.Contains(remoteIp)
+	log.WithFields(log.Fields{
+		"anonymousNetwork": auth.anonymousNetwork,
+		"remoteIp":         remoteIp,
+		"isAnonymousIp":    isAnonymousIp,
+		"requestId":        ctx.Value(web.ContextKeyRequestId),
+	}).Debug("Authorization check via remote IP")
+	return isAnonymousIp
+}
+
+func parseRemoteIp(ipStr string) net.IP {
+	idx := strings.LastIndex(ipStr, ":")
+	if idx > 0 {
+		ipStr = ipStr[0:idx]
+		ipStr = strings.TrimPrefix(ipStr, "[")
+		ipStr = strings.TrimSuffix(ipStr, "]")
+	}
+	return net.ParseIP(ipStr)
+}
@@ -0,0 +1,210 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package mtlsauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/security-onion-solutions/securityonion-soc/server"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCa struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	path string
+}
+
+func newTestCa(tester *testing.T, dir string) *testCa {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(tester, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(tester, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(tester, err)
+
+	path := filepath.Join(dir, "ca.crt")
+	assert.NoError(tester, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return &testCa{cert: cert, key: key, path: path}
+}
+
+func (ca *testCa) issue(tester *testing.T, serial int64, commonName string, usage x509.ExtKeyUsage) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(tester, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(tester, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(tester, err)
+	return cert
+}
+
+func (ca *testCa) writeCrl(tester *testing.T, path string, number int64, serials ...int64) {
+	template := &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, serial := range serials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	assert.NoError(tester, err)
+	assert.NoError(tester, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600))
+}
+
+func newTlsRequest(remoteAddr string, cert *x509.Certificate) *http.Request {
+	request := httptest.NewRequest("POST", "/api/node", nil)
+	request.RemoteAddr = remoteAddr
+	if cert != nil {
+		request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	}
+	return request
+}
+
+func newProxiedRequest(remoteAddr string, cert *x509.Certificate) *http.Request {
+	request := httptest.NewRequest("POST", "/api/node", nil)
+	request.RemoteAddr = remoteAddr
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	request.Header.Set(DEFAULT_CERT_HEADER, url.QueryEscape(string(encoded)))
+	return request
+}
+
+func TestPreprocessClientCertificate(tester *testing.T) {
+	dir := tester.TempDir()
+	ca := newTestCa(tester, dir)
+	other := newTestCa(tester, tester.TempDir())
+
+	auth := NewMtlsAuthImpl(server.NewFakeAuthorizedServer(nil))
+	assert.NoError(tester, auth.Init(ca.path, "", DEFAULT_CERT_HEADER, DEFAULT_PROXY_CIDR, "172.17.0.0/24"))
+
+	ctx, status, err := auth.Preprocess(context.Background(), newTlsRequest("1.1.1.1:443", ca.issue(tester, 10, "Sensor1", x509.ExtKeyUsageClientAuth)))
+	assert.NoError(tester, err)
+	assert.Equal(tester, 0, status)
+	assert.Equal(tester, "sensor1", ctx.Value(web.ContextKeyRequestorNodeId))
+	assert.Equal(tester, server.AGENT_ID, ctx.Value(web.ContextKeyRequestorId))
+
+	_, status, err = auth.Preprocess(context.Background(), newTlsRequest("1.1.1.1:443", other.issue(tester, 10, "Sensor1", x509.ExtKeyUsageClientAuth)))
+	assert.Error(tester, err)
+	assert.Equal(tester, http.StatusUnauthorized, status)
+
+	_, status, err = auth.Preprocess(context.Background(), newTlsRequest("1.1.1.1:443", ca.issue(tester, 11, "Sensor1", x509.ExtKeyUsageServerAuth)))
+	assert.Error(tester, err)
+	assert.Equal(tester, http.StatusUnauthorized, status)
+
+	_, status, err = auth.Preprocess(context.Background(), newTlsRequest("1.1.1.1:443", ca.issue(tester, 12, "", x509.ExtKeyUsageClientAuth)))
+	assert.Error(tester, err)
+	assert.Equal(tester, http.StatusUnauthorized, status)
+}
+
+func TestPreprocessAnonymous(tester *testing.T) {
+	ca := newTestCa(tester, tester.TempDir())
+
+	auth := NewMtlsAuthImpl(server.NewFakeAuthorizedServer(nil))
+	assert.NoError(tester, auth.Init(ca.path, "", DEFAULT_CERT_HEADER, DEFAULT_PROXY_CIDR, "172.17.0.0/24"))
+
+	ctx, status, err := auth.Preprocess(context.Background(), newTlsRequest("172.17.0.1:443", nil))
+	assert.NoError(tester, err)
+	assert.Equal(tester, 0, status)
+	assert.Nil(tester, ctx.Value(web.ContextKeyRequestorNodeId))
+
+	_, status, err = auth.Preprocess(context.Background(), newTlsRequest("1.1.1.1:443", nil))
+	assert.Error(tester, err)
+	assert.Equal(tester, http.StatusUnauthorized, status)
+}
+
+func TestPreprocessProxiedCertificate(tester *testing.T) {
+	ca := newTestCa(tester, tester.TempDir())
+	cert := ca.issue(tester, 10, "Sensor1", x509.ExtKeyUsageClientAuth)
+
+	auth := NewMtlsAuthImpl(server.NewFakeAuthorizedServer(nil))
+	assert.NoError(tester, auth.Init(ca.path, "", DEFAULT_CERT_HEADER, "172.17.0.0/24", "172.17.0.0/24"))
+
+	ctx, status, err := auth.Preprocess(context.Background(), newProxiedRequest("172.17.0.5:443", cert))
+	assert.NoError(tester, err)
+	assert.Equal(tester, 0, status)
+	assert.Equal(tester, "sensor1", ctx.Value(web.ContextKeyRequestorNodeId))
+
+	// The header is only trusted when sent by the proxy
+	_, status, err = auth.Preprocess(context.Background(), newProxiedRequest("1.1.1.1:443", cert))
+	assert.Error(tester, err)
+	assert.Equal(tester, http.StatusUnauthorized, status)
+}
+
+func TestPreprocessRevokedCertificate(tester *testing.T) {
+	dir := tester.TempDir()
+	ca := newTestCa(tester, dir)
+	crlPath := filepath.Join(dir, "ca.crl")
+	ca.writeCrl(tester, crlPath, 1, 11)
+
+	auth := NewMtlsAuthImpl(server.NewFakeAuthorizedServer(nil))
+	assert.NoError(tester, auth.Init(ca.path, crlPath, DEFAULT_CERT_HEADER, DEFAULT_PROXY_CIDR, "172.17.0.0/24"))
+
+	_, _, err := auth.Preprocess(context.Background(), newTlsRequest("1.1.1.1:443", ca.issue(tester, 10, "Sensor1", x509.ExtKeyUsageClientAuth)))
+	assert.NoError(tester, err)
+	_, status, err := auth.Preprocess(context.Background(), newTlsRequest("1.1.1.1:443", ca.issue(tester, 11, "Sensor2", x509.ExtKeyUsageClientAuth)))
+	assert.Error(tester, err)
+	assert.Equal(tester, http.StatusUnauthorized, status)
+
+	// Updated revocation lists are picked up without a restart
+	ca.writeCrl(tester, crlPath, 2, 10, 11)
+	future := time.Now().Add(time.Minute)
+	assert.NoError(tester, os.Chtimes(crlPath, future, future))
+	_, status, err = auth.Preprocess(context.Background(), newTlsRequest("1.1.1.1:443", ca.issue(tester, 10, "Sensor1", x509.ExtKeyUsageClientAuth)))
+	assert.Error(tester, err)
+	assert.Equal(tester, http.StatusUnauthorized, status)
+}
+
+func TestAuthImplInit(tester *testing.T) {
+	dir := tester.TempDir()
+	ca := newTestCa(tester, dir)
+	other := newTestCa(tester, tester.TempDir())
+	crlPath := filepath.Join(dir, "other.crl")
+	other.writeCrl(tester, crlPath, 1)
+
+	auth := NewMtlsAuthImpl(server.NewFakeAuthorizedServer(nil))
+	assert.Error(tester, auth.Init(filepath.Join(dir, "missing.crt"), "", DEFAULT_CERT_HEADER, DEFAULT_PROXY_CIDR, "*"))
+	assert.Error(tester, auth.Init(ca.path, "", DEFAULT_CERT_HEADER, DEFAULT_PROXY_CIDR, "invalid"))
+	assert.Error(tester, auth.Init(ca.path, "", DEFAULT_CERT_HEADER, "invalid", "*"))
+	assert.Error(tester, auth.Init(ca.path, crlPath, DEFAULT_CERT_HEADER, DEFAULT_PROXY_CIDR, "*"))
+	assert.NoError(tester, auth.Init(ca.path, "", DEFAULT_CERT_HEADER, DEFAULT_PROXY_CIDR, "*"))
+	assert.True(tester, auth.skipCidrCheck)
+}
@@ -0,0 +1,257 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package streamstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/apex/log"
+	"github.com/kennygrant/sanitize"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/security-onion-solutions/securityonion-soc/packet"
+	"github.com/security-onion-solutions/securityonion-soc/util"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+)
+
+const DEFAULT_STREAM_COMPRESSION = false
+const UNWRAPPED_FILE_EXTENSION = ".unwrapped"
+
+// StreamStore keeps the packet streams of jobs on disk, under a folder per node in the
+// job directory. Datastores remain responsible for looking up jobs and checking that
+// the requestor may access them before calling into the store.
+type StreamStore struct {
+	jobDir string
+	codec  *packet.StreamCodec
+}
+
+func NewStreamStore(jobDir string, codec *packet.StreamCodec) *StreamStore {
+	return &StreamStore{
+		jobDir: jobDir,
+		codec:  codec,
+	}
+}
+
+// NewStreamStoreFromConfig creates a store for the configured jobDir, compressing streams
+// with zstd if streamCompression is enabled and encrypting them with AES-GCM if a base64
+// encoded streamEncryptionKey is configured.
+func NewStreamStoreFromConfig(cfg module.ModuleConfig) (*StreamStore, error) {
+	jobDir, err := module.GetString(cfg, "jobDir")
+	if err != nil {
+		return nil, err
+	}
+	key, err := packet.ParseStreamKey(module.GetStringDefault(cfg, "streamEncryptionKey", ""))
+	if err != nil {
+		return nil, err
+	}
+	codec, err := packet.NewStreamCodec(module.GetBoolDefault(cfg, "streamCompression", DEFAULT_STREAM_COMPRESSION), key)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamStore(jobDir, codec), nil
+}
+
+// ConvertPacketStreams rewrites the packet streams already stored in the configured job
+// directory so that they are compressed and encrypted according to the configuration.
+// Returns the number of streams converted.
+func ConvertPacketStreams(cfg module.ModuleConfig) (int, error) {
+	store, err := NewStreamStoreFromConfig(cfg)
+	if err != nil {
+		return 0, err
+	}
+	return store.codec.ConvertFiles(store.jobDir)
+}
+
+// requestorName identifies the user making the request, for chain-of-custody records.
+func requestorName(ctx context.Context) string {
+	if user, ok := ctx.Value(web.ContextKeyRequestor).(*model.User); ok {
+		if user.Email != "" {
+			return user.Email
+		}
+		return user.Id
+	}
+	return ""
+}
+
+func (store *StreamStore) Filename(job *model.Job) string {
+	filename := fmt.Sprintf("%d.bin", job.Id)
+	folder := filepath.Join(store.jobDir, sanitize.Name(job.GetNodeId()))
+	return filepath.Join(folder, filename)
+}
+
+// Filenames returns every stream file that may exist on disk for the given job.
+func (store *StreamStore) Filenames(job *model.Job) []string {
+	filename := store.Filename(job)
+	return []string{
+		filename,
+		filename + UNWRAPPED_FILE_EXTENSION,
+		store.UploadFilename(job),
+	}
+}
+
+func (store *StreamStore) modifiedFilename(job *model.Job, unwrap bool) string {
+	filename := store.Filename(job)
+	if unwrap {
+		unwrappedFilename := filename + UNWRAPPED_FILE_EXTENSION
+		unwrapped := store.codec.UnwrapFile(filename, unwrappedFilename)
+		if unwrapped {
+			filename = unwrappedFilename
+		}
+	}
+	return filename
+}
+
+// Reuse links, or copies, the stream of the source job to the job. Returns the size of the
+// decoded stream.
+func (store *StreamStore) Reuse(job *model.Job, source *model.Job) (int64, error) {
+	size, err := util.LinkOrCopyFile(store.Filename(source), store.Filename(job))
+	if err == nil {
+		size, err = store.codec.StreamSize(store.Filename(job))
+	}
+	return size, err
+}
+
+// Packets parses a page of packets from the job's stream as it is decoded. Streams that
+// cannot be parsed yield no packets.
+func (store *StreamStore) Packets(job *model.Job, offset int, count int, unwrap bool) []*model.Packet {
+	var packets []*model.Packet
+	err := store.analyze(job, func(reader io.Reader) error {
+		var err error
+		packets, err = packet.ParsePcapStream(reader, offset, count, unwrap)
+		return err
+	})
+	if err != nil {
+		log.WithError(err).WithField("jobId", job.Id).Warn("Failed to parse captured packets")
+	}
+	return packets
+}
+
+func (store *StreamStore) Save(job *model.Job, reader io.Reader) error {
+	count, err := store.codec.WriteFile(store.Filename(job), reader)
+	if err != nil {
+		log.WithError(err).WithField("jobId", job.Id).Error("Failed to write packet stream to file")
+	} else {
+		log.WithFields(log.Fields{
+			"bytes": count,
+			"jobId": job.Id,
+		}).Info("Saved packet stream to file")
+	}
+	return err
+}
+
+// Merge replaces the job's stream with the time-ordered merge of the streams of the
+// given completed source jobs. Returns the merged stream size.
+func (store *StreamStore) Merge(job *model.Job, sources []*model.Job) (int64, error) {
+	filenames := make([]string, 0, len(sources))
+	for _, source := range sources {
+		if source.Status == model.JobStatusCompleted {
+			filenames = append(filenames, store.Filename(source))
+		}
+	}
+	filename := store.Filename(job)
+	os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+	size, err := store.codec.MergeFiles(filename, filenames)
+	if err != nil {
+		log.WithError(err).WithField("jobId", job.Id).Error("Failed to merge packet streams")
+	}
+	return size, err
+}
+
+// Open opens the job's stream for reading, optionally with tunneled packets unwrapped.
+// When the pcapng format is requested the stream is converted as it is read, with the
+// job's chain-of-custody details embedded as comments, and the returned length is -1
+// since it is not known in advance. Also returns the name to download the stream as.
+func (store *StreamStore) Open(ctx context.Context, job *model.Job, unwrap bool, format string) (io.ReadCloser, string, int64, error) {
+	filename := fmt.Sprintf("sensoroni_%s_%d.%s", sanitize.Name(job.GetNodeId()), job.Id, sanitize.Name(job.FileExtension))
+	streamFilename := store.modifiedFilename(job, unwrap)
+	reader, length, err := store.codec.OpenFile(streamFilename)
+	if err != nil {
+		log.WithError(err).WithField("jobId", job.Id).Error("Failed to open packet stream")
+		return nil, filename, 0, err
+	}
+	if format == packet.PcapFormatPcapNg {
+		reader = packet.NewPcapNgReader(reader, packet.JobCustodyComments(job, requestorName(ctx)))
+		length = -1
+	}
+	log.WithFields(log.Fields{
+		"streamSize":     length,
+		"streamFilename": filepath.Base(streamFilename),
+	}).Info("Streaming file")
+	return reader, filename, length, nil
+}
+
+// analyze runs the given analysis over the job's decoded stream.
+func (store *StreamStore) analyze(job *model.Job, analysis func(reader io.Reader) error) error {
+	reader, _, err := store.codec.OpenFile(store.Filename(job))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return analysis(reader)
+}
+
+// TcpStream reassembles the TCP connection with the given index from the job's stream.
+func (store *StreamStore) TcpStream(job *model.Job, index int, unwrap bool, maxBytes int) (*model.TcpStream, error) {
+	var stream *model.TcpStream
+	err := store.analyze(job, func(reader io.Reader) error {
+		var err error
+		stream, err = packet.ReassembleTcpStream(reader, index, unwrap, maxBytes)
+		return err
+	})
+	if stream != nil {
+		stream.JobId = job.Id
+	}
+	return stream, err
+}
+
+// CarvedFiles extracts the files transferred over the TCP connections in the job's stream,
+// keeping the content of the files accepted by the retained selector.
+func (store *StreamStore) CarvedFiles(job *model.Job, unwrap bool, maxBytes int, retained func(index int) bool) ([]*model.CarvedFile, error) {
+	var files []*model.CarvedFile
+	err := store.analyze(job, func(reader io.Reader) error {
+		var err error
+		files, err = packet.CarveFiles(reader, unwrap, maxBytes, retained)
+		return err
+	})
+	for _, file := range files {
+		file.JobId = job.Id
+	}
+	return files, err
+}
+
+// Statistics summarizes the talkers, conversations, protocols and packet rate of the
+// job's stream.
+func (store *StreamStore) Statistics(job *model.Job, unwrap bool, limit int) (*model.PacketStatistics, error) {
+	var stats *model.PacketStatistics
+	err := store.analyze(job, func(reader io.Reader) error {
+		var err error
+		stats, err = packet.ComputeStatistics(reader, unwrap, limit)
+		return err
+	})
+	if stats != nil {
+		stats.JobId = job.Id
+	}
+	return stats, err
+}
+
+// Fingerprints summarizes the fingerprints of the TLS and SSH handshakes in the job's
+// stream.
+func (store *StreamStore) Fingerprints(job *model.Job, unwrap bool) (*model.FingerprintSummary, error) {
+	var summary *model.FingerprintSummary
+	err := store.analyze(job, func(reader io.Reader) error {
+		var err error
+		summary, err = packet.ComputeFingerprints(reader, unwrap)
+		return err
+	})
+	if summary != nil {
+		summary.JobId = job.Id
+	}
+	return summary, err
+}
@@ -0,0 +1,292 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package streamstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/security-onion-solutions/securityonion-soc/packet"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	"github.com/stretchr/testify/assert"
+)
+
+const MY_USER_ID = "123"
+
+func newTestStore(tester *testing.T, cfg module.ModuleConfig) *StreamStore {
+	cfg["jobDir"] = tester.TempDir()
+	store, err := NewStreamStoreFromConfig(cfg)
+	assert.NoError(tester, err)
+	return store
+}
+
+func newTestJob(id int) *model.Job {
+	job := model.NewJob()
+	job.Id = id
+	job.SetNodeId("foo")
+	job.FileExtension = "pcap"
+	return job
+}
+
+func buildTcpPcap(tester *testing.T, payload string) []byte {
+	ethernet := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, Seq: 1, ACK: true, PSH: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	frame := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	assert.NoError(tester, gopacket.SerializeLayers(frame, options, ethernet, ip, tcp, gopacket.Payload(payload)))
+
+	var buf bytes.Buffer
+	writer := pcapgo.NewWriter(&buf)
+	assert.NoError(tester, writer.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(frame.Bytes()), Length: len(frame.Bytes())}
+	assert.NoError(tester, writer.WritePacket(ci, frame.Bytes()))
+	return buf.Bytes()
+}
+
+func readAll(tester *testing.T, reader io.ReadCloser) string {
+	content, err := io.ReadAll(reader)
+	assert.NoError(tester, err)
+	reader.Close()
+	return string(content)
+}
+
+func TestNewStreamStoreFromConfig(tester *testing.T) {
+	cfg := make(module.ModuleConfig)
+	_, err := NewStreamStoreFromConfig(cfg)
+	assert.Error(tester, err)
+
+	cfg["jobDir"] = "/tmp/jobs"
+	store, err := NewStreamStoreFromConfig(cfg)
+	if assert.NoError(tester, err) {
+		assert.True(tester, store.codec.IsPlain())
+		assert.Equal(tester, "/tmp/jobs/foo/1001.bin", store.Filename(newTestJob(1001)))
+		assert.Equal(tester, []string{
+			"/tmp/jobs/foo/1001.bin",
+			"/tmp/jobs/foo/1001.bin.unwrapped",
+			"/tmp/jobs/foo/1001.bin.part",
+		}, store.Filenames(newTestJob(1001)))
+	}
+
+	cfg["streamCompression"] = true
+	cfg["streamEncryptionKey"] = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	store, err = NewStreamStoreFromConfig(cfg)
+	if assert.NoError(tester, err) {
+		assert.False(tester, store.codec.IsPlain())
+	}
+
+	cfg["streamEncryptionKey"] = base64.StdEncoding.EncodeToString([]byte("short"))
+	_, err = NewStreamStoreFromConfig(cfg)
+	assert.Error(tester, err)
+}
+
+func TestConvertPacketStreams(tester *testing.T) {
+	cfg := module.ModuleConfig{}
+	plain := newTestStore(tester, cfg)
+	job := newTestJob(1001)
+	assert.NoError(tester, plain.Save(job, bytes.NewReader(buildTcpPcap(tester, "hello"))))
+
+	cfg["streamCompression"] = true
+	count, err := ConvertPacketStreams(cfg)
+	assert.NoError(tester, err)
+	assert.Equal(tester, 1, count)
+
+	store, _ := NewStreamStoreFromConfig(cfg)
+	reader, _, _, err := store.Open(context.Background(), job, false, "")
+	if assert.NoError(tester, err) {
+		assert.Contains(tester, readAll(tester, reader), "hello")
+	}
+}
+
+func TestEncodedStream(tester *testing.T) {
+	store := newTestStore(tester, module.ModuleConfig{
+		"streamCompression":   true,
+		"streamEncryptionKey": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+	})
+	job := newTestJob(1001)
+
+	assert.NoError(tester, store.Save(job, strings.NewReader("pcap")))
+	stored, _ := os.ReadFile(store.Filename(job))
+	assert.NotContains(tester, string(stored), "pcap")
+
+	reader, filename, length, err := store.Open(context.Background(), job, false, "")
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, "pcap", readAll(tester, reader))
+		assert.Equal(tester, "sensoroni_foo_1001.pcap", filename)
+		assert.Equal(tester, int64(4), length)
+	}
+
+	_, _, _, err = store.Open(context.Background(), newTestJob(9999), false, "")
+	assert.Error(tester, err)
+}
+
+func TestMergeAndReuse(tester *testing.T) {
+	store := newTestStore(tester, module.ModuleConfig{"streamCompression": true})
+	first := newTestJob(1001)
+	first.Status = model.JobStatusCompleted
+	second := newTestJob(1002)
+	second.SetNodeId("bar")
+	second.Status = model.JobStatusCompleted
+	failed := newTestJob(1003)
+	failed.Status = model.JobStatusFailed
+	assert.NoError(tester, store.Save(first, bytes.NewReader(buildTcpPcap(tester, "first"))))
+	assert.NoError(tester, store.Save(second, bytes.NewReader(buildTcpPcap(tester, "second"))))
+	assert.NoError(tester, store.Save(failed, bytes.NewReader(buildTcpPcap(tester, "failed"))))
+
+	parent := newTestJob(1000)
+	parent.SetNodeId("")
+	size, err := store.Merge(parent, []*model.Job{first, second, failed})
+	if assert.NoError(tester, err) {
+		assert.Greater(tester, size, int64(0))
+		stats, err := store.Statistics(parent, false, 10)
+		assert.NoError(tester, err)
+		assert.Equal(tester, 2, stats.PacketCount)
+	}
+
+	reused := newTestJob(1004)
+	size, err = store.Reuse(reused, first)
+	if assert.NoError(tester, err) {
+		assert.Greater(tester, size, int64(0))
+		stats, _ := store.Statistics(reused, false, 10)
+		assert.Equal(tester, 1, stats.PacketCount)
+	}
+
+	_, err = store.Reuse(newTestJob(1005), newTestJob(9999))
+	assert.Error(tester, err)
+}
+
+func TestPackets(tester *testing.T) {
+	store := newTestStore(tester, module.ModuleConfig{
+		"streamCompression":   true,
+		"streamEncryptionKey": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+	})
+	job := newTestJob(1001)
+	assert.NoError(tester, store.Save(job, bytes.NewReader(buildTcpPcap(tester, "hello"))))
+
+	packets := store.Packets(job, 0, 10, false)
+	if assert.Len(tester, packets, 1) {
+		assert.Equal(tester, "10.0.0.1", packets[0].SrcIp)
+		assert.Equal(tester, 80, packets[0].DstPort)
+	}
+	assert.Empty(tester, store.Packets(job, 1, 10, false))
+	assert.Empty(tester, store.Packets(newTestJob(9999), 0, 10, false))
+
+	// The stream is parsed as it is decoded, without a plain copy on disk
+	files, _ := os.ReadDir(filepath.Dir(store.Filename(job)))
+	assert.Len(tester, files, 1)
+}
+
+func TestTcpStream(tester *testing.T) {
+	store := newTestStore(tester, module.ModuleConfig{"streamCompression": true})
+	job := newTestJob(1001)
+	assert.NoError(tester, store.Save(job, bytes.NewReader(buildTcpPcap(tester, "hello"))))
+
+	stream, err := store.TcpStream(job, 0, false, 0)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, job.Id, stream.JobId)
+		assert.Equal(tester, 1, stream.Count)
+		assert.Len(tester, stream.Chunks, 1)
+		assert.Equal(tester, "hello", string(stream.Chunks[0].Bytes))
+	}
+
+	_, err = store.TcpStream(job, 1, false, 0)
+	assert.EqualError(tester, err, "TCP stream not found")
+
+	_, err = store.TcpStream(newTestJob(9999), 0, false, 0)
+	assert.Error(tester, err)
+}
+
+func TestCarvedFiles(tester *testing.T) {
+	store := newTestStore(tester, module.ModuleConfig{})
+	job := newTestJob(1001)
+	assert.NoError(tester, store.Save(job, bytes.NewReader(buildTcpPcap(tester, "POST /up.bin HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc"))))
+
+	files, err := store.CarvedFiles(job, false, 0, func(index int) bool { return true })
+	if assert.NoError(tester, err) && assert.Len(tester, files, 1) {
+		assert.Equal(tester, job.Id, files[0].JobId)
+		assert.Equal(tester, "up.bin", files[0].Name)
+		assert.Equal(tester, "abc", string(files[0].Bytes))
+	}
+}
+
+func TestStatistics(tester *testing.T) {
+	store := newTestStore(tester, module.ModuleConfig{})
+	job := newTestJob(1001)
+	assert.NoError(tester, store.Save(job, bytes.NewReader(buildTcpPcap(tester, "hello"))))
+
+	stats, err := store.Statistics(job, false, 10)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, job.Id, stats.JobId)
+		assert.Equal(tester, 1, stats.PacketCount)
+		if assert.Len(tester, stats.Conversations, 1) {
+			assert.Equal(tester, "10.0.0.1", stats.Conversations[0].SrcIp)
+			assert.Equal(tester, 80, stats.Conversations[0].DstPort)
+		}
+	}
+}
+
+func TestFingerprints(tester *testing.T) {
+	store := newTestStore(tester, module.ModuleConfig{})
+	job := newTestJob(1001)
+	assert.NoError(tester, store.Save(job, bytes.NewReader(buildTcpPcap(tester, "hello"))))
+
+	summary, err := store.Fingerprints(job, false)
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, job.Id, summary.JobId)
+		assert.Empty(tester, summary.Fingerprints)
+	}
+}
+
+func TestOpenPcapNg(tester *testing.T) {
+	store := newTestStore(tester, module.ModuleConfig{})
+	job := newTestJob(1001)
+	job.UserId = MY_USER_ID
+	assert.NoError(tester, store.Save(job, bytes.NewReader(buildTcpPcap(tester, "hello"))))
+
+	ctx := context.WithValue(context.Background(), web.ContextKeyRequestor, &model.User{Id: MY_USER_ID})
+	reader, _, length, err := store.Open(ctx, job, false, packet.PcapFormatPcapNg)
+	if assert.NoError(tester, err) {
+		defer reader.Close()
+		assert.Equal(tester, int64(-1), length)
+		ngReader, err := pcapgo.NewNgReader(reader, pcapgo.DefaultNgReaderOptions)
+		if assert.NoError(tester, err) {
+			comment := ngReader.SectionInfo().Comment
+			assert.Contains(tester, comment, fmt.Sprintf("Job ID: %d", job.Id))
+			assert.Contains(tester, comment, "Requested By: "+MY_USER_ID)
+			assert.Contains(tester, comment, "Exported By: "+MY_USER_ID)
+			data, _, err := ngReader.ReadPacketData()
+			assert.NoError(tester, err)
+			assert.Contains(tester, string(data), "hello")
+		}
+	}
+}
+
+func TestRequestorName(tester *testing.T) {
+	assert.Equal(tester, "", requestorName(context.Background()))
+	ctx := context.WithValue(context.Background(), web.ContextKeyRequestor, &model.User{Id: "id"})
+	assert.Equal(tester, "id", requestorName(ctx))
+	ctx = context.WithValue(context.Background(), web.ContextKeyRequestor, &model.User{Id: "id", Email: "a@b.c"})
+	assert.Equal(tester, "a@b.c", requestorName(ctx))
+}
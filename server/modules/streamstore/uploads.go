@@ -0,0 +1,53 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package streamstore
+
+import (
+	"io"
+
+	"github.com/apex/log"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+)
+
+const UPLOAD_FILE_EXTENSION = ".part"
+
+func (store *StreamStore) UploadFilename(job *model.Job) string {
+	return store.Filename(job) + UPLOAD_FILE_EXTENSION
+}
+
+// Upload returns how much of the job's chunked stream upload has been received, so that
+// an interrupted upload can resume from there.
+func (store *StreamStore) Upload(job *model.Job) *model.StreamUpload {
+	return model.NewStreamUpload(job.Id, store.codec.FileChunksSize(store.UploadFilename(job)))
+}
+
+// AppendChunk adds the next chunk to the job's stream upload. A chunk at offset zero
+// starts a new upload. On error, the returned upload reflects the data retained.
+func (store *StreamStore) AppendChunk(job *model.Job, offset int64, checksum string, reader io.Reader) (*model.StreamUpload, error) {
+	size, err := store.codec.AppendFileChunk(store.UploadFilename(job), offset, checksum, reader)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"jobId":  job.Id,
+			"offset": offset,
+		}).Warn("Rejected packet stream chunk")
+	}
+	return model.NewStreamUpload(job.Id, size), err
+}
+
+// CompleteUpload verifies the uploaded chunks add up to the expected stream and then
+// atomically replaces the job's stream with the assembled upload.
+func (store *StreamStore) CompleteUpload(job *model.Job, size int64, checksum string) error {
+	err := store.codec.CompleteFileChunks(store.UploadFilename(job), store.Filename(job), size, checksum)
+	if err != nil {
+		log.WithError(err).WithField("jobId", job.Id).Error("Failed to assemble packet stream upload")
+	} else {
+		log.WithFields(log.Fields{
+			"bytes": size,
+			"jobId": job.Id,
+		}).Info("Saved packet stream from chunked upload")
+	}
+	return err
+}
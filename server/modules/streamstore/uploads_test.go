@@ -0,0 +1,75 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package streamstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/security-onion-solutions/securityonion-soc/module"
+	"github.com/security-onion-solutions/securityonion-soc/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpload(tester *testing.T) {
+	store := newTestStore(tester, module.ModuleConfig{})
+	job := newTestJob(1001)
+
+	assert.Equal(tester, int64(0), store.Upload(job).Offset)
+
+	upload, err := store.AppendChunk(job, 0, util.Checksum([]byte("pc")), strings.NewReader("pc"))
+	assert.NoError(tester, err)
+	assert.Equal(tester, int64(2), upload.Offset)
+
+	upload, err = store.AppendChunk(job, 1, util.Checksum([]byte("ap")), strings.NewReader("ap"))
+	assert.Error(tester, err)
+	assert.Equal(tester, int64(2), upload.Offset)
+
+	upload, err = store.AppendChunk(job, 2, util.Checksum([]byte("ap")), strings.NewReader("ap"))
+	assert.NoError(tester, err)
+	assert.Equal(tester, int64(4), upload.Offset)
+	assert.Equal(tester, int64(4), store.Upload(job).Offset)
+
+	// Nothing is visible until the upload is complete
+	_, err = os.Stat(store.Filename(job))
+	assert.True(tester, os.IsNotExist(err))
+
+	assert.Error(tester, store.CompleteUpload(job, 4, util.Checksum([]byte("nope"))))
+	assert.NoError(tester, store.CompleteUpload(job, 4, util.Checksum([]byte("pcap"))))
+	content, err := os.ReadFile(store.Filename(job))
+	assert.NoError(tester, err)
+	assert.Equal(tester, "pcap", string(content))
+}
+
+func TestEncodedUpload(tester *testing.T) {
+	store := newTestStore(tester, module.ModuleConfig{
+		"streamCompression":   true,
+		"streamEncryptionKey": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+	})
+	job := newTestJob(1001)
+
+	_, err := store.AppendChunk(job, 0, util.Checksum([]byte("data")), strings.NewReader("data"))
+	assert.NoError(tester, err)
+	assert.Equal(tester, int64(4), store.Upload(job).Offset)
+	partial, _ := os.ReadFile(store.UploadFilename(job))
+	assert.NotContains(tester, string(partial), "data")
+
+	assert.NoError(tester, store.CompleteUpload(job, 4, util.Checksum([]byte("data"))))
+	assert.NoError(tester, store.CompleteUpload(job, 4, util.Checksum([]byte("data"))))
+	_, err = os.Stat(store.UploadFilename(job))
+	assert.True(tester, os.IsNotExist(err))
+
+	stored, _ := os.ReadFile(store.Filename(job))
+	assert.NotContains(tester, string(stored), "data")
+	reader, _, _, err := store.Open(context.Background(), job, false, "")
+	if assert.NoError(tester, err) {
+		assert.Equal(tester, "data", readAll(tester, reader))
+	}
+}
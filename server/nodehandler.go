@@ -7,7 +7,10 @@
 package server
 
 import (
+	"context"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/security-onion-solutions/securityonion-soc/model"
 	"github.com/security-onion-solutions/securityonion-soc/web"
@@ -26,10 +29,24 @@ func RegisterNodeRoutes(srv *Server, r chi.Router, prefix string) {
 
 	r.Route(prefix, func(r chi.Router) {
 		r.Post("/", h.postNode)
+		r.Post("/wait", h.postNodeWait)
 	})
 }
 
 func (h *NodeHandler) postNode(w http.ResponseWriter, r *http.Request) {
+	h.processNode(w, r, 0)
+}
+
+// postNodeWait is the long-poll variant of postNode. When no job is available for the
+// node it holds the request open for up to waitMs, capped by the server configuration,
+// and responds as soon as a job is added for the node.
+func (h *NodeHandler) postNodeWait(w http.ResponseWriter, r *http.Request) {
+	waitMs, _ := strconv.Atoi(r.URL.Query().Get("waitMs"))
+	waitMs = min(max(waitMs, 0), h.server.Config.JobWaitMaxMs)
+	h.processNode(w, r, time.Duration(waitMs)*time.Millisecond)
+}
+
+func (h *NodeHandler) processNode(w http.ResponseWriter, r *http.Request, wait time.Duration) {
 	ctx := r.Context()
 
 	node := model.NewNode("")
@@ -40,6 +57,12 @@ func (h *NodeHandler) postNode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = CheckNodeIdentity(ctx, node.Id)
+	if err != nil {
+		web.Respond(w, r, http.StatusForbidden, err)
+		return
+	}
+
 	node, err = h.server.Datastore.UpdateNode(ctx, node)
 	if err != nil {
 		web.Respond(w, r, http.StatusInternalServerError, err)
@@ -50,7 +73,34 @@ func (h *NodeHandler) postNode(w http.ResponseWriter, r *http.Request) {
 		h.server.Metrics.UpdateNodeMetrics(ctx, node)
 	}
 	h.server.Host.Broadcast("node", "nodes", node)
-	job := h.server.Datastore.GetNextJob(ctx, node.Id)
+	job := h.waitForNextJob(ctx, node.Id, wait)
 
 	web.Respond(w, r, http.StatusOK, job)
 }
+
+func (h *NodeHandler) waitForNextJob(ctx context.Context, nodeId string, wait time.Duration) *model.Job {
+	if wait <= 0 {
+		return h.server.Datastore.GetNextJob(ctx, nodeId)
+	}
+
+	// Subscribe before looking for a job so that a job added in between isn't missed
+	notification, release := h.server.JobNotifier.Subscribe(nodeId)
+	defer release()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		job := h.server.Datastore.GetNextJob(ctx, nodeId)
+		if job != nil {
+			return job
+		}
+		select {
+		case <-notification:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
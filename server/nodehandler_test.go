@@ -0,0 +1,140 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	"github.com/stretchr/testify/assert"
+)
+
+type nodeTestDatastore struct {
+	*FakeDatastore
+	nextJob *model.Job
+	lock    sync.Mutex
+}
+
+func (ds *nodeTestDatastore) UpdateNode(ctx context.Context, node *model.Node) (*model.Node, error) {
+	return node, nil
+}
+
+func (ds *nodeTestDatastore) GetNextJob(ctx context.Context, nodeId string) *model.Job {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	job := ds.nextJob
+	ds.nextJob = nil
+	return job
+}
+
+func (ds *nodeTestDatastore) setNextJob(job *model.Job) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.nextJob = job
+}
+
+func newNodeTestServer(waitMaxMs int) (*Server, *nodeTestDatastore) {
+	ds := &nodeTestDatastore{FakeDatastore: NewFakeDatastore()}
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+	srv.Config.JobWaitMaxMs = waitMaxMs
+	return srv, ds
+}
+
+func sendNodeRequest(srv *Server, url string) *httptest.ResponseRecorder {
+	return sendNodeRequestAs(srv, url, "")
+}
+
+func sendNodeRequestAs(srv *Server, url string, identity string) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	RegisterNodeRoutes(srv, r, "/api/node")
+
+	ctx := context.WithValue(context.Background(), web.ContextKeyRequestStart, time.Now())
+	if identity != "" {
+		ctx = context.WithValue(ctx, web.ContextKeyRequestorNodeId, identity)
+	}
+	request := httptest.NewRequest("POST", url, strings.NewReader(`{"id":"Sensor1"}`))
+	request = request.WithContext(ctx)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	return w
+}
+
+func TestPostNode(tester *testing.T) {
+	srv, ds := newNodeTestServer(60000)
+
+	w := sendNodeRequest(srv, "/api/node")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.Empty(tester, w.Body.String())
+
+	ds.setNextJob(&model.Job{Id: 12})
+	w = sendNodeRequest(srv, "/api/node")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.Contains(tester, w.Body.String(), `"id":12`)
+}
+
+func TestPostNodeWaitForAddedJob(tester *testing.T) {
+	srv, ds := newNodeTestServer(60000)
+
+	start := time.Now()
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- sendNodeRequest(srv, "/api/node/wait?waitMs=60000")
+	}()
+
+	assert.Eventually(tester, func() bool { return srv.JobNotifier.Waiting("sensor1") == 1 }, time.Second, time.Millisecond)
+	ds.setNextJob(&model.Job{Id: 34})
+	srv.JobNotifier.Notify("sensor1")
+
+	w := <-done
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.Contains(tester, w.Body.String(), `"id":34`)
+	assert.Less(tester, time.Since(start), 10*time.Second)
+	assert.Equal(tester, 0, srv.JobNotifier.Waiting("sensor1"))
+}
+
+func TestPostNodeWaitTimeout(tester *testing.T) {
+	srv, _ := newNodeTestServer(20)
+
+	// The requested wait is capped by the server
+	start := time.Now()
+	w := sendNodeRequest(srv, "/api/node/wait?waitMs=60000")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.Empty(tester, w.Body.String())
+	assert.GreaterOrEqual(tester, time.Since(start), 20*time.Millisecond)
+	assert.Less(tester, time.Since(start), 10*time.Second)
+
+	// Notifications for other nodes don't end the wait early
+	srv.Config.JobWaitMaxMs = 60000
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- sendNodeRequest(srv, "/api/node/wait?waitMs=50")
+	}()
+	assert.Eventually(tester, func() bool { return srv.JobNotifier.Waiting("sensor1") == 1 }, time.Second, time.Millisecond)
+	srv.JobNotifier.Notify("sensor2")
+	w = <-done
+	assert.Empty(tester, w.Body.String())
+}
+
+func TestPostNodeIdentity(tester *testing.T) {
+	srv, ds := newNodeTestServer(60000)
+	ds.setNextJob(&model.Job{Id: 12})
+
+	w := sendNodeRequestAs(srv, "/api/node", "sensor2")
+	assert.Equal(tester, http.StatusForbidden, w.Code)
+
+	w = sendNodeRequestAs(srv, "/api/node", "sensor1")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.Contains(tester, w.Body.String(), `"id":12`)
+}
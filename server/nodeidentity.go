@@ -0,0 +1,49 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+)
+
+// CheckNodeIdentity ensures that a requestor authenticated as a specific node, such as via a
+// node certificate, only reports on behalf of that same node. Requestors that were not
+// authenticated as a node are not restricted.
+func CheckNodeIdentity(ctx context.Context, nodeId string) error {
+	identity, ok := ctx.Value(web.ContextKeyRequestorNodeId).(string)
+	if !ok || identity == "" {
+		return nil
+	}
+
+	if !strings.EqualFold(identity, nodeId) {
+		log.WithFields(log.Fields{
+			"identity":  identity,
+			"nodeId":    nodeId,
+			"requestId": ctx.Value(web.ContextKeyRequestId),
+		}).Warn("Node identity does not match the reported node")
+		return errors.New("Node identity does not match the reported node")
+	}
+	return nil
+}
+
+// CheckJobNodeIdentity ensures that a requestor authenticated as a specific node only acts on
+// jobs assigned to that node. Unknown jobs are left for the caller to report.
+func (server *Server) CheckJobNodeIdentity(ctx context.Context, jobId int) error {
+	if identity, ok := ctx.Value(web.ContextKeyRequestorNodeId).(string); !ok || identity == "" {
+		return nil
+	}
+
+	job := server.Datastore.GetJob(ctx, jobId)
+	if job == nil {
+		return nil
+	}
+	return CheckNodeIdentity(ctx, job.GetNodeId())
+}
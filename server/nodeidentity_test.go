@@ -0,0 +1,56 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	"github.com/stretchr/testify/assert"
+)
+
+type identityTestDatastore struct {
+	*FakeDatastore
+	job *model.Job
+}
+
+func (ds *identityTestDatastore) GetJob(ctx context.Context, jobId int) *model.Job {
+	if ds.job != nil && ds.job.Id == jobId {
+		return ds.job
+	}
+	return nil
+}
+
+func TestCheckNodeIdentity(tester *testing.T) {
+	ctx := context.Background()
+	assert.NoError(tester, CheckNodeIdentity(ctx, "sensor1"))
+
+	ctx = context.WithValue(ctx, web.ContextKeyRequestorNodeId, "sensor1")
+	assert.NoError(tester, CheckNodeIdentity(ctx, "sensor1"))
+	assert.NoError(tester, CheckNodeIdentity(ctx, "Sensor1"))
+	assert.Error(tester, CheckNodeIdentity(ctx, "sensor2"))
+	assert.Error(tester, CheckNodeIdentity(ctx, ""))
+}
+
+func TestCheckJobNodeIdentity(tester *testing.T) {
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = &identityTestDatastore{
+		FakeDatastore: NewFakeDatastore(),
+		job:           &model.Job{Id: 12, NodeId: "Sensor1"},
+	}
+
+	ctx := context.Background()
+	assert.NoError(tester, srv.CheckJobNodeIdentity(ctx, 12))
+
+	ctx = context.WithValue(ctx, web.ContextKeyRequestorNodeId, "sensor1")
+	assert.NoError(tester, srv.CheckJobNodeIdentity(ctx, 12))
+	assert.NoError(tester, srv.CheckJobNodeIdentity(ctx, 13))
+
+	ctx = context.WithValue(ctx, web.ContextKeyRequestorNodeId, "sensor2")
+	assert.Error(tester, srv.CheckJobNodeIdentity(ctx, 12))
+}
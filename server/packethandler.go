@@ -7,10 +7,19 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 
+	"github.com/apex/log"
 	"github.com/go-chi/chi/v5"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/packet"
 	"github.com/security-onion-solutions/securityonion-soc/web"
 )
 
@@ -26,6 +35,12 @@ func RegisterPacketRoutes(srv *Server, r chi.Router, prefix string) {
 	r.Route(prefix, func(r chi.Router) {
 		r.Get("/", h.getPackets)
 		r.Get("/{jobId}", h.getPackets)
+		r.Get("/{jobId}/stream", h.getTcpStream)
+		r.Get("/{jobId}/files", h.getCarvedFiles)
+		r.Get("/{jobId}/statistics", h.getPacketStatistics)
+		r.Get("/{jobId}/fingerprints", h.getFingerprints)
+
+		r.Post("/{jobId}/files", h.postCarvedFiles)
 	})
 }
 
@@ -70,3 +85,233 @@ func (h *PacketHandler) getPackets(w http.ResponseWriter, r *http.Request) {
 
 	web.Respond(w, r, http.StatusOK, packets)
 }
+
+// getTcpStream returns the reassembled client and server conversation of one of the
+// job's TCP connections, rendered as ascii, hex or raw (base64) data.
+func (h *PacketHandler) getTcpStream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.ParseInt(chi.URLParam(r, "jobId"), 10, 32)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	unwrap, err := strconv.ParseBool(r.URL.Query().Get("unwrap"))
+	if err != nil {
+		unwrap = false
+	}
+
+	index, err := strconv.ParseInt(r.URL.Query().Get("index"), 10, 32)
+	if index <= 0 || err != nil {
+		index = 0
+	}
+
+	stream, err := h.server.Datastore.GetTcpStream(ctx, int(jobId), int(index), unwrap, h.server.Config.MaxTcpStreamBytes)
+	if err != nil {
+		web.Respond(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	err = packet.FormatTcpStream(stream, r.URL.Query().Get("format"))
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, stream)
+}
+
+// getCarvedFiles lists the files transferred over the job's TCP connections, without
+// their content.
+func (h *PacketHandler) getCarvedFiles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.ParseInt(chi.URLParam(r, "jobId"), 10, 32)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	unwrap, err := strconv.ParseBool(r.URL.Query().Get("unwrap"))
+	if err != nil {
+		unwrap = false
+	}
+
+	files, err := h.server.Datastore.GetCarvedFiles(ctx, int(jobId), unwrap, h.server.Config.MaxCarveBytes, nil)
+	if err != nil {
+		web.Respond(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, files)
+}
+
+// getPacketStatistics summarizes the top talkers, conversations, protocol hierarchy and
+// packet rate of the job's packets.
+func (h *PacketHandler) getPacketStatistics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.ParseInt(chi.URLParam(r, "jobId"), 10, 32)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	unwrap, err := strconv.ParseBool(r.URL.Query().Get("unwrap"))
+	if err != nil {
+		unwrap = false
+	}
+
+	limit, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 32)
+	if limit <= 0 || err != nil {
+		limit = packet.DEFAULT_STATISTICS_LIMIT
+	}
+
+	stats, err := h.server.Datastore.GetPacketStatistics(ctx, int(jobId), unwrap, int(limit))
+	if err != nil {
+		web.Respond(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, stats)
+}
+
+// getFingerprints summarizes the JA3, JA3S, JA4 and HASSH fingerprints of the job's TLS
+// and SSH handshakes, with queries to find the same fingerprints in events.
+func (h *PacketHandler) getFingerprints(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.ParseInt(chi.URLParam(r, "jobId"), 10, 32)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	unwrap, err := strconv.ParseBool(r.URL.Query().Get("unwrap"))
+	if err != nil {
+		unwrap = false
+	}
+
+	summary, err := h.server.Datastore.GetFingerprints(ctx, int(jobId), unwrap)
+	if err != nil {
+		web.Respond(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, summary)
+}
+
+// postCarvedFiles attaches the selected carved files of a job to a case, storing each
+// file as an artifact stream.
+func (h *PacketHandler) postCarvedFiles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if h.server.Casestore == nil {
+		web.Respond(w, r, http.StatusMethodNotAllowed, errors.New("ERROR_CASE_MODULE_NOT_ENABLED"))
+		return
+	}
+
+	jobId, err := strconv.ParseInt(chi.URLParam(r, "jobId"), 10, 32)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	request := &model.CarveRequest{}
+	err = web.ReadJson(r, request)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if strings.TrimSpace(request.CaseId) == "" {
+		web.Respond(w, r, http.StatusBadRequest, errors.New("A case is required to attach carved files"))
+		return
+	}
+
+	files, err := h.server.Datastore.GetCarvedFiles(ctx, int(jobId), request.Unwrap, h.server.Config.MaxCarveBytes, retainedCarvedFiles(request.Indexes))
+	if err != nil {
+		web.Respond(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	selected, err := selectCarvedFiles(files, request.Indexes)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	artifacts := make([]*model.Artifact, 0, len(selected))
+	for _, file := range selected {
+		artifact, err := h.attachCarvedFile(ctx, request, file)
+		if err != nil {
+			web.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	web.Respond(w, r, http.StatusOK, artifacts)
+}
+
+// retainedCarvedFiles selects the carved files whose content is needed, all of them when
+// no indexes are given.
+func retainedCarvedFiles(indexes []int) func(index int) bool {
+	return func(index int) bool {
+		return len(indexes) == 0 || slices.Contains(indexes, index)
+	}
+}
+
+func selectCarvedFiles(files []*model.CarvedFile, indexes []int) ([]*model.CarvedFile, error) {
+	if len(indexes) == 0 {
+		return files, nil
+	}
+	selected := make([]*model.CarvedFile, 0, len(indexes))
+	for _, index := range indexes {
+		if index < 0 || index >= len(files) {
+			return nil, fmt.Errorf("Carved file %d not found", index)
+		}
+		selected = append(selected, files[index])
+	}
+	return selected, nil
+}
+
+func (h *PacketHandler) attachCarvedFile(ctx context.Context, request *model.CarveRequest, file *model.CarvedFile) (*model.Artifact, error) {
+	artifact := model.NewArtifact()
+	artifact.CaseId = request.CaseId
+	artifact.GroupType = "evidence"
+	artifact.ArtifactType = "file"
+	artifact.Value = file.Name
+	artifact.Tlp = request.Tlp
+	artifact.Tags = request.Tags
+	artifact.Description = request.Description
+	if artifact.Description == "" {
+		artifact.Description = fmt.Sprintf("Carved from %s traffic in job %d (%s:%d -> %s:%d)",
+			file.Protocol, file.JobId, file.ClientIp, file.ClientPort, file.ServerIp, file.ServerPort)
+		if file.Incomplete {
+			artifact.Description += "; the file is incomplete"
+		}
+	}
+
+	artifactStream := model.NewArtifactStream()
+	var err error
+	artifact.StreamLen, artifact.MimeType, artifact.Md5, artifact.Sha1, artifact.Sha256, err = artifactStream.Write(bytes.NewReader(file.Bytes))
+	if err != nil {
+		return nil, err
+	}
+
+	artifact.StreamId, err = h.server.Casestore.CreateArtifactStream(ctx, artifactStream)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"jobId":     file.JobId,
+		"caseId":    request.CaseId,
+		"filename":  file.Name,
+		"streamLen": artifact.StreamLen,
+		"sha256":    artifact.Sha256,
+	}).Info("Attaching carved file to case")
+
+	return h.server.Casestore.CreateArtifact(ctx, artifact)
+}
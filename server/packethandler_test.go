@@ -0,0 +1,234 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func sendPacketRequest(srv *Server, url string) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	RegisterPacketRoutes(srv, r, "/api/packets")
+
+	request := httptest.NewRequest("GET", url, nil)
+	request = request.WithContext(context.WithValue(context.Background(), web.ContextKeyRequestStart, time.Now()))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	return w
+}
+
+func TestGetTcpStream(tester *testing.T) {
+	ds := NewFakeDatastore()
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	w := sendPacketRequest(srv, "/api/packets/1001/stream")
+	assert.Equal(tester, http.StatusNotFound, w.Code)
+
+	ds.tcpStream = model.NewTcpStream(0)
+	ds.tcpStream.Chunks = append(ds.tcpStream.Chunks, &model.TcpStreamChunk{
+		Direction: model.TcpStreamDirectionClient,
+		Length:    4,
+		Bytes:     []byte("GET\x00"),
+	})
+
+	w = sendPacketRequest(srv, "/api/packets/1001/stream?index=0&unwrap=true")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	stream := &model.TcpStream{}
+	assert.NoError(tester, json.Unmarshal(w.Body.Bytes(), stream))
+	assert.Equal(tester, model.TcpStreamFormatAscii, stream.Format)
+	assert.Equal(tester, "GET.", stream.Chunks[0].Data)
+
+	w = sendPacketRequest(srv, "/api/packets/1001/stream?format=raw")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.NoError(tester, json.Unmarshal(w.Body.Bytes(), stream))
+	assert.Equal(tester, "R0VUAA==", stream.Chunks[0].Data)
+
+	w = sendPacketRequest(srv, "/api/packets/1001/stream?format=pdf")
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+
+	w = sendPacketRequest(srv, "/api/packets/abc/stream")
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+}
+
+type carveCasestore struct {
+	Casestore
+	streams   []*model.ArtifactStream
+	artifacts []*model.Artifact
+}
+
+func (store *carveCasestore) CreateArtifactStream(ctx context.Context, artifactstream *model.ArtifactStream) (string, error) {
+	store.streams = append(store.streams, artifactstream)
+	return "stream-" + strconv.Itoa(len(store.streams)), nil
+}
+
+func (store *carveCasestore) CreateArtifact(ctx context.Context, artifact *model.Artifact) (*model.Artifact, error) {
+	store.artifacts = append(store.artifacts, artifact)
+	return artifact, nil
+}
+
+func sendPacketPost(srv *Server, url string, body string) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	RegisterPacketRoutes(srv, r, "/api/packets")
+
+	request := httptest.NewRequest("POST", url, strings.NewReader(body))
+	request = request.WithContext(context.WithValue(context.Background(), web.ContextKeyRequestStart, time.Now()))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	return w
+}
+
+func newCarvedFiles() []*model.CarvedFile {
+	return []*model.CarvedFile{
+		{JobId: 1001, Index: 0, Protocol: model.CarvedFileProtocolHttp, Name: "a.txt", Size: 5, Bytes: []byte("hello"),
+			ClientIp: "10.0.0.1", ClientPort: 40000, ServerIp: "10.0.0.2", ServerPort: 80},
+		{JobId: 1001, Index: 1, Protocol: model.CarvedFileProtocolSmb2, Name: "b.bin", Size: 3, Bytes: []byte{0, 1, 2}, Incomplete: true},
+	}
+}
+
+func TestGetCarvedFiles(tester *testing.T) {
+	ds := NewFakeDatastore()
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	w := sendPacketRequest(srv, "/api/packets/1001/files")
+	assert.Equal(tester, http.StatusNotFound, w.Code)
+
+	ds.carved = newCarvedFiles()
+	w = sendPacketRequest(srv, "/api/packets/1001/files?unwrap=true")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.NotContains(tester, w.Body.String(), "aGVsbG8") // content is not listed
+	files := make([]*model.CarvedFile, 0)
+	assert.NoError(tester, json.Unmarshal(w.Body.Bytes(), &files))
+	if assert.Len(tester, files, 2) {
+		assert.Equal(tester, "a.txt", files[0].Name)
+		assert.True(tester, files[1].Incomplete)
+	}
+
+	w = sendPacketRequest(srv, "/api/packets/abc/files")
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+}
+
+func TestGetPacketStatistics(tester *testing.T) {
+	ds := NewFakeDatastore()
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	w := sendPacketRequest(srv, "/api/packets/1001/statistics")
+	assert.Equal(tester, http.StatusNotFound, w.Code)
+
+	ds.statistics = &model.PacketStatistics{
+		JobId:       1001,
+		PacketCount: 2,
+		Protocols:   &model.PacketProtocolNode{Protocol: "Frame", Packets: 2},
+	}
+	w = sendPacketRequest(srv, "/api/packets/1001/statistics?unwrap=true&limit=5")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	stats := &model.PacketStatistics{}
+	assert.NoError(tester, json.Unmarshal(w.Body.Bytes(), stats))
+	assert.Equal(tester, 1001, stats.JobId)
+	assert.Equal(tester, 2, stats.PacketCount)
+	assert.Equal(tester, "Frame", stats.Protocols.Protocol)
+
+	w = sendPacketRequest(srv, "/api/packets/abc/statistics")
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+}
+
+func TestGetFingerprints(tester *testing.T) {
+	ds := NewFakeDatastore()
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	w := sendPacketRequest(srv, "/api/packets/1001/fingerprints")
+	assert.Equal(tester, http.StatusNotFound, w.Code)
+
+	ds.fingerprints = &model.FingerprintSummary{
+		JobId: 1001,
+		Fingerprints: []*model.FingerprintSummaryEntry{
+			{Type: model.FingerprintTypeJa3, Value: "abc", Count: 2, Query: `tls.client.ja3:"abc"`},
+		},
+	}
+	w = sendPacketRequest(srv, "/api/packets/1001/fingerprints?unwrap=true")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	summary := &model.FingerprintSummary{}
+	assert.NoError(tester, json.Unmarshal(w.Body.Bytes(), summary))
+	if assert.Len(tester, summary.Fingerprints, 1) {
+		assert.Equal(tester, "abc", summary.Fingerprints[0].Value)
+		assert.Equal(tester, `tls.client.ja3:"abc"`, summary.Fingerprints[0].Query)
+	}
+
+	w = sendPacketRequest(srv, "/api/packets/abc/fingerprints")
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+}
+
+func TestRetainedCarvedFiles(tester *testing.T) {
+	all := retainedCarvedFiles(nil)
+	assert.True(tester, all(0))
+	assert.True(tester, all(5))
+
+	selected := retainedCarvedFiles([]int{1, 3})
+	assert.False(tester, selected(0))
+	assert.True(tester, selected(1))
+	assert.True(tester, selected(3))
+}
+
+func TestPostCarvedFiles(tester *testing.T) {
+	ds := NewFakeDatastore()
+	ds.carved = newCarvedFiles()
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	w := sendPacketPost(srv, "/api/packets/1001/files", `{"caseId":"case1"}`)
+	assert.Equal(tester, http.StatusMethodNotAllowed, w.Code)
+
+	store := &carveCasestore{}
+	srv.Casestore = store
+
+	w = sendPacketPost(srv, "/api/packets/1001/files", `{"caseId":" "}`)
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+
+	w = sendPacketPost(srv, "/api/packets/1001/files", `{"caseId":"case1","indexes":[2]}`)
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+	assert.Empty(tester, store.artifacts)
+
+	w = sendPacketPost(srv, "/api/packets/1001/files", `{"caseId":"case1","indexes":[0],"tlp":"amber","tags":["carved"]}`)
+	assert.Equal(tester, http.StatusOK, w.Code)
+	if assert.Len(tester, store.artifacts, 1) {
+		artifact := store.artifacts[0]
+		assert.Equal(tester, "case1", artifact.CaseId)
+		assert.Equal(tester, "evidence", artifact.GroupType)
+		assert.Equal(tester, "file", artifact.ArtifactType)
+		assert.Equal(tester, "a.txt", artifact.Value)
+		assert.Equal(tester, "stream-1", artifact.StreamId)
+		assert.Equal(tester, 5, artifact.StreamLen)
+		assert.Equal(tester, "5d41402abc4b2a76b9719d911017c592", artifact.Md5)
+		assert.Equal(tester, "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", artifact.Sha1)
+		assert.Equal(tester, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", artifact.Sha256)
+		assert.Equal(tester, "amber", artifact.Tlp)
+		assert.Equal(tester, []string{"carved"}, artifact.Tags)
+		assert.Equal(tester, "Carved from http traffic in job 1001 (10.0.0.1:40000 -> 10.0.0.2:80)", artifact.Description)
+	}
+
+	w = sendPacketPost(srv, "/api/packets/1001/files", `{"caseId":"case1"}`)
+	assert.Equal(tester, http.StatusOK, w.Code)
+	if assert.Len(tester, store.artifacts, 3) {
+		assert.Equal(tester, "b.bin", store.artifacts[2].Value)
+		assert.Contains(tester, store.artifacts[2].Description, "incomplete")
+		assert.Len(tester, store.streams, 3)
+	}
+}
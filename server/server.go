@@ -42,6 +42,9 @@ type Server struct {
 	Agent            *model.User
 	Context          context.Context
 	DetectionEngines map[model.EngineName]DetectionEngine
+	scheduleRunner   *JobScheduleRunner
+	leaseMonitor     *JobLeaseMonitor
+	JobNotifier      *JobNotifier
 }
 
 func NewServer(cfg *config.ServerConfig, version string) *Server {
@@ -50,6 +53,7 @@ func NewServer(cfg *config.ServerConfig, version string) *Server {
 		Host:             web.NewHost(cfg.BindAddress, cfg.HtmlDir, cfg.IdleConnectionTimeoutMs, version, cfg.SrvKeyBytes, AGENT_ID),
 		stoppedChan:      make(chan bool, 1),
 		DetectionEngines: map[model.EngineName]DetectionEngine{},
+		JobNotifier:      NewJobNotifier(),
 	}
 	server.initContext()
 
@@ -99,6 +103,12 @@ func (server *Server) Start() {
 
 		server.Host.RegisterRouter("/api/", r)
 
+		server.scheduleRunner = NewJobScheduleRunner(server, server.Config.JobScheduleIntervalMs)
+		server.scheduleRunner.Start()
+
+		server.leaseMonitor = NewJobLeaseMonitor(server, server.Config.JobLeaseCheckIntervalMs)
+		server.leaseMonitor.Start()
+
 		server.Host.Start()
 	}
 
@@ -106,6 +116,12 @@ func (server *Server) Start() {
 }
 
 func (server *Server) Stop() {
+	if server.scheduleRunner != nil {
+		server.scheduleRunner.Stop()
+	}
+	if server.leaseMonitor != nil {
+		server.leaseMonitor.Stop()
+	}
 	if server.Host != nil {
 		log.Info("Stopping server")
 		server.Host.Stop()
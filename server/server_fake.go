@@ -10,6 +10,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"time"
 
 	"github.com/security-onion-solutions/securityonion-soc/config"
 	"github.com/security-onion-solutions/securityonion-soc/model"
@@ -58,9 +59,14 @@ func (impl *FakeRolestore) GetRoles(ctx context.Context) []string {
 }
 
 type FakeDatastore struct {
-	nodes   []*model.Node
-	jobs    []*model.Job
-	packets []*model.Packet
+	nodes        []*model.Node
+	jobs         []*model.Job
+	packets      []*model.Packet
+	schedules    []*model.JobSchedule
+	tcpStream    *model.TcpStream
+	statistics   *model.PacketStatistics
+	fingerprints *model.FingerprintSummary
+	carved       []*model.CarvedFile
 }
 
 func NewFakeDatastore() *FakeDatastore {
@@ -79,9 +85,10 @@ func NewFakeDatastore() *FakeDatastore {
 	packets = append(packets, &model.Packet{})
 
 	return &FakeDatastore{
-		nodes:   nodes,
-		jobs:    jobs,
-		packets: packets,
+		nodes:     nodes,
+		jobs:      jobs,
+		packets:   packets,
+		schedules: make([]*model.JobSchedule, 0),
 	}
 }
 
@@ -113,8 +120,8 @@ func (impl *FakeDatastore) GetJob(ctx context.Context, jobId int) *model.Job {
 	return nil
 }
 
-func (impl *FakeDatastore) GetJobs(ctx context.Context, kind string, parameters map[string]interface{}) []*model.Job {
-	return impl.jobs
+func (impl *FakeDatastore) GetJobs(ctx context.Context, query *model.JobQuery) (*model.JobPage, error) {
+	return &model.JobPage{Jobs: impl.jobs, TotalCount: len(impl.jobs)}, nil
 }
 
 func (impl *FakeDatastore) AddJob(ctx context.Context, job *model.Job) error {
@@ -133,18 +140,114 @@ func (impl *FakeDatastore) DeleteJob(ctx context.Context, jobId int) (*model.Job
 	return nil, nil
 }
 
+func (impl *FakeDatastore) CancelJob(ctx context.Context, jobId int) (*model.Job, error) {
+	return nil, nil
+}
+
+func (impl *FakeDatastore) UpdateJobProgress(ctx context.Context, jobId int, progress *model.JobProgress) (*model.Job, error) {
+	return nil, nil
+}
+
+func (impl *FakeDatastore) SetJobPriority(ctx context.Context, jobId int, priority int) (*model.Job, error) {
+	return nil, nil
+}
+
+func (impl *FakeDatastore) RenewJobLease(ctx context.Context, jobId int) (*model.Job, error) {
+	return nil, nil
+}
+
+func (impl *FakeDatastore) ExpireJobLeases(ctx context.Context) ([]*model.Job, error) {
+	return nil, nil
+}
+
 func (impl *FakeDatastore) GetPackets(ctx context.Context, jobId int, offset int, count int, unwrap bool) ([]*model.Packet, error) {
 	return impl.packets, nil
 }
 
+func (impl *FakeDatastore) MergePacketStreams(ctx context.Context, jobId int, sourceJobIds []int) (int, error) {
+	return 0, nil
+}
+
 func (impl *FakeDatastore) SavePacketStream(ctx context.Context, jobId int, reader io.ReadCloser) error {
 	return nil
 }
 
-func (impl *FakeDatastore) GetPacketStream(ctx context.Context, jobId int, unwrap bool) (io.ReadCloser, string, int64, error) {
+func (impl *FakeDatastore) GetPacketStreamUpload(ctx context.Context, jobId int) (*model.StreamUpload, error) {
+	return nil, nil
+}
+
+func (impl *FakeDatastore) AppendPacketStreamChunk(ctx context.Context, jobId int, offset int64, checksum string, reader io.Reader) (*model.StreamUpload, error) {
+	return nil, nil
+}
+
+func (impl *FakeDatastore) CompletePacketStreamUpload(ctx context.Context, jobId int, size int64, checksum string) error {
+	return nil
+}
+
+func (impl *FakeDatastore) GetPacketStream(ctx context.Context, jobId int, unwrap bool, format string) (io.ReadCloser, string, int64, error) {
 	return nil, "", 0, nil
 }
 
+func (impl *FakeDatastore) GetTcpStream(ctx context.Context, jobId int, index int, unwrap bool, maxBytes int) (*model.TcpStream, error) {
+	if impl.tcpStream == nil {
+		return nil, errors.New("TCP stream not found")
+	}
+	return impl.tcpStream, nil
+}
+
+func (impl *FakeDatastore) GetCarvedFiles(ctx context.Context, jobId int, unwrap bool, maxBytes int, retained func(index int) bool) ([]*model.CarvedFile, error) {
+	if impl.carved == nil {
+		return nil, errors.New("Job not found")
+	}
+	files := make([]*model.CarvedFile, 0, len(impl.carved))
+	for _, file := range impl.carved {
+		carved := *file
+		if retained == nil || !retained(file.Index) {
+			carved.Bytes = nil
+		}
+		files = append(files, &carved)
+	}
+	return files, nil
+}
+
+func (impl *FakeDatastore) GetPacketStatistics(ctx context.Context, jobId int, unwrap bool, limit int) (*model.PacketStatistics, error) {
+	if impl.statistics == nil {
+		return nil, errors.New("Job not found")
+	}
+	return impl.statistics, nil
+}
+
+func (impl *FakeDatastore) GetFingerprints(ctx context.Context, jobId int, unwrap bool) (*model.FingerprintSummary, error) {
+	if impl.fingerprints == nil {
+		return nil, errors.New("Job not found")
+	}
+	return impl.fingerprints, nil
+}
+
+func (impl *FakeDatastore) GetJobSchedules(ctx context.Context) []*model.JobSchedule {
+	return impl.schedules
+}
+
+func (impl *FakeDatastore) GetJobSchedule(ctx context.Context, scheduleId string) *model.JobSchedule {
+	return nil
+}
+
+func (impl *FakeDatastore) AddJobSchedule(ctx context.Context, schedule *model.JobSchedule) error {
+	return nil
+}
+
+func (impl *FakeDatastore) UpdateJobSchedule(ctx context.Context, schedule *model.JobSchedule) (*model.JobSchedule, error) {
+	return schedule, nil
+}
+
+func (impl *FakeDatastore) DeleteJobSchedule(ctx context.Context, scheduleId string) (*model.JobSchedule, error) {
+	return nil, nil
+}
+
+func (impl *FakeDatastore) RecordJobScheduleRun(ctx context.Context, scheduleId string, run *model.JobScheduleRun, nextRunTime time.Time) (*model.JobSchedule, error) {
+	return nil, nil
+}
+
 type FakeMetrics struct {
 }
 
@@ -15,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/security-onion-solutions/securityonion-soc/packet"
 	"github.com/security-onion-solutions/securityonion-soc/web"
 
 	"github.com/apex/log"
@@ -38,6 +39,10 @@ func RegisterStreamRoutes(srv *Server, r chi.Router, prefix string) {
 
 		r.Post("/", h.postStream)
 		r.Post("/{jobId}", h.postStream)
+
+		r.Get("/{jobId}/upload", h.getUpload)
+		r.Put("/{jobId}/upload", h.putUploadChunk)
+		r.Post("/{jobId}/upload/complete", h.postUploadComplete)
 	})
 }
 
@@ -61,7 +66,13 @@ func (h *StreamHandler) getStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reader, filename, length, err := h.server.Datastore.GetPacketStream(ctx, int(jobId), unwrap)
+	format := r.URL.Query().Get("format")
+	if format != "" && format != packet.PcapFormatPcap && format != packet.PcapFormatPcapNg {
+		web.Respond(w, r, http.StatusBadRequest, errors.New("Unsupported packet capture format"))
+		return
+	}
+
+	reader, filename, length, err := h.server.Datastore.GetPacketStream(ctx, int(jobId), unwrap, format)
 	if err != nil {
 		web.Respond(w, r, http.StatusNotFound, err)
 		return
@@ -84,7 +95,9 @@ func (h *StreamHandler) getStream(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "vnd.tcpdump.pcap")
-	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
 	w.Header().Set("Content-Transfer-Encoding", "binary")
 
@@ -120,6 +133,12 @@ func (h *StreamHandler) postStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = h.server.CheckJobNodeIdentity(ctx, int(jobId))
+	if err != nil {
+		web.Respond(w, r, http.StatusForbidden, err)
+		return
+	}
+
 	err = h.server.Datastore.SavePacketStream(ctx, int(jobId), r.Body)
 	if err != nil {
 		web.Respond(w, r, http.StatusInternalServerError, err)
@@ -128,3 +147,103 @@ func (h *StreamHandler) postStream(w http.ResponseWriter, r *http.Request) {
 
 	web.Respond(w, r, http.StatusOK, nil)
 }
+
+func (h *StreamHandler) getUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.Atoi(chi.URLParam(r, "jobId"))
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.server.CheckJobNodeIdentity(ctx, jobId)
+	if err != nil {
+		web.Respond(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	upload, err := h.server.Datastore.GetPacketStreamUpload(ctx, jobId)
+	if err != nil {
+		web.Respond(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, upload)
+}
+
+// putUploadChunk appends a chunk of a job's packet stream. The chunk must start at the
+// offset acknowledged by the previous chunk; otherwise the server responds with a
+// conflict and the current upload offset so that the agent can resume from there.
+func (h *StreamHandler) putUploadChunk(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.Atoi(chi.URLParam(r, "jobId"))
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.server.CheckJobNodeIdentity(ctx, jobId)
+	if err != nil {
+		web.Respond(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		web.Respond(w, r, http.StatusBadRequest, errors.New("Invalid chunk offset"))
+		return
+	}
+
+	checksum := r.URL.Query().Get("checksum")
+	if checksum == "" {
+		web.Respond(w, r, http.StatusBadRequest, errors.New("Chunk checksum is required"))
+		return
+	}
+
+	reader := http.MaxBytesReader(w, r.Body, int64(h.server.Config.MaxUploadSizeBytes))
+	upload, err := h.server.Datastore.AppendPacketStreamChunk(ctx, jobId, offset, checksum, reader)
+	if err != nil {
+		if upload == nil {
+			web.Respond(w, r, http.StatusNotFound, err)
+		} else if upload.Offset != offset {
+			web.Respond(w, r, http.StatusConflict, upload)
+		} else {
+			web.Respond(w, r, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, upload)
+}
+
+func (h *StreamHandler) postUploadComplete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	jobId, err := strconv.Atoi(chi.URLParam(r, "jobId"))
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.server.CheckJobNodeIdentity(ctx, jobId)
+	if err != nil {
+		web.Respond(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, errors.New("Invalid upload size"))
+		return
+	}
+
+	err = h.server.Datastore.CompletePacketStreamUpload(ctx, jobId, size, r.URL.Query().Get("checksum"))
+	if err != nil {
+		web.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, nil)
+}
@@ -0,0 +1,112 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/security-onion-solutions/securityonion-soc/model"
+	"github.com/security-onion-solutions/securityonion-soc/web"
+	"github.com/stretchr/testify/assert"
+)
+
+type uploadDatastore struct {
+	*FakeDatastore
+	offset   int64
+	checksum string
+}
+
+func (ds *uploadDatastore) AppendPacketStreamChunk(ctx context.Context, jobId int, offset int64, checksum string, reader io.Reader) (*model.StreamUpload, error) {
+	if jobId != 1001 {
+		return nil, errors.New("Job not found")
+	}
+	if offset != ds.offset {
+		return model.NewStreamUpload(jobId, ds.offset), errors.New("Chunk offset does not match the upload offset")
+	}
+	data, _ := io.ReadAll(reader)
+	if checksum != ds.checksum {
+		return model.NewStreamUpload(jobId, ds.offset), errors.New("Chunk checksum does not match the received data")
+	}
+	ds.offset += int64(len(data))
+	return model.NewStreamUpload(jobId, ds.offset), nil
+}
+
+func sendUploadRequest(srv *Server, method string, url string, body string) *httptest.ResponseRecorder {
+	r := chi.NewRouter()
+	RegisterStreamRoutes(srv, r, "/api/stream")
+
+	request := httptest.NewRequest(method, url, strings.NewReader(body))
+	request = request.WithContext(context.WithValue(context.Background(), web.ContextKeyRequestStart, time.Now()))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	return w
+}
+
+func TestPutUploadChunk(tester *testing.T) {
+	ds := &uploadDatastore{FakeDatastore: NewFakeDatastore(), offset: 4, checksum: "abc"}
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Config.MaxUploadSizeBytes = 1024
+	srv.Datastore = ds
+
+	w := sendUploadRequest(srv, "PUT", "/api/stream/1001/upload?offset=4&checksum=abc", "pcap")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	upload := model.NewStreamUpload(0, 0)
+	assert.NoError(tester, json.Unmarshal(w.Body.Bytes(), upload))
+	assert.Equal(tester, int64(8), upload.Offset)
+
+	// Out of order chunks report where to resume
+	w = sendUploadRequest(srv, "PUT", "/api/stream/1001/upload?offset=4&checksum=abc", "pcap")
+	assert.Equal(tester, http.StatusConflict, w.Code)
+	assert.NoError(tester, json.Unmarshal(w.Body.Bytes(), upload))
+	assert.Equal(tester, int64(8), upload.Offset)
+
+	w = sendUploadRequest(srv, "PUT", "/api/stream/1001/upload?offset=8&checksum=xyz", "pcap")
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+
+	w = sendUploadRequest(srv, "PUT", "/api/stream/1001/upload?offset=8", "pcap")
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+
+	w = sendUploadRequest(srv, "PUT", "/api/stream/1001/upload?offset=-1&checksum=abc", "pcap")
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+
+	w = sendUploadRequest(srv, "PUT", "/api/stream/1002/upload?offset=0&checksum=abc", "pcap")
+	assert.Equal(tester, http.StatusNotFound, w.Code)
+}
+
+type formatDatastore struct {
+	*FakeDatastore
+	format string
+}
+
+func (ds *formatDatastore) GetPacketStream(ctx context.Context, jobId int, unwrap bool, format string) (io.ReadCloser, string, int64, error) {
+	ds.format = format
+	return io.NopCloser(strings.NewReader("pcapng")), "sensoroni_foo_1001.bin", -1, nil
+}
+
+func TestGetStreamFormat(tester *testing.T) {
+	ds := &formatDatastore{FakeDatastore: NewFakeDatastore()}
+	srv := NewFakeAuthorizedServer(nil)
+	srv.Datastore = ds
+
+	w := sendUploadRequest(srv, "GET", "/api/stream/1001?unwrap=false&ext=pcapng&format=pcapng", "")
+	assert.Equal(tester, http.StatusOK, w.Code)
+	assert.Equal(tester, "pcapng", ds.format)
+	assert.Equal(tester, "pcapng", w.Body.String())
+	assert.Empty(tester, w.Header().Get("Content-Length"))
+	assert.Contains(tester, w.Header().Get("Content-Disposition"), `filename="sensoroni_foo_1001.pcapng"`)
+
+	w = sendUploadRequest(srv, "GET", "/api/stream/1001?unwrap=false&format=erf", "")
+	assert.Equal(tester, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,177 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LinkOrCopyFile makes the contents of the source file available at the destination,
+// preferring a hard link and falling back to a full copy when the two paths cannot be
+// linked. Returns the size of the destination file.
+func LinkOrCopyFile(source string, destination string) (int64, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return 0, err
+	}
+
+	os.MkdirAll(filepath.Dir(destination), os.ModePerm)
+	os.Remove(destination)
+	if os.Link(source, destination) == nil {
+		return info.Size(), nil
+	}
+
+	input, err := os.Open(source)
+	if err != nil {
+		return 0, err
+	}
+	defer input.Close()
+
+	output, err := os.Create(destination)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := io.Copy(output, input)
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destination)
+		size = 0
+	}
+	return size, err
+}
+
+// Checksum returns the hex encoded SHA256 digest of the data.
+func Checksum(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// FileChecksum returns the hex encoded SHA256 digest of the file's contents.
+func FileChecksum(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// FileSize returns the size of the file, or zero if the file does not exist.
+func FileSize(filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// AppendFileChunk writes the chunk to the file at the given offset, which must equal
+// the current size of the file, or zero to start the file over. The chunk is verified
+// against its hex encoded SHA256 checksum, and discarded if it does not match or is only
+// partially received. Returns the size of the file afterwards.
+func AppendFileChunk(filename string, offset int64, checksum string, reader io.Reader) (int64, error) {
+	size := FileSize(filename)
+	if offset != 0 && offset != size {
+		return size, errors.New("Chunk offset does not match the upload offset")
+	}
+
+	os.MkdirAll(filepath.Dir(filename), os.ModePerm)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return size, err
+	}
+	defer file.Close()
+
+	if err = file.Truncate(offset); err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		return size, err
+	}
+
+	hasher := sha256.New()
+	count, err := io.Copy(io.MultiWriter(file, hasher), reader)
+	if err == nil && !strings.EqualFold(hex.EncodeToString(hasher.Sum(nil)), checksum) {
+		err = errors.New("Chunk checksum does not match the received data")
+	}
+	if err != nil {
+		file.Truncate(offset)
+		return offset, err
+	}
+	return offset + count, nil
+}
+
+// CompleteFileChunks verifies that the file assembled by AppendFileChunk has the
+// expected size and checksum, and then atomically moves it to the destination. Repeating
+// a completion that already succeeded is not an error.
+func CompleteFileChunks(filename string, destination string, size int64, checksum string) error {
+	if _, err := os.Stat(filename); os.IsNotExist(err) && FileSize(destination) == size {
+		if actual, _ := FileChecksum(destination); strings.EqualFold(actual, checksum) {
+			return nil
+		}
+	}
+	if err := VerifyFileChunks(filename, size, checksum); err != nil {
+		return err
+	}
+	return os.Rename(filename, destination)
+}
+
+// VerifyFileChunks checks that the uploaded chunks add up to the expected size and
+// checksum.
+func VerifyFileChunks(filename string, size int64, checksum string) error {
+	if actual := FileSize(filename); actual != size {
+		return errors.New("Upload size does not match the expected size")
+	}
+	actual, err := FileChecksum(filename)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, checksum) {
+		return errors.New("Upload checksum does not match the expected checksum")
+	}
+	return nil
+}
+
+// WriteFileAtomically writes the reader's contents to a temporary file beside the
+// destination and moves it into place once the contents have been fully written, so
+// that an interrupted write never leaves a truncated destination behind. Returns the
+// number of bytes written.
+func WriteFileAtomically(destination string, reader io.Reader) (int64, error) {
+	os.MkdirAll(filepath.Dir(destination), os.ModePerm)
+	file, err := os.CreateTemp(filepath.Dir(destination), filepath.Base(destination)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := io.Copy(file, reader)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), destination)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		count = 0
+	}
+	return count, err
+}
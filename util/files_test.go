@@ -0,0 +1,101 @@
+// Copyright 2020-2023 Security Onion Solutions LLC and/or licensed to Security Onion Solutions LLC under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0 as shown at
+// https://securityonion.net/license; you may not use this file except in compliance with the
+// Elastic License 2.0.
+
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/tj/assert"
+)
+
+func TestLinkOrCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.bin")
+	destination := filepath.Join(dir, "nested", "destination.bin")
+	assert.NoError(t, os.WriteFile(source, []byte("packets"), 0644))
+
+	size, err := LinkOrCopyFile(source, destination)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), size)
+
+	// The destination remains readable after the source is removed
+	assert.NoError(t, os.Remove(source))
+	content, err := os.ReadFile(destination)
+	assert.NoError(t, err)
+	assert.Equal(t, "packets", string(content))
+
+	_, err = LinkOrCopyFile(source, destination)
+	assert.Error(t, err)
+}
+
+func TestAppendFileChunk(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "nested", "1001.bin.part")
+
+	size, err := AppendFileChunk(filename, 0, Checksum([]byte("abc")), strings.NewReader("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+
+	// Offsets must follow on from the data already received
+	size, err = AppendFileChunk(filename, 1, Checksum([]byte("def")), strings.NewReader("def"))
+	assert.EqualError(t, err, "Chunk offset does not match the upload offset")
+	assert.Equal(t, int64(3), size)
+
+	// Corrupted chunks are discarded
+	size, err = AppendFileChunk(filename, 3, Checksum([]byte("def")), strings.NewReader("dXf"))
+	assert.EqualError(t, err, "Chunk checksum does not match the received data")
+	assert.Equal(t, int64(3), size)
+	assert.Equal(t, int64(3), FileSize(filename))
+
+	size, err = AppendFileChunk(filename, 3, Checksum([]byte("def")), strings.NewReader("def"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), size)
+
+	destination := filepath.Join(dir, "1001.bin")
+	assert.EqualError(t, CompleteFileChunks(filename, destination, 5, Checksum([]byte("abcdef"))), "Upload size does not match the expected size")
+	assert.EqualError(t, CompleteFileChunks(filename, destination, 6, Checksum([]byte("abcxyz"))), "Upload checksum does not match the expected checksum")
+	assert.NoError(t, CompleteFileChunks(filename, destination, 6, Checksum([]byte("abcdef"))))
+
+	content, err := os.ReadFile(destination)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdef", string(content))
+	assert.Equal(t, int64(0), FileSize(filename))
+
+	// Completing again, such as when the first response was lost, succeeds
+	assert.NoError(t, CompleteFileChunks(filename, destination, 6, Checksum([]byte("abcdef"))))
+	assert.Error(t, CompleteFileChunks(filename, destination, 6, Checksum([]byte("abcxyz"))))
+
+	// Starting over at offset zero discards any previous upload
+	AppendFileChunk(filename, 0, Checksum([]byte("abc")), strings.NewReader("abc"))
+	size, err = AppendFileChunk(filename, 0, Checksum([]byte("x")), strings.NewReader("x"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), size)
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	destination := filepath.Join(dir, "1001.bin")
+	assert.NoError(t, os.WriteFile(destination, []byte("old"), 0644))
+
+	_, err := WriteFileAtomically(destination, iotest.ErrReader(errors.New("dropped")))
+	assert.Error(t, err)
+	content, _ := os.ReadFile(destination)
+	assert.Equal(t, "old", string(content))
+
+	count, err := WriteFileAtomically(destination, strings.NewReader("packets"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), count)
+	content, _ = os.ReadFile(destination)
+	assert.Equal(t, "packets", string(content))
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
@@ -9,6 +9,7 @@ package web
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"io"
@@ -43,6 +44,19 @@ func NewClient(url string, verifyCert bool) *Client {
 	return client
 }
 
+// SetClientCertificate presents the certificate to the server during the TLS handshake. When a
+// CA pool is provided the server certificate is always verified against it rather than the
+// system pool, even if the client was created without verifying certificates.
+func (client *Client) SetClientCertificate(cert tls.Certificate, rootCAs *x509.CertPool) {
+	if transport, ok := client.impl.Transport.(*http.Transport); ok {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		if rootCAs != nil {
+			transport.TLSClientConfig.RootCAs = rootCAs
+			transport.TLSClientConfig.InsecureSkipVerify = false
+		}
+	}
+}
+
 func (client *Client) MockStringResponse(body string, statusCode int, mockError error) {
 	mockResp := &http.Response{
 		Body:          io.NopCloser(bytes.NewBufferString(body)),
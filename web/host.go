@@ -29,10 +29,11 @@ const GENERIC_ERROR_MESSAGE = "The request could not be processed. Contact a ser
 type ContextKey string
 
 const (
-	ContextKeyRequestId    ContextKey = "ContextKeyRequestId"    // string
-	ContextKeyRequestorId  ContextKey = "ContextKeyRequestorId"  // string
-	ContextKeyRequestor    ContextKey = "ContextKeyRequestor"    // *model.User
-	ContextKeyRequestStart ContextKey = "ContextKeyRequestStart" // time.Time
+	ContextKeyRequestId       ContextKey = "ContextKeyRequestId"       // string
+	ContextKeyRequestorId     ContextKey = "ContextKeyRequestorId"     // string
+	ContextKeyRequestor       ContextKey = "ContextKeyRequestor"       // *model.User
+	ContextKeyRequestStart    ContextKey = "ContextKeyRequestStart"    // time.Time
+	ContextKeyRequestorNodeId ContextKey = "ContextKeyRequestorNodeId" // string
 )
 
 type HostHandler interface {